@@ -3,10 +3,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/sh"
@@ -28,6 +31,7 @@ func BuildAll() error {
 	}{
 		{"linux", "amd64"},
 		{"linux", "arm64"},
+		{"linux", "arm"}, // ARMv7, e.g. Raspberry Pi OS 32-bit
 		{"darwin", "amd64"},
 		{"darwin", "arm64"},
 		{"windows", "amd64"},
@@ -59,22 +63,51 @@ func buildFor(goos, goarch string) error {
 		ext = ".exe"
 	}
 
-	output := filepath.Join("dist", fmt.Sprintf("gpx_bitforge_dockermetrics_panel_%s_%s%s", goos, goarch, ext))
+	output := filepath.Join("dist", fmt.Sprintf("gpx_bitforge_dockermetrics_datasource_%s_%s%s", goos, goarch, ext))
 
 	env := map[string]string{
 		"GOOS":        goos,
 		"GOARCH":      goarch,
 		"CGO_ENABLED": "0",
 	}
+	if goarch == "arm" {
+		env["GOARM"] = "7"
+	}
 
 	fmt.Printf("Building for %s/%s -> %s\n", goos, goarch, output)
 
 	return sh.RunWithV(env, "go", "build",
-		"-ldflags", "-w -s",
+		"-ldflags", ldflags(),
 		"-o", output,
 		"./pkg")
 }
 
+// ldflags strips debug info and stamps the version ($VERSION or package.json), git commit and build date
+func ldflags() string {
+	const pkg = "github.com/bitforge/dockermetrics-datasource/pkg/plugin"
+
+	version := os.Getenv("VERSION")
+	if version == "" {
+		var manifest struct {
+			Version string `json:"version"`
+		}
+		if b, err := os.ReadFile("package.json"); err == nil && json.Unmarshal(b, &manifest) == nil {
+			version = manifest.Version
+		}
+	}
+	commit, _ := sh.Output("git", "rev-parse", "--short", "HEAD")
+
+	flags := []string{"-w", "-s",
+		fmt.Sprintf("-X %s.BuildDate=%s", pkg, time.Now().UTC().Format(time.RFC3339))}
+	if version != "" {
+		flags = append(flags, fmt.Sprintf("-X %s.Version=%s", pkg, version))
+	}
+	if commit != "" {
+		flags = append(flags, fmt.Sprintf("-X %s.Commit=%s", pkg, commit))
+	}
+	return strings.Join(flags, " ")
+}
+
 func getDeps() error {
 	return sh.Run("go", "mod", "download")
 }
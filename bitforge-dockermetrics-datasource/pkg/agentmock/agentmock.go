@@ -0,0 +1,745 @@
+// Package agentmock emulates the Docker Metrics Collector agent API (info, containers, metrics,
+// logs and container controls) so the datasource can be exercised without Docker. An Agent is an
+// http.Handler: serve it with httptest.NewServer in tests or http.ListenAndServe by hand.
+package agentmock
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InstanceHeader is the response header carrying the agent's instance ID
+const InstanceHeader = "X-Agent-Instance-Id"
+
+// SignatureHeader is the response header carrying the signature of the response
+const SignatureHeader = "X-Agent-Signature"
+
+// SignatureTimeHeader is the response header carrying the Unix time the response was signed at
+const SignatureTimeHeader = "X-Agent-Signature-Time"
+
+// NonceHeader is the request header whose value the signature covers
+const NonceHeader = "X-Agent-Nonce"
+
+// Container states and health statuses as the agent reports them
+const (
+	StateCreated    = "Created"
+	StateRunning    = "Running"
+	StatePaused     = "Paused"
+	StateRestarting = "Restarting"
+	StateExited     = "Exited"
+
+	HealthNone      = "None"
+	HealthStarting  = "Starting"
+	HealthHealthy   = "Healthy"
+	HealthUnhealthy = "Unhealthy"
+)
+
+// Container is a container known to the mock agent. Empty State means running.
+type Container struct {
+	ID           string
+	Name         string
+	State        string
+	HealthStatus string
+	Image        string
+	Pod          string
+	Labels       map[string]string
+	Networks     []string
+	Links        []string // names of linked containers
+}
+
+// Sample is one metrics sample; the state flags come from the container at request time
+type Sample struct {
+	ContainerID    string
+	Time           time.Time
+	CPUPercent     float64
+	MemoryBytes    float64
+	MemoryPercent  float64
+	NetworkRxBytes float64
+	NetworkTxBytes float64
+	DiskReadBytes  float64
+	DiskWriteBytes float64
+	UptimeSeconds  float64
+	CPUPressure    *PSI // nil on hosts without pressure stall information
+	MemoryPressure *PSI
+	IOPressure     *PSI
+}
+
+// PSI is the pressure stall information of a sample, in percent
+type PSI struct {
+	Some10  float64 `json:"some10"`
+	Some60  float64 `json:"some60"`
+	Some300 float64 `json:"some300"`
+	Full10  float64 `json:"full10"`
+	Full60  float64 `json:"full60"`
+	Full300 float64 `json:"full300"`
+}
+
+// LogLine is one line a container wrote to stdout or stderr
+type LogLine struct {
+	ContainerID string
+	Time        time.Time
+	Stream      string // "stdout" or "stderr"
+	Message     string
+}
+
+// Action is a control action the agent received
+type Action struct {
+	ContainerID string
+	Action      string
+	Params      string // encoded query, e.g. "force=true&volumes=true" for remove
+}
+
+// SwarmService is a service the agent lists when it runs on a swarm manager
+type SwarmService struct {
+	ID           string
+	Name         string
+	Mode         string // replicated (default) or global
+	DesiredTasks int64
+	RunningTasks int64
+}
+
+// SwarmNode is a node the agent lists when it runs on a swarm manager
+type SwarmNode struct {
+	ID           string
+	Hostname     string
+	Addr         string
+	Role         string // worker or manager
+	State        string // ready, down, ...
+	Availability string // active, pause or drain
+}
+
+// Fault replaces the agent's response to matching requests
+type Fault struct {
+	Status int           // HTTP status to answer with, default 500 when Body is empty
+	Body   string        // raw response body, e.g. malformed JSON or a changed schema
+	Delay  time.Duration // wait before answering (or until the request is cancelled)
+	Times  int           // number of requests to fail, 0 for all
+}
+
+// Agent is an in-memory agent. The zero value is not usable, create it with New.
+type Agent struct {
+	Hostname      string
+	Version       string
+	InstanceID    string
+	DockerVersion string
+	PsiSupported  bool
+	// LogsSupported reports the logs capability in /api/info and serves /api/logs
+	LogsSupported bool
+	// ClockOffset is added to the Date header, emulating an agent whose clock is off
+	ClockOffset time.Duration
+	// CPUCount and MemoryTotalBytes are the host capacity reported in /api/info, omitted when 0
+	CPUCount         int
+	MemoryTotalBytes int64
+	// Token, when set, is the bearer token every request must carry (401 otherwise)
+	Token string
+	// SigningKey, when set, signs every response (ECDSA over SHA-256, or Ed25519): the request
+	// method, request URI, signature time, request nonce and body, joined by newlines
+	SigningKey crypto.Signer
+
+	mu         sync.Mutex
+	containers map[string]*Container
+	services   []SwarmService // nil when not a swarm manager
+	nodes      []SwarmNode    // nil when not a swarm manager
+	samples    map[string][]Sample
+	logs       map[string][]LogLine
+	actions    []Action
+	faults     map[string]*Fault
+	requests   []string
+}
+
+// New returns an agent reporting the given hostname, with no containers
+func New(hostname string) *Agent {
+	return &Agent{
+		Hostname:      hostname,
+		Version:       "1.0.0-mock",
+		InstanceID:    "mock-" + hostname,
+		DockerVersion: "24.0.0",
+		containers:    make(map[string]*Container),
+		samples:       make(map[string][]Sample),
+		logs:          make(map[string][]LogLine),
+		faults:        make(map[string]*Fault),
+	}
+}
+
+// AddContainer adds or replaces a container
+func (a *Agent) AddContainer(c Container) {
+	if c.State == "" {
+		c.State = StateRunning
+	}
+	if c.HealthStatus == "" {
+		c.HealthStatus = HealthNone
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.containers[c.ID] = &c
+}
+
+// SetSwarmServices makes the agent a swarm manager listing the services at /api/swarm/services
+func (a *Agent) SetSwarmServices(services ...SwarmService) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.services = append([]SwarmService{}, services...)
+}
+
+// SetSwarmNodes makes the agent a swarm manager listing the nodes at /api/swarm/nodes
+func (a *Agent) SetSwarmNodes(nodes ...SwarmNode) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nodes = append([]SwarmNode{}, nodes...)
+}
+
+// AddSamples records samples; the container must have been added first
+func (a *Agent) AddSamples(samples ...Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range samples {
+		a.samples[s.ContainerID] = append(a.samples[s.ContainerID], s)
+	}
+}
+
+// AddLogs records log lines; the container must have been added first
+func (a *Agent) AddLogs(lines ...LogLine) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, l := range lines {
+		a.logs[l.ContainerID] = append(a.logs[l.ContainerID], l)
+	}
+}
+
+// Fail injects a fault for requests whose path (without query) starts with prefix, e.g.
+// "/api/metrics" or "/api/containers/abc/stop"
+func (a *Agent) Fail(prefix string, f Fault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults[prefix] = &f
+}
+
+// ClearFaults removes all injected faults
+func (a *Agent) ClearFaults() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = make(map[string]*Fault)
+}
+
+// Actions returns the control actions received so far
+func (a *Agent) Actions() []Action {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Action(nil), a.actions...)
+}
+
+// Requests returns "METHOD /path?query" for every request received so far
+func (a *Agent) Requests() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.requests...)
+}
+
+// ServeHTTP implements the agent API
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, r.Method+" "+r.URL.RequestURI())
+	fault := a.takeFault(r.URL.Path)
+	instanceID := a.InstanceID
+	clockOffset := a.ClockOffset
+	token := a.Token
+	signingKey := a.SigningKey
+	a.mu.Unlock()
+
+	w.Header().Set(InstanceHeader, instanceID)
+	if clockOffset != 0 {
+		w.Header().Set("Date", time.Now().Add(clockOffset).UTC().Format(http.TimeFormat))
+	}
+	if signingKey != nil {
+		signed := &signingWriter{ResponseWriter: w, status: http.StatusOK}
+		defer signed.flush(signingKey, r, time.Now().Add(clockOffset))
+		w = signed
+	}
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+		return
+	}
+	if fault != nil {
+		serveFault(w, r, *fault)
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodGet && (path == "" || path == "/"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{"service": "Docker Metrics Agent", "version": a.Version, "hostname": a.Hostname, "status": "running"})
+	case r.Method == http.MethodGet && path == "/api/info":
+		a.serveInfo(w)
+	case r.Method == http.MethodGet && path == "/api/containers":
+		a.serveContainers(w, r)
+	case r.Method == http.MethodGet && path == "/api/metrics":
+		a.serveMetrics(w, r)
+	case r.Method == http.MethodGet && path == "/api/logs" && a.LogsSupported:
+		a.serveLogs(w, r)
+	case r.Method == http.MethodGet && path == "/api/swarm/services":
+		a.serveSwarmServices(w)
+	case r.Method == http.MethodGet && path == "/api/swarm/nodes":
+		a.serveSwarmNodes(w)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/api/containers/"):
+		a.serveControl(w, r, strings.Split(strings.TrimPrefix(path, "/api/containers/"), "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// signingWriter buffers a response so its signature can be sent ahead of the body
+type signingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (s *signingWriter) WriteHeader(status int) { s.status = status }
+
+func (s *signingWriter) Write(b []byte) (int, error) { return s.body.Write(b) }
+
+func (s *signingWriter) flush(key crypto.Signer, r *http.Request, now time.Time) {
+	signedAt := strconv.FormatInt(now.Unix(), 10)
+	message := []byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + signedAt + "\n" + r.Header.Get(NonceHeader) + "\n")
+	message = append(message, s.body.Bytes()...)
+
+	var signature []byte
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		signature, _ = key.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		signature, _ = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	s.ResponseWriter.Header().Set(SignatureTimeHeader, signedAt)
+	s.ResponseWriter.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	s.ResponseWriter.WriteHeader(s.status)
+	_, _ = s.ResponseWriter.Write(s.body.Bytes())
+}
+
+// takeFault returns the fault for a path and counts it down; a.mu must be held
+func (a *Agent) takeFault(path string) *Fault {
+	for prefix, f := range a.faults {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		taken := *f
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				delete(a.faults, prefix)
+			}
+		}
+		return &taken
+	}
+	return nil
+}
+
+// serveFault answers with an injected fault
+func serveFault(w http.ResponseWriter, r *http.Request, f Fault) {
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+		if f.Body == "" {
+			status = http.StatusInternalServerError
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(f.Body))
+}
+
+func (a *Agent) serveInfo(w http.ResponseWriter) {
+	info := map[string]interface{}{
+		"hostname":        a.Hostname,
+		"agentVersion":    a.Version,
+		"dockerVersion":   a.DockerVersion,
+		"dockerConnected": true,
+		"psiSupported":    a.PsiSupported,
+		"os":              "linux",
+		"architecture":    "x86_64",
+		"kernelVersion":   "6.1.0",
+		"runtime":         "docker",
+		"instanceId":      a.InstanceID,
+	}
+	if a.CPUCount > 0 {
+		info["cpuCount"] = a.CPUCount
+	}
+	if a.MemoryTotalBytes > 0 {
+		info["memoryTotalBytes"] = a.MemoryTotalBytes
+	}
+	if a.LogsSupported {
+		info["capabilities"] = map[string]interface{}{"supportsLogs": true}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// serveContainers lists running containers, or all with ?all=true
+func (a *Agent) serveContainers(w http.ResponseWriter, r *http.Request) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]map[string]interface{}, 0, len(a.containers))
+	for _, c := range a.sortedContainers() {
+		if !all && c.State != StateRunning {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"containerId":   c.ID,
+			"containerName": c.Name,
+			"state":         c.State,
+			"healthStatus":  c.HealthStatus,
+			"image":         c.Image,
+			"pod":           c.Pod,
+			"labels":        c.Labels,
+			"networks":      c.Networks,
+			"links":         c.Links,
+			"isRunning":     c.State == StateRunning,
+			"isPaused":      c.State == StatePaused,
+			"isUnhealthy":   c.HealthStatus == HealthUnhealthy,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveSwarmServices lists the swarm services like an agent on a manager; like the agent,
+// it answers 409 when it isn't one
+func (a *Agent) serveSwarmServices(w http.ResponseWriter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.services == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "This node is not a swarm manager"})
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(a.services))
+	for _, s := range a.services {
+		mode := s.Mode
+		if mode == "" {
+			mode = "replicated"
+		}
+		list = append(list, map[string]interface{}{
+			"id":           s.ID,
+			"name":         s.Name,
+			"mode":         mode,
+			"desiredTasks": s.DesiredTasks,
+			"runningTasks": s.RunningTasks,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveSwarmNodes lists the swarm nodes like an agent on a manager, 409 when it isn't one
+func (a *Agent) serveSwarmNodes(w http.ResponseWriter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nodes == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "This node is not a swarm manager"})
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(a.nodes))
+	for _, n := range a.nodes {
+		list = append(list, map[string]interface{}{
+			"id":           n.ID,
+			"hostname":     n.Hostname,
+			"addr":         n.Addr,
+			"role":         n.Role,
+			"state":        n.State,
+			"availability": n.Availability,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// sortedContainers returns the containers by ID; a.mu must be held
+func (a *Agent) sortedContainers() []*Container {
+	list := make([]*Container, 0, len(a.containers))
+	for _, c := range a.containers {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// allFields are the fields projected when only timestamps=epoch is requested
+var allFields = []string{"cpupercent", "memorybytes", "memorypercent", "networkrxbytes", "networktxbytes", "diskreadbytes", "diskwritebytes", "uptimeseconds", "cpupressure", "memorypressure", "iopressure"}
+
+// serveMetrics returns samples between from and to (default the last 6 hours), newest first
+// per container like the agent. fields, containerFields, containerIds/containerId, limit,
+// latest and timestamps=epoch behave as in the agent.
+func (a *Agent) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-6 * time.Hour)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid %s", name)})
+				return
+			}
+			*target = t
+		}
+	}
+
+	var ids map[string]bool
+	if v := q.Get("containerIds"); v != "" {
+		ids = make(map[string]bool)
+		for _, id := range strings.Split(v, ",") {
+			ids[id] = true
+		}
+	} else if v := q.Get("containerId"); v != "" {
+		ids = map[string]bool{v: true}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	latest, _ := strconv.ParseBool(q.Get("latest"))
+	epoch := strings.EqualFold(q.Get("timestamps"), "epoch")
+
+	fields := make(map[string]bool)
+	if v := q.Get("fields"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			fields[strings.ToLower(strings.TrimSpace(f))] = true
+		}
+	} else {
+		for _, f := range allFields {
+			fields[f] = true
+		}
+	}
+
+	// containerFields overrides fields per container: id:field,field;id:field
+	containerFields := make(map[string]map[string]bool)
+	if v := q.Get("containerFields"); v != "" {
+		for _, entry := range strings.Split(v, ";") {
+			id, list, ok := strings.Cut(entry, ":")
+			if !ok {
+				continue
+			}
+			containerFields[id] = make(map[string]bool)
+			for _, f := range strings.Split(list, ",") {
+				containerFields[id][strings.ToLower(strings.TrimSpace(f))] = true
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	metrics := make([]map[string]interface{}, 0)
+	total := 0
+	for _, c := range a.sortedContainers() {
+		if ids != nil && !ids[c.ID] {
+			continue
+		}
+		inRange := make([]Sample, 0)
+		for _, s := range a.samples[c.ID] {
+			if !s.Time.Before(from) && !s.Time.After(to) {
+				inRange = append(inRange, s)
+			}
+		}
+		sort.SliceStable(inRange, func(i, j int) bool { return inRange[i].Time.After(inRange[j].Time) })
+		total += len(inRange)
+		if latest && len(inRange) > 1 {
+			inRange = inRange[:1]
+		} else if limit > 0 && len(inRange) > limit {
+			inRange = inRange[:limit]
+		}
+		projected := fields
+		if override, ok := containerFields[c.ID]; ok {
+			projected = override
+		}
+		for _, s := range inRange {
+			metrics = append(metrics, projectSample(c, s, projected, epoch))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metrics":  metrics,
+		"metadata": map[string]interface{}{"totalAvailable": total},
+	})
+}
+
+// serveLogs returns log lines between from and to (default the last hour), newest first
+// across containers, filtered by containerIds and cut to limit (default 1000)
+func (a *Agent) serveLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid %s", name)})
+				return
+			}
+			*target = t
+		}
+	}
+
+	var ids map[string]bool
+	if v := q.Get("containerIds"); v != "" {
+		ids = make(map[string]bool)
+		for _, id := range strings.Split(v, ",") {
+			ids[id] = true
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+	epoch := strings.EqualFold(q.Get("timestamps"), "epoch")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	type entry struct {
+		c *Container
+		l LogLine
+	}
+	entries := make([]entry, 0)
+	for _, c := range a.sortedContainers() {
+		if ids != nil && !ids[c.ID] {
+			continue
+		}
+		for _, l := range a.logs[c.ID] {
+			if !l.Time.Before(from) && !l.Time.After(to) {
+				entries = append(entries, entry{c, l})
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].l.Time.After(entries[j].l.Time) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	logs := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		var ts interface{} = e.l.Time.UTC().Format(time.RFC3339Nano)
+		if epoch {
+			ts = e.l.Time.UnixMilli()
+		}
+		logs = append(logs, map[string]interface{}{
+			"containerId":   e.c.ID,
+			"containerName": e.c.Name,
+			"timestamp":     ts,
+			"stream":        e.l.Stream,
+			"message":       e.l.Message,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"logs": logs})
+}
+
+// projectSample renders a sample with the base fields plus the requested ones
+func projectSample(c *Container, s Sample, fields map[string]bool, epoch bool) map[string]interface{} {
+	out := map[string]interface{}{
+		"containerId":   c.ID,
+		"containerName": c.Name,
+		"isRunning":     c.State == StateRunning,
+		"isPaused":      c.State == StatePaused,
+		"isUnhealthy":   c.HealthStatus == HealthUnhealthy,
+		"healthStatus":  strings.ToLower(c.HealthStatus),
+	}
+	if epoch {
+		out["timestamp"] = s.Time.UnixMilli()
+	} else {
+		out["timestamp"] = s.Time.Format(time.RFC3339Nano)
+	}
+	values := map[string]float64{
+		"cpuPercent":     s.CPUPercent,
+		"memoryBytes":    s.MemoryBytes,
+		"memoryPercent":  s.MemoryPercent,
+		"networkRxBytes": s.NetworkRxBytes,
+		"networkTxBytes": s.NetworkTxBytes,
+		"diskReadBytes":  s.DiskReadBytes,
+		"diskWriteBytes": s.DiskWriteBytes,
+		"uptimeSeconds":  s.UptimeSeconds,
+	}
+	for name, v := range values {
+		if fields[strings.ToLower(name)] {
+			out[name] = v
+		}
+	}
+	for name, psi := range map[string]*PSI{"cpuPressure": s.CPUPressure, "memoryPressure": s.MemoryPressure, "ioPressure": s.IOPressure} {
+		if fields[strings.ToLower(name)] {
+			out[name] = psi
+		}
+	}
+	return out
+}
+
+// controlStates is the container state after each control action
+var controlStates = map[string]string{
+	"start":   StateRunning,
+	"stop":    StateExited,
+	"restart": StateRunning,
+	"pause":   StatePaused,
+	"unpause": StateRunning,
+	"kill":    StateExited,
+	"remove":  "",
+	// recreate replaces the container with a running one named alike, ID "<id>-recreated"
+	"recreate": StateRunning,
+}
+
+// serveControl handles POST /api/containers/{id}/{action}
+func (a *Agent) serveControl(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) != 2 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	id, action := parts[0], parts[1]
+	state, ok := controlStates[action]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.containers[id]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "No such container: " + id})
+		return
+	}
+	query := r.URL.Query()
+	if action == "remove" && c.State == StateRunning && query.Get("force") != "true" {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"success": false, "action": action, "containerId": id,
+			"error": "You cannot remove a running container " + id + ". Stop the container before attempting removal or force remove"})
+		return
+	}
+	a.actions = append(a.actions, Action{ContainerID: id, Action: action, Params: r.URL.RawQuery})
+	result := map[string]interface{}{"success": true, "action": action, "containerId": id}
+	switch action {
+	case "remove":
+		delete(a.containers, id)
+	case "recreate":
+		delete(a.containers, id)
+		replacement := *c
+		replacement.ID = id + "-recreated"
+		replacement.State = state
+		a.containers[replacement.ID] = &replacement
+		result["newContainerId"] = replacement.ID
+	default:
+		c.State = state
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
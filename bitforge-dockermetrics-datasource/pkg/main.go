@@ -10,6 +10,8 @@ import (
 )
 
 func main() {
+	log.DefaultLogger.Info("Starting Docker Metrics datasource", "version", plugin.Version, "commit", plugin.Commit, "buildDate", plugin.BuildDate)
+
 	err := datasource.Manage("bitforge-dockermetrics-datasource", plugin.NewDatasource, datasource.ManageOpts{})
 
 	if err != nil {
@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregation modes for QueryModel.AggregateBy
+const (
+	AggregateNone       = ""
+	AggregatePod        = "pod"
+	AggregateCompose    = "compose" // compose service, so scaled replicas become one series
+	AggregateECSTask    = "ecs-task"
+	AggregateECSFamily  = "ecs-family" // ECS task definition family, i.e. all tasks of a service
+	AggregateNomadJob   = "nomad-job"
+	AggregateNomadAlloc = "nomad-alloc"
+	AggregateSwarm      = "swarm-service"
+	AggregateSwarmTask  = "swarm-task" // task slot, so a rescheduled task continues its series
+)
+
+// aggregateGroup returns the group a container belongs to for the aggregation mode and the
+// labels of the group's series. ok is false for containers that stay as their own series.
+func aggregateGroup(mode string, labels map[string]string) (key string, groupLabels map[string]string, ok bool) {
+	switch mode {
+	case AggregatePod:
+		if pod := labels["pod"]; pod != "" {
+			return pod, map[string]string{"pod": pod}, true
+		}
+	case AggregateCompose:
+		if service := labels["composeService"]; service != "" {
+			groupLabels := map[string]string{"composeService": service}
+			key := service
+			if project := labels["composeProject"]; project != "" {
+				key = project + "/" + service
+				groupLabels["composeProject"] = project
+			}
+			return key, groupLabels, true
+		}
+	case AggregateECSTask:
+		if task := labels["ecsTask"]; task != "" {
+			return task, map[string]string{"ecsCluster": labels["ecsCluster"], "ecsTask": task, "ecsTaskFamily": labels["ecsTaskFamily"]}, true
+		}
+	case AggregateECSFamily:
+		if family := labels["ecsTaskFamily"]; family != "" {
+			return labels["ecsCluster"] + "/" + family, map[string]string{"ecsCluster": labels["ecsCluster"], "ecsTaskFamily": family}, true
+		}
+	case AggregateNomadJob:
+		if job := labels["nomadJob"]; job != "" {
+			return labels["nomadNamespace"] + "/" + job, map[string]string{"nomadNamespace": labels["nomadNamespace"], "nomadJob": job}, true
+		}
+	case AggregateNomadAlloc:
+		if alloc := labels["nomadAlloc"]; alloc != "" {
+			return alloc, map[string]string{"nomadJob": labels["nomadJob"], "nomadTaskGroup": labels["nomadTaskGroup"], "nomadAlloc": alloc}, true
+		}
+	case AggregateSwarm:
+		if service := labels["swarmService"]; service != "" {
+			return service, map[string]string{"swarmService": service}, true
+		}
+	case AggregateSwarmTask:
+		if task := labels["swarmTask"]; task != "" {
+			return task, map[string]string{"swarmService": labels["swarmService"], "swarmTask": task}, true
+		}
+	}
+	return "", nil, false
+}
+
+// aggregateMetrics rolls up containers of the same group into one series per group.
+// Samples are matched by timestamp truncated to the second; counters are summed and gauges
+// averaged. Aggregated series get the ID "<mode>:<group>" and the group's labels.
+func aggregateMetrics(mode string, metrics []ContainerMetric, containerLabels map[string]map[string]string) ([]ContainerMetric, map[string]map[string]string) {
+	if mode == AggregateNone {
+		return metrics, containerLabels
+	}
+
+	type bucketKey struct {
+		group string
+		ts    int64
+	}
+	type bucket struct {
+		samples []ContainerMetric
+		time    time.Time
+	}
+
+	buckets := make(map[bucketKey]*bucket)
+	result := make([]ContainerMetric, 0, len(metrics))
+	labels := make(map[string]map[string]string, len(containerLabels))
+	for id, l := range containerLabels {
+		labels[id] = l
+	}
+
+	for _, m := range metrics {
+		group, groupLabels, ok := aggregateGroup(mode, containerLabels[m.ContainerID])
+		if !ok {
+			result = append(result, m)
+			continue
+		}
+		t := m.Timestamp.Truncate(time.Second)
+
+		key := bucketKey{group, t.Unix()}
+		if buckets[key] == nil {
+			buckets[key] = &bucket{time: t}
+		}
+		buckets[key].samples = append(buckets[key].samples, m)
+
+		id := mode + ":" + group
+		if labels[id] == nil {
+			labels[id] = groupLabels
+		}
+	}
+
+	keys := make([]bucketKey, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].ts < keys[j].ts
+	})
+
+	for _, k := range keys {
+		b := buckets[k]
+		merged := combineSamples(b.samples)
+		merged.ContainerID = mode + ":" + k.group
+		merged.ContainerName = k.group
+		merged.Timestamp = SampleTime{Time: b.time}
+		result = append(result, merged)
+	}
+
+	return result, labels
+}
+
+// combineSamples merges samples taken at the same time from several containers:
+// network and disk counters are summed, gauges (CPU, memory, uptime, PSI) are averaged.
+// Only samples carrying a metric count; a metric none of them carries stays NaN.
+func combineSamples(samples []ContainerMetric) ContainerMetric {
+	var out ContainerMetric
+	totals := out.floatFields()
+	counts := make(map[string]int, len(totals))
+
+	var cpu, mem, io []*PSIMetrics
+	for _, s := range samples {
+		for name, v := range s.floatFields() {
+			if isFinite(*v) {
+				*totals[name] += *v
+				counts[name]++
+			}
+		}
+		out.IsRunning = out.IsRunning || s.IsRunning
+		out.IsPaused = out.IsPaused || s.IsPaused
+		out.IsUnhealthy = out.IsUnhealthy || s.IsUnhealthy
+		cpu = append(cpu, s.CPUPressure)
+		mem = append(mem, s.MemoryPressure)
+		io = append(io, s.IOPressure)
+	}
+	for name, total := range totals {
+		switch {
+		case counts[name] == 0:
+			*total = math.NaN()
+		case !contains(counterMetrics, name):
+			*total /= float64(counts[name])
+		}
+	}
+	out.CPUPressure = averagePSI(cpu)
+	out.MemoryPressure = averagePSI(mem)
+	out.IOPressure = averagePSI(io)
+	return out
+}
+
+// averagePSI averages the pressure values that are present; nil if none are
+func averagePSI(values []*PSIMetrics) *PSIMetrics {
+	var out PSIMetrics
+	count := 0.0
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		out.Some10 += v.Some10
+		out.Some60 += v.Some60
+		out.Some300 += v.Some300
+		out.Full10 += v.Full10
+		out.Full60 += v.Full60
+		out.Full300 += v.Full300
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	out.Some10 /= count
+	out.Some60 /= count
+	out.Some300 /= count
+	out.Full10 /= count
+	out.Full60 /= count
+	out.Full300 /= count
+	return &out
+}
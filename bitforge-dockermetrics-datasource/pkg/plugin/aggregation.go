@@ -0,0 +1,257 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Functions for QueryModel.Aggregation, applied across the containers of a group
+const (
+	AggregationSum   = "sum"
+	AggregationAvg   = "avg"
+	AggregationMin   = "min"
+	AggregationMax   = "max"
+	AggregationCount = "count" // containers reporting the metric
+)
+
+// Groups for QueryModel.GroupBy; empty puts every container into one series
+const (
+	GroupByHost           = "host"
+	GroupByImage          = "image"
+	GroupByComposeProject = "composeProject"
+)
+
+// minAggregationStep is the agent's collection interval. Narrower buckets would hold only
+// some containers' samples, so sums would jump between points.
+const minAggregationStep = 10 * time.Second
+
+// validateAggregation checks the query's aggregation function and grouping
+func validateAggregation(qm QueryModel) error {
+	switch qm.Aggregation {
+	case "":
+		if qm.GroupBy != "" {
+			return fmt.Errorf("groupBy needs an aggregation")
+		}
+		return nil
+	case AggregationSum, AggregationAvg, AggregationMin, AggregationMax, AggregationCount:
+	default:
+		return fmt.Errorf("aggregation must be sum, avg, min, max or count")
+	}
+	switch qm.GroupBy {
+	case "", GroupByHost, GroupByImage, GroupByComposeProject:
+		return nil
+	}
+	return fmt.Errorf("groupBy must be host, image or composeProject")
+}
+
+// aggregationStep returns the bucket width for the query interval
+func aggregationStep(interval time.Duration) time.Duration {
+	if interval < minAggregationStep {
+		return minAggregationStep
+	}
+	return interval
+}
+
+// reduceValues applies an aggregation function to the values of one bucket
+func reduceValues(fn string, values []float64) float64 {
+	var out float64
+	switch fn {
+	case AggregationMin:
+		out = math.Inf(1)
+	case AggregationMax:
+		out = math.Inf(-1)
+	}
+	for _, v := range values {
+		switch fn {
+		case AggregationSum, AggregationAvg:
+			out += v
+		case AggregationMin:
+			out = math.Min(out, v)
+		case AggregationMax:
+			out = math.Max(out, v)
+		}
+	}
+	switch fn {
+	case AggregationAvg:
+		out /= float64(len(values))
+	case AggregationCount:
+		out = float64(len(values))
+	}
+	return out
+}
+
+// aggregationGroup is one series of an aggregated query
+type aggregationGroup struct {
+	name    string                                       // group value, e.g. the host name
+	buckets map[int64]map[containerKey]aggregationSample // bucket start (ms) -> newest sample per container
+}
+
+type aggregationSample struct {
+	metric  ContainerMetric
+	metrics []string // metrics selected for the container
+}
+
+// buildAggregatedFrames reduces the containers of each group to one frame per metric.
+// Each container contributes its newest sample per time bucket, so agents sampling at
+// different moments still line up. Containers without the group's value (e.g. no compose
+// project) form the "(none)" group.
+func (d *Datasource) buildAggregatedFrames(ctx context.Context, hosts []HostConfig, allMetrics []metricsWithHost, requested []string, qm QueryModel, interval time.Duration) []*data.Frame {
+	step := aggregationStep(interval)
+
+	images := make(map[containerKey]string)
+	if qm.GroupBy == GroupByImage {
+		for _, host := range hosts {
+			containers, err := d.fetchContainersFromHost(ctx, host)
+			if err != nil {
+				d.logger.Debug("Failed to fetch containers for image groups", "host", host.Name, "error", err)
+				continue
+			}
+			for _, c := range containers {
+				images[containerKey{host.ID, c.ContainerID}] = c.Image
+			}
+		}
+	}
+
+	groups := make(map[string]*aggregationGroup)
+	for _, mwh := range allMetrics {
+		for _, m := range mwh.Metrics {
+			key := containerKey{mwh.HostID, m.ContainerID}
+			var groupKey, name string
+			switch qm.GroupBy {
+			case GroupByHost:
+				groupKey, name = mwh.HostID, mwh.HostName
+			case GroupByImage:
+				groupKey = images[key]
+				name = groupKey
+			case GroupByComposeProject:
+				groupKey = mwh.ContainerLabels[m.ContainerID]["composeProject"]
+				name = groupKey
+			}
+			if name == "" && qm.GroupBy != "" {
+				name = "(none)"
+			}
+
+			g := groups[groupKey]
+			if g == nil {
+				g = &aggregationGroup{name: name, buckets: make(map[int64]map[containerKey]aggregationSample)}
+				groups[groupKey] = g
+			}
+			bucket := m.Timestamp.Truncate(step).UnixMilli()
+			if g.buckets[bucket] == nil {
+				g.buckets[bucket] = make(map[containerKey]aggregationSample)
+			}
+			if prev, ok := g.buckets[bucket][key]; !ok || m.Timestamp.After(prev.metric.Timestamp.Time) {
+				g.buckets[bucket][key] = aggregationSample{metric: m, metrics: d.getMetricsForContainer(mwh.HostSelection, m.ContainerID)}
+			}
+		}
+	}
+
+	groupKeys := make([]string, 0, len(groups))
+	for k := range groups {
+		groupKeys = append(groupKeys, k)
+	}
+	sort.Slice(groupKeys, func(i, j int) bool {
+		a, b := groups[groupKeys[i]], groups[groupKeys[j]]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return groupKeys[i] < groupKeys[j]
+	})
+	sortedMetrics := append([]string(nil), requested...)
+	sort.Strings(sortedMetrics)
+
+	frames := make([]*data.Frame, 0, len(groupKeys)*len(sortedMetrics))
+	for _, k := range groupKeys {
+		for _, metric := range sortedMetrics {
+			if frame := d.buildAggregatedFrame(groups[k], metric, qm); frame != nil {
+				frames = append(frames, frame)
+			}
+		}
+	}
+
+	// Host notices would otherwise be lost with the per-container frames
+	for _, mwh := range allMetrics {
+		addFrameNotice(frames, staleNotice(mwh.HostName, mwh.StaleAge))
+		addFrameNotice(frames, d.clockSkewNotice(mwh.HostName, mwh.ClockSkew))
+	}
+	return frames
+}
+
+// buildAggregatedFrame builds the frame of one group and metric; nil when no container of
+// the group reports the metric
+func (d *Datasource) buildAggregatedFrame(g *aggregationGroup, metric string, qm QueryModel) *data.Frame {
+	buckets := make([]int64, 0, len(g.buckets))
+	for b := range g.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	scale, unit, decimals := d.metricFormat(metric)
+	if qm.Aggregation == AggregationCount {
+		scale, unit, decimals = 1, "short", nil
+	}
+
+	times := make([]time.Time, 0, len(buckets))
+	values := make([]*float64, 0, len(buckets))
+	present := false
+	for _, b := range buckets {
+		reported := make([]float64, 0, len(g.buckets[b]))
+		for _, s := range g.buckets[b] {
+			if !contains(s.metrics, metric) {
+				continue
+			}
+			if value, ok := displayMetricValue(s.metric, metric); ok {
+				reported = append(reported, value)
+			}
+		}
+		times = append(times, time.UnixMilli(b).UTC())
+		if len(reported) == 0 {
+			values = append(values, nil)
+			continue
+		}
+		value := reduceValues(qm.Aggregation, reported) * scale
+		values = append(values, &value)
+		present = true
+	}
+	if !present {
+		return nil
+	}
+
+	labels := data.Labels{"aggregation": qm.Aggregation}
+	switch qm.GroupBy {
+	case GroupByHost:
+		labels["hostName"] = g.name
+	case GroupByImage:
+		labels["image"] = g.name
+	case GroupByComposeProject:
+		labels["composeProject"] = g.name
+	}
+
+	if qm.Alerting {
+		valueField := data.NewField(metric, labels, values)
+		valueField.Config = &data.FieldConfig{Unit: unit, Decimals: decimals}
+		frame := data.NewFrame(metric, data.NewField("time", nil, times), valueField)
+		frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeGraph}
+		return frame
+	}
+
+	displayName := metricDisplayNames[metric]
+	if displayName == "" {
+		displayName = metric
+	}
+	series := g.name
+	if series == "" {
+		series = "all"
+	}
+	name := fmt.Sprintf("%s(%s) - %s", qm.Aggregation, series, displayName)
+	valueField := data.NewField(displayName, labels, values)
+	valueField.Config = &data.FieldConfig{DisplayName: name, Unit: unit, Decimals: decimals}
+	frame := data.NewFrame(name, data.NewField("time", nil, times), valueField)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeGraph}
+	return frame
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultAuditDays = 365
+
+var auditBucket = []byte("audit")
+
+// AuditSettings configures the persistent audit trail of control actions. Every action is
+// logged either way; with the trail enabled it is also stored and served by "audit" queries.
+type AuditSettings struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // store file, default one file per datasource in the temp directory
+	Days    int    `json:"days"` // entries older than this are pruned, default 365
+}
+
+func (s AuditSettings) days() int {
+	if s.Days > 0 {
+		return s.Days
+	}
+	return defaultAuditDays
+}
+
+// path returns the store file, named like the retention store's
+func (s AuditSettings) path(orgID int64, uid string) string {
+	if s.Path != "" {
+		return s.Path
+	}
+	if orgID > 1 {
+		uid = fmt.Sprintf("%d-%s", orgID, uid)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dockermetrics-audit-%s.db", discoveredHostID("audit", uid)))
+}
+
+// AuditEntry is one control action attempt
+type AuditEntry struct {
+	Time           time.Time `json:"time"`
+	User           string    `json:"user"` // login, empty for queries without a user
+	Role           string    `json:"role"`
+	Action         string    `json:"action"`
+	ContainerID    string    `json:"containerId"`
+	HostID         string    `json:"hostId"`
+	HostName       string    `json:"hostName"`
+	Result         string    `json:"result"` // success, failed, refused or skipped (after a failed step of a sequence)
+	Error          string    `json:"error,omitempty"`
+	NewContainerID string    `json:"newContainerId,omitempty"`
+}
+
+// auditStore is a BoltDB file laid out as audit/<unix nanos><sequence> -> entry JSON
+type auditStore struct {
+	path string
+	db   *bolt.DB
+
+	mu       sync.Mutex
+	prunedAt time.Time
+}
+
+func openAuditStore(path string) (*auditStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(auditBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize audit store: %w", err)
+	}
+	return &auditStore{path: path, db: db}, nil
+}
+
+// put stores an entry; the sequence keeps entries of the same instant apart
+func (s *auditStore) put(e AuditEntry) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(timeKey(e.Time), seq)
+		return bucket.Put(key, value)
+	})
+}
+
+// rangeQuery returns the entries with from <= time < to, oldest first
+func (s *auditStore) rangeQuery(from, to time.Time) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		end := timeKey(to)
+		for k, value := c.Seek(timeKey(from)); k != nil && string(k[:8]) < string(end); k, value = c.Next() {
+			var e AuditEntry
+			if err := json.Unmarshal(value, &e); err != nil {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// prune deletes entries older than cutoff, at most once an hour
+func (s *auditStore) prune(cutoff, now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.prunedAt) < time.Hour {
+		s.mu.Unlock()
+		return nil
+	}
+	s.prunedAt = now
+	s.mu.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		end := timeKey(cutoff)
+		for k, _ := c.First(); k != nil && string(k[:8]) < string(end); k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// startAudit opens the audit store if the trail is enabled. A store that can't be opened
+// leaves control actions audited in the log only.
+func (d *Datasource) startAudit(state *sharedState) {
+	settings := d.settings.Audit
+	if !settings.Enabled {
+		return
+	}
+	store, err := state.auditStore(settings.path(d.orgID, d.uid))
+	if err != nil {
+		d.logger.Error("Persistent audit trail disabled", "error", err)
+		return
+	}
+	d.audit = store
+}
+
+// recordAudit logs a control action attempt and stores it in the audit trail
+func (d *Datasource) recordAudit(e AuditEntry) {
+	d.logger.Info("Audit: container control action",
+		"user", e.User,
+		"role", e.Role,
+		"action", e.Action,
+		"container", e.ContainerID,
+		"hostId", e.HostID,
+		"host", e.HostName,
+		"result", e.Result,
+		"error", e.Error,
+	)
+	if d.audit == nil {
+		return
+	}
+	if err := d.audit.put(e); err != nil {
+		d.logger.Error("Failed to store audit entry", "action", e.Action, "container", e.ContainerID, "error", err)
+	}
+	if err := d.audit.prune(e.Time.AddDate(0, 0, -d.settings.Audit.days()), e.Time); err != nil {
+		d.logger.Warn("Failed to prune audit trail", "error", err)
+	}
+}
+
+// queryAudit returns the stored control actions of the time range, newest first. The trail
+// names users, so only editors and admins can read it.
+func (d *Datasource) queryAudit(ctx context.Context, user *backend.User, query backend.DataQuery) backend.DataResponse {
+	var response backend.DataResponse
+	if d.audit == nil {
+		response.Error = fmt.Errorf("the audit trail is disabled in datasource settings")
+		return response
+	}
+	if !isEditorUser(user) {
+		response.Error = &roleError{"reading the audit trail requires the Editor or Admin role"}
+		return response
+	}
+
+	entries, err := d.audit.rangeQuery(query.TimeRange.From, query.TimeRange.To)
+	if err != nil {
+		response.Error = fmt.Errorf("failed to read audit trail: %w", err)
+		return response
+	}
+
+	n := len(entries)
+	times := make([]time.Time, n)
+	users := make([]string, n)
+	roles := make([]string, n)
+	actions := make([]string, n)
+	containers := make([]string, n)
+	hostIDs := make([]string, n)
+	hostNames := make([]string, n)
+	results := make([]string, n)
+	errs := make([]string, n)
+	newContainers := make([]string, n)
+	for i, e := range entries {
+		j := n - 1 - i
+		times[j] = e.Time
+		users[j] = e.User
+		roles[j] = e.Role
+		actions[j] = e.Action
+		containers[j] = e.ContainerID
+		hostIDs[j] = e.HostID
+		hostNames[j] = e.HostName
+		results[j] = e.Result
+		errs[j] = e.Error
+		newContainers[j] = e.NewContainerID
+	}
+
+	frame := data.NewFrame("audit",
+		data.NewField("time", nil, times),
+		data.NewField("user", nil, users),
+		data.NewField("role", nil, roles),
+		data.NewField("action", nil, actions),
+		data.NewField("containerId", nil, containers),
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("hostName", nil, hostNames),
+		data.NewField("result", nil, results),
+		data.NewField("error", nil, errs),
+		data.NewField("newContainerId", nil, newContainers),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	response.Frames = data.Frames{frame}
+	return response
+}
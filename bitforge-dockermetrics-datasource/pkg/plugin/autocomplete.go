@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// HostOption is one entry of the hosts resource
+type HostOption struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// ContainerOption is one entry of the hosts/{id}/containers resource
+type ContainerOption struct {
+	ContainerID   string `json:"containerId"`
+	ContainerName string `json:"containerName"`
+	State         string `json:"state"`
+	Image         string `json:"image,omitempty"`
+}
+
+// MetricOption is one entry of the metrics resource
+type MetricOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+// resourceScope returns the claims of the ?scopeToken= of an autocomplete request, under the
+// same rules as queries so scoped dashboards only list what their panels can show
+func (d *Datasource) resourceScope(r *http.Request) (*ScopeClaims, error) {
+	qm := QueryModel{ScopeToken: r.URL.Query().Get("scopeToken")}
+	return d.queryScope(r.Context(), backend.PluginConfigFromContext(r.Context()), qm)
+}
+
+// handleHostList lists enabled hosts for editor dropdowns (GET /hosts)
+func (d *Datasource) handleHostList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	claims, err := d.resourceScope(r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	result := make([]HostOption, 0)
+	for _, host := range d.getEnabledHosts(nil) {
+		if !claims.allowsHost(host.ID) {
+			continue
+		}
+		result = append(result, HostOption{ID: host.ID, Name: host.Name, Group: host.Group, Tags: host.Tags})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleHosts routes the per-host resources: GET /hosts/{id}/containers and
+// POST /hosts/{id}/enabled
+func (d *Datasource) handleHosts(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/hosts"), "/"), "/")
+	if len(parts) == 2 && parts[1] == "containers" {
+		d.handleHostContainers(w, r, parts[0])
+		return
+	}
+	d.handleHostState(w, r)
+}
+
+// handleHostContainers lists a host's containers by name for editor dropdowns
+func (d *Datasource) handleHostContainers(w http.ResponseWriter, r *http.Request, hostID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	claims, err := d.resourceScope(r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	host, ok := d.hosts.find(hostID)
+	if !ok || !host.Enabled || !claims.allowsHost(host.ID) {
+		writeError(w, http.StatusNotFound, "host not found: "+hostID)
+		return
+	}
+
+	containers, err := d.fetchContainersFromHost(withQueryScope(r.Context(), claims), host)
+	if err != nil {
+		d.logHostError(host, "Failed to fetch containers from host", err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	result := make([]ContainerOption, 0, len(containers))
+	for _, c := range containers {
+		result = append(result, ContainerOption{ContainerID: c.ContainerID, ContainerName: c.ContainerName, State: c.State, Image: c.Image})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ContainerName < result[j].ContainerName })
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleMetricList lists the queryable metrics with their display names and units (GET /metrics)
+func (d *Datasource) handleMetricList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	result := make([]MetricOption, 0, len(AllMetrics))
+	for _, metric := range AllMetrics {
+		label := metricDisplayNames[metric]
+		if label == "" {
+			label = metric
+		}
+		_, unit, _ := d.metricFormat(metric)
+		result = append(result, MetricOption{Value: metric, Label: label, Unit: unit})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
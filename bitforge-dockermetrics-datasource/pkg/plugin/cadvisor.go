@@ -0,0 +1,223 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// cadvisorContainer is the subset of a cAdvisor v1.3 container info entry the datasource maps
+type cadvisorContainer struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+	Spec    struct {
+		CreationTime time.Time         `json:"creation_time"`
+		Labels       map[string]string `json:"labels"`
+		Image        string            `json:"image"`
+		Memory       struct {
+			Limit uint64 `json:"limit"`
+		} `json:"memory"`
+	} `json:"spec"`
+	Stats []cadvisorStats `json:"stats"`
+}
+
+type cadvisorStats struct {
+	Timestamp time.Time `json:"timestamp"`
+	CPU       struct {
+		Usage struct {
+			Total uint64 `json:"total"` // cumulative nanoseconds
+		} `json:"usage"`
+	} `json:"cpu"`
+	Memory struct {
+		Usage uint64 `json:"usage"`
+	} `json:"memory"`
+	Network struct {
+		RxBytes    uint64 `json:"rx_bytes"`
+		TxBytes    uint64 `json:"tx_bytes"`
+		Interfaces []struct {
+			RxBytes uint64 `json:"rx_bytes"`
+			TxBytes uint64 `json:"tx_bytes"`
+		} `json:"interfaces"`
+	} `json:"network"`
+	DiskIO struct {
+		IOServiceBytes []struct {
+			Stats map[string]uint64 `json:"stats"`
+		} `json:"io_service_bytes"`
+	} `json:"diskio"`
+}
+
+// cadvisorAttributes is the cAdvisor v2.0 attributes response
+type cadvisorAttributes struct {
+	KernelVersion      string `json:"kernel_version"`
+	ContainerOSVersion string `json:"container_os_version"`
+	DockerVersion      string `json:"docker_version"`
+	CAdvisorVersion    string `json:"cadvisor_version"`
+	NumCores           int    `json:"num_cores"`
+	MemoryCapacity     int64  `json:"memory_capacity"`
+}
+
+// fetchCAdvisorContainers reads all Docker containers and their recent stats from cAdvisor
+func (d *Datasource) fetchCAdvisorContainers(ctx context.Context, host HostConfig) ([]cadvisorContainer, string, error) {
+	resp, servedBy, err := d.doHostRequest(ctx, host, http.MethodGet, "/api/v1.3/docker/", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var byName map[string]cadvisorContainer
+	if err := json.NewDecoder(resp.Body).Decode(&byName); err != nil {
+		return nil, "", fmt.Errorf("failed to decode cAdvisor response: %w", err)
+	}
+
+	containers := make([]cadvisorContainer, 0, len(byName))
+	for _, c := range byName {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, servedBy, nil
+}
+
+// cadvisorIdentity returns the agent-style container ID and name for a cAdvisor entry.
+// cAdvisor names Docker containers /docker/<id> and lists the Docker name and ID as aliases.
+func cadvisorIdentity(c cadvisorContainer) (id, name string) {
+	id = c.Name[strings.LastIndex(c.Name, "/")+1:]
+	for _, alias := range c.Aliases {
+		if alias != id {
+			name = alias
+			break
+		}
+	}
+	if name == "" {
+		name = id
+	}
+	return id, "/" + strings.TrimPrefix(name, "/")
+}
+
+// fetchCAdvisorMetrics maps cAdvisor stats into agent metric samples within the time range.
+// CPU is derived from consecutive cumulative usage samples, so a sample without a usable previous
+// one (the oldest, or the first after a counter reset) is left out; network and disk stay
+// cumulative like the agent's.
+func (d *Datasource) fetchCAdvisorMetrics(ctx context.Context, host HostConfig, timeRange backend.TimeRange) ([]ContainerMetric, string, error) {
+	containers, servedBy, err := d.fetchCAdvisorContainers(ctx, host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]ContainerMetric, 0)
+	for _, c := range containers {
+		id, name := cadvisorIdentity(c)
+		for i, s := range c.Stats {
+			if i == 0 || s.Timestamp.Before(timeRange.From) || s.Timestamp.After(timeRange.To) {
+				continue
+			}
+			prev := c.Stats[i-1]
+			elapsed := s.Timestamp.Sub(prev.Timestamp).Nanoseconds()
+			if elapsed <= 0 || s.CPU.Usage.Total < prev.CPU.Usage.Total {
+				continue
+			}
+
+			m := ContainerMetric{
+				ContainerID:    id,
+				ContainerName:  name,
+				Timestamp:      SampleTime{Time: s.Timestamp.UTC()},
+				MemoryBytes:    float64(s.Memory.Usage),
+				NetworkRxBytes: float64(s.Network.RxBytes),
+				NetworkTxBytes: float64(s.Network.TxBytes),
+				CPUPercent:     float64(s.CPU.Usage.Total-prev.CPU.Usage.Total) / float64(elapsed) * 100,
+				IsRunning:      true,
+			}
+
+			if len(s.Network.Interfaces) > 0 {
+				m.NetworkRxBytes, m.NetworkTxBytes = 0, 0
+				for _, iface := range s.Network.Interfaces {
+					m.NetworkRxBytes += float64(iface.RxBytes)
+					m.NetworkTxBytes += float64(iface.TxBytes)
+				}
+			}
+			for _, dev := range s.DiskIO.IOServiceBytes {
+				m.DiskReadBytes += float64(dev.Stats["Read"])
+				m.DiskWriteBytes += float64(dev.Stats["Write"])
+			}
+
+			// cAdvisor reports an effectively unlimited limit as a huge value; only use real limits
+			if limit := c.Spec.Memory.Limit; limit > 0 && limit < 1<<62 {
+				m.MemoryPercent = float64(s.Memory.Usage) / float64(limit) * 100
+			}
+			if !c.Spec.CreationTime.IsZero() {
+				m.UptimeSeconds = s.Timestamp.Sub(c.Spec.CreationTime).Seconds()
+			}
+			result = append(result, m)
+		}
+	}
+
+	return result, servedBy, nil
+}
+
+// fetchCAdvisorContainerList lists cAdvisor containers in the agent's /api/containers shape.
+// cAdvisor only tracks live containers, so every entry is reported as running.
+func (d *Datasource) fetchCAdvisorContainerList(ctx context.Context, host HostConfig) ([]ContainerInfo, error) {
+	containers, _, err := d.fetchCAdvisorContainers(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		id, name := cadvisorIdentity(c)
+		result = append(result, ContainerInfo{
+			ContainerID:   id,
+			ContainerName: name,
+			State:         "Running",
+			IsRunning:     true,
+			Image:         c.Spec.Image,
+			Labels:        c.Spec.Labels,
+		})
+	}
+	return result, nil
+}
+
+// fetchCAdvisorInfo builds agent info from cAdvisor's attributes. cAdvisor is read-only
+// and has no PSI or log support.
+func (d *Datasource) fetchCAdvisorInfo(ctx context.Context, host HostConfig) (*AgentInfo, error) {
+	resp, _, err := d.doHostRequest(ctx, host, http.MethodGet, "/api/v2.0/attributes", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var attrs cadvisorAttributes
+	if err := json.NewDecoder(resp.Body).Decode(&attrs); err != nil {
+		return nil, err
+	}
+
+	noSupport := false
+	return &AgentInfo{
+		Hostname:         host.Name,
+		AgentVersion:     "cadvisor " + attrs.CAdvisorVersion,
+		DockerVersion:    attrs.DockerVersion,
+		DockerConnected:  true,
+		OS:               attrs.ContainerOSVersion,
+		KernelVersion:    attrs.KernelVersion,
+		Runtime:          RuntimeDocker,
+		CPUCount:         attrs.NumCores,
+		MemoryTotalBytes: attrs.MemoryCapacity,
+		Capabilities: &AgentCapabilities{
+			SupportsLogs:     &noSupport,
+			SupportsControls: &noSupport,
+		},
+	}, nil
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// capabilitiesTTL controls how long discovered host capabilities are cached
+const capabilitiesTTL = 5 * time.Minute
+
+// psiMetrics lists metrics that require PSI support on the agent host
+var psiMetrics = []string{
+	"cpuPressureSome", "cpuPressureFull",
+	"memoryPressureSome", "memoryPressureFull",
+	"ioPressureSome", "ioPressureFull",
+}
+
+// AgentCapabilities is the optional capabilities block reported by newer agents in /api/info
+type AgentCapabilities struct {
+	SupportsLogs     *bool `json:"supportsLogs"`
+	SupportsControls *bool `json:"supportsControls"`
+	RetentionSeconds int64 `json:"retentionSeconds"`
+}
+
+// HostCapabilities describes what a single agent host supports
+type HostCapabilities struct {
+	HostID              string    `json:"hostId"`
+	HostName            string    `json:"hostName"`
+	Reachable           bool      `json:"reachable"`
+	Error               string    `json:"error,omitempty"`
+	AgentVersion        string    `json:"agentVersion"`
+	SupportsPSI         bool      `json:"supportsPsi"`
+	SupportsLogs        bool      `json:"supportsLogs"`
+	SupportsControls    bool      `json:"supportsControls"`
+	Runtime             string    `json:"runtime"`
+	Orchestrator        string    `json:"orchestrator,omitempty"`
+	InstanceID          string    `json:"instanceId,omitempty"`
+	SupportsPods        bool      `json:"supportsPods"`
+	SupportsSwarm       bool      `json:"supportsSwarm"`
+	SupportsNamespaces  bool      `json:"supportsNamespaces"`
+	MaxRetentionSeconds int64     `json:"maxRetentionSeconds"`
+	SupportedMetrics    []string  `json:"supportedMetrics"`
+	FetchedAt           time.Time `json:"fetchedAt"`
+}
+
+// capabilityCache caches capabilities per host ID
+type capabilityCache struct {
+	mu      sync.Mutex
+	entries map[string]HostCapabilities
+}
+
+func newCapabilityCache() *capabilityCache {
+	return &capabilityCache{entries: make(map[string]HostCapabilities)}
+}
+
+// get returns cached capabilities if they are still fresh
+func (c *capabilityCache) get(hostID string) (HostCapabilities, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	caps, ok := c.entries[hostID]
+	if !ok || time.Since(caps.FetchedAt) > capabilitiesTTL {
+		return HostCapabilities{}, false
+	}
+	return caps, true
+}
+
+func (c *capabilityCache) set(caps HostCapabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[caps.HostID] = caps
+}
+
+func (c *capabilityCache) invalidate(hostID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, hostID)
+}
+
+// capabilitiesFromAgentInfo derives host capabilities from an /api/info response.
+// Agents that predate the capabilities block are assumed to support controls but not logs.
+func capabilitiesFromAgentInfo(host HostConfig, info *AgentInfo) HostCapabilities {
+	caps := HostCapabilities{
+		HostID:           host.ID,
+		HostName:         host.Name,
+		Reachable:        true,
+		AgentVersion:     info.AgentVersion,
+		SupportsPSI:      info.PsiSupported,
+		SupportsControls: true,
+		Runtime:          info.Runtime,
+		Orchestrator:     info.Orchestrator,
+		InstanceID:       info.InstanceID,
+		FetchedAt:        time.Now(),
+	}
+
+	// Agents that predate runtime detection only ever ran against Docker
+	if caps.Runtime == "" {
+		caps.Runtime = RuntimeDocker
+	}
+	caps.SupportsPods = caps.Runtime == RuntimePodman
+	caps.SupportsSwarm = caps.Runtime == RuntimeDocker
+	caps.SupportsNamespaces = caps.Runtime == RuntimeContainerd
+
+	if info.Capabilities != nil {
+		if info.Capabilities.SupportsLogs != nil {
+			caps.SupportsLogs = *info.Capabilities.SupportsLogs
+		}
+		if info.Capabilities.SupportsControls != nil {
+			caps.SupportsControls = *info.Capabilities.SupportsControls
+		}
+		caps.MaxRetentionSeconds = info.Capabilities.RetentionSeconds
+	}
+
+	caps.SupportedMetrics = make([]string, 0, len(AllMetrics))
+	for _, m := range AllMetrics {
+		if !caps.SupportsPSI && contains(psiMetrics, m) {
+			continue
+		}
+		caps.SupportedMetrics = append(caps.SupportedMetrics, m)
+	}
+
+	return caps
+}
+
+// getHostCapabilities returns capabilities for a host, fetching from the agent when the cache is stale
+func (d *Datasource) getHostCapabilities(ctx context.Context, host HostConfig) HostCapabilities {
+	if caps, ok := d.capabilities.get(host.ID); ok {
+		return caps
+	}
+
+	info, err := d.fetchAgentInfoFromHost(ctx, host)
+	if err != nil {
+		d.logger.Warn("Failed to fetch agent capabilities",
+			"host", host.Name,
+			"error", err,
+		)
+		// Unreachable hosts are not cached so the next request retries
+		return HostCapabilities{
+			HostID:           host.ID,
+			HostName:         host.Name,
+			Error:            err.Error(),
+			SupportedMetrics: []string{},
+			FetchedAt:        time.Now(),
+		}
+	}
+
+	caps := capabilitiesFromAgentInfo(host, info)
+	d.capabilities.set(caps)
+	return caps
+}
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// chaosEnvVar must be "true" on the Grafana server before chaos settings take effect, so a
+// datasource copied from a development instance can't inject faults in production
+const chaosEnvVar = "DOCKERMETRICS_ALLOW_CHAOS"
+
+// chaosHeader marks responses whose failure or payload was injected
+const chaosHeader = "X-Dockermetrics-Chaos"
+
+// ChaosSettings injects faults into agent requests so dashboard authors can check how their
+// panels behave when some hosts are slow, failing or answering garbage. Rates are the share
+// of requests affected, from 0 to 1.
+type ChaosSettings struct {
+	Enabled bool `json:"enabled"`
+	// LatencyMs delays every affected request by up to this many milliseconds
+	LatencyMs int `json:"latencyMs"`
+	// ErrorRate answers requests with ErrorStatus (default 503) without reaching the agent
+	ErrorRate   float64 `json:"errorRate"`
+	ErrorStatus int     `json:"errorStatus"`
+	// MalformedRate truncates agent payloads so they fail to decode
+	MalformedRate float64 `json:"malformedRate"`
+	// HostIDs and Paths limit faults to these hosts and agent path prefixes, empty for all
+	HostIDs []string `json:"hostIds"`
+	Paths   []string `json:"paths"`
+}
+
+// chaosAllowed reports whether the server permits fault injection
+func chaosAllowed() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv(chaosEnvVar)), "true")
+}
+
+// validateChaos reports fault injection that is on, or configured but refused by the server
+func validateChaos(s ChaosSettings) []string {
+	if !s.Enabled {
+		return nil
+	}
+	if !chaosAllowed() {
+		return []string{fmt.Sprintf("chaos fault injection is ignored, %s is not set on the Grafana server", chaosEnvVar)}
+	}
+	warnings := []string{"chaos fault injection is active, agent requests fail on purpose"}
+	for name, rate := range map[string]float64{"error": s.ErrorRate, "malformed": s.MalformedRate} {
+		if rate < 0 || rate > 1 {
+			warnings = append(warnings, fmt.Sprintf("chaos %s rate %v is outside 0-1", name, rate))
+		}
+	}
+	return warnings
+}
+
+// chaosInjector applies ChaosSettings to agent requests, nil when fault injection is off
+type chaosInjector struct {
+	settings ChaosSettings
+	chance   func() float64
+}
+
+// newChaosInjector returns the injector of s when the settings and the server allow it
+func newChaosInjector(s ChaosSettings) *chaosInjector {
+	if !s.Enabled || !chaosAllowed() {
+		return nil
+	}
+	return &chaosInjector{settings: s, chance: rand.Float64}
+}
+
+// affects reports whether faults apply to a request to host
+func (c *chaosInjector) affects(host HostConfig, req *http.Request) bool {
+	if len(c.settings.HostIDs) > 0 && !contains(c.settings.HostIDs, host.ID) {
+		return false
+	}
+	if len(c.settings.Paths) == 0 {
+		return true
+	}
+	for _, p := range c.settings.Paths {
+		if strings.HasPrefix(req.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// do sends req with client, delaying it, failing it or truncating its payload as configured.
+// Injected failures happen before the request is sent, so the agent never acts on them.
+func (c *chaosInjector) do(client *http.Client, host HostConfig, req *http.Request) (*http.Response, error) {
+	if c == nil || !c.affects(host, req) {
+		return client.Do(req)
+	}
+	if c.settings.LatencyMs > 0 {
+		delay := time.Duration(c.chance() * float64(c.settings.LatencyMs) * float64(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	if c.settings.ErrorRate > 0 && c.chance() < c.settings.ErrorRate {
+		status := c.settings.ErrorStatus
+		if status < 400 || status > 599 {
+			status = http.StatusServiceUnavailable
+		}
+		body := "injected fault"
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{chaosHeader: []string{"error"}, "Content-Type": []string{"text/plain"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil || c.settings.MalformedRate <= 0 || c.chance() >= c.settings.MalformedRate {
+		return resp, err
+	}
+	payload, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// Half a document fails to decode like a payload cut off by a dying agent
+	payload = payload[:len(payload)/2]
+	resp.Body = io.NopCloser(bytes.NewReader(payload))
+	resp.ContentLength = int64(len(payload))
+	resp.Header = resp.Header.Clone()
+	resp.Header.Del("Content-Length")
+	resp.Header.Set(chaosHeader, "malformed")
+	return resp, nil
+}
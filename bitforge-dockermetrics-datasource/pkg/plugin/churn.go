@@ -0,0 +1,81 @@
+package plugin
+
+import "time"
+
+const (
+	// churnWindow is how far back container starts and stops count towards a host's churn
+	churnWindow = 5 * time.Minute
+	// busyChurn is the number of events within the window that makes a host busy
+	busyChurn = 3
+	// churnStopAfter counts a container as stopped once its newest sample is this old
+	churnStopAfter = 30 * time.Second
+	// quietPollFactor slows polling of hosts without events for a whole window
+	quietPollFactor = 4
+	// maxAdaptiveInterval is the bundled collector's interval, slower polling would delay points
+	maxAdaptiveInterval = 10 * time.Second
+)
+
+// churnTracker derives container starts and stops from the samples a stream polls
+type churnTracker struct {
+	since   time.Time
+	primed  bool                 // the first poll's containers were running before the stream
+	seen    map[string]time.Time // container ID -> newest sample
+	stopped map[string]time.Time // container ID -> when it was counted as stopped
+	events  []time.Time          // starts and stops within churnWindow
+}
+
+func newChurnTracker(now time.Time) *churnTracker {
+	return &churnTracker{since: now, seen: make(map[string]time.Time), stopped: make(map[string]time.Time)}
+}
+
+// observe records the starts and stops a poll's samples show. Containers without samples
+// for churnStopAfter have stopped; they count as started when samples appear again.
+func (c *churnTracker) observe(samples []ContainerMetric, now time.Time) {
+	for _, m := range samples {
+		newest, known := c.seen[m.ContainerID]
+		if !m.Timestamp.After(newest) {
+			continue
+		}
+		_, wasStopped := c.stopped[m.ContainerID]
+		if c.primed && (!known || wasStopped) {
+			c.events = append(c.events, now)
+		}
+		delete(c.stopped, m.ContainerID)
+		c.seen[m.ContainerID] = m.Timestamp.Time
+	}
+	c.primed = true
+
+	for id, newest := range c.seen {
+		stoppedAt, wasStopped := c.stopped[id]
+		switch {
+		case !wasStopped && now.Sub(newest) > churnStopAfter:
+			c.stopped[id] = now
+			c.events = append(c.events, now)
+		case wasStopped && now.Sub(stoppedAt) > churnWindow:
+			// Long gone, a container with this ID later is a new start
+			delete(c.stopped, id)
+			delete(c.seen, id)
+		}
+	}
+
+	cutoff := now.Add(-churnWindow)
+	kept := c.events[:0]
+	for _, t := range c.events {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	c.events = kept
+}
+
+// interval adapts a stream's poll interval to the host's churn: busy hosts are polled twice
+// as often, hosts without events for a whole window up to four times less often
+func (c *churnTracker) interval(base time.Duration, now time.Time) time.Duration {
+	switch {
+	case len(c.events) >= busyChurn:
+		return max(base/2, minStreamInterval)
+	case len(c.events) == 0 && now.Sub(c.since) >= churnWindow:
+		return max(base, min(base*quietPollFactor, maxAdaptiveInterval))
+	}
+	return base
+}
@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultClockSkewWarn = 30 * time.Second
+	// minClockSkewWarn is the smallest usable threshold: the Date header has one second
+	// resolution and agents may cache it for another second
+	minClockSkewWarn = 2 * time.Second
+)
+
+// ClockSkewSettings controls detection of agents whose clock differs from Grafana's
+type ClockSkewSettings struct {
+	WarnSeconds int  `json:"warnSeconds"` // skew that adds a notice to the host's frames, default 30
+	Correct     bool `json:"correct"`     // shift the host's timestamps by the measured skew
+}
+
+// clockSkews remembers the clock skew last measured for each host: agent time minus
+// Grafana time, estimated from the Date header of agent responses
+type clockSkews struct {
+	mu    sync.Mutex
+	skews map[string]time.Duration // host ID -> skew
+}
+
+func newClockSkews() *clockSkews {
+	return &clockSkews{skews: make(map[string]time.Duration)}
+}
+
+// record estimates the skew from a response received at received for a request sent at sent,
+// taking the middle of the round trip as the moment the agent stamped its Date header.
+// Responses from the host's fallback agent are ignored, since it runs on another machine.
+func (c *clockSkews) record(host HostConfig, baseURL string, resp *http.Response, sent, received time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil || fallbackURL(host, baseURL) != "" {
+		return
+	}
+	// The header is truncated to the second, so its mean error is half a second
+	agentTime := date.Add(500 * time.Millisecond)
+	skew := agentTime.Sub(sent.Add(received.Sub(sent) / 2))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skews[host.ID] = skew
+}
+
+// skew returns the host's last measured skew
+func (c *clockSkews) skew(hostID string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	skew, ok := c.skews[hostID]
+	return skew, ok
+}
+
+// clockSkewWarn returns the configured skew threshold
+func (d *Datasource) clockSkewWarn() time.Duration {
+	warn := time.Duration(d.settings.ClockSkew.WarnSeconds) * time.Second
+	if warn <= 0 {
+		return defaultClockSkewWarn
+	}
+	if warn < minClockSkewWarn {
+		return minClockSkewWarn
+	}
+	return warn
+}
+
+// hostClockSkew returns the host's skew if it exceeds the threshold, otherwise 0. Prometheus
+// mode hosts are stamped at scrape time by the backend and never skewed.
+func (d *Datasource) hostClockSkew(host HostConfig) time.Duration {
+	if host.Mode == HostModePrometheus {
+		return 0
+	}
+	skew, ok := d.skews.skew(host.ID)
+	if !ok || (skew < d.clockSkewWarn() && skew > -d.clockSkewWarn()) {
+		return 0
+	}
+	return skew.Round(time.Second)
+}
+
+// skewCorrection returns the offset to remove from the host's timestamps, 0 unless
+// correction is enabled and the host is skewed beyond the threshold
+func (d *Datasource) skewCorrection(host HostConfig) time.Duration {
+	if !d.settings.ClockSkew.Correct {
+		return 0
+	}
+	return d.hostClockSkew(host)
+}
+
+// shiftTimeRange moves a Grafana time range onto a skewed agent's clock
+func shiftTimeRange(timeRange backend.TimeRange, skew time.Duration) backend.TimeRange {
+	return backend.TimeRange{From: timeRange.From.Add(skew), To: timeRange.To.Add(skew)}
+}
+
+// correctClockSkew moves sample timestamps from a skewed agent's clock to Grafana's
+func correctClockSkew(metrics []ContainerMetric, skew time.Duration) []ContainerMetric {
+	if skew == 0 {
+		return metrics
+	}
+	for i := range metrics {
+		metrics[i].Timestamp = SampleTime{Time: metrics[i].Timestamp.Add(-skew)}
+	}
+	return metrics
+}
+
+// clockSkewNotice describes a skewed host; nil when the skew is within the threshold
+func (d *Datasource) clockSkewNotice(hostName string, skew time.Duration) *data.Notice {
+	if skew == 0 {
+		return nil
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction, skew = "behind", -skew
+	}
+	text := fmt.Sprintf("Host %s clock is %s %s Grafana, series may appear shifted", hostName, skew, direction)
+	if d.settings.ClockSkew.Correct {
+		text = fmt.Sprintf("Host %s clock is %s %s Grafana, timestamps were corrected", hostName, skew, direction)
+	}
+	return &data.Notice{Severity: data.NoticeSeverityWarning, Text: text}
+}
@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// composeProjectRow is one row of a "composeProjects" query
+type composeProjectRow struct {
+	name                        string
+	hosts, services             map[string]bool
+	containers, running, paused int64
+	unhealthy                   int64
+	values                      map[string][]float64 // metric -> newest value of each container
+}
+
+// queryComposeProjects returns one row per compose project across the selected hosts: the
+// hosts and services it runs, its containers by state and, per metric (default cpuPercent and
+// memoryBytes), the newest sample of each of its containers reduced with the query's
+// aggregation (default sum), for stack-level panels. Containers outside a project form the
+// "(none)" row.
+func (d *Datasource) queryComposeProjects(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	fn := qm.Aggregation
+	switch fn {
+	case "":
+		fn = AggregationSum
+	case AggregationSum, AggregationAvg, AggregationMin, AggregationMax, AggregationCount:
+	default:
+		response.Error = fmt.Errorf("aggregation must be sum, avg, min, max or count")
+		return response
+	}
+	metrics := qm.Metrics
+	if len(metrics) == 0 {
+		metrics = []string{"cpuPercent", "memoryBytes"}
+	}
+	for _, metric := range metrics {
+		if !contains(AllMetrics, metric) {
+			response.Error = fmt.Errorf("unknown metric %q", metric)
+			return response
+		}
+	}
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	projects := make(map[string]*composeProjectRow)
+	project := func(name string) *composeProjectRow {
+		if name == "" {
+			name = "(none)"
+		}
+		p := projects[name]
+		if p == nil {
+			p = &composeProjectRow{name: name, hosts: make(map[string]bool), services: make(map[string]bool), values: make(map[string][]float64)}
+			projects[name] = p
+		}
+		return p
+	}
+
+	notices := make([]data.Notice, 0)
+	answered := false
+	var lastErr error
+	for _, host := range hosts {
+		containers, err := d.fetchContainersFromHost(ctx, host)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch containers from host", err)
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+		answered = true
+		for _, c := range containers {
+			if containerPattern != nil && !containerPattern.MatchString(c.ContainerName) {
+				continue
+			}
+			if len(qm.ContainerIDs) > 0 && !contains(qm.ContainerIDs, c.ContainerID) {
+				continue
+			}
+			if len(qm.Namespaces) > 0 && !contains(qm.Namespaces, c.Namespace) {
+				continue
+			}
+			labels := containerSeriesLabels(c)
+			if !matchesLabelFilters(labels, qm.LabelFilters) {
+				continue
+			}
+			p := project(labels["composeProject"])
+			p.hosts[host.ID] = true
+			if service := c.Labels[composeServiceLabel]; service != "" {
+				p.services[service] = true
+			}
+			p.containers++
+			if c.IsRunning {
+				p.running++
+			}
+			if c.IsPaused {
+				p.paused++
+			}
+			if c.IsUnhealthy {
+				p.unhealthy++
+			}
+		}
+	}
+	if !answered {
+		response.Error = lastErr
+		return response
+	}
+
+	// Values are per container, aggregated series would be counted as one container
+	qm.AggregateBy = ""
+	for _, mwh := range d.collectMetrics(ctx, hosts, qm, query.TimeRange, metrics) {
+		for _, m := range latestPerContainer(mwh.Metrics) {
+			p := project(mwh.ContainerLabels[m.ContainerID]["composeProject"])
+			for _, metric := range metrics {
+				if value, ok := displayMetricValue(m, metric); ok {
+					p.values[metric] = append(p.values[metric], value)
+				}
+			}
+		}
+	}
+
+	rows := make([]*composeProjectRow, 0, len(projects))
+	for _, p := range projects {
+		rows = append(rows, p)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].name < rows[j].name })
+
+	n := len(rows)
+	names := make([]string, n)
+	hostCounts := make([]int64, n)
+	serviceCounts := make([]int64, n)
+	total := make([]int64, n)
+	running := make([]int64, n)
+	paused := make([]int64, n)
+	unhealthy := make([]int64, n)
+	for i, p := range rows {
+		names[i] = p.name
+		hostCounts[i] = int64(len(p.hosts))
+		serviceCounts[i] = int64(len(p.services))
+		total[i] = p.containers
+		running[i] = p.running
+		paused[i] = p.paused
+		unhealthy[i] = p.unhealthy
+	}
+	frame := data.NewFrame("composeProjects",
+		data.NewField("composeProject", nil, names),
+		data.NewField("hosts", nil, hostCounts),
+		data.NewField("services", nil, serviceCounts),
+		data.NewField("containers", nil, total),
+		data.NewField("running", nil, running),
+		data.NewField("paused", nil, paused),
+		data.NewField("unhealthy", nil, unhealthy),
+	)
+	for _, metric := range metrics {
+		scale, unit, decimals := d.metricFormat(metric)
+		values := make([]*float64, n)
+		for i, p := range rows {
+			if samples := p.values[metric]; len(samples) > 0 {
+				value := reduceValues(fn, samples)
+				if fn != AggregationCount {
+					value *= scale
+				}
+				values[i] = &value
+			}
+		}
+		displayName := metricDisplayNames[metric]
+		if displayName == "" {
+			displayName = metric
+		}
+		field := data.NewField(metric, nil, values)
+		field.Config = &data.FieldConfig{DisplayNameFromDS: displayName}
+		if fn != AggregationCount {
+			field.Config.Unit, field.Config.Decimals = unit, decimals
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Notices:                notices,
+		Custom:                 map[string]interface{}{"queryType": "composeProjects"},
+	}
+	response.Frames = data.Frames{frame}
+	return response
+}
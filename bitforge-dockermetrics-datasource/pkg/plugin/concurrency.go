@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultFetchConcurrency = 8
+	// defaultHostTimeout bounds one host's share of a query, well below the agent client's
+	// 30 second timeout so a slow agent doesn't hold up the panel
+	defaultHostTimeout = 10 * time.Second
+)
+
+// fetchConcurrency returns how many hosts a query fetches from at once
+func (d *Datasource) fetchConcurrency() int {
+	if d.settings.FetchConcurrency > 0 {
+		return d.settings.FetchConcurrency
+	}
+	return defaultFetchConcurrency
+}
+
+// hostTimeout returns the time a query waits for one host
+func (d *Datasource) hostTimeout() time.Duration {
+	if d.settings.HostTimeoutSeconds > 0 {
+		return time.Duration(d.settings.HostTimeoutSeconds) * time.Second
+	}
+	return defaultHostTimeout
+}
+
+// forEachHost calls fetch for every host, fetchConcurrency at a time, each with a context
+// that expires after hostTimeout. fetch stores its result by index, so results stay in host
+// order; host failures are fetch's to record and never cancel the other hosts.
+func (d *Datasource) forEachHost(ctx context.Context, hosts []HostConfig, fetch func(ctx context.Context, i int, host HostConfig)) {
+	var g errgroup.Group
+	g.SetLimit(d.fetchConcurrency())
+	for i, host := range hosts {
+		i, host := i, host
+		g.Go(func() error {
+			hostCtx, cancel := context.WithTimeout(ctx, d.hostTimeout())
+			defer cancel()
+			fetch(hostCtx, i, host)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// containerCacheKey stores a per-request container list cache in the context, so building
+// series labels and the containers frame for the same query fetch each host's list once
+type containerCacheKey struct{}
+
+type containerCache struct {
+	mu      sync.Mutex
+	entries map[string][]ContainerInfo
+}
+
+// withContainerCache returns a context that caches container lists for one request
+func withContainerCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, containerCacheKey{}, &containerCache{entries: make(map[string][]ContainerInfo)})
+}
+
+func containerCacheFrom(ctx context.Context) *containerCache {
+	cache, _ := ctx.Value(containerCacheKey{}).(*containerCache)
+	return cache
+}
+
+// Container runtimes reported by the agent
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+)
+
+// Orchestrators the agent detects it is running under; empty when standalone
+const (
+	OrchestratorECS   = "ecs"
+	OrchestratorNomad = "nomad"
+)
+
+// Container labels set by Docker Compose (and podman-compose) on every service container
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	// composeDependsOnLabel lists the services a service depends on, set by Compose v2.20 and later
+	composeDependsOnLabel = "com.docker.compose.depends_on"
+)
+
+// Container labels set by the ECS container agent. ECS does not label containers with the
+// service name, so the task definition family is the closest grouping for a service's tasks.
+const (
+	ecsClusterLabel   = "com.amazonaws.ecs.cluster"
+	ecsTaskARNLabel   = "com.amazonaws.ecs.task-arn"
+	ecsFamilyLabel    = "com.amazonaws.ecs.task-definition-family"
+	ecsRevisionLabel  = "com.amazonaws.ecs.task-definition-version"
+	ecsContainerLabel = "com.amazonaws.ecs.container-name"
+)
+
+// Container labels set by Nomad's docker driver
+const (
+	nomadJobLabel       = "com.hashicorp.nomad.job_name"
+	nomadAllocLabel     = "com.hashicorp.nomad.alloc_id"
+	nomadTaskGroupLabel = "com.hashicorp.nomad.task_group_name"
+	nomadTaskLabel      = "com.hashicorp.nomad.task_name"
+	nomadNamespaceLabel = "com.hashicorp.nomad.namespace"
+)
+
+// Container labels set by Docker on swarm task containers, whose names end in a random task ID
+const (
+	swarmServiceNameLabel = "com.docker.swarm.service.name"
+	swarmTaskNameLabel    = "com.docker.swarm.task.name"
+	swarmNodeIDLabel      = "com.docker.swarm.node.id"
+)
+
+// containerSeriesLabels returns the labels a container contributes to its series
+func containerSeriesLabels(c ContainerInfo) map[string]string {
+	labels := make(map[string]string)
+	if c.Pod != "" {
+		labels["pod"] = c.Pod
+	}
+	if c.Namespace != "" {
+		labels["namespace"] = c.Namespace
+	}
+	if project := c.Labels[composeProjectLabel]; project != "" {
+		labels["composeProject"] = project
+	}
+	if service := c.Labels[composeServiceLabel]; service != "" {
+		labels["composeService"] = service
+	}
+	if cluster := c.Labels[ecsClusterLabel]; cluster != "" {
+		labels["ecsCluster"] = arnResource(cluster)
+	}
+	if task := c.Labels[ecsTaskARNLabel]; task != "" {
+		labels["ecsTask"] = arnResource(task)
+	}
+	if family := c.Labels[ecsFamilyLabel]; family != "" {
+		labels["ecsTaskFamily"] = family
+	}
+	if revision := c.Labels[ecsRevisionLabel]; revision != "" {
+		labels["ecsTaskRevision"] = revision
+	}
+	if name := c.Labels[ecsContainerLabel]; name != "" {
+		labels["ecsContainer"] = name
+	}
+	if job := c.Labels[nomadJobLabel]; job != "" {
+		labels["nomadJob"] = job
+	}
+	if alloc := c.Labels[nomadAllocLabel]; alloc != "" {
+		labels["nomadAlloc"] = alloc
+	}
+	if group := c.Labels[nomadTaskGroupLabel]; group != "" {
+		labels["nomadTaskGroup"] = group
+	}
+	if task := c.Labels[nomadTaskLabel]; task != "" {
+		labels["nomadTask"] = task
+	}
+	if ns := c.Labels[nomadNamespaceLabel]; ns != "" {
+		labels["nomadNamespace"] = ns
+	}
+	if service := c.Labels[swarmServiceNameLabel]; service != "" {
+		labels["swarmService"] = service
+	}
+	if task := c.Labels[swarmTaskNameLabel]; task != "" {
+		labels["swarmTask"] = swarmTaskSlot(task)
+	}
+	if node := c.Labels[swarmNodeIDLabel]; node != "" {
+		labels["swarmNode"] = node
+	}
+	return labels
+}
+
+// arnResource returns the last path segment of an ARN, e.g. the cluster name or task ID;
+// plain names are returned unchanged
+func arnResource(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// filterByNamespace keeps samples of containers in one of the namespaces; no namespaces keeps all
+func filterByNamespace(metrics []ContainerMetric, containerLabels map[string]map[string]string, namespaces []string) []ContainerMetric {
+	if len(namespaces) == 0 {
+		return metrics
+	}
+	filtered := make([]ContainerMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if contains(namespaces, containerLabels[m.ContainerID]["namespace"]) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// filterByLabels keeps samples of containers whose series labels match every filter;
+// each filter maps a label name to its accepted values
+func filterByLabels(metrics []ContainerMetric, containerLabels map[string]map[string]string, filters map[string][]string) []ContainerMetric {
+	if len(filters) == 0 {
+		return metrics
+	}
+	filtered := make([]ContainerMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if matchesLabelFilters(containerLabels[m.ContainerID], filters) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+func matchesLabelFilters(labels map[string]string, filters map[string][]string) bool {
+	for name, values := range filters {
+		if len(values) > 0 && !contains(values, labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerLabelsForHost returns the Docker labels per container ID for a host; like the series
+// labels, failures only cost the label selection and are logged at debug level
+func (d *Datasource) dockerLabelsForHost(ctx context.Context, host HostConfig) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	containers, err := d.fetchContainersFromHost(ctx, host)
+	if err != nil {
+		d.logger.Debug("Failed to fetch containers for label selection", "host", host.Name, "error", err)
+		return result
+	}
+	for _, c := range containers {
+		result[c.ContainerID] = c.Labels
+	}
+	return result
+}
+
+// containerLabelsForHost returns series labels per container ID for a host.
+// Failures only cost the extra labels, so they are logged at debug level.
+func (d *Datasource) containerLabelsForHost(ctx context.Context, host HostConfig) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	containers, err := d.fetchContainersFromHost(ctx, host)
+	if err != nil {
+		d.logger.Debug("Failed to fetch containers for series labels", "host", host.Name, "error", err)
+		return result
+	}
+	for _, c := range containers {
+		if labels := containerSeriesLabels(c); len(labels) > 0 {
+			result[c.ContainerID] = labels
+		}
+	}
+	return result
+}
@@ -0,0 +1,3705 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitforge/dockermetrics-datasource/pkg/agentmock"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/klauspost/compress/snappy"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Golden files live in testdata; regenerate them with go test ./pkg/plugin -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// contractStart is the first sample time of the mock agents
+var contractStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// contractTraceID makes the requestId frame meta stable across runs
+var contractTraceID = trace.TraceID{0x0c, 0x0f, 0xfe, 0xe0}
+
+// newContractAgent returns a mock agent with a web and a db container and five samples each,
+// one every 10 seconds
+func newContractAgent(hostname string) *agentmock.Agent {
+	agent := agentmock.New(hostname)
+	agent.AddContainer(agentmock.Container{ID: "web1", Name: "web", Image: "nginx:1.25"})
+	agent.AddContainer(agentmock.Container{ID: "db1", Name: "db", Image: "postgres:16", HealthStatus: agentmock.HealthUnhealthy})
+	for i := 0; i < 5; i++ {
+		t := contractStart.Add(time.Duration(i) * 10 * time.Second)
+		agent.AddSamples(
+			agentmock.Sample{ContainerID: "web1", Time: t, CPUPercent: 10 + float64(i), MemoryBytes: 64 << 20, MemoryPercent: 12.5, UptimeSeconds: 3600 + 10*float64(i)},
+			agentmock.Sample{ContainerID: "db1", Time: t, CPUPercent: 40 + 5*float64(i), MemoryBytes: 512 << 20, MemoryPercent: 50, UptimeSeconds: 60 + 10*float64(i)},
+		)
+	}
+	return agent
+}
+
+// contractHost is a mock agent served over HTTP with its host configuration
+type contractHost struct {
+	agent  *agentmock.Agent
+	config HostConfig
+}
+
+// startContractHosts serves one mock agent per name, as hosts h1, h2, ...
+func startContractHosts(t *testing.T, names ...string) []contractHost {
+	t.Helper()
+	hosts := make([]contractHost, 0, len(names))
+	for i, name := range names {
+		agent := newContractAgent(name)
+		server := httptest.NewServer(agent)
+		t.Cleanup(server.Close)
+		hosts = append(hosts, contractHost{
+			agent:  agent,
+			config: HostConfig{ID: fmt.Sprintf("h%d", i+1), Name: name, URL: server.URL, Enabled: true},
+		})
+	}
+	return hosts
+}
+
+// newContractDatasource creates a datasource for the hosts; extra settings are merged into
+// the JSON data
+func newContractDatasource(t *testing.T, hosts []contractHost, extra map[string]interface{}) *Datasource {
+	t.Helper()
+	return newContractDatasourceInOrg(t, 0, hosts, extra)
+}
+
+// newContractDatasourceInOrg creates the datasource as Grafana does for an org; the UID
+// only depends on the test name
+func newContractDatasourceInOrg(t *testing.T, orgID int64, hosts []contractHost, extra map[string]interface{}) *Datasource {
+	t.Helper()
+	configs := make([]HostConfig, 0, len(hosts))
+	for _, h := range hosts {
+		configs = append(configs, h.config)
+	}
+	settings := map[string]interface{}{"hosts": configs}
+	for k, v := range extra {
+		settings[k] = v
+	}
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+	instance, err := NewDatasource(ctx, backend.DataSourceInstanceSettings{
+		UID:      "contract-" + strings.ReplaceAll(t.Name(), "/", "-"),
+		JSONData: jsonData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := instance.(*Datasource)
+	t.Cleanup(ds.Dispose)
+	return ds
+}
+
+// runContractQuery runs one query over the first 40 seconds of samples
+func runContractQuery(t *testing.T, ds *Datasource, query string) backend.DataResponse {
+	t.Helper()
+	return runContractQueryRange(t, ds, query, backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)})
+}
+
+// runContractQueryRange runs one query over a time range
+func runContractQueryRange(t *testing.T, ds *Datasource, query string, timeRange backend.TimeRange) backend.DataResponse {
+	t.Helper()
+	return runContractDataQuery(t, ds, backend.DataQuery{JSON: json.RawMessage(query), TimeRange: timeRange})
+}
+
+// contractScopeToken signs a scope token for ds with the contract secret, which it installs
+func contractScopeToken(t *testing.T, ds *Datasource, hosts, containers []string) string {
+	t.Helper()
+	if ds.secrets == nil {
+		ds.secrets = map[string]string{}
+	}
+	ds.secrets[scopeSecretKey] = "contract-secret"
+	token, err := signScopeToken("contract-secret", ScopeClaims{Datasource: ds.uid, Hosts: hosts, Containers: containers, IssuedAt: time.Now().Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// runContractDataQuery runs a query as refId A
+func runContractDataQuery(t *testing.T, ds *Datasource, query backend.DataQuery) backend.DataResponse {
+	t.Helper()
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: contractTraceID}))
+	query.RefID = "A"
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Responses["A"]
+}
+
+// callContractResource calls a resource anonymously and returns the status and body
+func callContractResource(t *testing.T, ds *Datasource, method, url, body string) (int, string) {
+	t.Helper()
+	var status int
+	var out []byte
+	path, _, _ := strings.Cut(url, "?")
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		Path:   path,
+		Method: method,
+		URL:    url,
+		Body:   []byte(body),
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		status = resp.Status
+		out = append(out, resp.Body...)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, string(out)
+}
+
+func TestContractGoldenFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		query    string
+	}{
+		{name: "metrics", query: `{"metrics": ["cpuPercent", "memoryBytes"]}`},
+		{name: "metrics_matrix", query: `{"hostSelections": {"h1": {"hostId": "h1", "mode": "whitelist", "containerIds": ["web1"], "containerMetrics": {"web1": ["cpuPercent", "memoryPercent"]}}}}`},
+		{name: "alerting", query: `{"metrics": ["cpuPercent"], "alerting": true}`},
+		{name: "no_match", query: `{"metrics": ["cpuPercent"], "containerNamePattern": "^cache$"}`},
+		{name: "alerting_state_metrics", query: `{"metrics": ["isRunning", "isHealthy"], "alerting": true}`},
+		{name: "state", query: `{"queryType": "state", "states": ["isRunning", "isUnhealthy"]}`},
+		{name: "threshold", query: `{"queryType": "threshold", "thresholdMetric": "cpuPercent", "thresholdOperator": ">", "threshold": 50}`},
+		{name: "containers", query: `{"queryType": "containers"}`},
+		{name: "snapshot", query: `{"metrics": ["cpuPercent"], "containerIds": ["web1"], "snapshot": true}`},
+		{name: "metric_formats", settings: map[string]interface{}{"metricFormats": map[string]interface{}{"memoryBytes": map[string]interface{}{"scale": 0.001, "unit": "decgbytes", "decimals": 2}}}, query: `{"metrics": ["memoryBytes"]}`},
+		{name: "label_pruning", settings: map[string]interface{}{"labelPruning": map[string]interface{}{"drop": []string{"hostName"}, "hash": []string{"containerId"}}}, query: `{"metrics": ["cpuPercent"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newContractDatasource(t, startContractHosts(t, "alpha"), tt.settings)
+			resp := runContractQuery(t, ds, tt.query)
+			experimental.CheckGoldenJSONResponse(t, "testdata", "contract_"+tt.name, &resp, *updateGolden)
+		})
+	}
+}
+
+func TestContractAggregation(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha", "beta"), nil)
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "aggregation": "sum", "groupBy": "host"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_aggregation", &resp, *updateGolden)
+
+	// Without groupBy every container of every host is one series
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "aggregation": "max"}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	if name := resp.Frames[0].Name; name != "max(all) - CPU %" {
+		t.Errorf("frame = %s, want max(all) - CPU %%", name)
+	}
+	if got := resp.Frames[0].Fields[1].At(4); *got.(*float64) != 60 {
+		t.Errorf("newest max = %v, want 60", *got.(*float64))
+	}
+
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "aggregation": "count", "groupBy": "image"}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	for _, f := range resp.Frames[:2] {
+		if got := f.Fields[1].At(0); *got.(*float64) != 2 {
+			t.Errorf("%s counts %v containers, want one per host", f.Name, *got.(*float64))
+		}
+	}
+
+	for _, query := range []string{
+		`{"metrics": ["cpuPercent"], "aggregation": "median"}`,
+		`{"metrics": ["cpuPercent"], "aggregation": "sum", "groupBy": "pod"}`,
+		`{"metrics": ["cpuPercent"], "groupBy": "host"}`,
+	} {
+		if resp := runContractQuery(t, ds, query); resp.Error == nil {
+			t.Errorf("query %s was accepted", query)
+		}
+	}
+}
+
+func TestContractDownsampling(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha"), nil)
+	run := func(query string, maxDataPoints int64, interval time.Duration) backend.DataResponse {
+		t.Helper()
+		return runContractDataQuery(t, ds, backend.DataQuery{
+			JSON:          json.RawMessage(query),
+			TimeRange:     backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+			MaxDataPoints: maxDataPoints,
+			Interval:      interval,
+		})
+	}
+	points := func(resp backend.DataResponse) []float64 {
+		t.Helper()
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		var values []float64
+		for _, f := range resp.Frames {
+			if f.Name == "db - CPU %" {
+				for i := 0; i < f.Rows(); i++ {
+					values = append(values, *f.Fields[1].At(i).(*float64))
+				}
+			}
+		}
+		return values
+	}
+
+	resp := run(`{"metrics": ["cpuPercent"]}`, 2, 0)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_downsampling", &resp, *updateGolden)
+
+	// db1 climbs 40..60 in steps of 5
+	if got := points(run(`{"metrics": ["cpuPercent"], "downsample": "max"}`, 2, 0)); len(got) > 2 || got[len(got)-1] != 60 {
+		t.Errorf("max points = %v, want at most 2 ending at 60", got)
+	}
+	if got := points(run(`{"metrics": ["cpuPercent"], "downsample": "lttb"}`, 3, 0)); len(got) != 3 || got[0] != 40 || got[2] != 60 {
+		t.Errorf("lttb points = %v, want 3 from 40 to 60", got)
+	}
+	if got := points(run(`{"metrics": ["cpuPercent"], "downsample": "lttb"}`, 0, 20*time.Second)); len(got) != 3 {
+		t.Errorf("20s interval kept %v, want 3 points", got)
+	}
+	if got := points(run(`{"metrics": ["cpuPercent"], "downsample": "none"}`, 2, 0)); len(got) != 5 {
+		t.Errorf("downsample none kept %v, want all 5 points", got)
+	}
+	if resp := run(`{"metrics": ["cpuPercent"], "downsample": "median"}`, 2, 0); resp.Error == nil {
+		t.Error("downsample median was accepted")
+	}
+}
+
+func TestContractDuplicateContainerNames(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha", "beta"), nil)
+	resp := runContractQuery(t, ds, `{"hostSelections": {
+		"h1": {"hostId": "h1", "mode": "blacklist", "metrics": ["cpuPercent"], "containerMetrics": {"web1": ["cpuPercent"], "db1": ["cpuPercent"]}},
+		"h2": {"hostId": "h2", "mode": "blacklist", "metrics": ["cpuPercent"], "containerMetrics": {"web1": ["cpuPercent"], "db1": ["cpuPercent"]}}}}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_duplicate_names", &resp, *updateGolden)
+}
+
+func TestContractLegacyPanelQuery(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha", "beta"), map[string]interface{}{
+		"hostMetadataLabels": []string{"os"},
+	})
+	// Old panels stored a single metric and container; frames keep the old names and labels
+	resp := runContractQuery(t, ds, `{"refId": "A", "queryType": "metrics", "metric": "cpuPercent", "containerId": "web1"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_legacy_panel", &resp, *updateGolden)
+}
+
+func TestContractClockSkew(t *testing.T) {
+	for _, correct := range []bool{false, true} {
+		t.Run(fmt.Sprintf("correct=%v", correct), func(t *testing.T) {
+			hosts := startContractHosts(t, "alpha")
+			agent := hosts[0].agent
+			agent.ClockOffset = 2 * time.Minute
+			// What the skewed agent recorded during the query range, on its own clock
+			agent.AddSamples(agentmock.Sample{ContainerID: "web1", Time: contractStart.Add(2*time.Minute + 20*time.Second), CPUPercent: 99})
+			ds := newContractDatasource(t, hosts, map[string]interface{}{"clockSkew": map[string]interface{}{"correct": correct}})
+
+			// The first query measures the skew, the second is corrected
+			runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+			resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+			if resp.Error != nil {
+				t.Fatal(resp.Error)
+			}
+
+			var web *data.Frame
+			for _, f := range resp.Frames {
+				if f.Name == "web - CPU %" {
+					web = f
+				}
+			}
+			if web == nil || len(web.Meta.Notices) == 0 || !strings.Contains(web.Meta.Notices[0].Text, "ahead of Grafana") {
+				t.Fatalf("web frame %v has no clock skew notice", web)
+			}
+			gotCorrected := false
+			for i := 0; i < web.Rows(); i++ {
+				if v, _ := web.Fields[1].ConcreteAt(i); v == 99.0 {
+					gotCorrected = true
+					if ts := web.Fields[0].At(i).(time.Time); ts.Sub(contractStart.Add(20*time.Second)).Abs() > 2*time.Second {
+						t.Fatalf("corrected sample at %v, want about %v", ts, contractStart.Add(20*time.Second))
+					}
+				}
+			}
+			if gotCorrected != correct {
+				t.Fatalf("skewed sample returned: %v, want %v", gotCorrected, correct)
+			}
+		})
+	}
+}
+
+func TestContractOrgIsolation(t *testing.T) {
+	alpha := startContractHosts(t, "alpha")
+	beta := startContractHosts(t, "beta")
+	beta[0].agent.Version = "2.0.0-mock"
+	// Same datasource UID and host ID in two orgs
+	ds1 := newContractDatasourceInOrg(t, 1, alpha, nil)
+	ds2 := newContractDatasourceInOrg(t, 2, beta, nil)
+
+	versions := func(ds *Datasource, orgID int64) (int, VersionInfo) {
+		var status int
+		var info VersionInfo
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{OrgID: orgID},
+			Path:          "version",
+			Method:        "GET",
+			URL:           "version",
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status = resp.Status
+			return json.Unmarshal(resp.Body, &info)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status, info
+	}
+
+	if _, info := versions(ds2, 2); info.Agents["h1"].AgentVersion != "2.0.0-mock" {
+		t.Fatalf("org 2 sees agent %+v, want its own agent", info.Agents["h1"])
+	}
+	if _, info := versions(ds1, 1); info.Agents["h1"].AgentVersion != "1.0.0-mock" {
+		t.Fatalf("org 1 sees agent %+v cached for org 2", info.Agents["h1"])
+	}
+	if status, _ := versions(ds1, 2); status != 403 {
+		t.Fatalf("request from org 2 to an org 1 datasource returned status %d, want 403", status)
+	}
+}
+
+func TestContractLogs(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	// beta runs an agent without logs support
+	alpha := hosts[0].agent
+	alpha.LogsSupported = true
+	alpha.AddLogs(
+		agentmock.LogLine{ContainerID: "web1", Time: contractStart.Add(5 * time.Second), Stream: "stdout", Message: `GET /index.html 200`},
+		agentmock.LogLine{ContainerID: "db1", Time: contractStart.Add(10 * time.Second), Stream: "stderr", Message: `WARNING: could not flush dirty data`},
+		agentmock.LogLine{ContainerID: "db1", Time: contractStart.Add(20 * time.Second), Stream: "stderr", Message: `connection reset by peer`},
+		agentmock.LogLine{ContainerID: "web1", Time: contractStart.Add(time.Hour), Stream: "stdout", Message: `outside the range`},
+	)
+
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"featureToggles": map[string]bool{"logs": true}})
+	resp := runContractQuery(t, ds, `{"queryType": "logs"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_logs", &resp, *updateGolden)
+
+	disabled := newContractDatasource(t, hosts, nil)
+	if resp := runContractQuery(t, disabled, `{"queryType": "logs"}`); resp.Error == nil {
+		t.Fatal("logs query succeeded with the logs feature off")
+	}
+}
+
+func TestContractSummary(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	// beta runs an agent that doesn't report host capacity
+	alpha := hosts[0].agent
+	alpha.CPUCount = 4
+	alpha.MemoryTotalBytes = 8 << 30
+	alpha.AddContainer(agentmock.Container{ID: "cache1", Name: "cache", Image: "redis:7", State: agentmock.StatePaused})
+	alpha.AddContainer(agentmock.Container{ID: "worker1", Name: "worker", Image: "busybox", State: agentmock.StateRestarting})
+
+	ds := newContractDatasource(t, hosts, nil)
+	resp := runContractQuery(t, ds, `{"queryType": "summary"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_summary", &resp, *updateGolden)
+}
+
+func TestContractLifecycle(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	// web stops reporting for 70s (exited) and is gone again after its last sample
+	hosts[0].agent.AddSamples(
+		agentmock.Sample{ContainerID: "web1", Time: contractStart.Add(110 * time.Second), CPUPercent: 10, UptimeSeconds: 5},
+		agentmock.Sample{ContainerID: "web1", Time: contractStart.Add(120 * time.Second), CPUPercent: 10, UptimeSeconds: 15},
+	)
+	ds := newContractDatasource(t, hosts, nil)
+
+	resp := runContractQueryRange(t, ds, `{"queryType": "lifecycle"}`, backend.TimeRange{From: contractStart, To: contractStart.Add(200 * time.Second)})
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_lifecycle", &resp, *updateGolden)
+
+	frame := resp.Frames[0]
+	_, web := frame.FieldByName("containerName")
+	for i := 0; i < frame.Rows(); i++ {
+		if frame.Fields[web].At(i) != "web" {
+			continue
+		}
+		running, _ := frame.FieldByName("runningSeconds")
+		exited, _ := frame.FieldByName("exitedSeconds")
+		transitions, _ := frame.FieldByName("transitions")
+		if running.At(i) != 50.0 || exited.At(i) != 150.0 || transitions.At(i) != int64(3) {
+			t.Errorf("web ran %vs, exited %vs with %v transitions, want 50s, 150s and 3", running.At(i), exited.At(i), transitions.At(i))
+		}
+	}
+}
+
+func TestContractReport(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	// web restarts (its uptime drops) after 70s without samples
+	hosts[0].agent.AddSamples(
+		agentmock.Sample{ContainerID: "web1", Time: contractStart.Add(110 * time.Second), CPUPercent: 10, MemoryBytes: 64 << 20, UptimeSeconds: 5},
+		agentmock.Sample{ContainerID: "web1", Time: contractStart.Add(120 * time.Second), CPUPercent: 10, MemoryBytes: 64 << 20, UptimeSeconds: 15},
+	)
+	ds := newContractDatasource(t, hosts, nil)
+	call := func(format, body string) (int, []byte) {
+		var status int
+		var respBody []byte
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			Path:   "report",
+			Method: http.MethodPost,
+			URL:    "report?format=" + format,
+			Body:   []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status, respBody = resp.Status, resp.Body
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status, respBody
+	}
+	rangeBody := fmt.Sprintf(`{"from": "%d", "to": "%d"`, contractStart.UnixMilli(), contractStart.Add(200*time.Second).UnixMilli())
+
+	status, body := call("json", rangeBody+`}`)
+	if status != http.StatusOK {
+		t.Fatalf("report returned %d: %s", status, body)
+	}
+	var report slaReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Containers) != 2 || report.Restarts != 1 {
+		t.Fatalf("report has %d containers and %d restarts, want 2 and 1: %s", len(report.Containers), report.Restarts, body)
+	}
+	db, web := report.Containers[0], report.Containers[1]
+	if web.ContainerName != "web" || web.Restarts != 1 || web.UptimePercent != 25 || web.MaxCPUPercent != 14 {
+		t.Errorf("web row = %+v, want 1 restart, 25%% uptime and 14%% max CPU", web)
+	}
+	if db.ContainerName != "db" || db.Restarts != 0 || db.UptimePercent != 20 || db.MaxCPUPercent != 60 || db.AvgMemoryBytes != 512<<20 {
+		t.Errorf("db row = %+v, want no restarts, 20%% uptime, 60%% max CPU and 512 MiB", db)
+	}
+	if report.UptimePercent != 22.5 {
+		t.Errorf("report uptime = %v, want 22.5", report.UptimePercent)
+	}
+
+	// The query's container filters apply, and CSV has a row per container
+	status, body = call("csv", rangeBody+`, "query": {"containerNamePattern": "^web$"}}`)
+	records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if status != http.StatusOK || err != nil || len(records) != 2 {
+		t.Fatalf("csv report returned %d %v: %s", status, err, body)
+	}
+	if records[0][4] != "uptimePercent" || records[1][3] != "web" || records[1][4] != "25" || records[1][8] != "1" {
+		t.Errorf("csv report = %q", records)
+	}
+
+	if status, _ := call("xml", rangeBody+`}`); status != http.StatusBadRequest {
+		t.Errorf("unknown format returned %d, want 400", status)
+	}
+
+	// Host selections apply like in the panel
+	status, body = call("json", rangeBody+`, "query": {"hostSelections": {"h1": {"hostId": "h1", "mode": "whitelist", "containerIds": ["db1"]}}}}`)
+	report = slaReport{}
+	if err := json.Unmarshal(body, &report); status != http.StatusOK || err != nil || len(report.Containers) != 1 || report.Containers[0].ContainerName != "db" {
+		t.Errorf("selected report returned %d: %s", status, body)
+	}
+
+	t.Run("scoped", func(t *testing.T) {
+		hosts := startContractHosts(t, "alpha", "beta")
+		ds := newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+		ds.secrets = map[string]string{scopeSecretKey: "contract-secret"}
+		call := func(user *backend.User, path, body string) (int, []byte) {
+			var status int
+			var respBody []byte
+			resource, _, _ := strings.Cut(path, "?")
+			err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{User: user},
+				Path:          resource,
+				Method:        http.MethodPost,
+				URL:           path,
+				Body:          []byte(body),
+			}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+				status, respBody = resp.Status, resp.Body
+				return nil
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return status, respBody
+		}
+		viewer := &backend.User{Login: "viewer", Role: "Viewer"}
+
+		if status, body := call(viewer, "report?format=json", rangeBody+`}`); status != http.StatusForbidden {
+			t.Fatalf("report for a viewer without a token returned %d: %s", status, body)
+		}
+		status, body := call(&backend.User{Login: "editor", Role: "Editor"}, "scope-tokens", `{"hosts": ["h1"], "containers": ["web"]}`)
+		var issued struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(body, &issued); status != http.StatusOK || err != nil {
+			t.Fatalf("issuing a scope token returned %d: %s", status, body)
+		}
+
+		status, body = call(viewer, "report?format=json", rangeBody+fmt.Sprintf(`, "query": {"scopeToken": %q}}`, issued.Token))
+		var report slaReport
+		if err := json.Unmarshal(body, &report); status != http.StatusOK || err != nil {
+			t.Fatalf("scoped report returned %d: %s", status, body)
+		}
+		if len(report.Containers) != 1 || report.Containers[0].HostID != "h1" || report.Containers[0].ContainerName != "web" {
+			t.Errorf("scoped report has %+v, want only web on alpha", report.Containers)
+		}
+		if status, body := call(viewer, "report?format=json", rangeBody+fmt.Sprintf(`, "query": {"hostIds": ["h2"], "scopeToken": %q}}`, issued.Token)); status != http.StatusForbidden {
+			t.Errorf("report of a host outside the scope returned %d: %s", status, body)
+		}
+	})
+}
+
+func TestContractComposeProjects(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	for _, h := range hosts {
+		h.agent.AddContainer(agentmock.Container{ID: "web1", Name: "web", Image: "nginx:1.25", Labels: map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.service": "web"}})
+	}
+	ds := newContractDatasource(t, hosts, nil)
+
+	rows := func(query string) map[string][]interface{} {
+		t.Helper()
+		resp := runContractQuery(t, ds, query)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		result := make(map[string][]interface{})
+		for i := 0; i < frame.Rows(); i++ {
+			row := make([]interface{}, 0, len(frame.Fields)-1)
+			for _, field := range frame.Fields[1:] {
+				value, _ := field.ConcreteAt(i)
+				row = append(row, value)
+			}
+			result[frame.Fields[0].At(i).(string)] = row
+		}
+		return result
+	}
+
+	// hosts, services, containers, running, paused, unhealthy, cpuPercent, memoryBytes (MB)
+	got := rows(`{"queryType": "composeProjects"}`)
+	want := map[string][]interface{}{
+		"(none)": {int64(2), int64(0), int64(2), int64(2), int64(0), int64(2), 120.0, 1024.0},
+		"shop":   {int64(2), int64(1), int64(2), int64(2), int64(0), int64(0), 28.0, 128.0},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("projects = %v, want %v", got, want)
+	}
+
+	got = rows(`{"queryType": "composeProjects", "aggregation": "avg", "metrics": ["cpuPercent"], "hostIds": ["h1"]}`)
+	want = map[string][]interface{}{
+		"(none)": {int64(1), int64(0), int64(1), int64(1), int64(0), int64(1), 60.0},
+		"shop":   {int64(1), int64(1), int64(1), int64(1), int64(0), int64(0), 14.0},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("averaged projects = %v, want %v", got, want)
+	}
+
+	if resp := runContractQuery(t, ds, `{"queryType": "composeProjects", "aggregation": "median"}`); resp.Error == nil {
+		t.Error("unknown aggregation was accepted")
+	}
+
+	// With required scope tokens, viewers only see the projects of the token's hosts and containers
+	scoped := newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+	token := contractScopeToken(t, scoped, []string{"h1"}, []string{"web"})
+	if resp := runContractQuery(t, scoped, `{"queryType": "composeProjects"}`); resp.Error == nil {
+		t.Error("tokenless viewer saw the compose projects")
+	}
+	resp := runContractQuery(t, scoped, `{"queryType": "composeProjects", "metrics": ["cpuPercent"], "scopeToken": "`+token+`"}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	// project, hosts, containers and cpuPercent
+	frame := resp.Frames[0]
+	cpu, _ := frame.Fields[len(frame.Fields)-1].ConcreteAt(0)
+	if frame.Rows() != 1 || frame.Fields[0].At(0) != "shop" || frame.Fields[1].At(0) != int64(1) || frame.Fields[3].At(0) != int64(1) || cpu != 14.0 {
+		t.Errorf("scoped query returned %d projects, first %v with %v hosts, %v containers and %v%% CPU; want only alpha's shop web container",
+			frame.Rows(), frame.Fields[0].At(0), frame.Fields[1].At(0), frame.Fields[3].At(0), cpu)
+	}
+}
+
+func TestContractCost(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	hosts[0].config.Group = "eu"
+	for _, h := range hosts {
+		h.agent.AddContainer(agentmock.Container{ID: "api1", Name: "api", Image: "shop/api:2", Labels: map[string]string{"com.docker.compose.project": "shop"}})
+		for i := 0; i < 5; i++ {
+			h.agent.AddSamples(agentmock.Sample{ContainerID: "api1", Time: contractStart.Add(time.Duration(i) * 10 * time.Second), CPUPercent: 100, MemoryBytes: 1 << 30})
+		}
+	}
+	ds := newContractDatasource(t, hosts, map[string]interface{}{
+		"costModel": map[string]interface{}{
+			"cpuHour":  36,
+			"gbHour":   3.6,
+			"currency": "USD",
+			"groups":   map[string]interface{}{"eu": map[string]float64{"cpuHour": 72, "gbHour": 7.2}},
+			"hosts":    map[string]interface{}{"h2": map[string]float64{"cpuHour": 360}},
+		},
+	})
+
+	resp := runContractQuery(t, ds, `{"queryType": "cost"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_cost", &resp, *updateGolden)
+
+	// api uses one core and 1 GB for the 40s the samples cover
+	resp = runContractQuery(t, ds, `{"queryType": "cost", "groupBy": "composeProject"}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	frame := resp.Frames[0]
+	_, project := frame.FieldByName("composeProject")
+	cost, _ := frame.FieldByName("cost")
+	for i := 0; i < frame.Rows(); i++ {
+		if frame.Fields[project].At(i) != "shop" {
+			continue
+		}
+		if got, want := cost.At(i).(float64), (72+7.2+360)*40.0/3600; math.Abs(got-want) > 1e-9 {
+			t.Errorf("shop costs %v, want %v", got, want)
+		}
+	}
+
+	if resp := runContractQuery(t, ds, `{"queryType": "cost", "groupBy": "image"}`); resp.Error == nil {
+		t.Error("cost query grouped by image was accepted")
+	}
+}
+
+func TestContractFieldPushdown(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, nil)
+	resp := runContractQuery(t, ds, `{"hostSelections": {"h1": {"hostId": "h1", "mode": "whitelist", "containerIds": ["web1", "db1"],
+		"containerMetrics": {"web1": ["cpuPercent"], "db1": ["memoryPercent"]}}}}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+
+	var fetch url.Values
+	for _, r := range hosts[0].agent.Requests() {
+		if path, query, _ := strings.Cut(strings.TrimPrefix(r, "GET "), "?"); path == "/api/metrics" {
+			fetch, _ = url.ParseQuery(query)
+		}
+	}
+	if got := fetch.Get("containerIds"); got != "web1,db1" {
+		t.Errorf("containerIds = %q, want web1,db1", got)
+	}
+	if got := fetch.Get("containerFields"); got != "db1:memoryPercent;web1:cpuPercent" {
+		t.Errorf("containerFields = %q, want db1:memoryPercent;web1:cpuPercent", got)
+	}
+
+	names := make([]string, 0, len(resp.Frames))
+	for _, f := range resp.Frames {
+		names = append(names, f.Name)
+	}
+	for _, want := range []string{"web - CPU %", "db - Memory %"} {
+		if !contains(names, want) {
+			t.Errorf("frames %v are missing %q", names, want)
+		}
+	}
+
+	t.Run("pressure", func(t *testing.T) {
+		hosts := startContractHosts(t, "alpha")
+		hosts[0].agent.AddContainer(agentmock.Container{ID: "app1", Name: "app"})
+		hosts[0].agent.AddSamples(agentmock.Sample{ContainerID: "app1", Time: contractStart, CPUPressure: &agentmock.PSI{Some10: 7.5, Full10: 2}})
+		ds := newContractDatasource(t, hosts, nil)
+		for _, query := range []string{
+			`{"metrics": ["cpuPressureSome"], "containerIds": ["app1"]}`,
+			`{"metrics": ["cpuPressureSome"], "hostSelections": {"h1": {"hostId": "h1", "mode": "whitelist", "containerIds": ["app1"],
+				"containerMetrics": {"app1": ["cpuPressureSome"]}}}}`,
+		} {
+			resp := runContractQuery(t, ds, query)
+			if resp.Error != nil {
+				t.Fatal(resp.Error)
+			}
+			var value *float64
+			for _, f := range resp.Frames {
+				if strings.HasPrefix(f.Name, "app") && f.Rows() > 0 {
+					value = f.Fields[1].At(0).(*float64)
+				}
+			}
+			if value == nil || *value != 7.5 {
+				t.Errorf("%s: got cpuPressureSome %v, want 7.5 from the agent's cpuPressure", query, value)
+			}
+		}
+	})
+}
+
+// streamPackets collects the packets a stream sends
+type streamPackets chan *backend.StreamPacket
+
+func (p streamPackets) Send(packet *backend.StreamPacket) error {
+	p <- packet
+	return nil
+}
+
+func TestContractStream(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	// Samples the agent collected moments ago, within the stream's backfill
+	recent := time.Now().Add(-5 * time.Second).Truncate(time.Second)
+	hosts[0].agent.AddSamples(
+		agentmock.Sample{ContainerID: "web1", Time: recent, CPUPercent: 21},
+		agentmock.Sample{ContainerID: "db1", Time: recent, CPUPercent: 42},
+	)
+	const path = "metrics/h1/cpu"
+	streamData := json.RawMessage(`{"metrics": ["cpuPercent"], "containerIds": ["web1"], "intervalMs": 500}`)
+
+	disabled := newContractDatasource(t, hosts, nil)
+	sub, err := disabled.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path, Data: streamData})
+	if err != nil || sub.Status != backend.SubscribeStreamStatusNotFound {
+		t.Fatalf("subscription with streaming off: %v, %v", sub, err)
+	}
+
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"featureToggles": map[string]bool{"streaming": true}})
+	for p, want := range map[string]backend.SubscribeStreamStatus{
+		path:             backend.SubscribeStreamStatusOK,
+		"metrics/nohost": backend.SubscribeStreamStatusNotFound,
+		"other/h1":       backend.SubscribeStreamStatusNotFound,
+	} {
+		sub, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: p, Data: streamData})
+		if err != nil || sub.Status != want {
+			t.Errorf("subscription to %s: %v, %v, want status %v", p, sub, err, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(streamPackets, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- ds.RunStream(ctx, &backend.RunStreamRequest{Path: path, Data: streamData}, backend.NewStreamSender(packets))
+	}()
+
+	var frame data.Frame
+	select {
+	case packet := <-packets:
+		if err := json.Unmarshal(packet.Data, &frame); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream sent no packet")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if frame.Rows() != 1 {
+		t.Fatalf("streamed %d rows, want the one recent web sample", frame.Rows())
+	}
+	if id, _ := frame.Fields[1].ConcreteAt(0); id != "web1" {
+		t.Errorf("streamed container %v, want web1", id)
+	}
+	if v, _ := frame.Fields[3].ConcreteAt(0); v != 21.0 {
+		t.Errorf("streamed cpuPercent %v, want 21", v)
+	}
+}
+
+func TestContractStreamChurn(t *testing.T) {
+	start := time.Now()
+	sample := func(id string, at time.Time) ContainerMetric {
+		return ContainerMetric{ContainerID: id, Timestamp: SampleTime{Time: at}}
+	}
+	churn := newChurnTracker(start)
+	churn.observe([]ContainerMetric{sample("web1", start), sample("db1", start)}, start)
+	if got := churn.interval(time.Second, start); got != time.Second {
+		t.Errorf("new stream polls every %v, want the requested second", got)
+	}
+
+	// Nothing started or stopped for a whole window: poll less often, not slower than the collector
+	quiet := start.Add(churnWindow)
+	churn.observe([]ContainerMetric{sample("web1", quiet), sample("db1", quiet)}, quiet)
+	if got := churn.interval(time.Second, quiet); got != 4*time.Second {
+		t.Errorf("quiet host polled every %v, want 4s", got)
+	}
+	if got := churn.interval(5*time.Second, quiet); got != maxAdaptiveInterval {
+		t.Errorf("quiet host polled every %v, want %v", got, maxAdaptiveInterval)
+	}
+
+	// db1 stops, a job container starts and web1 restarts after a stop
+	stopped := quiet.Add(time.Minute)
+	churn.observe([]ContainerMetric{sample("job1", stopped)}, stopped)
+	restarted := stopped.Add(10 * time.Second)
+	churn.observe([]ContainerMetric{sample("web1", restarted), sample("job1", restarted)}, restarted)
+	if got := churn.interval(time.Second, restarted); got != minStreamInterval {
+		t.Errorf("busy host polled every %v, want %v", got, minStreamInterval)
+	}
+	if got := churn.interval(4*time.Second, restarted); got != 2*time.Second {
+		t.Errorf("busy host polled every %v, want 2s", got)
+	}
+}
+
+func TestContractTemplateVariables(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"variables": map[string]interface{}{"host": "h2"}})
+	frameNames := func(query string) string {
+		resp := runContractQuery(t, ds, query)
+		if resp.Error != nil {
+			t.Fatalf("query %s: %v", query, resp.Error)
+		}
+		names := make([]string, 0, len(resp.Frames))
+		for _, f := range resp.Frames {
+			if f.Name != "containers" {
+				names = append(names, f.Name)
+			}
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "container IDs",
+			query: `{"metrics": ["cpuPercent"], "hostIds": ["h1"], "containerIds": ["$container"], "variables": {"container": "web1"}}`,
+			want:  "web - CPU %",
+		},
+		{
+			name:  "multi-value csv",
+			query: `{"metrics": ["cpuPercent"], "hostIds": ["h1"], "containerIds": ["${containers:csv}"], "variables": {"containers": ["web1", "db1"]}}`,
+			want:  "db - CPU %, web - CPU %",
+		},
+		{
+			name:  "name pattern as regex",
+			query: `{"metrics": ["cpuPercent"], "hostIds": ["h1"], "containerNamePattern": "^${name}$", "variables": {"name": ["web", "d.b"]}}`,
+			want:  "web - CPU %",
+		},
+		{
+			name:  "datasource default",
+			query: `{"metrics": ["cpuPercent"], "hostIds": ["$host"], "containerIds": ["db1"]}`,
+			want:  "db - CPU %",
+		},
+		{
+			name:  "host selections per host",
+			query: `{"hostSelections": {"$host": {"hostId": "$host", "mode": "whitelist", "containerIds": ["$container"], "containerMetrics": {"$container": ["cpuPercent"]}}}, "variables": {"host": ["h1", "h2"], "container": "web1"}}`,
+			want:  "web (alpha) - CPU %, web (beta) - CPU %",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := frameNames(tt.query); got != tt.want {
+				t.Errorf("frames = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Unknown variables stay as they are and match nothing
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "containerIds": ["$nope"]}`)
+	if len(resp.Frames) == 0 || resp.Frames[0].Meta == nil || len(resp.Frames[0].Meta.Notices) == 0 {
+		t.Errorf("unknown variable selected %d frames", len(resp.Frames))
+	}
+}
+
+func TestContractSelectionSemantics(t *testing.T) {
+	tests := []struct {
+		name       string
+		selection  string
+		wantFrames []string
+		wantError  string
+	}{
+		{
+			name:       "union",
+			selection:  `"selectionSemantics": "union", "mode": "whitelist", "containerIds": ["web1", "db1"], "metrics": ["cpuPercent"], "containerMetrics": {"web1": ["memoryPercent"]}`,
+			wantFrames: []string{"db - CPU %", "web - CPU %", "web - Memory %"},
+		},
+		{
+			name:       "intersection",
+			selection:  `"selectionSemantics": "intersection", "mode": "whitelist", "containerIds": ["web1", "db1"], "metrics": ["cpuPercent", "memoryPercent"], "containerMetrics": {"web1": ["memoryPercent", "uptimeSeconds"]}`,
+			wantFrames: []string{"db - CPU %", "db - Memory %", "web - Memory %"},
+		},
+		{
+			name:       "blacklist",
+			selection:  `"selectionSemantics": "union", "mode": "blacklist", "containerIds": ["db1"], "metrics": ["cpuPercent"]`,
+			wantFrames: []string{"web - CPU %"},
+		},
+		{
+			name:      "unselected_container_metrics",
+			selection: `"selectionSemantics": "union", "mode": "whitelist", "containerIds": ["web1"], "metrics": ["cpuPercent"], "containerMetrics": {"db1": ["cpuPercent"]}`,
+			wantError: "host selection h1: containerMetrics lists db1, which the whitelist doesn't select",
+		},
+		{
+			name:      "empty_intersection",
+			selection: `"selectionSemantics": "intersection", "mode": "whitelist", "containerIds": ["web1"], "metrics": ["cpuPercent"], "containerMetrics": {"web1": ["memoryPercent"]}`,
+			wantError: "host selection h1: container web1 selects no metrics",
+		},
+		{
+			name:      "blacklist_without_metrics",
+			selection: `"selectionSemantics": "intersection", "mode": "blacklist", "containerIds": ["db1"]`,
+			wantError: "host selection h1: blacklist mode needs metrics for the containers without containerMetrics",
+		},
+		{
+			name:      "unknown_semantics",
+			selection: `"selectionSemantics": "either", "mode": "whitelist", "containerIds": ["web1"]`,
+			wantError: "host selection h1: selectionSemantics must be union or intersection",
+		},
+	}
+
+	ds := newContractDatasource(t, startContractHosts(t, "alpha"), nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := runContractQuery(t, ds, `{"hostSelections": {"h1": {"hostId": "h1", `+tt.selection+`}}}`)
+			if tt.wantError != "" {
+				if resp.Error == nil || resp.Error.Error() != tt.wantError {
+					t.Fatalf("error = %v, want %q", resp.Error, tt.wantError)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatal(resp.Error)
+			}
+			names := make([]string, 0, len(resp.Frames))
+			for _, f := range resp.Frames {
+				if f.Name != "containers" {
+					names = append(names, f.Name)
+				}
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.wantFrames) {
+				t.Errorf("frames = %v, want %v", names, tt.wantFrames)
+			}
+		})
+	}
+}
+
+func TestContractLabelSelection(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	agent := hosts[0].agent
+	agent.AddContainer(agentmock.Container{ID: "web1", Name: "web", Image: "nginx:1.25", Labels: map[string]string{"com.docker.compose.project": "webapp", "env": "prod"}})
+	agent.AddContainer(agentmock.Container{ID: "db1", Name: "db", Image: "postgres:16", Labels: map[string]string{"com.docker.compose.project": "webapp", "env": "staging"}})
+	agent.AddContainer(agentmock.Container{ID: "cache1", Name: "cache", Image: "redis:7", Labels: map[string]string{"com.docker.compose.project": "other"}})
+	agent.AddSamples(agentmock.Sample{ContainerID: "cache1", Time: contractStart, CPUPercent: 3, MemoryBytes: 16 << 20, MemoryPercent: 2})
+	ds := newContractDatasource(t, hosts, nil)
+
+	tests := []struct {
+		name           string
+		selection      string
+		wantFrames     []string
+		wantContainers []string
+		wantError      string
+	}{
+		{
+			name:           "include_and_exclude",
+			selection:      `"mode": "whitelist", "includeLabels": ["com.docker.compose.project=webapp"], "excludeLabels": ["env=staging"], "metrics": ["cpuPercent"], "selectionSemantics": "union"`,
+			wantFrames:     []string{"web - CPU %"},
+			wantContainers: []string{"web1"},
+		},
+		{
+			name:           "labels_extend_whitelist",
+			selection:      `"mode": "whitelist", "containerIds": ["db1"], "includeLabels": ["com.docker.compose.project=other"], "metrics": ["cpuPercent"], "selectionSemantics": "union"`,
+			wantFrames:     []string{"cache - CPU %", "db - CPU %"},
+			wantContainers: []string{"cache1", "db1"},
+		},
+		{
+			name:           "labels_restrict_blacklist",
+			selection:      `"mode": "blacklist", "containerIds": ["db1"], "includeLabels": ["env"], "metrics": ["cpuPercent"], "selectionSemantics": "union"`,
+			wantFrames:     []string{"web - CPU %"},
+			wantContainers: []string{"web1"},
+		},
+		{
+			name:      "invalid_selector",
+			selection: `"mode": "whitelist", "includeLabels": ["=prod"], "metrics": ["cpuPercent"]`,
+			wantError: `host selection h1: invalid label selector "=prod", expected key or key=value`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := runContractQuery(t, ds, `{"hostSelections": {"h1": {"hostId": "h1", `+tt.selection+`}}}`)
+			if tt.wantError != "" {
+				if resp.Error == nil || resp.Error.Error() != tt.wantError {
+					t.Fatalf("error = %v, want %q", resp.Error, tt.wantError)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatal(resp.Error)
+			}
+			names := make([]string, 0, len(resp.Frames))
+			var containers []string
+			for _, f := range resp.Frames {
+				if f.Name != "containers" {
+					names = append(names, f.Name)
+					continue
+				}
+				for i := 0; i < f.Rows(); i++ {
+					containers = append(containers, f.Fields[0].At(i).(string))
+				}
+			}
+			sort.Strings(names)
+			sort.Strings(containers)
+			if !slices.Equal(names, tt.wantFrames) || !slices.Equal(containers, tt.wantContainers) {
+				t.Errorf("frames = %v, containers = %v, want %v and %v", names, containers, tt.wantFrames, tt.wantContainers)
+			}
+		})
+	}
+}
+
+func TestContractContainerExclusions(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha"), map[string]interface{}{"excludeContainers": []string{"^db$"}})
+	containerNames := func(resp backend.DataResponse) []string {
+		t.Helper()
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		names := make([]string, 0)
+		for _, f := range resp.Frames {
+			if f.Name != "containers" {
+				continue
+			}
+			for i := 0; i < f.Rows(); i++ {
+				names = append(names, f.Fields[1].At(i).(string))
+			}
+		}
+		return names
+	}
+
+	metrics := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	for _, f := range metrics.Frames {
+		if strings.HasPrefix(f.Name, "db") {
+			t.Errorf("excluded container has frame %s", f.Name)
+		}
+	}
+	if got := containerNames(metrics); fmt.Sprint(got) != "[web]" {
+		t.Errorf("containers = %v, want [web]", got)
+	}
+	if got := containerNames(runContractQuery(t, ds, `{"queryType": "containers"}`)); fmt.Sprint(got) != "[web]" {
+		t.Errorf("containers query = %v, want [web]", got)
+	}
+	// A query's own list replaces the datasource's, an empty one shows everything
+	if got := containerNames(runContractQuery(t, ds, `{"queryType": "containers", "excludeContainers": []}`)); len(got) != 2 {
+		t.Errorf("containers query without exclusions = %v, want both", got)
+	}
+	if resp := runContractQuery(t, ds, `{"queryType": "containers", "excludeContainers": ["("]}`); resp.Error == nil {
+		t.Error("invalid exclusion pattern was accepted")
+	}
+}
+
+func TestContractContainerPages(t *testing.T) {
+	ds := newContractDatasource(t, startContractHosts(t, "alpha", "beta"), nil)
+	page := func(query string) (string, map[string]interface{}) {
+		t.Helper()
+		resp := runContractQuery(t, ds, query)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		rows := make([]string, 0, frame.Rows())
+		for i := 0; i < frame.Rows(); i++ {
+			rows = append(rows, frame.Fields[2].At(i).(string)+"/"+frame.Fields[1].At(i).(string))
+		}
+		custom, _ := frame.Meta.Custom.(map[string]interface{})
+		return strings.Join(rows, " "), custom
+	}
+
+	// Pages are ordered by host, then container name
+	rows, meta := page(`{"queryType": "containers", "limit": 3, "offset": 1}`)
+	if rows != "h1/web h2/db h2/web" {
+		t.Errorf("page = %s, want h1/web h2/db h2/web", rows)
+	}
+	if meta["totalCount"] != 4 || meta["offset"] != 1 || meta["limit"] != 3 {
+		t.Errorf("page metadata = %v, want totalCount 4, offset 1, limit 3", meta)
+	}
+	if rows, meta = page(`{"queryType": "containers", "limit": 2, "offset": 4}`); rows != "" || meta["totalCount"] != 4 {
+		t.Errorf("page past the end = %q %v, want no rows of 4", rows, meta)
+	}
+	if _, meta = page(`{"queryType": "containers"}`); meta["totalCount"] != nil {
+		t.Errorf("unpaged query has metadata %v", meta)
+	}
+	if resp := runContractQuery(t, ds, `{"queryType": "containers", "limit": -1}`); resp.Error == nil {
+		t.Error("negative limit was accepted")
+	}
+}
+
+func TestContractScopeTokens(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+	ds.secrets = map[string]string{scopeSecretKey: "contract-secret"}
+	editor := &backend.User{Login: "editor", Role: "Editor"}
+	viewer := &backend.User{Login: "viewer", Role: "Viewer"}
+
+	issue := func(user *backend.User, body string) (int, string) {
+		var status int
+		var issued struct {
+			Token string `json:"token"`
+		}
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Path:          "scope-tokens",
+			Method:        "POST",
+			URL:           "scope-tokens",
+			Body:          []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status = resp.Status
+			return json.Unmarshal(resp.Body, &issued)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status, issued.Token
+	}
+	query := func(user *backend.User, query string) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      json.RawMessage(query),
+				TimeRange: backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+
+	if status, _ := issue(viewer, `{"hosts": ["h1"]}`); status != 403 {
+		t.Fatalf("viewer issued a scope token, status %d", status)
+	}
+	status, token := issue(editor, `{"hosts": ["h1"], "containers": ["web"]}`)
+	if status != 200 || token == "" {
+		t.Fatalf("issuing a scope token returned status %d", status)
+	}
+
+	if resp := query(viewer, `{"metrics": ["cpuPercent"]}`); resp.Error == nil {
+		t.Fatal("viewer query without a scope token succeeded")
+	}
+	if resp := query(editor, `{"metrics": ["cpuPercent"]}`); resp.Error != nil || len(resp.Frames) != 5 {
+		t.Fatalf("editor query returned %d frames, error %v; want 4 metric frames and the containers frame", len(resp.Frames), resp.Error)
+	}
+
+	resp := query(viewer, fmt.Sprintf(`{"metrics": ["cpuPercent"], "scopeToken": %q}`, token))
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	for _, f := range resp.Frames {
+		if f.Name == "containers" {
+			if f.Rows() != 1 {
+				t.Fatalf("scoped containers frame has %d rows, want only web on alpha", f.Rows())
+			}
+			continue
+		}
+		if labels := f.Fields[1].Labels; labels["hostName"] != "alpha" || labels["containerName"] != "web" {
+			t.Fatalf("scoped query returned series %v", labels)
+		}
+	}
+
+	for name, q := range map[string]string{
+		"other host": fmt.Sprintf(`{"metrics": ["cpuPercent"], "hostIds": ["h2"], "scopeToken": %q}`, token),
+		"matrix":     fmt.Sprintf(`{"hostSelections": {"h2": {"hostId": "h2", "mode": "blacklist", "metrics": ["cpuPercent"]}}, "scopeToken": %q}`, token),
+		"control":    fmt.Sprintf(`{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1", "scopeToken": %q}`, token),
+		"tampered":   fmt.Sprintf(`{"metrics": ["cpuPercent"], "scopeToken": %q}`, "e30"+token[3:]),
+	} {
+		if resp := query(viewer, q); resp.Error == nil {
+			t.Errorf("%s: scoped query succeeded", name)
+		}
+	}
+}
+
+func TestContractAutocomplete(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+	ds.secrets = map[string]string{scopeSecretKey: "contract-secret"}
+	editor := &backend.User{Login: "editor", Role: "Editor"}
+	viewer := &backend.User{Login: "viewer", Role: "Viewer"}
+
+	call := func(user *backend.User, method, target, body string, out interface{}) int {
+		var status int
+		path, _, _ := strings.Cut(target, "?")
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Path:          path,
+			Method:        method,
+			URL:           target,
+			Body:          []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status = resp.Status
+			if status != 200 {
+				return nil
+			}
+			return json.Unmarshal(resp.Body, out)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	names := func(containers []ContainerOption) []string {
+		result := make([]string, 0, len(containers))
+		for _, c := range containers {
+			result = append(result, c.ContainerName)
+		}
+		return result
+	}
+
+	var hostList []HostOption
+	if status := call(editor, "GET", "hosts", "", &hostList); status != 200 || len(hostList) != 2 || hostList[0].Name != "alpha" {
+		t.Fatalf("hosts for editor: status %d, %+v", status, hostList)
+	}
+	var containers []ContainerOption
+	if status := call(editor, "GET", "hosts/h1/containers", "", &containers); status != 200 || strings.Join(names(containers), ",") != "db,web" {
+		t.Fatalf("containers of h1: status %d, %+v", status, containers)
+	}
+	if status := call(editor, "GET", "hosts/nohost/containers", "", &containers); status != 404 {
+		t.Errorf("containers of unknown host: status %d, want 404", status)
+	}
+	var metrics []MetricOption
+	if status := call(editor, "GET", "metrics", "", &metrics); status != 200 || len(metrics) != len(AllMetrics) {
+		t.Fatalf("metrics: status %d, %d entries", status, len(metrics))
+	}
+	if metrics[0].Value != "cpuPercent" || metrics[0].Label != "CPU %" || metrics[0].Unit != "percent" {
+		t.Errorf("first metric %+v", metrics[0])
+	}
+
+	// Viewers of scoped dashboards only see what their token allows
+	if status := call(viewer, "GET", "hosts", "", &hostList); status != 403 {
+		t.Errorf("hosts for viewer without token: status %d, want 403", status)
+	}
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if status := call(editor, "POST", "scope-tokens", `{"hosts": ["h1"], "containers": ["web"]}`, &issued); status != 200 {
+		t.Fatalf("issuing a scope token returned status %d", status)
+	}
+	scoped := "?scopeToken=" + url.QueryEscape(issued.Token)
+	if status := call(viewer, "GET", "hosts"+scoped, "", &hostList); status != 200 || len(hostList) != 1 || hostList[0].ID != "h1" {
+		t.Errorf("scoped hosts: status %d, %+v", status, hostList)
+	}
+	if status := call(viewer, "GET", "hosts/h1/containers"+scoped, "", &containers); status != 200 || strings.Join(names(containers), ",") != "web" {
+		t.Errorf("scoped containers: status %d, %+v", status, containers)
+	}
+	if status := call(viewer, "GET", "hosts/h2/containers"+scoped, "", &containers); status != 404 {
+		t.Errorf("containers outside the scope: status %d, want 404", status)
+	}
+}
+
+func TestContractRetries(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	agent := hosts[0].agent
+	hosts[0].config.Retry = &RetrySettings{StatusCodes: []int{500, 503}}
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"retry": map[string]interface{}{"backoffMs": 1}})
+	metricRequests := func() int {
+		n := 0
+		for _, r := range agent.Requests() {
+			if strings.HasPrefix(r, "GET /api/metrics") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A restarting agent answers 503 once, the retry gets the data
+	agent.Fail("/api/metrics", agentmock.Fault{Status: 503, Times: 1})
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if resp.Error != nil || len(resp.Frames) != 3 {
+		t.Fatalf("got %d frames, error %v, want 2 series and the containers frame", len(resp.Frames), resp.Error)
+	}
+	if n := metricRequests(); n != 2 {
+		t.Errorf("agent got %d metrics requests, want 2", n)
+	}
+
+	// The host's status codes include 500; a query can turn retries off
+	agent.Fail("/api/metrics", agentmock.Fault{Status: 500, Times: 1})
+	if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`); resp.Error != nil || len(resp.Frames) != 3 {
+		t.Errorf("500 wasn't retried: %d frames, error %v", len(resp.Frames), resp.Error)
+	}
+	agent.Fail("/api/metrics", agentmock.Fault{Status: 503, Times: 1})
+	before := metricRequests()
+	if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "retry": {"attempts": 1}}`); len(resp.Frames) > 1 {
+		t.Errorf("query without retries got %d frames", len(resp.Frames))
+	}
+	if n := metricRequests() - before; n != 1 {
+		t.Errorf("query without retries sent %d metrics requests, want 1", n)
+	}
+
+	// Rejected tokens aren't retried
+	agent.Token = "secret"
+	before = len(agent.Requests())
+	runContractQuery(t, ds, `{"queryType": "containers"}`)
+	if n := len(agent.Requests()) - before; n != 1 {
+		t.Errorf("unauthorized containers request was sent %d times, want once", n)
+	}
+
+	if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "retry": {"attempts": -1}}`); resp.Error == nil {
+		t.Error("negative retry attempts were accepted")
+	}
+}
+
+func TestContractPrefetch(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	agent := hosts[0].agent
+	now := time.Now()
+	for i := 1; i <= 6; i++ {
+		agent.AddSamples(agentmock.Sample{ContainerID: "web1", Time: now.Add(-time.Duration(i) * time.Minute), CPUPercent: float64(i)})
+	}
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"featureToggles": map[string]bool{"prefetch": true}})
+	lastFrom := func() time.Time {
+		var from time.Time
+		for _, r := range agent.Requests() {
+			if u, err := url.Parse(strings.TrimPrefix(r, "GET ")); err == nil && u.Path == "/api/metrics" {
+				from, _ = time.Parse(time.RFC3339, u.Query().Get("from"))
+			}
+		}
+		return from
+	}
+
+	if _, ok := prefetchKeyFor("h1", backend.TimeRange{From: contractStart, To: contractStart.Add(time.Hour)}, []string{"cpuPercent"}, nil, now); ok {
+		t.Error("historical range is tracked")
+	}
+
+	// A dashboard refreshing every 30 seconds asked for the last hour three times
+	lastHour := backend.TimeRange{From: now.Add(-time.Hour), To: now}
+	key, ok := prefetchKeyFor("h1", lastHour, []string{"cpuPercent"}, nil, now)
+	if !ok {
+		t.Fatal("last hour isn't tracked")
+	}
+	for i := 3; i >= 1; i-- {
+		ds.prefetches.observe(key, lastHour, []string{"cpuPercent"}, nil, now.Add(-time.Duration(i)*30*time.Second))
+	}
+	ds.runPrefetch(now.Add(-2 * time.Second))
+	if from := lastFrom(); !from.Before(now.Add(-59 * time.Minute)) {
+		t.Fatalf("last hour wasn't prefetched, last request from %v", from)
+	}
+
+	// The refresh is served from the prefetch, only newer samples are fetched
+	resp := runContractDataQuery(t, ds, backend.DataQuery{TimeRange: lastHour, JSON: []byte(`{"metrics": ["cpuPercent"]}`)})
+	if resp.Error != nil || len(resp.Frames) == 0 || resp.Frames[0].Rows() != 6 {
+		t.Fatalf("prefetched query: %d frames, error %v", len(resp.Frames), resp.Error)
+	}
+	if from := lastFrom(); from.Before(now.Add(-time.Minute)) {
+		t.Errorf("refresh fetched the whole range from %v", from)
+	}
+}
+
+func TestContractHostTokens(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	hosts[0].agent.Token = "alpha-token"
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true})
+
+	// Without its token alpha is left out and reported
+	resp := runContractQuery(t, ds, `{"queryType": "containers"}`)
+	if resp.Error != nil || len(resp.Frames) == 0 {
+		t.Fatalf("containers query without token failed: %v", resp.Error)
+	}
+	if rows, _ := resp.Frames[0].RowLen(); rows != 2 {
+		t.Fatalf("containers query without token returned %d rows, want beta's 2", rows)
+	}
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), hostTokenPrefix+"h1") {
+		t.Fatalf("control without token returned %v, want the missing token setting", resp.Error)
+	}
+
+	ds.secrets = map[string]string{hostTokenPrefix + "h1": "wrong"}
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "rejected") {
+		t.Fatalf("control with a wrong token returned %v, want a rejection", resp.Error)
+	}
+
+	ds.secrets = map[string]string{hostTokenPrefix + "h1": "alpha-token"}
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "hostIds": ["h1"], "containerIds": ["web1"]}`)
+	if resp.Error != nil || len(resp.Frames) == 0 {
+		t.Fatalf("metrics query with token failed: %v", resp.Error)
+	}
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error != nil {
+		t.Fatalf("control with token failed: %v", resp.Error)
+	}
+	if actions := hosts[0].agent.Actions(); len(actions) != 1 {
+		t.Fatalf("agent received actions %v, want the authenticated stop only", actions)
+	}
+}
+
+func TestContractStaleData(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	// The agent stopped collecting five minutes ago
+	now := time.Now()
+	hosts[0].agent.AddSamples(agentmock.Sample{ContainerID: "web1", Time: now.Add(-5 * time.Minute), CPUPercent: 12})
+	ds := newContractDatasource(t, hosts, nil)
+
+	resp := runContractQueryRange(t, ds, `{"metrics": ["cpuPercent"], "containerIds": ["web1"]}`, backend.TimeRange{From: now.Add(-10 * time.Minute), To: now})
+	if resp.Error != nil || len(resp.Frames) == 0 {
+		t.Fatalf("query returned %d frames, error %v", len(resp.Frames), resp.Error)
+	}
+	frame := resp.Frames[0]
+	if len(frame.Meta.Notices) == 0 || !strings.Contains(frame.Meta.Notices[0].Text, "stale") {
+		t.Fatalf("frame %s has no stale notice", frame.Name)
+	}
+	age, idx := frame.FieldByName("ageSeconds")
+	if idx < 0 || age.At(0).(float64) < 300 || age.At(0).(float64) > 330 {
+		t.Fatalf("frame %s has no ageSeconds field of about 300", frame.Name)
+	}
+
+	// The same data viewed as history is not stale
+	resp = runContractQueryRange(t, ds, `{"metrics": ["cpuPercent"], "containerIds": ["web1"]}`, backend.TimeRange{From: now.Add(-10 * time.Minute), To: now.Add(-4 * time.Minute)})
+	if _, idx := resp.Frames[0].FieldByName("ageSeconds"); idx >= 0 {
+		t.Fatal("historical range was marked stale")
+	}
+}
+
+func TestContractRelabeling(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"relabeling": []map[string]interface{}{
+		{"hostPattern": "^al(.*)$", "hostName": "prod-al$1", "labels": map[string]string{"env": "prod"}},
+		{"hostIds": []string{"h2"}, "containerPattern": "^web$", "containerReplacement": "frontend"},
+		{"hostPattern": "("},
+	}})
+
+	// hostTags opts out of the legacy frame shape, which keeps only the built-in labels
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "containerIds": ["web1"], "hostTags": []}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	got := make(map[string]data.Labels)
+	for _, frame := range resp.Frames {
+		got[frame.Name] = frame.Fields[1].Labels
+	}
+	alpha, ok := got["web - CPU %"]
+	if !ok || alpha["hostName"] != "prod-alpha" || alpha["env"] != "prod" {
+		t.Fatalf("alpha series has labels %v in frames %v, want hostName prod-alpha and env prod", alpha, got)
+	}
+	beta, ok := got["frontend - CPU %"]
+	if !ok || beta["hostName"] != "beta" || beta["env"] != "" {
+		t.Fatalf("beta series has labels %v in frames %v, want hostName beta and no env", beta, got)
+	}
+
+	// Query patterns and container lists see rewritten names
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "containerNamePattern": "^frontend$"}`)
+	names := make([]string, 0)
+	for _, frame := range resp.Frames {
+		if len(frame.Fields) > 0 && frame.Name != "containers" {
+			names = append(names, frame.Name)
+		}
+	}
+	if !slices.Equal(names, []string{"frontend - CPU %"}) {
+		t.Fatalf("pattern on the rewritten name returned series %v", names)
+	}
+	resp = runContractQuery(t, ds, `{"queryType": "containers", "hostIds": ["h2"]}`)
+	listed := make([]string, 0)
+	for _, frame := range resp.Frames {
+		if frame.Name != "containers" {
+			continue
+		}
+		for i := 0; i < frame.Rows(); i++ {
+			listed = append(listed, frame.Fields[1].At(i).(string))
+		}
+	}
+	if !slices.Contains(listed, "frontend") {
+		t.Fatalf("container list of beta has names %v, want frontend", listed)
+	}
+
+	health, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(health.Message, "ignoring relabeling rule 3") {
+		t.Fatalf("health message %q doesn't warn about the invalid rule", health.Message)
+	}
+}
+
+func TestContractControl(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true})
+
+	resp := runContractQuery(t, ds, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_control", &resp, *updateGolden)
+
+	actions := hosts[0].agent.Actions()
+	if len(actions) != 1 || actions[0] != (agentmock.Action{ContainerID: "web1", Action: "stop"}) {
+		t.Fatalf("agent received actions %v, want one stop of web1", actions)
+	}
+
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "start", "targetContainer": "gone", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "No such container") {
+		t.Fatalf("control of an unknown container returned %v, want the agent's error", resp.Error)
+	}
+}
+
+func TestContractControlSequence(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true})
+	statuses := func(resp backend.DataResponse) []string {
+		t.Helper()
+		if len(resp.Frames) != 1 {
+			t.Fatalf("got %d frames, want the step results", len(resp.Frames))
+		}
+		field, _ := resp.Frames[0].FieldByName("status")
+		got := make([]string, field.Len())
+		for i := range got {
+			got[i] = field.At(i).(string)
+		}
+		return got
+	}
+
+	resp := runContractQuery(t, ds, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "stop", "targetContainer": "web1"},
+		{"controlAction": "start", "targetContainer": "db1"}
+	]}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	if got := statuses(resp); !slices.Equal(got, []string{"success", "success"}) {
+		t.Errorf("got step statuses %v", got)
+	}
+	want := []agentmock.Action{{ContainerID: "web1", Action: "stop"}, {ContainerID: "db1", Action: "start"}}
+	if got := hosts[0].agent.Actions(); !slices.Equal(got, want) {
+		t.Fatalf("agent received %v, want %v", got, want)
+	}
+
+	// A failing step stops the sequence and undoes the steps already done
+	before := len(hosts[0].agent.Actions())
+	resp = runContractQuery(t, ds, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "stop", "targetContainer": "db1"},
+		{"controlAction": "pause", "targetContainer": "web1"},
+		{"controlAction": "start", "targetContainer": "gone"},
+		{"controlAction": "restart", "targetContainer": "web1"}
+	]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "step 3") {
+		t.Errorf("got error %v, want step 3 to fail", resp.Error)
+	}
+	if got := statuses(resp); !slices.Equal(got, []string{"rolled back", "rolled back", "failed", "skipped"}) {
+		t.Errorf("got step statuses %v", got)
+	}
+	want = []agentmock.Action{
+		{ContainerID: "db1", Action: "stop"},
+		{ContainerID: "web1", Action: "pause"},
+		{ContainerID: "web1", Action: "unpause"},
+		{ContainerID: "db1", Action: "start"},
+	}
+	if got := hosts[0].agent.Actions()[before:]; !slices.Equal(got, want) {
+		t.Fatalf("agent received %v, want the steps undone in reverse order %v", got, want)
+	}
+
+	// An undo that fails is reported on its step and in the error
+	hosts[0].agent.Fail("/api/containers/db1/start", agentmock.Fault{Status: 500, Times: 1})
+	resp = runContractQuery(t, ds, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "stop", "targetContainer": "db1"},
+		{"controlAction": "start", "targetContainer": "gone"}
+	]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "undoing step 1 failed") {
+		t.Errorf("got error %v, want the failed undo named", resp.Error)
+	}
+	if got := statuses(resp); !slices.Equal(got, []string{"rollback failed", "failed"}) {
+		t.Errorf("got step statuses %v", got)
+	}
+
+	// Steps that can't be undone may only come last, and undoing needs the inverse action
+	before = len(hosts[0].agent.Actions())
+	resp = runContractQuery(t, ds, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "restart", "targetContainer": "web1"},
+		{"controlAction": "stop", "targetContainer": "db1"}
+	]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "step 1: restart can't be undone") || len(hosts[0].agent.Actions()) != before {
+		t.Errorf("got error %v, want the sequence refused before anything runs", resp.Error)
+	}
+	limited := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true, "allowedControlActions": []string{"stop", "restart"}})
+	resp = runContractQuery(t, limited, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "stop", "targetContainer": "web1"},
+		{"controlAction": "restart", "targetContainer": "db1"}
+	]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "undoing stop needs action 'start'") {
+		t.Errorf("got error %v, want the sequence refused without its inverse action", resp.Error)
+	}
+
+	// A refused step refuses the whole sequence before anything runs
+	before = len(hosts[0].agent.Actions())
+	resp = runContractQuery(t, ds, `{"queryType": "control", "targetHost": "h1", "controlSteps": [
+		{"controlAction": "stop", "targetContainer": "web1"},
+		{"controlAction": "kill", "targetContainer": "db1"}
+	]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "step 2: action 'kill' is not allowed") {
+		t.Errorf("got error %v, want step 2 refused", resp.Error)
+	}
+	if len(resp.Frames) != 0 || len(hosts[0].agent.Actions()) != before {
+		t.Error("a refused sequence was executed")
+	}
+}
+
+func TestContractControlDestructive(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true})
+
+	// An empty allowed list keeps kill, remove and recreate off
+	resp := runContractQuery(t, ds, `{"queryType": "control", "controlAction": "kill", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "not allowed") {
+		t.Fatalf("kill without an allowed list returned %v, want it refused", resp.Error)
+	}
+
+	ds = newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true, "allowedControlActions": []string{"kill", "remove", "recreate"}})
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "start", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil {
+		t.Fatal("start succeeded although the allowed list leaves it out")
+	}
+
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "remove", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "cannot remove a running container") {
+		t.Fatalf("unforced remove of a running container returned %v, want the agent's error", resp.Error)
+	}
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "remove", "targetContainer": "web1", "targetHost": "h1", "force": true, "removeVolumes": true}`)
+	if resp.Error != nil {
+		t.Fatalf("forced remove failed: %v", resp.Error)
+	}
+
+	resp = runContractQuery(t, ds, `{"queryType": "control", "controlAction": "recreate", "targetContainer": "db1", "targetHost": "h1"}`)
+	if resp.Error != nil {
+		t.Fatalf("recreate failed: %v", resp.Error)
+	}
+	field, _ := resp.Frames[0].FieldByName("newContainerId")
+	if field == nil || field.At(0) != "db1-recreated" {
+		t.Fatalf("recreate result has newContainerId %v, want db1-recreated", field)
+	}
+
+	want := []agentmock.Action{
+		{ContainerID: "web1", Action: "remove", Params: "force=true&volumes=true"},
+		{ContainerID: "db1", Action: "recreate"},
+	}
+	if actions := hosts[0].agent.Actions(); !slices.Equal(actions, want) {
+		t.Fatalf("agent received actions %v, want %v", actions, want)
+	}
+}
+
+func TestContractReadOnly(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"enableContainerControls": true, "readOnly": true})
+	admin := &backend.User{Login: "admin", Role: "Admin"}
+
+	resp := runContractQuery(t, ds, `{"queryType": "control", "controlAction": "restart", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "read-only") {
+		t.Fatalf("control query on a read-only datasource returned %v, want it refused", resp.Error)
+	}
+	if actions := hosts[0].agent.Actions(); len(actions) != 0 {
+		t.Fatalf("agent received actions %v from a read-only datasource", actions)
+	}
+
+	call := func(method, path string, body string) (int, []byte) {
+		var status int
+		var respBody []byte
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: admin},
+			Path:          path,
+			Method:        method,
+			URL:           path,
+			Body:          []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status, respBody = resp.Status, resp.Body
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status, respBody
+	}
+	for _, write := range []struct{ method, path, body string }{
+		{"POST", "maintenance/h1", `{"reason": "upgrade"}`},
+		{"POST", "hosts/h1/enabled", `{"enabled": false}`},
+		{"POST", "registrations/r1/approve", ""},
+	} {
+		if status, _ := call(write.method, write.path, write.body); status != http.StatusForbidden {
+			t.Errorf("%s %s on a read-only datasource answered %d, want 403", write.method, write.path, status)
+		}
+	}
+	if host, _ := ds.hosts.find("h1"); !host.Enabled {
+		t.Fatal("read-only datasource disabled a host")
+	}
+
+	if status, _ := call("GET", "hosts/h1/containers", ""); status != http.StatusOK {
+		t.Fatalf("container listing on a read-only datasource answered %d", status)
+	}
+	status, body := call("GET", "capabilities", "")
+	var caps map[string]HostCapabilities
+	if err := json.Unmarshal(body, &caps); status != http.StatusOK || err != nil {
+		t.Fatalf("capabilities answered %d: %v", status, err)
+	}
+	if caps["h1"].SupportsControls {
+		t.Fatal("read-only datasource advertised controls")
+	}
+}
+
+func TestContractControlAuthorization(t *testing.T) {
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/api/users/lookup" && r.URL.Query().Get("loginOrEmail") == "ops":
+			_, _ = io.WriteString(w, `{"id": 7, "login": "ops"}`)
+		case r.URL.Path == "/api/users/lookup":
+			_, _ = io.WriteString(w, `{"id": 8}`)
+		case r.URL.Path == "/api/users/7/teams":
+			_, _ = io.WriteString(w, `[{"id": 1, "name": "sre"}]`)
+		case r.URL.Path == "/api/users/8/teams":
+			_, _ = io.WriteString(w, `[{"id": 2, "name": "frontend"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(grafana.Close)
+
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{
+		"enableContainerControls": true,
+		"controlAuthorization":    map[string]interface{}{"minRole": "Editor", "allowedTeams": []string{"sre"}},
+	})
+	ds.secrets = map[string]string{"grafanaApiToken": "sa-token"}
+	ctx := backend.WithGrafanaConfig(context.Background(), backend.NewGrafanaCfg(map[string]string{backend.AppURL: grafana.URL}))
+	control := func(user *backend.User) backend.DataResponse {
+		resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Queries: []backend.DataQuery{{
+				RefID: "A",
+				JSON:  json.RawMessage(`{"queryType": "control", "controlAction": "restart", "targetContainer": "web1", "targetHost": "h1"}`),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+
+	for _, tc := range []struct {
+		user      *backend.User
+		wantError string
+	}{
+		{user: nil, wantError: "signed-in Grafana user"},
+		{user: &backend.User{Login: "ops", Role: "Viewer"}, wantError: "require the Editor role"},
+		{user: &backend.User{Login: "dev", Role: "Admin"}, wantError: "membership in one of the teams sre"},
+	} {
+		if resp := control(tc.user); resp.Error == nil || !strings.Contains(resp.Error.Error(), tc.wantError) {
+			t.Errorf("control as %v returned %v, want %q", tc.user, resp.Error, tc.wantError)
+		}
+	}
+	if actions := hosts[0].agent.Actions(); len(actions) != 0 {
+		t.Fatalf("agent received actions %v from unauthorized users", actions)
+	}
+
+	if resp := control(&backend.User{Login: "ops", Role: "Editor"}); resp.Error != nil {
+		t.Fatalf("control by an editor in sre failed: %v", resp.Error)
+	}
+	if actions := hosts[0].agent.Actions(); len(actions) != 1 {
+		t.Fatalf("agent received actions %v, want the authorized restart", actions)
+	}
+}
+
+func TestContractAudit(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{
+		"enableContainerControls": true,
+		"allowedControlActions":   []string{"stop", "start"},
+		"audit":                   map[string]interface{}{"enabled": true, "path": filepath.Join(t.TempDir(), "audit.db")},
+	})
+	editor := &backend.User{Login: "ops", Role: "Editor"}
+	runOn := func(ds *Datasource, user *backend.User, query string) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      json.RawMessage(query),
+				TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+	run := func(user *backend.User, query string) backend.DataResponse { return runOn(ds, user, query) }
+	auditRows := func(resp backend.DataResponse) []string {
+		t.Helper()
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		rows := make([]string, 0, frame.Rows())
+		for i := 0; i < frame.Rows(); i++ {
+			row := make([]string, 0, 5)
+			for _, name := range []string{"user", "action", "containerId", "hostName", "result"} {
+				field, _ := frame.FieldByName(name)
+				row = append(row, field.At(i).(string))
+			}
+			rows = append(rows, strings.Join(row, " "))
+		}
+		return rows
+	}
+
+	run(editor, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	run(editor, `{"queryType": "control", "controlAction": "restart", "targetContainer": "web1", "targetHost": "h1"}`)
+	run(nil, `{"queryType": "control", "controlAction": "start", "targetContainer": "gone", "targetHost": "h1"}`)
+
+	if resp := run(&backend.User{Login: "viewer", Role: "Viewer"}, `{"queryType": "audit"}`); resp.Error == nil {
+		t.Fatal("a viewer read the audit trail")
+	}
+	rows := auditRows(run(editor, `{"queryType": "audit"}`))
+	want := []string{
+		" start gone alpha failed",
+		"ops restart web1  refused",
+		"ops stop web1 alpha success",
+	}
+	if !slices.Equal(rows, want) {
+		t.Fatalf("audit trail = %q, want %q", rows, want)
+	}
+
+	t.Run("read-only", func(t *testing.T) {
+		readOnly := newContractDatasource(t, hosts, map[string]interface{}{
+			"readOnly":                true,
+			"enableContainerControls": true,
+			"audit":                   map[string]interface{}{"enabled": true, "path": filepath.Join(t.TempDir(), "audit.db")},
+		})
+		if resp := runOn(readOnly, editor, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`); !errors.Is(resp.Error, errReadOnly) {
+			t.Fatalf("got error %v, want the read-only refusal", resp.Error)
+		}
+		runOn(readOnly, editor, `{"queryType": "control", "targetHost": "h1", "controlSteps": [{"controlAction": "start", "targetContainer": "db1"}]}`)
+		rows := auditRows(runOn(readOnly, editor, `{"queryType": "audit"}`))
+		want := []string{"ops start db1  refused", "ops stop web1  refused"}
+		if !slices.Equal(rows, want) {
+			t.Fatalf("audit trail = %q, want %q", rows, want)
+		}
+	})
+}
+
+func TestContractControlDisabled(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, nil)
+
+	resp := runContractQuery(t, ds, `{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if resp.Error == nil {
+		t.Fatal("control action succeeded with controls disabled")
+	}
+	if actions := hosts[0].agent.Actions(); len(actions) != 0 {
+		t.Fatalf("agent received actions %v with controls disabled", actions)
+	}
+}
+
+func TestContractFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		fault     agentmock.Fault
+		wantError string // substring of the query error; empty when the response must succeed
+	}{
+		{name: "agent_error", fault: agentmock.Fault{Status: 500, Body: "docker unavailable"}},
+		{name: "changed_schema", fault: agentmock.Fault{Body: `{"data": []}`}, wantError: "host alpha returned an invalid payload: metrics is missing"},
+		{name: "out_of_range", fault: agentmock.Fault{Body: `{"metrics": [{"containerId": "web1", "containerName": "web", "timestamp": 1709294400000, "memoryPercent": 140}]}`}, wantError: "metrics[0].memoryPercent is 140"},
+		{name: "malformed_json", fault: agentmock.Fault{Body: `{"metrics": [`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := startContractHosts(t, "alpha")
+			hosts[0].agent.Fail("/api/metrics", tt.fault)
+			ds := newContractDatasource(t, hosts, nil)
+
+			resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "alerting": true}`)
+			switch {
+			case tt.wantError == "" && resp.Error != nil:
+				t.Fatalf("query failed: %v", resp.Error)
+			case tt.wantError != "" && (resp.Error == nil || !strings.Contains(resp.Error.Error(), tt.wantError)):
+				t.Fatalf("query error %v, want %q", resp.Error, tt.wantError)
+			case tt.wantError == "" && len(resp.Frames) != 0:
+				t.Fatalf("failing host returned %d frames", len(resp.Frames))
+			}
+		})
+	}
+}
+
+func TestContractPartialOutage(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	hosts[1].agent.Fail("/api/metrics", agentmock.Fault{Body: `{"data": []}`})
+	ds := newContractDatasource(t, hosts, nil)
+
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	experimental.CheckGoldenJSONResponse(t, "testdata", "contract_partial_outage", &resp, *updateGolden)
+}
+
+func TestContractFailedHostNotices(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	hosts[1].agent.Fail("/api/metrics", agentmock.Fault{Status: 500, Body: "docker unavailable"})
+	ds := newContractDatasource(t, hosts, nil)
+
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if resp.Error != nil || resp.Status != backend.StatusOK {
+		t.Fatalf("partial outage returned status %d, error %v", resp.Status, resp.Error)
+	}
+	var texts []string
+	for _, n := range resp.Frames[0].Meta.Notices {
+		texts = append(texts, n.Text)
+	}
+	want := []string{"1 of 2 hosts unreachable", "Host beta: unexpected status 500: docker unavailable"}
+	if strings.Join(texts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notices = %q, want %q", texts, want)
+	}
+
+	hosts[0].agent.Fail("/api/metrics", agentmock.Fault{Status: 500, Body: "docker unavailable"})
+	hosts[0].agent.Fail("/api/containers", agentmock.Fault{Status: 500})
+	hosts[1].agent.Fail("/api/containers", agentmock.Fault{Status: 500})
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if resp.Error == nil || resp.Status != backend.StatusBadGateway || !strings.HasPrefix(resp.Error.Error(), "2 of 2 hosts unreachable") {
+		t.Errorf("full outage returned status %d, error %v", resp.Status, resp.Error)
+	}
+}
+
+func TestContractSignedResponses(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := func(key crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	hosts := startContractHosts(t, "alpha", "beta", "gamma")
+	hosts[0].agent.SigningKey = ecKey
+	hosts[0].config.PublicKey = publicPEM(&ecKey.PublicKey)
+	hosts[1].agent.SigningKey = edKey
+	hosts[1].config.PublicKey = publicPEM(edPublic)
+	// gamma's responses are signed by someone else, as by a tampering proxy
+	hosts[2].agent.SigningKey = otherKey
+	hosts[2].config.PublicKey = publicPEM(&ecKey.PublicKey)
+	ds := newContractDatasource(t, hosts, nil)
+	failures := func(resp backend.DataResponse) []string {
+		var texts []string
+		for _, n := range resp.Frames[0].Meta.Notices {
+			texts = append(texts, n.Text)
+		}
+		return texts
+	}
+
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	want := []string{"1 of 3 hosts unreachable", "Host gamma: agent response signature is invalid, the response may have been tampered with"}
+	if got := failures(resp); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notices = %q, want %q", got, want)
+	}
+	// Rejected responses aren't retried
+	metricRequests := 0
+	for _, r := range hosts[2].agent.Requests() {
+		if strings.HasPrefix(r, "GET /api/metrics") {
+			metricRequests++
+		}
+	}
+	if metricRequests != 1 {
+		t.Errorf("gamma got %d metrics requests, want 1", metricRequests)
+	}
+
+	hosts[2].agent.SigningKey = nil
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if got := failures(resp); len(got) != 2 || !strings.Contains(got[1], "agent response is not signed") {
+		t.Errorf("unsigned response notices = %q", got)
+	}
+
+	// Signatures are bound to the time they were made at
+	hosts[1].agent.ClockOffset = -10 * time.Minute
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	hosts[1].agent.ClockOffset = 0
+	if got := strings.Join(failures(resp), "\n"); !strings.Contains(got, "Host beta: agent response signature is stale") {
+		t.Errorf("late signature notices = %q, want beta's signature stale", got)
+	}
+
+	// and to the request, so a proxy can't replay a signed response
+	var mu sync.Mutex
+	type recorded struct {
+		status int
+		header http.Header
+		body   []byte
+	}
+	replays := map[string]*recorded{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		rec := replays[r.URL.Path]
+		if rec == nil {
+			req, _ := http.NewRequest(r.Method, hosts[0].config.URL+r.URL.RequestURI(), nil)
+			req.Header = r.Header.Clone()
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			rec = &recorded{resp.StatusCode, resp.Header.Clone(), body}
+			replays[r.URL.Path] = rec
+		}
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body)
+	}))
+	defer proxy.Close()
+	proxied := hosts[0]
+	proxied.config.URL = proxy.URL
+	t.Run("replay", func(t *testing.T) {
+		ds := newContractDatasource(t, []contractHost{proxied}, nil)
+		if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`); resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+		if resp.Error == nil || !strings.Contains(resp.Error.Error(), "signature is invalid") {
+			t.Errorf("replayed response returned %v, want an invalid signature", resp.Error)
+		}
+	})
+
+	warnings := validateHosts([]HostConfig{{ID: "h1", Name: "alpha", URL: "http://alpha:5000", PublicKey: "not a key"}})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "invalid public key") {
+		t.Errorf("invalid key warnings = %q", warnings)
+	}
+}
+
+func TestContractSecondaryReadThrough(t *testing.T) {
+	var forwarded []map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Queries []map[string]interface{} `json:"queries"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/api/ds/query" || r.Header.Get("Authorization") != "Bearer sa-token" || json.Unmarshal(raw, &body) != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		forwarded = append(forwarded, body.Queries...)
+		frame := data.NewFrame("secondary", data.NewField("time", nil, []time.Time{contractStart}), data.NewField("CPU %", nil, []float64{42}))
+		result := backend.NewQueryDataResponse()
+		result.Responses["A"] = backend.DataResponse{Frames: data.Frames{frame}}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(grafana.Close)
+
+	hosts := startContractHosts(t, "alpha")
+	hosts[0].config.URL = "http://127.0.0.1:1"
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"secondaryDatasourceUid": "passive", "enableContainerControls": true})
+	ds.secrets = map[string]string{"grafanaApiToken": "sa-token"}
+	ctx := backend.WithGrafanaConfig(context.Background(), backend.NewGrafanaCfg(map[string]string{backend.AppURL: grafana.URL}))
+	query := func(q string) backend.DataResponse {
+		resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      json.RawMessage(q),
+			TimeRange: backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+
+	resp := query(`{"metrics": ["cpuPercent"]}`)
+	if resp.Error != nil || len(resp.Frames) != 1 || resp.Frames[0].Name != "secondary" {
+		t.Fatalf("read-through returned %v %v, want the secondary's frame", resp.Frames, resp.Error)
+	}
+	if notices := resp.Frames[0].Meta.Notices; len(notices) != 1 || !strings.Contains(notices[0].Text, "passive") {
+		t.Errorf("notices = %v, want the secondary named", notices)
+	}
+	if len(forwarded) != 1 || forwarded[0]["readThrough"] != true || forwarded[0]["datasource"].(map[string]interface{})["uid"] != "passive" {
+		t.Fatalf("forwarded %v, want one query marked readThrough for passive", forwarded)
+	}
+
+	// Forwarded queries and control actions are never passed on
+	query(`{"metrics": ["cpuPercent"], "readThrough": true}`)
+	query(`{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if len(forwarded) != 1 {
+		t.Errorf("forwarded %d queries, want only the first", len(forwarded))
+	}
+}
+
+func TestContractFederation(t *testing.T) {
+	var forwarded []map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Queries []map[string]interface{} `json:"queries"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/api/ds/query" || r.Header.Get("Authorization") != "Bearer sa-token" || json.Unmarshal(raw, &body) != nil || len(body.Queries) != 1 {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		forwarded = append(forwarded, body.Queries...)
+		if body.Queries[0]["datasource"].(map[string]interface{})["uid"] != "site-b" {
+			http.Error(w, "datasource not found", http.StatusNotFound)
+			return
+		}
+		frame := data.NewFrame("site-b",
+			data.NewField("time", nil, []time.Time{contractStart}),
+			data.NewField("cpuPercent", data.Labels{"hostName": "alpha"}, []float64{42}),
+		)
+		result := backend.NewQueryDataResponse()
+		result.Responses["A"] = backend.DataResponse{Frames: data.Frames{frame}}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(grafana.Close)
+
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{
+		"federatedDatasourceUids": []string{"site-b", "site-c", "site-b"},
+		"enableContainerControls": true,
+	})
+	ds.secrets = map[string]string{"grafanaApiToken": "sa-token"}
+	ctx := backend.WithGrafanaConfig(context.Background(), backend.NewGrafanaCfg(map[string]string{backend.AppURL: grafana.URL}))
+	query := func(q string) backend.DataResponse {
+		resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      json.RawMessage(q),
+			TimeRange: backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+
+	resp := query(`{"metrics": ["cpuPercent"], "hostTags": []}`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	var local, federated int
+	for _, f := range resp.Frames {
+		if f.Name == "containers" || len(f.Fields) < 2 {
+			continue
+		}
+		switch f.Fields[1].Labels["datasource"] {
+		case "":
+			local++
+		case "site-b":
+			federated++
+		default:
+			t.Errorf("frame %s labelled %v", f.Name, f.Fields[1].Labels)
+		}
+	}
+	if local != 2 || federated != 1 {
+		t.Fatalf("got %d local and %d federated frames, want 2 and 1", local, federated)
+	}
+	if notices := resp.Frames[0].Meta.Notices; len(notices) != 1 || !strings.Contains(notices[0].Text, "site-c") {
+		t.Errorf("notices = %v, want the failed site-c named", notices)
+	}
+	if len(forwarded) != 2 || forwarded[0]["readThrough"] != true {
+		t.Fatalf("forwarded %v, want one query marked readThrough per datasource", forwarded)
+	}
+
+	// Forwarded queries and control actions are never federated
+	query(`{"metrics": ["cpuPercent"], "readThrough": true}`)
+	query(`{"queryType": "control", "controlAction": "stop", "targetContainer": "web1", "targetHost": "h1"}`)
+	if len(forwarded) != 2 {
+		t.Errorf("forwarded %d queries, want only the first two", len(forwarded))
+	}
+
+	// An instance without hosts of its own serves the federated results alone
+	t.Run("hostless", func(t *testing.T) {
+		only := newContractDatasource(t, nil, map[string]interface{}{"federatedDatasourceUids": []string{"site-b"}})
+		only.secrets = map[string]string{"grafanaApiToken": "sa-token"}
+		res, err := only.QueryData(ctx, &backend.QueryDataRequest{Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      json.RawMessage(`{"metrics": ["cpuPercent"]}`),
+			TimeRange: backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := res.Responses["A"]; r.Error != nil || len(r.Frames) != 1 || r.Frames[0].Name != "site-b" || len(r.Frames[0].Meta.Notices) != 0 {
+			t.Errorf("federating instance returned %v %v, want only site-b's frame without notices", r.Frames, r.Error)
+		}
+	})
+
+	// Queries refused here aren't answered by the sites with the service account's privileges
+	t.Run("refused", func(t *testing.T) {
+		before := len(forwarded)
+		for _, c := range []struct {
+			setting string
+			value   interface{}
+			query   string
+			want    string
+		}{
+			{"audit", map[string]interface{}{"enabled": true, "path": filepath.Join(t.TempDir(), "audit.db")}, `{"queryType": "audit"}`, "Editor or Admin"},
+			{"scopeTokens", map[string]interface{}{"required": true}, `{"metrics": ["cpuPercent"]}`, "scope token"},
+		} {
+			strict := newContractDatasource(t, hosts, map[string]interface{}{"federatedDatasourceUids": []string{"site-b"}, c.setting: c.value})
+			strict.secrets = map[string]string{"grafanaApiToken": "sa-token", scopeSecretKey: "contract-secret"}
+			res, err := strict.QueryData(ctx, &backend.QueryDataRequest{
+				PluginContext: backend.PluginContext{User: &backend.User{Login: "guest", Role: "Viewer"}},
+				Queries: []backend.DataQuery{{
+					RefID:     "A",
+					JSON:      json.RawMessage(c.query),
+					TimeRange: backend.TimeRange{From: contractStart, To: contractStart.Add(40 * time.Second)},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := res.Responses["A"]; r.Error == nil || !strings.Contains(r.Error.Error(), c.want) || len(r.Frames) != 0 {
+				t.Errorf("viewer query %s returned %d frames, error %v; want refused", c.query, len(r.Frames), r.Error)
+			}
+		}
+		if n := len(forwarded) - before; n != 0 {
+			t.Errorf("forwarded %d refused queries, want none", n)
+		}
+	})
+}
+
+func TestContractTopology(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	hosts[0].agent.AddContainer(agentmock.Container{ID: "web1", Name: "web", Image: "nginx:1.25",
+		Labels:   map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.service": "web", "com.docker.compose.depends_on": "db:service_healthy:false"},
+		Networks: []string{"shop_default", "bridge"},
+		Links:    []string{"cache"},
+	})
+	hosts[0].agent.AddContainer(agentmock.Container{ID: "db1", Name: "db", Image: "postgres:16",
+		Labels:   map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.service": "db"},
+		Networks: []string{"shop_default"},
+	})
+	hosts[0].agent.AddContainer(agentmock.Container{ID: "cache1", Name: "cache", Image: "redis:7", Networks: []string{"host"}})
+	ds := newContractDatasource(t, hosts, nil)
+
+	topology := func(query string) (nodes, edges []string) {
+		t.Helper()
+		resp := runContractQuery(t, ds, query)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		if len(resp.Frames) != 2 || resp.Frames[0].Name != "nodes" || resp.Frames[1].Name != "edges" {
+			t.Fatalf("got frames %v, want nodes and edges", resp.Frames)
+		}
+		for i := 0; i < resp.Frames[0].Rows(); i++ {
+			nodes = append(nodes, fmt.Sprintf("%v %v", resp.Frames[0].Fields[0].At(i), resp.Frames[0].Fields[5].At(i)))
+		}
+		for i := 0; i < resp.Frames[1].Rows(); i++ {
+			edges = append(edges, fmt.Sprintf("%v %v %v", resp.Frames[1].Fields[1].At(i), resp.Frames[1].Fields[2].At(i), resp.Frames[1].Fields[3].At(i)))
+		}
+		sort.Strings(nodes)
+		sort.Strings(edges)
+		return nodes, edges
+	}
+
+	nodes, edges := topology(`{"queryType": "topology"}`)
+	wantNodes := []string{"h1/cache1 container", "h1/db1 container", "h1/network/bridge network", "h1/network/shop_default network", "h1/web1 container"}
+	wantEdges := []string{
+		"h1/db1 h1/network/shop_default network",
+		"h1/web1 h1/cache1 link",
+		"h1/web1 h1/db1 depends_on",
+		"h1/web1 h1/network/bridge network",
+		"h1/web1 h1/network/shop_default network",
+	}
+	if !slices.Equal(nodes, wantNodes) || !slices.Equal(edges, wantEdges) {
+		t.Errorf("topology nodes %q edges %q, want %q and %q", nodes, edges, wantNodes, wantEdges)
+	}
+
+	// Filtered out containers take their edges along
+	nodes, edges = topology(`{"queryType": "topology", "containerNamePattern": "^web$"}`)
+	wantNodes = []string{"h1/network/bridge network", "h1/network/shop_default network", "h1/web1 container"}
+	wantEdges = []string{"h1/web1 h1/network/bridge network", "h1/web1 h1/network/shop_default network"}
+	if !slices.Equal(nodes, wantNodes) || !slices.Equal(edges, wantEdges) {
+		t.Errorf("filtered topology nodes %q edges %q, want %q and %q", nodes, edges, wantNodes, wantEdges)
+	}
+
+	// With required scope tokens, containers outside the token take their edges along too
+	ds = newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+	if resp := runContractQuery(t, ds, `{"queryType": "topology"}`); resp.Error == nil {
+		t.Error("tokenless viewer saw the topology")
+	}
+	token := contractScopeToken(t, ds, []string{"h1"}, []string{"web"})
+	nodes, edges = topology(`{"queryType": "topology", "scopeToken": "` + token + `"}`)
+	if !slices.Equal(nodes, wantNodes) || !slices.Equal(edges, wantEdges) {
+		t.Errorf("scoped topology nodes %q edges %q, want %q and %q", nodes, edges, wantNodes, wantEdges)
+	}
+}
+
+func TestContractRetentionSegments(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	now := time.Now().Truncate(time.Second)
+	hosts[0].agent.AddSamples(agentmock.Sample{ContainerID: "web1", Time: now.Add(-time.Minute), CPUPercent: 20, MemoryBytes: 64 << 20, UptimeSeconds: 100})
+	ds := newContractDatasource(t, hosts, map[string]interface{}{
+		"retention": map[string]interface{}{"enabled": true, "path": filepath.Join(t.TempDir(), "retention.db")},
+	})
+	if ds.retention == nil {
+		t.Fatal("retention store not opened")
+	}
+	stored := ContainerMetric{ContainerID: "web1", ContainerName: "web", Timestamp: SampleTime{Time: now.Add(-7 * time.Hour)}, CPUPercent: 5, IsRunning: true}
+	if err := ds.retention.put("h1", []ContainerMetric{stored}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := `{"metrics": ["cpuPercent"], "containerIds": ["web1"], "hostTags": []}`
+	resp := runContractQueryRange(t, ds, query, backend.TimeRange{From: now.Add(-8 * time.Hour), To: now})
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	var frame *data.Frame
+	for _, f := range resp.Frames {
+		if strings.HasPrefix(f.Name, "web") {
+			frame = f
+		}
+	}
+	if frame == nil {
+		t.Fatal("no web frame")
+	}
+	if n := frame.Rows(); n != 3 || frame.Fields[1].At(1).(*float64) != nil {
+		t.Fatalf("got %d points, want the stored and the live one with a gap between them", n)
+	}
+	custom, _ := frame.Meta.Custom.(map[string]interface{})
+	segments, _ := custom["segments"].([]dataSegment)
+	if len(segments) != 2 || segments[0].Source != segmentRetention || segments[1].Source != segmentAgent {
+		t.Fatalf("got segments %+v, want retention then agent", segments)
+	}
+	if !segments[0].From.Equal(now.Add(-8*time.Hour)) || !segments[1].To.Equal(now) || !segments[0].To.Equal(segments[1].From) {
+		t.Errorf("segments %+v don't cover the range", segments)
+	}
+	found := false
+	for _, n := range frame.Meta.Notices {
+		found = found || strings.Contains(n.Text, "local retention store")
+	}
+	if !found {
+		t.Errorf("no retention notice in %+v", frame.Meta.Notices)
+	}
+
+	// Ranges the agent still keeps are planned without segments
+	resp = runContractQueryRange(t, ds, query, backend.TimeRange{From: now.Add(-time.Hour), To: now})
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	for _, frame := range resp.Frames {
+		if custom, ok := frame.Meta.Custom.(map[string]interface{}); ok && custom["segments"] != nil {
+			t.Errorf("frame %s has segments for a live range", frame.Name)
+		}
+	}
+}
+
+func TestContractInventoryDiff(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	hosts[0].agent.AddContainer(agentmock.Container{ID: "cache1", Name: "cache", Image: "redis:7"})
+	ds := newContractDatasource(t, hosts, nil)
+
+	now := time.Now()
+	ds.inventory.add("h1", inventorySnapshot{Time: now.Add(-time.Hour), Containers: []inventoryEntry{
+		{ContainerID: "cron1", ContainerName: "cron", Image: "busybox:1.36"},
+		{ContainerID: "db0", ContainerName: "db", Image: "postgres:16"},
+		{ContainerID: "web1", ContainerName: "web", Image: "nginx:1.24"},
+	}})
+
+	resp := runContractQueryRange(t, ds, `{"queryType": "inventoryDiff"}`, backend.TimeRange{From: now.Add(-30 * time.Minute), To: now})
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	if len(resp.Frames) != 1 {
+		t.Fatalf("got %d frames, want 1", len(resp.Frames))
+	}
+	frame := resp.Frames[0]
+	want := [][]string{
+		{"cache", inventoryAdded, "cache1", "", "redis:7", ""},
+		{"cron", inventoryRemoved, "", "cron1", "", "busybox:1.36"},
+		{"db", inventoryRecreated, "db1", "db0", "postgres:16", "postgres:16"},
+		{"web", inventoryImageChanged, "web1", "web1", "nginx:1.25", "nginx:1.24"},
+	}
+	if frame.Rows() != len(want) {
+		t.Fatalf("got %d changes, want %d", frame.Rows(), len(want))
+	}
+	for i, row := range want {
+		for j, name := range []string{"containerName", "change", "containerId", "previousContainerId", "image", "previousImage"} {
+			field, _ := frame.FieldByName(name)
+			if got := field.At(i).(string); got != row[j] {
+				t.Errorf("row %d %s = %q, want %q", i, name, got, row[j])
+			}
+		}
+	}
+
+	// Nothing was recorded that far back
+	resp = runContractQueryRange(t, ds, `{"queryType": "inventoryDiff"}`, backend.TimeRange{From: now.Add(-3 * time.Hour), To: now})
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "no container inventory recorded") {
+		t.Errorf("got error %v, want missing inventory", resp.Error)
+	}
+
+	// Persisted lists are read from the retention store
+	t.Run("retention", func(t *testing.T) {
+		ds := newContractDatasource(t, hosts, map[string]interface{}{
+			"retention": map[string]interface{}{"enabled": true, "path": filepath.Join(t.TempDir(), "retention.db")},
+		})
+		for _, ago := range []time.Duration{4 * time.Hour, 2 * time.Hour} {
+			err := ds.retention.putInventory("h1", inventorySnapshot{Time: now.Add(-ago), Containers: []inventoryEntry{
+				{ContainerID: "web1", ContainerName: "web", Image: "nginx:" + ago.String()},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp := runContractQueryRange(t, ds, `{"queryType": "inventoryDiff", "containerNamePattern": "^web$"}`, backend.TimeRange{From: now.Add(-3 * time.Hour), To: now.Add(-time.Hour)})
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		if frame.Rows() != 1 {
+			t.Fatalf("got %d changes, want 1", frame.Rows())
+		}
+		previous, _ := frame.FieldByName("previousImage")
+		image, _ := frame.FieldByName("image")
+		if previous.At(0) != "nginx:4h0m0s" || image.At(0) != "nginx:2h0m0s" {
+			t.Errorf("got %v -> %v, want the stored lists before each time point", previous.At(0), image.At(0))
+		}
+	})
+
+	// With required scope tokens, viewers only see changes to the token's hosts and containers
+	t.Run("scoped", func(t *testing.T) {
+		hosts := startContractHosts(t, "alpha", "beta")
+		ds := newContractDatasource(t, hosts, map[string]interface{}{"scopeTokens": map[string]interface{}{"required": true}})
+		for _, id := range []string{"h1", "h2"} {
+			ds.inventory.add(id, inventorySnapshot{Time: now.Add(-time.Hour), Containers: []inventoryEntry{
+				{ContainerID: "db0", ContainerName: "db", Image: "postgres:16"},
+				{ContainerID: "web1", ContainerName: "web", Image: "nginx:1.24"},
+			}})
+		}
+		timeRange := backend.TimeRange{From: now.Add(-30 * time.Minute), To: now}
+		if resp := runContractQueryRange(t, ds, `{"queryType": "inventoryDiff"}`, timeRange); resp.Error == nil {
+			t.Error("tokenless viewer saw the inventory diff")
+		}
+		token := contractScopeToken(t, ds, []string{"h1"}, []string{"web"})
+		resp := runContractQueryRange(t, ds, `{"queryType": "inventoryDiff", "scopeToken": "`+token+`"}`, timeRange)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		name, _ := frame.FieldByName("containerName")
+		host, _ := frame.FieldByName("hostName")
+		if frame.Rows() != 1 {
+			t.Fatalf("scoped query returned %d changes, want only alpha's web container", frame.Rows())
+		}
+		if name.At(0) != "web" || host.At(0) != "alpha" {
+			t.Errorf("scoped query returned %v on %v, want alpha's web container", name.At(0), host.At(0))
+		}
+	})
+}
+
+func TestContractSwarmServices(t *testing.T) {
+	hosts := startContractHosts(t, "manager", "worker")
+	task := func(id, service, slot string) agentmock.Container {
+		return agentmock.Container{ID: id, Name: service + "." + slot + "." + id, Image: service + ":1", Labels: map[string]string{
+			"com.docker.swarm.service.name": service,
+			"com.docker.swarm.task.name":    service + "." + slot + "." + id,
+		}}
+	}
+	replaced := task("t1", "web", "1")
+	replaced.State = agentmock.StateExited
+	hosts[0].agent.AddContainer(replaced)
+	hosts[0].agent.AddContainer(task("t1b", "web", "1"))
+	hosts[1].agent.AddContainer(task("t2", "web", "2"))
+	api := task("t3", "api", "1")
+	api.HealthStatus = agentmock.HealthUnhealthy
+	hosts[1].agent.AddContainer(api)
+	for i := 0; i < 4; i++ {
+		at := contractStart.Add(time.Duration(i) * 10 * time.Second)
+		// t1 was rescheduled as t1b halfway through
+		rescheduled := "t1"
+		if i >= 2 {
+			rescheduled = "t1b"
+		}
+		hosts[0].agent.AddSamples(agentmock.Sample{ContainerID: rescheduled, Time: at, CPUPercent: 10})
+		hosts[1].agent.AddSamples(agentmock.Sample{ContainerID: "t2", Time: at, CPUPercent: 20}, agentmock.Sample{ContainerID: "t3", Time: at, CPUPercent: 5})
+	}
+	hosts[0].agent.SetSwarmServices(
+		agentmock.SwarmService{ID: "s1", Name: "web", DesiredTasks: 3, RunningTasks: 2},
+		agentmock.SwarmService{ID: "s2", Name: "api", DesiredTasks: 1, RunningTasks: 1},
+		agentmock.SwarmService{ID: "s3", Name: "batch", DesiredTasks: 2},
+	)
+
+	disabled := newContractDatasource(t, hosts, nil)
+	resp := runContractQuery(t, disabled, `{"queryType": "swarmServices"}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "swarm mode is not enabled") {
+		t.Fatalf("got error %v, want swarm mode disabled", resp.Error)
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		ds := newContractDatasource(t, hosts, map[string]interface{}{"swarm": map[string]interface{}{"enabled": true}})
+		resp := runContractQuery(t, ds, `{"queryType": "swarmServices", "metrics": ["cpuPercent"]}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		if frame.Rows() != 3 {
+			t.Fatalf("got %d services, want 3", frame.Rows())
+		}
+		field := func(name string) *data.Field {
+			f, _ := frame.FieldByName(name)
+			if f == nil {
+				t.Fatalf("no %s field", name)
+			}
+			return f
+		}
+		// Rows are api, batch, web
+		if got := field("swarmService").At(2); got != "web" {
+			t.Fatalf("row 2 is %v, want web", got)
+		}
+		if got := *field("desiredReplicas").At(2).(*int64); got != 3 {
+			t.Errorf("web desires %d replicas, want 3", got)
+		}
+		if got := *field("runningReplicas").At(2).(*int64); got != 2 {
+			t.Errorf("web runs %d replicas, want 2", got)
+		}
+		if got := field("nodes").At(2); got != int64(2) {
+			t.Errorf("web runs on %v nodes, want 2", got)
+		}
+		if got := field("tasks").At(2); got != int64(3) {
+			t.Errorf("web has %v task containers, want 3", got)
+		}
+		if got := *field("cpuPercent").At(2).(*float64); got != 30 {
+			t.Errorf("web uses %v%% CPU, want 30", got)
+		}
+		if got := field("unhealthy").At(0); got != int64(1) {
+			t.Errorf("api has %v unhealthy tasks, want 1", got)
+		}
+		if got := field("tasks").At(1); got != int64(0) || field("cpuPercent").At(1).(*float64) != nil {
+			t.Errorf("batch has tasks or usage without containers")
+		}
+
+		// A rescheduled task continues the series of its slot
+		resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "hostIds": ["h1"], "aggregateBy": "swarm-task", "hostTags": []}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		found := false
+		for _, f := range resp.Frames {
+			if len(f.Fields) < 2 || f.Fields[1].Labels["swarmTask"] != "web.1" {
+				continue
+			}
+			found = true
+			if f.Rows() != 4 {
+				t.Errorf("web.1 has %d points, want 4", f.Rows())
+			}
+		}
+		if !found {
+			t.Error("no web.1 series")
+		}
+	})
+
+	// Without a manager the services are still listed from their containers
+	t.Run("no manager", func(t *testing.T) {
+		ds := newContractDatasource(t, hosts[1:], map[string]interface{}{"swarm": map[string]interface{}{"enabled": true}})
+		resp := runContractQuery(t, ds, `{"queryType": "swarmServices"}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		if frame.Rows() != 2 || frame.Fields[2].At(0).(*int64) != nil {
+			t.Errorf("got %d rows with replicas %v, want api and web with unknown replicas", frame.Rows(), frame.Fields[2].At(0))
+		}
+		if len(frame.Meta.Notices) == 0 {
+			t.Error("no notice about the missing manager")
+		}
+	})
+
+	// With required scope tokens, viewers only see services with a task in the token's scope
+	t.Run("scoped", func(t *testing.T) {
+		ds := newContractDatasource(t, hosts, map[string]interface{}{
+			"swarm":       map[string]interface{}{"enabled": true},
+			"scopeTokens": map[string]interface{}{"required": true},
+		})
+		if resp := runContractQuery(t, ds, `{"queryType": "swarmServices"}`); resp.Error == nil {
+			t.Error("tokenless viewer saw the swarm services")
+		}
+		token := contractScopeToken(t, ds, []string{"h1", "h2"}, []string{"t2"})
+		resp := runContractQuery(t, ds, `{"queryType": "swarmServices", "metrics": ["cpuPercent"], "scopeToken": "`+token+`"}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		frame := resp.Frames[0]
+		if frame.Rows() != 1 {
+			t.Fatalf("scoped query returned %d services, want only web", frame.Rows())
+		}
+		tasks, _ := frame.FieldByName("tasks")
+		cpu, _ := frame.FieldByName("cpuPercent")
+		if frame.Fields[0].At(0) != "web" || tasks.At(0) != int64(1) || *cpu.At(0).(*float64) != 20 {
+			t.Errorf("got %v with %v tasks and %v%% CPU, want web with t2 alone", frame.Fields[0].At(0), tasks.At(0), *cpu.At(0).(*float64))
+		}
+	})
+}
+
+func TestContractTransforms(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	hosts[0].agent.AddContainer(agentmock.Container{ID: "proxy1", Name: "proxy", Image: "haproxy:2"})
+	// The counter resets at the last sample, e.g. after a restart
+	for i, rx := range []float64{0, 10, 30, 5} {
+		hosts[0].agent.AddSamples(agentmock.Sample{ContainerID: "proxy1", Time: contractStart.Add(time.Duration(i) * 10 * time.Second), NetworkRxBytes: rx * (1 << 20)})
+	}
+	ds := newContractDatasource(t, hosts, nil)
+
+	series := func(transform string) (*data.Field, []*float64) {
+		t.Helper()
+		resp := runContractQuery(t, ds, `{"metrics": ["networkRxBytes"], "containerIds": ["proxy1"], "hostTags": [], "transforms": {"networkRxBytes": "`+transform+`"}}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		for _, frame := range resp.Frames {
+			if strings.HasPrefix(frame.Name, "proxy") {
+				field := frame.Fields[1]
+				values := make([]*float64, field.Len())
+				for i := range values {
+					values[i] = field.At(i).(*float64)
+				}
+				return field, values
+			}
+		}
+		t.Fatal("no proxy series")
+		return nil, nil
+	}
+	check := func(transform string, want []float64) {
+		t.Helper()
+		_, got := series(transform)
+		if len(got) != len(want) || got[0] != nil {
+			t.Fatalf("%s: got %d points starting with %v, want %d starting with null", transform, len(got), got[0], len(want))
+		}
+		for i := 1; i < len(want); i++ {
+			if got[i] == nil || *got[i] != want[i] {
+				t.Errorf("%s: point %d = %v, want %v", transform, i, got[i], want[i])
+			}
+		}
+	}
+
+	check(TransformDelta, []float64{0, 10, 20, 5})
+	check(TransformRate, []float64{0, 1, 2, 0.5})
+	field, _ := series(TransformRate)
+	if field.Config.Unit != "MBs" || !strings.Contains(field.Config.DisplayName, "Network RX (MB/s)") {
+		t.Errorf("rate series has unit %q and name %q, want MB/s", field.Config.Unit, field.Config.DisplayName)
+	}
+
+	resp := runContractQuery(t, ds, `{"metrics": ["networkRxBytes"], "transforms": {"networkRxBytes": "integral"}}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "must be rate, delta or none") {
+		t.Errorf("got error %v, want the transform refused", resp.Error)
+	}
+	resp = runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "transforms": {"cpuPercent": "rate"}}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "cpuPercent is not a counter") {
+		t.Errorf("got error %v, want the rate of a gauge refused", resp.Error)
+	}
+}
+
+func TestContractChaos(t *testing.T) {
+	hosts := startContractHosts(t, "h1", "h2", "h3")
+	settings := map[string]interface{}{"chaos": map[string]interface{}{
+		"enabled": true, "errorRate": 1, "errorStatus": 500, "hostIds": []string{"h1"},
+	}}
+	seriesHosts := func(resp backend.DataResponse) []string {
+		seen := make([]string, 0)
+		for _, frame := range resp.Frames {
+			if len(frame.Fields) < 2 || frame.Fields[1].Labels["hostName"] == "" {
+				continue
+			}
+			if name := frame.Fields[1].Labels["hostName"]; !slices.Contains(seen, name) {
+				seen = append(seen, name)
+			}
+		}
+		sort.Strings(seen)
+		return seen
+	}
+	query := `{"queryType": "metrics", "metrics": ["cpuPercent"], "hostTags": []}`
+
+	refused := newContractDatasource(t, hosts, settings)
+	if got := seriesHosts(runContractQuery(t, refused, query)); !slices.Equal(got, []string{"h1", "h2", "h3"}) {
+		t.Fatalf("got series of %v without %s, want every host", got, chaosEnvVar)
+	}
+	if !slices.ContainsFunc(refused.hostWarnings, func(w string) bool { return strings.Contains(w, "is ignored") }) {
+		t.Fatalf("got warnings %v, want the refused chaos settings", refused.hostWarnings)
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		t.Setenv(chaosEnvVar, "true")
+		settings["chaos"].(map[string]interface{})["latencyMs"] = 20
+		ds := newContractDatasource(t, hosts, settings)
+		resp := runContractQuery(t, ds, query)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		if got := seriesHosts(resp); !slices.Equal(got, []string{"h2", "h3"}) {
+			t.Fatalf("got series of %v, want h1 failing", got)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Setenv(chaosEnvVar, "true")
+		ds := newContractDatasource(t, hosts, map[string]interface{}{"chaos": map[string]interface{}{
+			"enabled": true, "malformedRate": 1, "hostIds": []string{"h2"}, "paths": []string{"/api/metrics"},
+		}})
+		resp := runContractQuery(t, ds, query)
+		if got := seriesHosts(resp); !slices.Equal(got, []string{"h1", "h3"}) {
+			t.Fatalf("got series of %v, want h2's payload rejected", got)
+		}
+	})
+}
+
+func TestContractGapsAndNonFiniteValues(t *testing.T) {
+	hosts := startContractHosts(t, "h1")
+	webFrame := func(resp backend.DataResponse) *data.Frame {
+		t.Helper()
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		for _, f := range resp.Frames {
+			if strings.HasPrefix(f.Name, "web") {
+				return f
+			}
+		}
+		t.Fatal("no web frame")
+		return nil
+	}
+	query := `{"queryType": "metrics", "metrics": ["cpuPercent"], "containerIds": ["web1"], "downsample": "none"}`
+
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"heartbeatSeconds": 5})
+	frame := webFrame(runContractQuery(t, ds, query))
+	// Samples every 10s with a 5s heartbeat get a null halfway between each pair
+	if frame.Rows() != 9 {
+		t.Fatalf("got %d points, want 5 samples and 4 gaps", frame.Rows())
+	}
+	for i := 0; i < frame.Rows(); i++ {
+		value := frame.Fields[1].At(i).(*float64)
+		if (value == nil) != (i%2 == 1) {
+			t.Fatalf("got point %d = %v, want nulls between samples", i, value)
+		}
+		if i == 1 && !frame.Fields[0].At(i).(time.Time).Equal(contractStart.Add(5*time.Second)) {
+			t.Fatalf("got the first gap at %v, want halfway", frame.Fields[0].At(i))
+		}
+	}
+
+	t.Run("non-finite", func(t *testing.T) {
+		body := fmt.Sprintf(`{"metrics": [
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "cpuPercent": 10, "memoryBytes": 1},
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "cpuPercent": "NaN", "memoryBytes": 1},
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "cpuPercent": "Infinity", "memoryBytes": 1,
+			 "cpuPressure": {"some10": "NaN", "some60": 1, "some300": 1, "full10": 0, "full60": 0, "full300": 0}}
+		]}`, contractStart.Format(time.RFC3339), contractStart.Add(10*time.Second).Format(time.RFC3339), contractStart.Add(20*time.Second).Format(time.RFC3339))
+		hosts[0].agent.Fail("/api/metrics", agentmock.Fault{Body: body})
+		ds := newContractDatasource(t, hosts, nil)
+		frame := webFrame(runContractQuery(t, ds, query))
+		if frame.Rows() != 3 || frame.Fields[1].At(0).(*float64) == nil {
+			t.Fatalf("got %d points, want the 3 samples", frame.Rows())
+		}
+		for i := 1; i < 3; i++ {
+			if value := frame.Fields[1].At(i).(*float64); value != nil {
+				t.Fatalf("got point %d = %v, want non-finite values as null", i, *value)
+			}
+		}
+
+		stored := ContainerMetric{ContainerID: "web1", CPUPercent: math.NaN(), MemoryBytes: math.Inf(1)}
+		raw, err := json.Marshal(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded ContainerMetric
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !math.IsNaN(decoded.CPUPercent) || !math.IsInf(decoded.MemoryBytes, 1) || decoded.ContainerID != "web1" {
+			t.Fatalf("got %+v from %s, want the non-finite values back", decoded, raw)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		body := fmt.Sprintf(`{"metrics": [
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "cpuPercent": 10, "memoryBytes": 1048576},
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "memoryBytes": 1048576},
+			{"containerId": "web1", "containerName": "web", "timestamp": %q, "cpuPercent": null}
+		]}`, contractStart.Format(time.RFC3339), contractStart.Add(10*time.Second).Format(time.RFC3339), contractStart.Add(20*time.Second).Format(time.RFC3339))
+		hosts[0].agent.Fail("/api/metrics", agentmock.Fault{Body: body})
+		ds := newContractDatasource(t, hosts, nil)
+		resp := runContractQuery(t, ds, `{"queryType": "metrics", "metrics": ["cpuPercent", "memoryBytes"], "containerIds": ["web1"], "downsample": "none"}`)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		got := make(map[string][]*float64)
+		for _, f := range resp.Frames {
+			if strings.HasPrefix(f.Name, "web") {
+				got[f.Fields[1].Name] = []*float64{f.Fields[1].At(0).(*float64), f.Fields[1].At(1).(*float64), f.Fields[1].At(2).(*float64)}
+			}
+		}
+		cpu, memory := got["CPU %"], got["Memory (MB)"]
+		if len(cpu) != 3 || cpu[0] == nil || cpu[1] != nil || cpu[2] != nil {
+			t.Fatalf("got cpuPercent %v, want nulls where the samples lack it", cpu)
+		}
+		if len(memory) != 3 || memory[0] == nil || memory[1] == nil || memory[2] != nil {
+			t.Fatalf("got memoryBytes %v, want a null where the sample lacks it", memory)
+		}
+	})
+}
+
+func TestContractRetentionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention.db")
+	store, err := openRetentionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []ContainerMetric
+	for i := 0; i < 5; i++ {
+		ts := SampleTime{Time: contractStart.Add(time.Duration(i) * 10 * time.Second)}
+		samples = append(samples,
+			ContainerMetric{ContainerID: "web1", Timestamp: ts, CPUPercent: float64(i), MemoryBytes: math.NaN(), IsRunning: true},
+			ContainerMetric{ContainerID: "db1", Timestamp: ts, CPUPercent: float64(10 + i), IOPressure: &PSIMetrics{Some10: 1.5}})
+	}
+	if err := store.put("h1", samples); err != nil {
+		t.Fatal(err)
+	}
+	// overlapping ingests overwrite rather than duplicate
+	if err := store.put("h1", samples[2:6]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = openRetentionStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+
+	byContainer := func(metrics []ContainerMetric) map[string][]float64 {
+		out := map[string][]float64{}
+		for _, m := range metrics {
+			out[m.ContainerID] = append(out[m.ContainerID], m.CPUPercent)
+		}
+		return out
+	}
+
+	all, err := store.rangeQuery("h1", contractStart, contractStart.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := byContainer(all), map[string][]float64{"web1": {0, 1, 2, 3, 4}, "db1": {10, 11, 12, 13, 14}}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("stored samples = %v, want %v", got, want)
+	}
+	for _, m := range all {
+		switch m.ContainerID {
+		case "web1":
+			if !m.Timestamp.Equal(contractStart.Add(time.Duration(m.CPUPercent) * 10 * time.Second)) {
+				t.Fatalf("web1 sample %v stored at %v", m.CPUPercent, m.Timestamp.Time)
+			}
+			if !math.IsNaN(m.MemoryBytes) || !m.IsRunning {
+				t.Fatalf("web1 sample did not round-trip: %+v", m)
+			}
+		case "db1":
+			if m.IOPressure == nil || m.IOPressure.Some10 != 1.5 || m.IOPressure.Full10 != 0 {
+				t.Fatalf("db1 pressure did not round-trip: %+v", m.IOPressure)
+			}
+		}
+	}
+
+	window, err := store.rangeQuery("h1", contractStart.Add(10*time.Second), contractStart.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := byContainer(window), map[string][]float64{"web1": {1, 2}, "db1": {11, 12}}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("range [10s, 30s) = %v, want %v", got, want)
+	}
+	if other, err := store.rangeQuery("h2", contractStart, contractStart.Add(time.Minute)); err != nil || len(other) != 0 {
+		t.Fatalf("unknown host returned %v, %v", other, err)
+	}
+
+	removed, err := store.prune(contractStart.Add(30 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 6 {
+		t.Fatalf("prune removed %d samples, want 6", removed)
+	}
+	kept, err := store.rangeQuery("h1", contractStart, contractStart.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := byContainer(kept), map[string][]float64{"web1": {3, 4}, "db1": {13, 14}}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after prune = %v, want %v", got, want)
+	}
+	if removed, err := store.prune(contractStart.Add(30 * time.Second)); err != nil || removed != 0 {
+		t.Fatalf("second prune removed %d, %v", removed, err)
+	}
+}
+
+func TestContractRemoteWrite(t *testing.T) {
+	type pbField struct {
+		num   protowire.Number
+		bytes []byte
+		value uint64
+	}
+	decode := func(b []byte) []pbField {
+		var out []pbField
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("bad tag: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			f := pbField{num: num}
+			switch typ {
+			case protowire.BytesType:
+				f.bytes, n = protowire.ConsumeBytes(b)
+			case protowire.Fixed64Type:
+				f.value, n = protowire.ConsumeFixed64(b)
+			case protowire.VarintType:
+				f.value, n = protowire.ConsumeVarint(b)
+			default:
+				t.Fatalf("unexpected wire type %v", typ)
+			}
+			if n < 0 {
+				t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			out = append(out, f)
+		}
+		return out
+	}
+
+	var received []byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "pusher" || pass != "hunter2" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var err error
+		if received, err = snappy.Decode(nil, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer endpoint.Close()
+
+	exporter := newRemoteWriteExporter(RemoteWriteSettings{Enabled: true, URL: endpoint.URL, Username: "pusher"}, map[string]string{"remoteWritePassword": "hunter2"})
+	batch := exportBatch{
+		host: HostConfig{ID: "h1", Name: "alpha"},
+		// host labels colliding with the built-in labels or each other are dropped
+		labels: map[string]string{"team.name": "ops", "team_name": "dev", "host": "other", "__name__": "spoofed", "container.id": "x"},
+		images: map[string]string{"web1": "nginx:1.25"},
+		metrics: []ContainerMetric{
+			{ContainerID: "web1", ContainerName: "web", Timestamp: SampleTime{Time: contractStart.Add(10 * time.Second)}, CPUPercent: 2.5, MemoryBytes: math.NaN()},
+			{ContainerID: "web1", ContainerName: "web", Timestamp: SampleTime{Time: contractStart}, CPUPercent: 1.5, MemoryBytes: 4096},
+		},
+	}
+	if err := exporter.export(context.Background(), []exportBatch{batch}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, ts := range decode(received) {
+		if ts.num != 1 {
+			t.Fatalf("WriteRequest field %d, want only timeseries", ts.num)
+		}
+		var labels []string
+		var names []string
+		var samples []string
+		for _, f := range decode(ts.bytes) {
+			switch f.num {
+			case 1:
+				label := decode(f.bytes)
+				if len(label) != 2 || label[0].num != 1 || label[1].num != 2 {
+					t.Fatalf("malformed label %v", label)
+				}
+				names = append(names, string(label[0].bytes))
+				labels = append(labels, string(label[0].bytes)+"="+string(label[1].bytes))
+			case 2:
+				sample := decode(f.bytes)
+				if len(sample) != 2 || sample[0].num != 1 || sample[1].num != 2 {
+					t.Fatalf("malformed sample %v", sample)
+				}
+				samples = append(samples, fmt.Sprintf("%v@%d", math.Float64frombits(sample[0].value), int64(sample[1].value)))
+			}
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Fatalf("labels are not sorted by name: %v", names)
+		}
+		if len(slices.Compact(slices.Clone(names))) != len(names) {
+			t.Fatalf("duplicate label names: %v", names)
+		}
+		got[strings.Join(labels, ",")] = strings.Join(samples, " ")
+	}
+
+	start := contractStart.UnixMilli()
+	common := "container_id=web1,container_name=web,host=alpha,host_id=h1,image=nginx:1.25,team_name=ops"
+	if samples := got["__name__=docker_container_cpu_percent,"+common]; samples != fmt.Sprintf("1.5@%d 2.5@%d", start, start+10000) {
+		t.Fatalf("cpu series = %q, want both samples in time order; series: %v", samples, got)
+	}
+	// a missing value is left out of the series rather than written as zero
+	if samples := got["__name__=docker_container_memory_bytes,"+common]; samples != fmt.Sprintf("4096@%d", start) {
+		t.Fatalf("memory series = %q, want only the first sample; series: %v", samples, got)
+	}
+
+	exporter.password = "wrong"
+	if err := exporter.export(context.Background(), []exportBatch{batch}); err == nil || !strings.Contains(err.Error(), "unexpected status 401") {
+		t.Fatalf("rejected push returned %v, want the endpoint's status", err)
+	}
+}
+
+func TestContractOTLPExport(t *testing.T) {
+	var received otlpRequest
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Authorization") != "Bearer otlp-token" {
+			http.Error(w, "bad request", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer endpoint.Close()
+
+	exporter := newOTLPExporter(OTLPSettings{Enabled: true, Endpoint: endpoint.URL}, map[string]string{"otlpAuthorization": "Bearer otlp-token"})
+	batch := exportBatch{
+		host:   HostConfig{ID: "h1", Name: "alpha"},
+		images: map[string]string{"web1": "nginx:1.25"},
+		metrics: []ContainerMetric{
+			{ContainerID: "web1", ContainerName: "/web", Timestamp: SampleTime{Time: contractStart.Add(10 * time.Second)}, CPUPercent: 2.5, NetworkRxBytes: 2048},
+			{ContainerID: "web1", ContainerName: "/web", Timestamp: SampleTime{Time: contractStart}, CPUPercent: 1.5, NetworkRxBytes: 1024},
+		},
+	}
+	if err := exporter.export(context.Background(), []exportBatch{batch}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received.ResourceMetrics) != 1 {
+		t.Fatalf("got %d resources, want one per container", len(received.ResourceMetrics))
+	}
+	resource := received.ResourceMetrics[0]
+	attrs := map[string]string{}
+	for _, a := range resource.Resource.Attributes {
+		attrs[a.Key] = a.Value["stringValue"]
+	}
+	if attrs["host.name"] != "alpha" || attrs["container.name"] != "web" || attrs["container.image.name"] != "nginx:1.25" {
+		t.Errorf("resource attributes %v, want alpha's web container", attrs)
+	}
+	metrics := map[string]otlpMetric{}
+	for _, m := range resource.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	// Percentages stay gauges, byte counters are cumulative monotonic sums
+	cpu := metrics["docker.container.cpu_percent"]
+	if cpu.Gauge == nil || cpu.Sum != nil || len(cpu.Gauge.DataPoints) != 2 || cpu.Gauge.DataPoints[0].AsDouble != 1.5 {
+		t.Errorf("cpu metric %+v, want a gauge of both samples in time order", cpu)
+	}
+	rx := metrics["docker.container.network_rx_bytes"]
+	if rx.Sum == nil || rx.Gauge != nil {
+		t.Fatalf("network rx metric %+v, want a sum", rx)
+	}
+	if rx.Sum.AggregationTemporality != otlpCumulative || !rx.Sum.IsMonotonic || len(rx.Sum.DataPoints) != 2 || rx.Sum.DataPoints[1].AsDouble != 2048 {
+		t.Errorf("network rx sum %+v, want cumulative and monotonic", rx.Sum)
+	}
+}
+
+func TestContractDNSDiscovery(t *testing.T) {
+	// A DNS server answering every question with the agents' SRV records
+	type srv struct {
+		priority, port uint16
+		target         string
+	}
+	records := []srv{{10, 5000, "agent-a.example.com."}, {20, 5001, "agent-b.example.com."}}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			// the question follows the 12 byte header: labels, then type and class
+			end := 12
+			for end < n && query[end] != 0 {
+				end += int(query[end]) + 1
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			reply := append([]byte{}, query[:2]...)
+			reply = append(reply, 0x81, 0x80, 0, 1)
+			reply = binary.BigEndian.AppendUint16(reply, uint16(len(records)))
+			reply = append(reply, 0, 0, 0, 0)
+			reply = append(reply, query[12:end]...)
+			for _, r := range records {
+				var target []byte
+				for _, label := range strings.Split(strings.TrimSuffix(r.target, "."), ".") {
+					target = append(append(target, byte(len(label))), label...)
+				}
+				target = append(target, 0)
+				reply = append(reply, 0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60)
+				reply = binary.BigEndian.AppendUint16(reply, uint16(6+len(target)))
+				reply = binary.BigEndian.AppendUint16(reply, r.priority)
+				reply = binary.BigEndian.AppendUint16(reply, 1)
+				reply = binary.BigEndian.AppendUint16(reply, r.port)
+				reply = append(reply, target...)
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+
+	d, err := newDiscoverer(DiscoverySettings{Mode: "dns-srv", SRVRecord: "_dockermetrics._tcp.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discoverer := d.(*dnsSRVDiscoverer)
+	discoverer.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "udp", conn.LocalAddr().String())
+	}}
+	got, err := discoverer.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostConfig{
+		{ID: discoveredHostID("dns", "agent-a.example.com:5000"), Name: "agent-a.example.com", URL: "http://agent-a.example.com:5000", Enabled: true},
+		{ID: discoveredHostID("dns", "agent-b.example.com:5001"), Name: "agent-b.example.com", URL: "http://agent-b.example.com:5001", Enabled: true},
+	}
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("SRV hosts = %+v, want one host per record in priority order %+v", got, want)
+	}
+
+	if _, err := newDiscoverer(DiscoverySettings{Mode: "dns-srv"}, nil); err == nil {
+		t.Error("dns-srv discovery without a record was accepted")
+	}
+}
+
+func TestContractExport(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, nil)
+	call := func(method, url, body string) (int, string) {
+		t.Helper()
+		return callContractResource(t, ds, method, url, body)
+	}
+	from, to := contractStart.Add(-time.Minute), contractStart.Add(time.Minute)
+	exportBody := fmt.Sprintf(`{"query": {"metrics": ["cpuPercent"], "containerIds": ["web1"]}, "from": "%d", "to": "%d"}`, from.UnixMilli(), to.UnixMilli())
+
+	// CSV has one row per sample with a column per label
+	status, body := call("POST", "export?format=csv", exportBody)
+	if status != http.StatusOK {
+		t.Fatalf("csv export returned %d: %s", status, body)
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 || !slices.Equal(records[0][:4], []string{"time", "frame", "field", "value"}) {
+		t.Fatalf("csv export %q, want a header and five samples", records)
+	}
+	nameColumn := slices.Index(records[0], "containerName")
+	if nameColumn < 0 {
+		t.Fatalf("csv header %q has no containerName column", records[0])
+	}
+	for i, record := range records[1:] {
+		if want := contractStart.Add(time.Duration(i) * 10 * time.Second).Format(time.RFC3339Nano); record[0] != want || record[3] != strconv.Itoa(10+i) || record[nameColumn] != "web" {
+			t.Errorf("csv row %d = %q, want web at %s with %d%% CPU", i, record, want, 10+i)
+		}
+	}
+
+	// NDJSON has the same samples as one object per line, the containers frame row by row
+	status, body = call("POST", "export", exportBody)
+	if status != http.StatusOK {
+		t.Fatalf("ndjson export returned %d: %s", status, body)
+	}
+	var values []float64
+	tableRows := 0
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		var row exportRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("ndjson line %q: %v", line, err)
+		}
+		if row.Frame == "containers" {
+			tableRows++
+			continue
+		}
+		if row.Labels["containerName"] != "web" {
+			t.Fatalf("ndjson row %+v, want only web's samples", row)
+		}
+		values = append(values, row.Value.(float64))
+	}
+	if !slices.Equal(values, []float64{10, 11, 12, 13, 14}) || tableRows != 2 {
+		t.Errorf("ndjson cpu values %v and %d container rows, want web's five samples and alpha's two containers", values, tableRows)
+	}
+
+	for _, bad := range []struct{ url, body string }{
+		{"export?format=xml", exportBody},
+		{"export", `{"query": {"queryType": "control"}}`},
+		{"export", `{"query": {"metrics": ["cpuPercent"]}, "from": "yesterday"}`},
+	} {
+		if status, _ := call("POST", bad.url, bad.body); status != http.StatusBadRequest {
+			t.Errorf("export %s %s returned %d, want 400", bad.url, bad.body, status)
+		}
+	}
+
+}
+
+func TestContractGraphiteRender(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	ds := newContractDatasource(t, hosts, nil)
+	from, to := contractStart.Add(-time.Minute), contractStart.Add(time.Minute)
+
+	// Each path node is matched with wildcards and values are returned raw
+	status, body := callContractResource(t, ds, "GET", fmt.Sprintf("render?target=alpha.web.cpuPercent&target=alpha.d%%3F.cpu*&from=%d&until=%d", from.Unix(), to.Unix()), "")
+	if status != http.StatusOK {
+		t.Fatalf("render returned %d: %s", status, body)
+	}
+	var series []graphiteSeries
+	if err := json.Unmarshal([]byte(body), &series); err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, s := range series {
+		targets = append(targets, s.Target)
+	}
+	if !slices.Equal(targets, []string{"alpha.db.cpuPercent", "alpha.web.cpuPercent"}) {
+		t.Fatalf("render targets %q, want db's and web's CPU", targets)
+	}
+	if points := series[1].Datapoints; len(points) != 5 || points[0] != [2]float64{10, float64(contractStart.Unix())} || series[1].Tags["container"] != "web" {
+		t.Errorf("web series %+v, want five [value, seconds] points", series[1])
+	}
+	if status, _ := callContractResource(t, ds, "GET", "render?target=alpha.web", ""); status != http.StatusBadRequest {
+		t.Errorf("two node target returned %d, want 400", status)
+	}
+}
+
+func TestContractSwarmDiscovery(t *testing.T) {
+	hosts := startContractHosts(t, "manager")
+	manager := hosts[0]
+	discoverer := &swarmDiscoverer{managerURL: manager.config.URL + "/", urlTemplate: "https://{hostname}.swarm:5000"}
+
+	// the manager agent answers 409 until it knows it runs on a manager
+	if _, err := discoverer.discover(context.Background()); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("non-manager returned %v, want the agent's 409", err)
+	}
+
+	manager.agent.SetSwarmNodes(
+		agentmock.SwarmNode{ID: "n1", Hostname: "manager", Addr: "10.0.0.1", Role: "manager", State: "ready", Availability: "active"},
+		agentmock.SwarmNode{ID: "n2", Hostname: "worker-a", Addr: "10.0.0.2", Role: "worker", State: "ready", Availability: "drain"},
+		agentmock.SwarmNode{ID: "n3", Hostname: "worker-b", Addr: "10.0.0.3", Role: "worker", State: "down", Availability: "active"},
+		agentmock.SwarmNode{ID: "n4", Hostname: "worker-c", Addr: "10.0.0.4", Role: "worker", State: "ready", Availability: "pause"},
+	)
+	got, err := discoverer.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostConfig{
+		{ID: discoveredHostID("swarm", "n1"), Name: "manager", URL: "https://manager.swarm:5000", Enabled: true},
+		{ID: discoveredHostID("swarm", "n4"), Name: "worker-c", URL: "https://worker-c.swarm:5000", Enabled: true},
+	}
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("swarm hosts = %+v, want the ready, undrained nodes %+v", got, want)
+	}
+
+	discoverer.urlTemplate = ""
+	if got, err := discoverer.discover(context.Background()); err != nil || len(got) != 2 || got[1].URL != "http://10.0.0.4:5000" {
+		t.Fatalf("default template gave %+v, %v; want node addresses on port 5000", got, err)
+	}
+}
+
+func TestContractRegistration(t *testing.T) {
+	agent := newContractAgent("edge")
+	agent.Token = "agent-secret"
+	server := httptest.NewServer(agent)
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "registrations.json")
+	ds := newContractDatasource(t, nil, map[string]interface{}{"registrationsPath": path})
+	ds.secrets = map[string]string{"registrationToken": "reg-token"}
+	call := func(user *backend.User, method, path string, headers map[string][]string, body string) (int, []byte) {
+		var status int
+		var respBody []byte
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: user},
+			Path:          path,
+			Method:        method,
+			URL:           path,
+			Headers:       headers,
+			Body:          []byte(body),
+		}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+			status, respBody = resp.Status, resp.Body
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status, respBody
+	}
+	register := func(token string) (int, Registration) {
+		// Grafana authenticates the Authorization header itself, the registration token is in the body
+		status, body := call(nil, "POST", "register", map[string][]string{"Authorization": {"Bearer grafana-session"}},
+			fmt.Sprintf(`{"name": "edge", "url": "%s/", "token": "agent-secret", "registrationToken": "%s"}`, server.URL, token))
+		var reg Registration
+		json.Unmarshal(body, &reg)
+		return status, reg
+	}
+
+	if status, _ := register("wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("registration with a wrong token answered %d, want 401", status)
+	}
+	status, reg := register("reg-token")
+	if status != http.StatusOK || reg.Status != RegistrationPending || reg.URL != server.URL {
+		t.Fatalf("registration answered %d %+v, want a pending registration", status, reg)
+	}
+	if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`); len(resp.Frames) > 1 {
+		t.Fatalf("pending registration was queried: %d frames", len(resp.Frames))
+	}
+
+	admin := &backend.User{Login: "admin", Role: "Admin"}
+	if status, _ := call(admin, "POST", "registrations/"+reg.ID+"/approve", nil, ""); status != http.StatusOK {
+		t.Fatalf("approve answered %d", status)
+	}
+	// the approved agent requires its token, which it registered with
+	if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`); resp.Error != nil || len(resp.Frames) != 3 {
+		t.Fatalf("approved registration: %d frames, error %v, want 2 series and the containers frame", len(resp.Frames), resp.Error)
+	}
+	_, body := call(admin, "GET", "registrations", nil, "")
+	if strings.Contains(string(body), "agent-secret") {
+		t.Fatalf("registration list exposes the agent token: %s", body)
+	}
+
+	// registrations survive a restart, with their status and agent token
+	restarted := newRegistrationStore()
+	if err := restarted.open(path); err != nil {
+		t.Fatal(err)
+	}
+	if regs := restarted.list(); len(regs) != 1 || regs[0].ID != reg.ID || regs[0].Status != RegistrationApproved || restarted.agentToken(reg.ID) != "agent-secret" {
+		t.Fatalf("reloaded registrations %+v, want the approved edge agent with its token", regs)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("registrations file %v, %v; want it readable by the owner only", info, err)
+	}
+	if status, _ := call(admin, "POST", "registrations/"+reg.ID+"/remove", nil, ""); status != http.StatusOK {
+		t.Fatalf("remove answered %d", status)
+	}
+	restarted = newRegistrationStore()
+	if err := restarted.open(path); err != nil || len(restarted.list()) != 0 {
+		t.Fatalf("removed registration reloaded: %+v, %v", restarted.list(), err)
+	}
+}
+
+func TestContractRegistryDiscovery(t *testing.T) {
+	t.Run("consul", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/health/service/docker agent" || r.Header.Get("X-Consul-Token") != "consul-token" {
+				http.Error(w, "unexpected request "+r.URL.Path, http.StatusForbidden)
+				return
+			}
+			if q := r.URL.Query(); q.Get("passing") != "true" || q.Get("dc") != "eu1" || q.Get("tag") != "metrics" {
+				http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[
+				{"Node": {"Node": "alpha", "Address": "10.0.0.1", "Datacenter": "eu1"},
+				 "Service": {"ID": "agent-a", "Service": "docker agent", "Port": 5000, "Tags": ["metrics", "edge"], "Meta": {"rack": "r1"}}},
+				{"Node": {"Node": "beta", "Address": "10.0.0.2", "Datacenter": "eu1"},
+				 "Service": {"ID": "agent-b", "Service": "docker agent", "Address": "fd00::2", "Port": 5001}}
+			]`))
+		}))
+		defer registry.Close()
+
+		consul := &consulDiscoverer{address: registry.URL + "/", service: "docker agent", datacenter: "eu1", tag: "metrics", token: "consul-token", scheme: "https"}
+		hosts, err := consul.discover(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []HostConfig{
+			{ID: discoveredHostID("consul", "agent-a"), Name: "alpha", URL: "https://10.0.0.1:5000", Enabled: true,
+				Labels: map[string]string{"datacenter": "eu1", "node": "alpha", "tags": "metrics,edge", "rack": "r1"}},
+			{ID: discoveredHostID("consul", "agent-b"), Name: "beta", URL: "https://[fd00::2]:5001", Enabled: true,
+				Labels: map[string]string{"datacenter": "eu1", "node": "beta"}},
+		}
+		if fmt.Sprintf("%+v", hosts) != fmt.Sprintf("%+v", want) {
+			t.Fatalf("consul hosts = %+v, want %+v", hosts, want)
+		}
+
+		consul.token = "wrong"
+		if _, err := consul.discover(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+			t.Fatalf("rejected lookup returned %v, want the registry's status", err)
+		}
+	})
+
+	t.Run("etcd", func(t *testing.T) {
+		b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, _ := r.BasicAuth(); r.URL.Path != "/v3/kv/range" || user != "root" || pass != "etcd-pass" {
+				http.Error(w, "unexpected request", http.StatusUnauthorized)
+				return
+			}
+			var req map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["key"] != b64("/agents/") || req["range_end"] != b64("/agents0") {
+				http.Error(w, fmt.Sprintf("unexpected range %v", req), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": []map[string]string{
+				{"key": b64("/agents/alpha"), "value": b64(`{"name": "Alpha", "url": "http://10.0.0.1:5000", "labels": {"env": "prod"}}`)},
+				{"key": b64("/agents/beta"), "value": b64("http://10.0.0.2:5000\n")},
+				{"key": b64("/agents/empty"), "value": b64(`{"name": "nothing"}`)},
+			}})
+		}))
+		defer registry.Close()
+
+		etcd := &etcdDiscoverer{endpoint: registry.URL, prefix: "/agents/", username: "root", password: "etcd-pass"}
+		hosts, err := etcd.discover(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []HostConfig{
+			{ID: discoveredHostID("etcd", "alpha"), Name: "Alpha", URL: "http://10.0.0.1:5000", Enabled: true, Labels: map[string]string{"env": "prod"}},
+			{ID: discoveredHostID("etcd", "beta"), Name: "beta", URL: "http://10.0.0.2:5000", Enabled: true},
+		}
+		if fmt.Sprintf("%+v", hosts) != fmt.Sprintf("%+v", want) {
+			t.Fatalf("etcd hosts = %+v, want %+v", hosts, want)
+		}
+	})
+}
+
+func TestContractFailover(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	t.Run("ordering", func(t *testing.T) {
+		ds := newContractDatasource(t, nil, nil)
+		// the tracker is shared by instances with the same UID, start from a clean slate
+		ds.endpoints = newEndpointTracker()
+		host := HostConfig{ID: "h1", URL: "http://a:5000/", URLs: []string{"http://b:5000", "http://A:5000"}, FallbackURL: "http://f:5000/"}
+		for i, want := range []string{
+			"http://a:5000 http://b:5000 http://f:5000",
+			"http://b:5000 http://a:5000 http://f:5000",
+			"http://a:5000 http://b:5000 http://f:5000",
+		} {
+			if got := strings.Join(ds.hostEndpoints(host, http.MethodGet), " "); got != want {
+				t.Errorf("read %d tries %s, want %s", i, got, want)
+			}
+		}
+		// writes aren't balanced, and don't advance the read rotation
+		if got := strings.Join(ds.hostEndpoints(host, http.MethodPost), " "); got != "http://a:5000 http://b:5000 http://f:5000" {
+			t.Errorf("write tries %s, want configured order", got)
+		}
+		if got := strings.Join(ds.hostEndpoints(host, http.MethodGet), " "); got != "http://b:5000 http://a:5000 http://f:5000" {
+			t.Errorf("read after write tries %s, want the rotation to continue", got)
+		}
+
+		host.LoadBalancing = LoadBalanceLeastFailures
+		host.URLs = []string{"http://b:5000", "http://c:5000"}
+		ds.endpoints.recordFailure("http://a:5000")
+		ds.endpoints.recordFailure("http://A:5000/")
+		ds.endpoints.recordFailure("http://b:5000")
+		if got := strings.Join(ds.hostEndpoints(host, http.MethodGet), " "); got != "http://c:5000 http://b:5000 http://a:5000 http://f:5000" {
+			t.Errorf("least-failures tries %s, want healthiest first", got)
+		}
+		ds.endpoints.recordSuccess("http://a:5000")
+		if got := strings.Join(ds.hostEndpoints(host, http.MethodGet), " "); got != "http://a:5000 http://c:5000 http://b:5000 http://f:5000" {
+			t.Errorf("after a success tries %s, want a's failures forgotten", got)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		hosts := startContractHosts(t, "alpha", "standby")
+		primary, standby := hosts[0], hosts[1]
+		host := primary
+		host.config.FallbackURL = standby.config.URL
+		ds := newContractDatasource(t, []contractHost{host}, nil)
+
+		metricRequests := func(agent *agentmock.Agent) int {
+			n := 0
+			for _, r := range agent.Requests() {
+				if strings.HasPrefix(r, "GET /api/metrics") {
+					n++
+				}
+			}
+			return n
+		}
+
+		// an agent answering 5xx to a read hands over to the fallback without a retry
+		primary.agent.Fail("/api/metrics", agentmock.Fault{Status: 502, Times: 1})
+		if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "retry": {"attempts": 1}}`); resp.Error != nil || len(resp.Frames) != 3 {
+			t.Fatalf("got %d frames, error %v, want the fallback's data", len(resp.Frames), resp.Error)
+		}
+		if p, s := metricRequests(primary.agent), metricRequests(standby.agent); p != 1 || s != 1 {
+			t.Fatalf("primary got %d and fallback %d metrics requests, want one each", p, s)
+		}
+
+		// control actions only fail over when the primary is unreachable
+		primary.agent.Fail("/api/containers", agentmock.Fault{Status: 503, Times: 1})
+		resp, servedBy, err := ds.doHostRequest(context.Background(), host.config, http.MethodPost, "/api/containers/web1/restart", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 503 || servedBy != primary.config.URL || len(standby.agent.Actions()) != 0 {
+			t.Fatalf("failed write answered %d by %s, fallback actions %v; want the primary's 503", resp.StatusCode, servedBy, standby.agent.Actions())
+		}
+
+		// nor after the primary accepted the request and then timed out
+		primary.agent.Fail("/api/containers", agentmock.Fault{Delay: time.Second, Times: 1})
+		agentClient := ds.agentClient
+		ds.agentClient = &http.Client{Timeout: 100 * time.Millisecond}
+		if _, servedBy, err := ds.doHostRequest(context.Background(), host.config, http.MethodPost, "/api/containers/web1/restart", nil); err == nil || len(standby.agent.Actions()) != 0 {
+			t.Fatalf("timed out write was served by %q (error %v), fallback actions %v; want the timeout", servedBy, err, standby.agent.Actions())
+		}
+		ds.agentClient = agentClient
+
+		host.config.URL = deadURL
+		ds = newContractDatasource(t, []contractHost{host}, nil)
+		resp, servedBy, err = ds.doHostRequest(context.Background(), host.config, http.MethodPost, "/api/containers/web1/restart", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if servedBy != standby.config.URL || fallbackURL(host.config, servedBy) == "" {
+			t.Fatalf("write to an unreachable agent was served by %q, want the fallback", servedBy)
+		}
+		if resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`); resp.Error != nil || len(resp.Frames) != 3 {
+			t.Fatalf("unreachable primary: %d frames, error %v, want the fallback's data", len(resp.Frames), resp.Error)
+		}
+	})
+}
+
+func TestContractLargeFleetNotice(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta", "gamma")
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"largeFleetThreshold": 2, "fetchConcurrency": 2})
+
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"]}`)
+	if resp.Error != nil || len(resp.Frames) == 0 || resp.Frames[0].Meta == nil {
+		t.Fatalf("got %d frames, error %v", len(resp.Frames), resp.Error)
+	}
+	notices := resp.Frames[0].Meta.Notices
+	if len(notices) != 1 || !strings.Contains(notices[0].Text, "spans 3 hosts") || !strings.Contains(notices[0].Text, "queried 2 at a time") {
+		t.Fatalf("notices = %v, want the fleet size and fetch concurrency", notices)
+	}
+}
+
+func TestContractCAdvisor(t *testing.T) {
+	stat := func(offset time.Duration, cpuNanos uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"timestamp": contractStart.Add(offset).Format(time.RFC3339Nano),
+			"cpu":       map[string]interface{}{"usage": map[string]interface{}{"total": cpuNanos}},
+			"memory":    map[string]interface{}{"usage": 250},
+			"network": map[string]interface{}{"rx_bytes": 1, "interfaces": []map[string]interface{}{
+				{"rx_bytes": 100, "tx_bytes": 10}, {"rx_bytes": 200, "tx_bytes": 20},
+			}},
+			"diskio": map[string]interface{}{"io_service_bytes": []map[string]interface{}{{"stats": map[string]uint64{"Read": 7, "Write": 9}}}},
+		}
+	}
+	containers := map[string]interface{}{
+		"/docker/abc": map[string]interface{}{
+			"name": "/docker/abc", "aliases": []string{"web", "abc"},
+			"spec": map[string]interface{}{"image": "nginx:1.25", "memory": map[string]interface{}{"limit": 1000}},
+			// the usage counter resets at 30s
+			"stats": []interface{}{stat(0, 0), stat(10*time.Second, 1e9), stat(20*time.Second, 3e9), stat(30*time.Second, 1e8), stat(40*time.Second, 6e8)},
+		},
+		"/docker/def": map[string]interface{}{
+			"name": "/docker/def", "aliases": []string{"def"},
+			"spec":  map[string]interface{}{"memory": map[string]interface{}{"limit": uint64(1) << 63}},
+			"stats": []interface{}{stat(10*time.Second, 0), stat(20*time.Second, 5e8)},
+		},
+	}
+	cadvisor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1.3/docker/" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(containers)
+	}))
+	defer cadvisor.Close()
+
+	host := HostConfig{ID: "c1", Name: "cadvisor", URL: cadvisor.URL, Mode: HostModeCAdvisor, Enabled: true}
+	ds := newContractDatasource(t, nil, map[string]interface{}{"hosts": []HostConfig{host}})
+	metrics, _, err := ds.fetchCAdvisorMetrics(context.Background(), host, backend.TimeRange{From: contractStart.Add(5 * time.Second), To: contractStart.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Samples without a usable previous one have no CPU usage and are left out
+	var got []string
+	for _, m := range metrics {
+		got = append(got, fmt.Sprintf("%s %s %.0fs cpu=%g", m.ContainerID, m.ContainerName, m.Timestamp.Sub(contractStart).Seconds(), m.CPUPercent))
+	}
+	want := []string{"abc /web 10s cpu=10", "abc /web 20s cpu=20", "abc /web 40s cpu=5", "def /def 20s cpu=5"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("samples %q, want %q", got, want)
+	}
+	m := metrics[0]
+	if m.MemoryBytes != 250 || m.MemoryPercent != 25 || m.NetworkRxBytes != 300 || m.NetworkTxBytes != 30 || m.DiskReadBytes != 7 || m.DiskWriteBytes != 9 {
+		t.Errorf("sample %+v, want memory, interface totals and disk bytes mapped", m)
+	}
+	// an effectively unlimited limit has no memory percentage
+	if metrics[3].MemoryPercent != 0 {
+		t.Errorf("unlimited container has memory percent %v", metrics[3].MemoryPercent)
+	}
+}
+
+func TestContractSlowHost(t *testing.T) {
+	hosts := startContractHosts(t, "alpha", "beta")
+	for _, path := range []string{"/api/metrics", "/api/containers", "/api/info"} {
+		hosts[1].agent.Fail(path, agentmock.Fault{Delay: 10 * time.Second})
+	}
+	ds := newContractDatasource(t, hosts, map[string]interface{}{"hostTimeoutSeconds": 1})
+
+	for _, query := range []string{
+		`{"metrics": ["cpuPercent"]}`,
+		`{"hostSelections": {"h1": {"hostId": "h1", "mode": "blacklist"}, "h2": {"hostId": "h2", "mode": "blacklist"}}, "metrics": ["cpuPercent"]}`,
+	} {
+		start := time.Now()
+		resp := runContractQuery(t, ds, query)
+		// the metrics fetch and the container listing each wait one host timeout at most
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("query %s took %s, the slow host wasn't cut off", query, elapsed)
+		}
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		containers := 0
+		for _, f := range resp.Frames {
+			if f.Name == "containers" {
+				containers++
+				for i := 0; i < f.Rows(); i++ {
+					if host := f.Fields[3].At(i).(string); host != "alpha" {
+						t.Errorf("containers frame lists %s, want only alpha", host)
+					}
+				}
+				continue
+			}
+			if host := f.Fields[1].Labels["hostName"]; host != "alpha" {
+				t.Errorf("frame %s is from %s, want only alpha", f.Name, host)
+			}
+		}
+		if len(resp.Frames) < 2 || containers != 1 {
+			t.Errorf("query %s got %d frames, %d containers frames; want alpha's", query, len(resp.Frames), containers)
+		}
+	}
+}
+
+func TestContractRecoversAfterFault(t *testing.T) {
+	hosts := startContractHosts(t, "alpha")
+	hosts[0].agent.Fail("/api/metrics", agentmock.Fault{Status: 503, Times: 1})
+	ds := newContractDatasource(t, hosts, nil)
+
+	runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "alerting": true}`)
+	resp := runContractQuery(t, ds, `{"metrics": ["cpuPercent"], "alerting": true}`)
+	if resp.Error != nil || len(resp.Frames) != 2 {
+		t.Fatalf("query after the fault cleared returned %d frames, error %v; want 2 frames", len(resp.Frames), resp.Error)
+	}
+}
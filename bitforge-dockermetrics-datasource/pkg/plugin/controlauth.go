@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ControlAuthorization restricts control queries to Grafana users with a minimum org role
+// and, optionally, membership in one of the listed teams
+type ControlAuthorization struct {
+	MinRole string `json:"minRole"` // Viewer, Editor or Admin; unset lets any user through
+	// AllowedTeams are team names looked up through the Grafana API with the grafanaApiToken
+	// service account, which needs permission to read users and teams
+	AllowedTeams []string `json:"allowedTeams"`
+}
+
+// roleRanks orders Grafana org roles
+var roleRanks = map[string]int{"None": 0, "Viewer": 1, "Editor": 2, "Admin": 3}
+
+// validateControlAuthorization warns about settings that would refuse every control query
+func validateControlAuthorization(a ControlAuthorization, secrets map[string]string) []string {
+	warnings := make([]string, 0)
+	if _, ok := roleRanks[a.MinRole]; a.MinRole != "" && !ok {
+		warnings = append(warnings, fmt.Sprintf("controlAuthorization.minRole %q is unknown, only Admins can run control actions", a.MinRole))
+	}
+	if len(a.AllowedTeams) > 0 && secrets["grafanaApiToken"] == "" {
+		warnings = append(warnings, "controlAuthorization.allowedTeams needs secureJsonData.grafanaApiToken, control actions are refused")
+	}
+	return warnings
+}
+
+// authorizeControl checks the user running a control query against controlAuthorization.
+// Queries without a user, such as alert evaluation, only pass while neither a role nor teams
+// are required.
+func (d *Datasource) authorizeControl(ctx context.Context, user *backend.User) error {
+	auth := d.settings.ControlAuthorization
+	if auth.MinRole == "" && len(auth.AllowedTeams) == 0 {
+		return nil
+	}
+	if user == nil {
+		return fmt.Errorf("control actions require a signed-in Grafana user")
+	}
+
+	if auth.MinRole != "" {
+		required, ok := roleRanks[auth.MinRole]
+		if !ok {
+			required = roleRanks["Admin"]
+		}
+		if roleRanks[user.Role] < required {
+			return fmt.Errorf("control actions require the %s role, %s has %s", auth.MinRole, user.Login, user.Role)
+		}
+	}
+
+	if len(auth.AllowedTeams) > 0 {
+		teams, err := d.userTeams(ctx, user)
+		if err != nil {
+			return fmt.Errorf("failed to check team membership of %s: %w", user.Login, err)
+		}
+		for _, team := range teams {
+			if contains(auth.AllowedTeams, team) {
+				return nil
+			}
+		}
+		return fmt.Errorf("control actions require membership in one of the teams %s", strings.Join(auth.AllowedTeams, ", "))
+	}
+	return nil
+}
+
+// userTeams returns the names of the user's teams through the Grafana API
+func (d *Datasource) userTeams(ctx context.Context, user *backend.User) ([]string, error) {
+	token := d.secrets["grafanaApiToken"]
+	if token == "" {
+		return nil, fmt.Errorf("set the grafanaApiToken secure setting to check teams")
+	}
+	appURL, err := backend.GrafanaConfigFromContext(ctx).AppURL()
+	if err != nil {
+		return nil, fmt.Errorf("grafana app URL unavailable: %w", err)
+	}
+	base := strings.TrimSuffix(appURL, "/")
+
+	var found struct {
+		ID int64 `json:"id"`
+	}
+	if err := grafanaAPIRequest(ctx, http.MethodGet, base+"/api/users/lookup?loginOrEmail="+url.QueryEscape(user.Login), token, nil, &found); err != nil {
+		return nil, err
+	}
+	var teams []struct {
+		Name string `json:"name"`
+	}
+	if err := grafanaAPIRequest(ctx, http.MethodGet, fmt.Sprintf("%s/api/users/%d/teams", base, found.ID), token, nil, &teams); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(teams))
+	for _, team := range teams {
+		names = append(names, team.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxControlSteps bounds the actions of one control query
+const maxControlSteps = 20
+
+// Step states of a control sequence's result frame
+const (
+	stepSucceeded      = "success"
+	stepFailed         = "failed"
+	stepSkipped        = "skipped"
+	stepRolledBack     = "rolled back"
+	stepRollbackFailed = "rollback failed"
+)
+
+// controlInverses maps the actions a failed sequence can undo to the action that undoes them
+var controlInverses = map[string]string{
+	"start":   "stop",
+	"stop":    "start",
+	"pause":   "unpause",
+	"unpause": "pause",
+}
+
+// ControlStep is one action of a control sequence, e.g. {"controlAction": "stop",
+// "targetContainer": "abc"}; targetHost defaults to the query's
+type ControlStep struct {
+	ControlAction   string `json:"controlAction"`
+	TargetContainer string `json:"targetContainer"`
+	TargetHost      string `json:"targetHost"`
+	Force           bool   `json:"force"`
+	RemoveVolumes   bool   `json:"removeVolumes"`
+}
+
+// queryControlSequence runs the steps of a control query as a transaction, for runbook buttons
+// such as "stop A, start B". Every step is validated before the first one runs, so a sequence
+// with a refused step changes nothing. Every step but the last must be reversible (see
+// controlInverses) with its inverse allowed. The first failing step stops the sequence, the
+// steps after it are skipped and the steps already done are undone in reverse order. The result
+// frame has a row per step, and the response fails with the failing step's error.
+func (d *Datasource) queryControlSequence(ctx context.Context, user *backend.User, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	steps := make([]ControlStep, len(qm.ControlSteps))
+	for i, step := range qm.ControlSteps {
+		if step.TargetHost == "" {
+			step.TargetHost = qm.TargetHost
+		}
+		steps[i] = step
+	}
+
+	// Every step is audited, refused and skipped ones included
+	entries := make([]AuditEntry, len(steps))
+	for i, step := range steps {
+		entries[i] = AuditEntry{Time: time.Now(), Action: step.ControlAction, ContainerID: step.TargetContainer, HostID: step.TargetHost}
+		if user != nil {
+			entries[i].User, entries[i].Role = user.Login, user.Role
+		}
+		entries[i].Result = "refused"
+	}
+	defer func() {
+		for _, entry := range entries {
+			if entry.Error == "" && entry.Result == "refused" && response.Error != nil {
+				entry.Error = response.Error.Error()
+			}
+			d.recordAudit(entry)
+		}
+	}()
+
+	if err := d.controlsAllowed(); err != nil {
+		response.Error = err
+		return response
+	}
+	if len(steps) > maxControlSteps {
+		response.Error = fmt.Errorf("a control sequence has at most %d steps", maxControlSteps)
+		return response
+	}
+	if err := d.authorizeControl(ctx, user); err != nil {
+		d.logger.Warn("Refused control sequence", "steps", len(steps), "error", err)
+		response.Error = err
+		return response
+	}
+
+	targets := make([]HostConfig, len(steps))
+	for i, step := range steps {
+		host, err := d.controlTarget(step)
+		if err != nil {
+			response.Error = fmt.Errorf("step %d: %w", i+1, err)
+			return response
+		}
+		targets[i] = host
+		entries[i].HostName = host.Name
+
+		// A failure after this step must be able to undo it
+		if i < len(steps)-1 {
+			inverse, ok := controlInverses[step.ControlAction]
+			if !ok {
+				response.Error = fmt.Errorf("step %d: %s can't be undone, so it can only be the last step", i+1, step.ControlAction)
+				return response
+			}
+			if !d.controlActionAllowed(inverse) {
+				response.Error = fmt.Errorf("step %d: undoing %s needs action '%s', which datasource settings don't allow", i+1, step.ControlAction, inverse)
+				return response
+			}
+		}
+	}
+
+	n := len(steps)
+	numbers := make([]int64, n)
+	actions := make([]string, n)
+	hostIDs := make([]string, n)
+	containerIDs := make([]string, n)
+	states := make([]string, n)
+	messages := make([]string, n)
+	newContainerIDs := make([]string, n)
+	for i, step := range steps {
+		numbers[i] = int64(i + 1)
+		actions[i] = step.ControlAction
+		hostIDs[i] = step.TargetHost
+		containerIDs[i] = step.TargetContainer
+		states[i] = stepSkipped
+		entries[i].Result = stepSkipped
+	}
+
+	completed := 0
+	for i, step := range steps {
+		if err := ctx.Err(); err != nil {
+			response.Error = fmt.Errorf("control sequence cancelled before step %d: %w", i+1, err)
+			break
+		}
+		actionCtx, done, ok := d.actions.start(ctx, describeAction(targets[i], step.TargetContainer, step.ControlAction))
+		if !ok {
+			response.Error = fmt.Errorf("datasource is shutting down, step %d not started", i+1)
+			break
+		}
+		entries[i].Time = time.Now()
+		result, err := d.executeControlAction(actionCtx, targets[i], step.TargetContainer, step.ControlAction, controlParams(step))
+		done(d.logger, err)
+		if err != nil {
+			d.logger.Error("Control sequence step failed",
+				"step", i+1,
+				"action", step.ControlAction,
+				"container", step.TargetContainer,
+				"host", targets[i].Name,
+				"error", err,
+			)
+			states[i], messages[i] = stepFailed, err.Error()
+			entries[i].Result, entries[i].Error = stepFailed, err.Error()
+			response.Error = fmt.Errorf("step %d (%s %s) failed: %w", i+1, step.ControlAction, step.TargetContainer, err)
+			break
+		}
+
+		d.logger.Info("Control sequence step executed",
+			"step", i+1,
+			"action", step.ControlAction,
+			"container", step.TargetContainer,
+			"host", targets[i].Name,
+			"newContainer", result.NewContainerID,
+		)
+		states[i] = stepSucceeded
+		newContainerIDs[i] = result.NewContainerID
+		entries[i].Result, entries[i].NewContainerID = stepSucceeded, result.NewContainerID
+		completed++
+	}
+
+	if response.Error != nil {
+		for i := completed - 1; i >= 0; i-- {
+			entry, err := d.rollbackControlStep(ctx, user, targets[i], steps[i])
+			entries = append(entries, entry)
+			if err != nil {
+				states[i], messages[i] = stepRollbackFailed, err.Error()
+				response.Error = fmt.Errorf("%w; undoing step %d failed: %v", response.Error, i+1, err)
+				continue
+			}
+			states[i] = stepRolledBack
+		}
+	}
+
+	frame := data.NewFrame("control_result",
+		data.NewField("step", nil, numbers),
+		data.NewField("action", nil, actions),
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("containerId", nil, containerIDs),
+		data.NewField("status", nil, states),
+		data.NewField("error", nil, messages),
+		data.NewField("newContainerId", nil, newContainerIDs),
+	)
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Custom: map[string]interface{}{
+			"queryType": "control",
+		},
+	}
+	response.Frames = append(response.Frames, frame)
+	return response
+}
+
+// rollbackControlStep undoes a completed step of a failed sequence with its inverse action and
+// returns the audit entry of the undo
+func (d *Datasource) rollbackControlStep(ctx context.Context, user *backend.User, host HostConfig, step ControlStep) (AuditEntry, error) {
+	inverse := controlInverses[step.ControlAction]
+	entry := AuditEntry{Time: time.Now(), Action: inverse, ContainerID: step.TargetContainer, HostID: step.TargetHost, HostName: host.Name, Result: stepSucceeded}
+	if user != nil {
+		entry.User, entry.Role = user.Login, user.Role
+	}
+
+	actionCtx, done, ok := d.actions.start(ctx, describeAction(host, step.TargetContainer, inverse))
+	if !ok {
+		err := fmt.Errorf("datasource is shutting down")
+		entry.Result, entry.Error = stepFailed, err.Error()
+		return entry, err
+	}
+	_, err := d.executeControlAction(actionCtx, host, step.TargetContainer, inverse, controlParams(ControlStep{ControlAction: inverse}))
+	done(d.logger, err)
+	if err != nil {
+		d.logger.Error("Undoing control sequence step failed",
+			"action", inverse,
+			"container", step.TargetContainer,
+			"host", host.Name,
+			"error", err,
+		)
+		entry.Result, entry.Error = stepFailed, err.Error()
+		return entry, err
+	}
+	d.logger.Info("Control sequence step undone",
+		"action", inverse,
+		"container", step.TargetContainer,
+		"host", host.Name,
+	)
+	return entry, nil
+}
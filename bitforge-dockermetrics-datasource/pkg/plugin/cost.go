@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// CostRates are prices per hour of use
+type CostRates struct {
+	CPUHour float64 `json:"cpuHour"` // one fully used core for an hour
+	GBHour  float64 `json:"gbHour"`  // one GB (2^30 bytes, as Docker reports memory) held for an hour
+}
+
+// CostModel prices container usage for "cost" queries. A host's entry replaces its group's
+// rates, which replace the default rates.
+type CostModel struct {
+	CostRates
+	Currency string               `json:"currency"` // e.g. USD or €, shown as the cost fields' unit
+	Groups   map[string]CostRates `json:"groups"`   // host group -> rates
+	Hosts    map[string]CostRates `json:"hosts"`    // host ID -> rates
+}
+
+func (m CostModel) configured() bool {
+	return m.CPUHour != 0 || m.GBHour != 0 || len(m.Groups) > 0 || len(m.Hosts) > 0
+}
+
+// ratesFor returns the rates of a host
+func (m CostModel) ratesFor(host HostConfig) CostRates {
+	if r, ok := m.Hosts[host.ID]; ok {
+		return r
+	}
+	if r, ok := m.Groups[host.Group]; ok && host.Group != "" {
+		return r
+	}
+	return m.CostRates
+}
+
+// containerUsage is the CPU and memory a container used over a query range
+type containerUsage struct {
+	coreHours, gbHours float64
+}
+
+// usageOf integrates a container's samples (sorted by time) up to end: each sample's usage
+// lasts until the next sample. Like lifecycleOf, gaps and tails longer than maxGap are time the
+// container wasn't running and cost nothing.
+func usageOf(samples []ContainerMetric, end time.Time, maxGap time.Duration) containerUsage {
+	var u containerUsage
+	for i, m := range samples {
+		next := end
+		if i+1 < len(samples) {
+			next = samples[i+1].Timestamp.Time
+		}
+		span := next.Sub(m.Timestamp.Time)
+		if span <= 0 || span > maxGap {
+			continue
+		}
+		if v, ok := rawMetricValue(m, "cpuPercent"); ok {
+			u.coreHours += v / 100 * span.Hours()
+		}
+		if v, ok := rawMetricValue(m, "memoryBytes"); ok {
+			u.gbHours += v / (1 << 30) * span.Hours()
+		}
+	}
+	return u
+}
+
+// costRow is one container or compose project of a "cost" query
+type costRow struct {
+	hostID, hostName           string
+	containerID, containerName string
+	project                    string
+	containers                 int64
+	usage                      containerUsage
+	cpuCost, memoryCost        float64
+}
+
+// queryCost estimates what containers cost over the range from their CPU and memory use and
+// the datasource's cost model, one row per container or, with groupBy composeProject, per
+// compose project (containers of the project on every host, each at its host's rates)
+func (d *Datasource) queryCost(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	model := d.settings.CostModel
+	if !model.configured() {
+		response.Error = fmt.Errorf("no cost model configured")
+		return response
+	}
+	if qm.GroupBy != "" && qm.GroupBy != GroupByComposeProject {
+		response.Error = fmt.Errorf("groupBy must be composeProject for cost queries")
+		return response
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+	rates := make(map[string]CostRates, len(hosts))
+	for _, host := range hosts {
+		rates[host.ID] = model.ratesFor(host)
+	}
+
+	end := query.TimeRange.To
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	maxGap := d.staleAfter()
+
+	// Costs are per container, aggregated series would be counted at averaged usage
+	qm.AggregateBy = ""
+	collected := d.collectMetrics(ctx, hosts, qm, query.TimeRange, []string{"cpuPercent", "memoryBytes"})
+	rows := make([]costRow, 0)
+	projects := make(map[string]*costRow)
+	for _, mwh := range collected {
+		byContainer := make(map[string][]ContainerMetric)
+		for _, m := range mwh.Metrics {
+			byContainer[m.ContainerID] = append(byContainer[m.ContainerID], m)
+		}
+		r := rates[mwh.HostID]
+		for containerID, samples := range byContainer {
+			sortMetricsByTime(samples)
+			u := usageOf(samples, end, maxGap)
+			row := costRow{
+				hostID:        mwh.HostID,
+				hostName:      mwh.HostName,
+				containerID:   containerID,
+				containerName: samples[len(samples)-1].ContainerName,
+				containers:    1,
+				usage:         u,
+				cpuCost:       u.coreHours * r.CPUHour,
+				memoryCost:    u.gbHours * r.GBHour,
+			}
+			if qm.GroupBy != GroupByComposeProject {
+				rows = append(rows, row)
+				continue
+			}
+
+			project := mwh.ContainerLabels[containerID]["composeProject"]
+			if project == "" {
+				project = "(none)"
+			}
+			p := projects[project]
+			if p == nil {
+				p = &costRow{project: project}
+				projects[project] = p
+			}
+			p.containers++
+			p.usage.coreHours += u.coreHours
+			p.usage.gbHours += u.gbHours
+			p.cpuCost += row.cpuCost
+			p.memoryCost += row.memoryCost
+		}
+	}
+	for _, p := range projects {
+		rows = append(rows, *p)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.project != b.project {
+			return a.project < b.project
+		}
+		if a.hostName != b.hostName {
+			return a.hostName < b.hostName
+		}
+		if a.containerName != b.containerName {
+			return a.containerName < b.containerName
+		}
+		return a.containerID < b.containerID
+	})
+
+	n := len(rows)
+	coreHours := make([]float64, n)
+	gbHours := make([]float64, n)
+	cpuCost := make([]float64, n)
+	memoryCost := make([]float64, n)
+	total := make([]float64, n)
+	for i, r := range rows {
+		coreHours[i] = r.usage.coreHours
+		gbHours[i] = r.usage.gbHours
+		cpuCost[i] = r.cpuCost
+		memoryCost[i] = r.memoryCost
+		total[i] = r.cpuCost + r.memoryCost
+	}
+
+	var fields []*data.Field
+	if qm.GroupBy == GroupByComposeProject {
+		names := make([]string, n)
+		containers := make([]int64, n)
+		for i, r := range rows {
+			names[i] = r.project
+			containers[i] = r.containers
+		}
+		fields = append(fields,
+			data.NewField("composeProject", nil, names),
+			data.NewField("containers", nil, containers),
+		)
+	} else {
+		hostIDs := make([]string, n)
+		hostNames := make([]string, n)
+		containerIDs := make([]string, n)
+		containerNames := make([]string, n)
+		for i, r := range rows {
+			hostIDs[i] = r.hostID
+			hostNames[i] = r.hostName
+			containerIDs[i] = r.containerID
+			containerNames[i] = r.containerName
+		}
+		fields = append(fields,
+			data.NewField("hostId", nil, hostIDs),
+			data.NewField("hostName", nil, hostNames),
+			data.NewField("containerId", nil, containerIDs),
+			data.NewField("containerName", nil, containerNames),
+		)
+	}
+
+	currency := ""
+	if model.Currency != "" {
+		currency = "currency:" + model.Currency
+	}
+	withUnit := func(field *data.Field, unit string) *data.Field {
+		field.Config = &data.FieldConfig{Unit: unit}
+		return field
+	}
+	fields = append(fields,
+		data.NewField("cpuCoreHours", nil, coreHours),
+		data.NewField("memoryGbHours", nil, gbHours),
+		withUnit(data.NewField("cpuCost", nil, cpuCost), currency),
+		withUnit(data.NewField("memoryCost", nil, memoryCost), currency),
+		withUnit(data.NewField("cost", nil, total), currency),
+	)
+
+	frame := data.NewFrame("cost", fields...)
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Custom:                 map[string]interface{}{"queryType": "cost"},
+	}
+	response.Frames = data.Frames{frame}
+	reportInvalidPayloads(&response, collected)
+	return response
+}
@@ -10,6 +10,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -22,13 +24,16 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
-// httpClient with reasonable timeouts
+// httpClient with reasonable timeouts; proxies come from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
 var httpClient = &http.Client{
 	Timeout: 30 * time.Second,
 	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     90 * time.Second,
@@ -41,19 +46,156 @@ type HostConfig struct {
 	Name    string `json:"name"`
 	URL     string `json:"url"`
 	Enabled bool   `json:"enabled"`
+
+	// URLs lists additional agent replicas for this host; reads are balanced across URL and URLs
+	URLs          []string `json:"urls,omitempty"`
+	LoadBalancing string   `json:"loadBalancing,omitempty"` // "round-robin" (default) | "least-failures"
+
+	// FallbackURL is a secondary agent queried when the primary is unreachable
+	FallbackURL string `json:"fallbackUrl,omitempty"`
+
+	// Group and tags allow queries to select hosts without enumerating IDs
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// Labels are attached to every series from this host (e.g. datacenter/tags from discovery)
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Mode selects the API behind URL: "" for the agent, "cadvisor" for a cAdvisor endpoint,
+	// "prometheus" for a /metrics exposition with cAdvisor-style series
+	Mode        string `json:"mode,omitempty"`
+	MetricsPath string `json:"metricsPath,omitempty"` // prometheus mode, defaults to /metrics
+
+	// Timezone (IANA name) for agent timestamps without a zone; defaults to UTC
+	Timezone string `json:"timezone,omitempty"`
+
+	// Retry overrides the datasource's retry settings for this host's agents
+	Retry *RetrySettings `json:"retry,omitempty"`
+
+	// PublicKey (PEM, ECDSA P-256 or Ed25519) verifies the agent's signed responses; responses
+	// without a valid signature are rejected
+	PublicKey string `json:"publicKey,omitempty"`
+
+	// containerRewrites are the container name rewrites of the relabeling rules matching the host
+	containerRewrites []containerRewrite
 }
 
 // DatasourceSettings contains the data source configuration
 type DatasourceSettings struct {
-	Hosts                  []HostConfig `json:"hosts"`
-	EnableContainerControls bool        `json:"enableContainerControls"`
-	AllowedControlActions   []string    `json:"allowedControlActions"`
+	Hosts                   []HostConfig      `json:"hosts"`
+	EnableContainerControls bool              `json:"enableContainerControls"`
+	AllowedControlActions   []string          `json:"allowedControlActions"`
+	Discovery               DiscoverySettings `json:"discovery"`
+	// RegistrationsPath is the file agent self-registrations are saved to
+	RegistrationsPath string `json:"registrationsPath"`
+	// ReadOnly refuses control queries and every resource write whatever the other settings,
+	// for monitoring-only deployments
+	ReadOnly bool `json:"readOnly"`
+	// ControlAuthorization sets the role and teams control queries require
+	ControlAuthorization ControlAuthorization `json:"controlAuthorization"`
+	// Audit stores every control action for "audit" queries
+	Audit AuditSettings `json:"audit"`
+	// HostMetadataLabels selects /api/info fields (see HostMetadataFields) to attach as series labels
+	HostMetadataLabels []string `json:"hostMetadataLabels"`
+	// LargeFleetThreshold is the host count above which queries warn and health checks sample hosts
+	LargeFleetThreshold int               `json:"largeFleetThreshold"`
+	Retention           RetentionSettings `json:"retention"`
+	Export              ExportSettings    `json:"export"`
+	Notifier            NotifierSettings  `json:"notifier"`
+	RecordingRules      []RecordingRule   `json:"recordingRules"`
+	// DuplicateNamePattern names containers whose name exists on several hosts, default "{container} ({host})"
+	DuplicateNamePattern string `json:"duplicateNamePattern"`
+	// DataLinks are attached to every container series
+	DataLinks []DataLink `json:"dataLinks"`
+	// MetricFormats overrides decimals, scaling and unit per metric name
+	MetricFormats map[string]MetricFormat `json:"metricFormats"`
+	// LogLevel is the minimum level logged by this datasource: debug, info (default), warn or error
+	LogLevel string `json:"logLevel"`
+	// FeatureToggles turns subsystems on or off (see featureDefaults)
+	FeatureToggles map[string]bool `json:"featureToggles"`
+	// UsageStats opts in to counting query types, metrics and host counts (see the usage resource)
+	UsageStats bool `json:"usageStats"`
+	// ProxyURL routes agent requests through this proxy instead of the environment's
+	ProxyURL string `json:"proxyUrl"`
+	// LabelPruning drops or hashes high-cardinality labels (e.g. containerId) on emitted fields
+	LabelPruning LabelPruning `json:"labelPruning"`
+	// ClockSkew sets when agent clocks count as skewed and whether their timestamps are corrected
+	ClockSkew ClockSkewSettings `json:"clockSkew"`
+	// ScopeTokens can require viewer queries to carry a scope token
+	ScopeTokens ScopeTokenSettings `json:"scopeTokens"`
+	// StaleSeconds is the age of a host's newest sample above which its frames are marked stale, default 60
+	StaleSeconds int `json:"staleSeconds"`
+	// HeartbeatSeconds is the longest expected gap between a container's samples; longer gaps
+	// get a null so lines break, default StaleSeconds, negative to connect every sample
+	HeartbeatSeconds int `json:"heartbeatSeconds"`
+	// FetchConcurrency is how many hosts a query fetches from at once, default 8
+	FetchConcurrency int `json:"fetchConcurrency"`
+	// HostTimeoutSeconds bounds how long a query waits for one host, default 10
+	HostTimeoutSeconds int `json:"hostTimeoutSeconds"`
+	// ExcludeContainers are container name patterns left out of every query unless the query
+	// sets its own list, e.g. the agent's own container or pause containers
+	ExcludeContainers []string `json:"excludeContainers"`
+	// Relabeling rewrites host names, container names and labels of matching hosts
+	Relabeling []RelabelRule `json:"relabeling"`
+	// SecondaryDatasourceUID answers queries whose hosts are all unreachable (see readThrough)
+	SecondaryDatasourceUID string `json:"secondaryDatasourceUid"`
+	// FederatedDatasourceUIDs are Docker Metrics datasources whose results are merged into
+	// every query of this one (see federate)
+	FederatedDatasourceUIDs []string `json:"federatedDatasourceUids"`
+	// CostModel prices CPU and memory for "cost" queries
+	CostModel CostModel `json:"costModel"`
+	// Swarm enables "swarmServices" queries against a swarm manager's agent
+	Swarm SwarmSettings `json:"swarm"`
+	// Chaos injects latency, errors and malformed payloads into agent requests, for testing
+	// dashboards on development instances
+	Chaos ChaosSettings `json:"chaos"`
+	// Retry sets how agent reads are retried; hosts and queries can override it
+	Retry RetrySettings `json:"retry"`
+	// Variables are default template variable values, for alert rules and public dashboards
+	// that run queries without the frontend
+	Variables map[string]VariableValues `json:"variables"`
 }
 
 // Datasource is a data source instance
 type Datasource struct {
-	settings DatasourceSettings
-	logger   log.Logger
+	uid             string
+	orgID           int64 // org the instance was created for, requests from other orgs are refused
+	settings        DatasourceSettings
+	secrets         map[string]string // decrypted secureJsonData
+	logger          log.Logger
+	hosts           *hostRegistry
+	endpoints       *endpointTracker
+	registrations   *registrationStore
+	maintenance     *maintenanceStore
+	capabilities    *capabilityCache
+	overrides       *hostOverrides
+	metadata        *hostMetadataCache
+	scrapes         *scrapeCache
+	recordings      *recordingCache
+	inventory       *inventoryHistory
+	instances       *agentInstances
+	skews           *clockSkews
+	usage           *usageStats
+	actions         *inflightActions         // per instance, drained by Dispose
+	agentClient     *http.Client             // agent requests, honors the proxy settings
+	chaos           *chaosInjector           // fault injection, nil when off
+	agentKeys       *agentKeys               // parsed host public keys
+	recordingExprs  map[string]recordingExpr // valid recording rules by name
+	retention       *retentionStore          // nil unless local retention is enabled
+	audit           *auditStore              // nil unless the audit trail is enabled
+	prefetches      *prefetcher              // nil unless the prefetch feature is on
+	exclusions      []*regexp.Regexp         // compiled ExcludeContainers
+	resourceHandler backend.CallResourceHandler
+
+	// hostWarnings are configuration problems found at instance creation
+	hostWarnings []string
+	// healthCursor rotates the hosts sampled by CheckHealth for large fleets
+	healthCursor atomic.Uint64
+
+	// Background workers (discovery etc.) run until Dispose cancels bgCtx
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	wg       sync.WaitGroup
 }
 
 // NewDatasource creates a new datasource instance
@@ -67,29 +209,149 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 			return nil, fmt.Errorf("failed to parse datasource settings: %w", err)
 		}
 	}
+	logger = newLevelLogger(logger, dsSettings.LogLevel)
+
+	// Containerized deployments may provide hosts purely through the environment
+	orgID := backend.PluginConfigFromContext(ctx).OrgID
+	envHosts, err := loadEnvHosts(orgID)
+	if err != nil {
+		logger.Error("Ignoring hosts from environment", "error", err)
+	}
+	dsSettings.Hosts = mergeEnvHosts(dsSettings.Hosts, envHosts)
 
 	logger.Info("Created Docker Metrics datasource instance",
 		"hosts", len(dsSettings.Hosts),
 		"id", settings.ID,
+		"orgId", orgID,
 	)
 
-	return &Datasource{
-		settings: dsSettings,
-		logger:   logger,
-	}, nil
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	state := sharedStateFor(orgID, settings.UID)
+	relabeling, relabelWarnings := compileRelabeling(dsSettings.Relabeling)
+	ds := &Datasource{
+		uid:           settings.UID,
+		orgID:         orgID,
+		settings:      dsSettings,
+		secrets:       settings.DecryptedSecureJSONData,
+		logger:        logger,
+		hosts:         newHostRegistry(dsSettings.Hosts, state.overrides, relabeling),
+		endpoints:     state.endpoints,
+		registrations: state.registrations,
+		maintenance:   state.maintenance,
+		capabilities:  state.capabilities,
+		metadata:      state.metadata,
+		overrides:     state.overrides,
+		scrapes:       state.scrapes,
+		instances:     state.instances,
+		skews:         state.skews,
+		usage:         state.usage,
+		actions:       newInflightActions(),
+		agentKeys:     newAgentKeys(),
+		recordings:    state.recordings,
+		inventory:     state.inventory,
+		bgCtx:         bgCtx,
+		bgCancel:      bgCancel,
+		hostWarnings:  validateHosts(dsSettings.Hosts),
+	}
+	ds.resourceHandler = ds.newResourceHandler()
+
+	exprs, ruleWarnings := validRecordingRules(dsSettings.RecordingRules)
+	ds.recordingExprs = exprs
+	ds.hostWarnings = append(ds.hostWarnings, ruleWarnings...)
+	ds.hostWarnings = append(ds.hostWarnings, validateFeatureToggles(dsSettings.FeatureToggles)...)
+	ds.hostWarnings = append(ds.hostWarnings, validateLabelPruning(dsSettings.LabelPruning)...)
+	exclusions, exclusionWarnings := compileExclusions(dsSettings.ExcludeContainers)
+	ds.exclusions = exclusions
+	ds.hostWarnings = append(ds.hostWarnings, exclusionWarnings...)
+	ds.hostWarnings = append(ds.hostWarnings, relabelWarnings...)
+	ds.hostWarnings = append(ds.hostWarnings, validateControlAuthorization(dsSettings.ControlAuthorization, settings.DecryptedSecureJSONData)...)
+	ds.hostWarnings = append(ds.hostWarnings, validateFederation(dsSettings.FederatedDatasourceUIDs, settings.DecryptedSecureJSONData)...)
+	ds.hostWarnings = append(ds.hostWarnings, validateSwarm(dsSettings.Swarm, dsSettings.Hosts)...)
+	agentClient, err := newAgentClient(dsSettings.ProxyURL)
+	if err != nil {
+		ds.hostWarnings = append(ds.hostWarnings, err.Error())
+	}
+	ds.agentClient = agentClient
+	ds.hostWarnings = append(ds.hostWarnings, validateChaos(dsSettings.Chaos)...)
+	ds.chaos = newChaosInjector(dsSettings.Chaos)
+
+	for _, warning := range ds.hostWarnings {
+		logger.Warn("Host configuration problem", "warning", warning)
+	}
+	if len(dsSettings.Hosts) > ds.largeFleetThreshold() {
+		logger.Warn("Large host fleet configured, health checks will sample hosts",
+			"hosts", len(dsSettings.Hosts),
+			"threshold", ds.largeFleetThreshold(),
+		)
+	}
+
+	if updated, changed := state.reload(settings.Updated, dsSettings, ds.hosts); updated {
+		logger.Info("Reloaded datasource settings",
+			"updated", settings.Updated,
+			"changedHosts", changed,
+		)
+	}
+	if err := state.registrations.open(dsSettings.registrationsPath(orgID, settings.UID)); err != nil {
+		logger.Error("Agent registrations are kept in memory only", "error", err)
+	}
+	ds.syncRegisteredHosts()
+
+	if err := ds.startDiscovery(); err != nil {
+		bgCancel()
+		logger.Error("Failed to start host discovery", "error", err)
+		return nil, fmt.Errorf("failed to start host discovery: %w", err)
+	}
+	ds.startRetention(state)
+	ds.startAudit(state)
+	ds.startExporters()
+	ds.startNotifier()
+	ds.startRecordingRules()
+	ds.startPrefetch()
+
+	return ds, nil
 }
 
 // Dispose cleans up resources when instance is destroyed
 func (d *Datasource) Dispose() {
 	d.logger.Info("Disposing Docker Metrics datasource instance")
+	// Cancels background workers and outstanding metric fetches; control actions get a drain window
+	d.bgCancel()
+	d.actions.drain(controlDrainTimeout, d.logger)
+	d.wg.Wait()
 }
 
 // QueryData handles multiple queries
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	if err := d.checkOrg(req.PluginContext); err != nil {
+		return nil, err
+	}
 	response := backend.NewQueryDataResponse()
 
+	// Dispose cancels running queries (control actions are detached, see inflightActions)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.bgCtx, cancel)
+	defer stop()
+
+	// Grafana sets FromAlert when the query is evaluated for an alert rule
+	ctx = withAlertQuery(ctx, req.Headers["FromAlert"] == "true")
+	if d.featureEnabled(FeatureCaching) {
+		ctx = withContainerCache(ctx)
+	}
+
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+		qctx, id := withRequestID(ctx)
+		qctx, reach := withReachability(qctx)
+		res := d.query(qctx, req.PluginContext, q)
+		if reach.allUnreachable() {
+			res = d.readThrough(qctx, q, res)
+		}
+		res = d.federate(qctx, q, res)
+		d.pruneLabels(res.Frames)
+		tagFrames(res.Frames, id)
+		if res.Error != nil {
+			d.log(qctx).Warn("Query failed", "refId", q.RefID, "error", res.Error)
+		}
 		response.Responses[q.RefID] = res
 	}
 
@@ -103,6 +365,12 @@ type HostSelection struct {
 	ContainerIDs     []string            `json:"containerIds"`
 	ContainerMetrics map[string][]string `json:"containerMetrics"`
 	Metrics          []string            `json:"metrics"` // For blacklist mode
+	// SelectionSemantics combines metrics and containerMetrics: "union" or "intersection", empty for the legacy rules
+	SelectionSemantics string `json:"selectionSemantics"`
+	// IncludeLabels and ExcludeLabels select containers by Docker label, "key=value" or "key"
+	// (see selects), so selections survive redeploys that change container IDs
+	IncludeLabels []string `json:"includeLabels"`
+	ExcludeLabels []string `json:"excludeLabels"`
 }
 
 // Query model from frontend
@@ -116,10 +384,81 @@ type QueryModel struct {
 	Metrics              []string `json:"metrics"`
 	HostIDs              []string `json:"hostIds"`
 
+	// Host group/tag selection (applies on top of hostIds and hostSelections)
+	HostGroups []string `json:"hostGroups"` // host must be in one of these groups
+	HostTags   []string `json:"hostTags"`   // host must have all of these tags
+
+	// AggregateBy rolls containers up into one series per group ("pod", "compose")
+	AggregateBy string `json:"aggregateBy"`
+	// Aggregation reduces the containers of each GroupBy group ("host", "image",
+	// "composeProject", empty for all) to one series: sum, avg, min, max or count. "cost"
+	// queries group by composeProject alone.
+	Aggregation string `json:"aggregation"`
+	GroupBy     string `json:"groupBy"`
+	// Downsample merges points when a series has more than the panel's MaxDataPoints or is
+	// denser than the query interval: "avg" (default), "max", "lttb" or "none"
+	Downsample string `json:"downsample"`
+	// Transforms plots metrics as "rate" (per second) or "delta" (per sample) instead of their
+	// raw values, e.g. {"networkRxBytes": "rate"}; counters are cumulative, so bandwidth panels need rate
+	Transforms map[string]string `json:"transforms"`
+	// Identity keys series by container ID (default), "name" or compose "service", so the
+	// latter two continue one series across container recreation
+	Identity string `json:"identity"`
+	// Namespaces limits containerd hosts to containers in these namespaces
+	Namespaces []string `json:"namespaces"`
+
+	// Rules selects recording rules for "recording" queries
+	Rules []string `json:"rules"`
+
+	// Alerting returns plain numeric frames for alert rules and expressions: one value field
+	// named after the metric, labels that survive container re-creation (no containerId),
+	// no display names and no containers frame
+	Alerting bool `json:"alerting"`
+
+	// Threshold queries return containers whose latest ThresholdMetric value violates
+	// ThresholdOperator (>, >=, <, <=, ==, !=; default >) Threshold
+	ThresholdMetric   string  `json:"thresholdMetric"`
+	ThresholdOperator string  `json:"thresholdOperator"`
+	Threshold         float64 `json:"threshold"`
+
+	// States selects the 0/1 series returned by "state" queries (isRunning, isUnhealthy, isPaused)
+	States []string `json:"states"`
+
+	// LogLimit caps the lines returned by "logs" queries, default 1000
+	LogLimit int `json:"logLimit"`
+
+	// Snapshot adds the host metadata table to metrics queries, so snapshots and public
+	// dashboards render without live agent access
+	Snapshot bool `json:"snapshot"`
+
+	// Limit and Offset page through "containers" queries; 0 limit returns every container
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+
+	// ScopeToken restricts the query to the hosts and containers signed into it (see ScopeClaims)
+	ScopeToken string `json:"scopeToken"`
+
+	// ExcludeContainers replaces the datasource's container exclusions; an empty list shows every container
+	ExcludeContainers []string `json:"excludeContainers"`
+
+	// Retry overrides the host's retry settings for this query's agent reads
+	Retry *RetrySettings `json:"retry"`
+
+	// LabelFilters keeps containers whose series labels (e.g. ecsCluster) have one of the values
+	LabelFilters map[string][]string `json:"labelFilters"`
+
+	// Variables are template variable values for backend interpolation of hostIds, containerIds,
+	// containerNamePattern and hostSelections, sent by the frontend or set in alert queries
+	Variables map[string]VariableValues `json:"variables"`
+
 	// Control action fields (for queryType: "control")
-	ControlAction   string `json:"controlAction"`   // start, stop, restart, pause, unpause
+	ControlAction   string `json:"controlAction"`   // start, stop, restart, pause, unpause, kill, remove, recreate
 	TargetContainer string `json:"targetContainer"` // container ID
 	TargetHost      string `json:"targetHost"`      // host ID
+	Force           bool   `json:"force"`           // remove: remove a running container
+	RemoveVolumes   bool   `json:"removeVolumes"`   // remove: also remove the container's anonymous volumes
+	// ControlSteps runs several actions in order instead of the one above (see queryControlSequence)
+	ControlSteps []ControlStep `json:"controlSteps"`
 }
 
 // AllMetrics lists all available metrics
@@ -131,6 +470,7 @@ var AllMetrics = []string{
 	"cpuPressureSome", "cpuPressureFull",
 	"memoryPressureSome", "memoryPressureFull",
 	"ioPressureSome", "ioPressureFull",
+	"isRunning", "isHealthy",
 }
 
 // query handles a single query
@@ -140,17 +480,21 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 	// Parse query model
 	var qm QueryModel
 	if err := json.Unmarshal(query.JSON, &qm); err != nil {
-		d.logger.Error("Failed to parse query", "error", err)
+		d.log(ctx).Error("Failed to parse query", "error", err)
 		response.Error = fmt.Errorf("failed to parse query: %w", err)
 		return response
 	}
 
-	// Default to metrics query if not specified
-	if qm.QueryType == "" {
-		qm.QueryType = "metrics"
+	ctx, legacy, err := d.prepareQuery(ctx, pCtx, query.JSON, &qm)
+	if err != nil {
+		response.Error = err
+		return response
 	}
 
-	d.logger.Debug("Processing query",
+	d.recordUsage(qm)
+
+	d.log(ctx).Debug("Processing query",
+		"refId", query.RefID,
 		"queryType", qm.QueryType,
 		"metrics", qm.Metrics,
 		"containerPattern", qm.ContainerNamePattern,
@@ -159,35 +503,97 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 
 	switch qm.QueryType {
 	case "metrics", "":
-		return d.queryMetrics(ctx, query, qm)
+		return d.queryLegacyAware(ctx, query, qm, legacy)
 	case "containers":
 		return d.queryContainers(ctx, qm)
+	case "hosts":
+		return d.queryHosts(ctx, qm)
 	case "control":
-		return d.queryControl(ctx, qm)
+		return d.queryControl(ctx, pCtx.User, qm)
+	case "audit":
+		return d.queryAudit(ctx, pCtx.User, query)
+	case "recording":
+		return d.queryRecording(query, qm)
+	case "threshold":
+		return d.queryThreshold(ctx, query, qm)
+	case "state":
+		return d.queryState(ctx, query, qm)
+	case "logs":
+		return d.queryLogs(ctx, query, qm)
+	case "summary":
+		return d.querySummary(ctx, query, qm)
+	case "lifecycle":
+		return d.queryLifecycle(ctx, query, qm)
+	case "cost":
+		return d.queryCost(ctx, query, qm)
+	case "topology":
+		return d.queryTopology(ctx, qm)
+	case "composeProjects":
+		return d.queryComposeProjects(ctx, query, qm)
+	case "inventoryDiff":
+		return d.queryInventoryDiff(ctx, query, qm)
+	case "swarmServices":
+		return d.querySwarmServices(ctx, query, qm)
 	default:
 		// Treat unknown as metrics query for backward compatibility
-		return d.queryMetrics(ctx, query, qm)
+		return d.queryLegacyAware(ctx, query, qm, legacy)
 	}
 }
 
+// prepareQuery applies what every query goes through before it runs, also for resources that
+// run queries: legacy fields, template variables, the scope token, exclusions and retries.
+// It returns the query's context and whether raw came from an old panel.
+func (d *Datasource) prepareQuery(ctx context.Context, pCtx backend.PluginContext, raw json.RawMessage, qm *QueryModel) (context.Context, bool, error) {
+	// Default to metrics query if not specified
+	if qm.QueryType == "" {
+		qm.QueryType = "metrics"
+	}
+	legacy := adaptLegacyQuery(raw, qm)
+	d.interpolateQuery(qm)
+
+	scope, err := d.queryScope(ctx, pCtx, *qm)
+	if err == nil && scope != nil {
+		err = scope.restrict(qm)
+		ctx = withQueryScope(ctx, scope)
+	}
+	if err != nil {
+		return ctx, legacy, &scopeError{err}
+	}
+	ctx, err = queryExclusions(ctx, *qm)
+	if err == nil {
+		ctx, err = queryRetry(ctx, *qm)
+	}
+	return ctx, legacy, err
+}
+
+// queryLegacyAware runs a metrics query, naming frames the old way for old panel queries
+func (d *Datasource) queryLegacyAware(ctx context.Context, query backend.DataQuery, qm QueryModel, legacy bool) backend.DataResponse {
+	response := d.queryMetrics(ctx, query, qm)
+	if legacy {
+		legacyFrames(response.Frames)
+	}
+	return response
+}
+
 // ContainerMetric represents a single metric data point from the agent
 type ContainerMetric struct {
-	ContainerID     string      `json:"containerId"`
-	ContainerName   string      `json:"containerName"`
-	Timestamp       string      `json:"timestamp"`
-	CPUPercent      float64     `json:"cpuPercent"`
-	MemoryBytes     float64     `json:"memoryBytes"`
-	MemoryPercent   float64     `json:"memoryPercent"`
-	NetworkRxBytes  float64     `json:"networkRxBytes"`
-	NetworkTxBytes  float64     `json:"networkTxBytes"`
-	DiskReadBytes   float64     `json:"diskReadBytes"`
-	DiskWriteBytes  float64     `json:"diskWriteBytes"`
-	UptimeSeconds   float64     `json:"uptimeSeconds"`
-	IsRunning       bool        `json:"isRunning"`
-	IsPaused        bool        `json:"isPaused"`
-	CPUPressure     *PSIMetrics `json:"cpuPressure"`
-	MemoryPressure  *PSIMetrics `json:"memoryPressure"`
-	IOPressure      *PSIMetrics `json:"ioPressure"`
+	ContainerID    string      `json:"containerId"`
+	ContainerName  string      `json:"containerName"`
+	Timestamp      SampleTime  `json:"timestamp"`
+	CPUPercent     float64     `json:"cpuPercent"`
+	MemoryBytes    float64     `json:"memoryBytes"`
+	MemoryPercent  float64     `json:"memoryPercent"`
+	NetworkRxBytes float64     `json:"networkRxBytes"`
+	NetworkTxBytes float64     `json:"networkTxBytes"`
+	DiskReadBytes  float64     `json:"diskReadBytes"`
+	DiskWriteBytes float64     `json:"diskWriteBytes"`
+	UptimeSeconds  float64     `json:"uptimeSeconds"`
+	IsRunning      bool        `json:"isRunning"`
+	IsPaused       bool        `json:"isPaused"`
+	IsUnhealthy    bool        `json:"isUnhealthy"`
+	CPUPressure    *PSIMetrics `json:"cpuPressure"`
+	MemoryPressure *PSIMetrics `json:"memoryPressure"`
+	IOPressure     *PSIMetrics `json:"ioPressure"`
 }
 
 // PSIMetrics represents pressure stall information
@@ -207,6 +613,16 @@ type MetricsResponse struct {
 
 // queryMetrics fetches metrics from Docker agents and returns DataFrames
 func (d *Datasource) queryMetrics(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	if err := validateAggregation(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if err := validateDownsample(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if err := validateTransforms(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
 	// New path: if hostSelections exists, use matrix-based filtering
 	if len(qm.HostSelections) > 0 {
 		return d.queryMetricsMatrix(ctx, query, qm)
@@ -221,12 +637,51 @@ func (d *Datasource) queryMetrics(ctx context.Context, query backend.DataQuery,
 	}
 
 	// Get enabled hosts
-	hosts := d.getEnabledHosts(qm.HostIDs)
+	hosts := d.excludeMaintenanceForAlerts(ctx, d.selectHosts(qm, qm.HostIDs))
 	if len(hosts) == 0 {
 		response.Error = fmt.Errorf("no enabled hosts configured")
 		return response
 	}
 
+	// Collect metrics from all hosts
+	allMetrics := d.collectMetrics(ctx, hosts, qm, query.TimeRange, qm.Metrics)
+
+	// Build DataFrames - one frame per metric type per container
+	var frames []*data.Frame
+	if qm.Aggregation != "" {
+		frames = d.buildAggregatedFrames(ctx, hosts, allMetrics, qm.Metrics, qm, query.Interval)
+	} else {
+		frames = d.buildMetricFrames(allMetrics, qm.Metrics, qm.Alerting, qm.Identity, downsamplingFor(query, qm), qm.Transforms)
+	}
+	if qm.Alerting {
+		response.Frames = frames
+		reportInvalidPayloads(&response, allMetrics)
+		d.reportFailedHosts(&response, allMetrics, len(hosts), true)
+		return response
+	}
+	addFrameNotice(frames, d.scalingNotice(len(hosts)))
+	frames = append(frames, unmatchedFrames(allMetrics)...)
+
+	// Also include containers frame for public dashboard support
+	// This allows panels to receive container state info without a separate query
+	containersFrame := d.buildContainersFrame(ctx, hosts)
+	if containersFrame != nil {
+		frames = append(frames, containersFrame)
+	}
+	if qm.Snapshot {
+		frames = d.addSnapshotFrames(ctx, frames, hosts)
+	}
+
+	response.Frames = frames
+	reportInvalidPayloads(&response, allMetrics)
+	d.reportFailedHosts(&response, allMetrics, len(hosts), false)
+
+	return response
+}
+
+// collectMetrics fetches metrics from hosts and applies the query's legacy container
+// filters (name pattern, IDs), namespace and label filters and aggregation
+func (d *Datasource) collectMetrics(ctx context.Context, hosts []HostConfig, qm QueryModel, timeRange backend.TimeRange, requested []string) []metricsWithHost {
 	// Compile container name pattern if provided
 	var containerPattern *regexp.Regexp
 	if qm.ContainerNamePattern != "" {
@@ -237,18 +692,15 @@ func (d *Datasource) queryMetrics(ctx context.Context, query backend.DataQuery,
 		}
 	}
 
-	// Collect metrics from all hosts
-	allMetrics := make([]metricsWithHost, 0)
-
-	for _, host := range hosts {
-		metrics, err := d.fetchMetricsFromHost(ctx, host, query.TimeRange, qm.Metrics)
+	results := make([]*metricsWithHost, len(hosts))
+	d.forEachHost(ctx, hosts, func(ctx context.Context, i int, host HostConfig) {
+		hostCtx, segments := withSegments(ctx)
+		metrics, servedBy, err := d.fetchMetrics(hostCtx, host, timeRange, requested)
 		if err != nil {
-			d.logger.Error("Failed to fetch metrics from host",
-				"host", host.Name,
-				"url", host.URL,
-				"error", err,
-			)
-			continue
+			d.logHostError(host, "Failed to fetch metrics from host", err)
+			failed := failedHost(host, err)
+			results[i] = &failed
+			return
 		}
 
 		// Filter by container pattern
@@ -262,119 +714,158 @@ func (d *Datasource) queryMetrics(ctx context.Context, query backend.DataQuery,
 			}
 			filtered = append(filtered, m)
 		}
+		containerLabels := d.containerLabelsForHost(ctx, host)
+		filtered = filterByNamespace(filtered, containerLabels, qm.Namespaces)
+		filtered = filterByLabels(filtered, containerLabels, qm.LabelFilters)
+		filtered, containerLabels = aggregateMetrics(qm.AggregateBy, filtered, containerLabels)
+
+		results[i] = &metricsWithHost{
+			HostID:          host.ID,
+			HostName:        host.Name,
+			HostLabels:      d.hostLabels(ctx, host),
+			ContainerLabels: containerLabels,
+			Metrics:         filtered,
+			Fallback:        d.noticeURL(host, fallbackURL(host, servedBy)),
+			Segments:        segments.list(),
+			ClockSkew:       d.hostClockSkew(host),
+			StaleAge:        d.staleAge(timeRange, metrics, time.Now()),
+			Unmatched:       len(metrics) > 0 && len(filtered) == 0,
+		}
+	})
 
-		allMetrics = append(allMetrics, metricsWithHost{
-			HostID:   host.ID,
-			HostName: host.Name,
-			Metrics:  filtered,
-		})
-	}
-
-	// Build DataFrames - one frame per metric type per container
-	frames := d.buildMetricFrames(allMetrics, qm.Metrics)
+	return collectedHosts(results)
+}
 
-	// Also include containers frame for public dashboard support
-	// This allows panels to receive container state info without a separate query
-	containersFrame := d.buildContainersFrame(ctx, hosts)
-	if containersFrame != nil {
-		frames = append(frames, containersFrame)
+// collectedHosts drops the hosts that returned nothing, keeping host order
+func collectedHosts(results []*metricsWithHost) []metricsWithHost {
+	allMetrics := make([]metricsWithHost, 0, len(results))
+	for _, mwh := range results {
+		if mwh != nil {
+			allMetrics = append(allMetrics, *mwh)
+		}
 	}
-
-	response.Frames = frames
-
-	return response
+	return allMetrics
 }
 
 // queryMetricsMatrix handles matrix-based container/metric selection
 func (d *Datasource) queryMetricsMatrix(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
 	var response backend.DataResponse
 
-	// Log incoming hostSelections for debugging
-	for hostID, hostSel := range qm.HostSelections {
-		d.logger.Debug("queryMetricsMatrix: incoming hostSelection",
-			"hostID", hostID,
-			"mode", hostSel.Mode,
-			"containerIDsCount", len(hostSel.ContainerIDs),
-			"containerMetricsKeys", len(hostSel.ContainerMetrics),
-		)
-		for containerID, metrics := range hostSel.ContainerMetrics {
-			d.logger.Debug("queryMetricsMatrix: containerMetrics entry",
-				"hostID", hostID,
-				"containerID", containerID,
-				"metricsCount", len(metrics),
-				"metrics", metrics,
-			)
-		}
-	}
-
 	// Collect all hosts from selections
 	hostIDs := make([]string, 0, len(qm.HostSelections))
 	for hostID := range qm.HostSelections {
 		hostIDs = append(hostIDs, hostID)
 	}
 
-	hosts := d.getEnabledHosts(hostIDs)
+	sortedIDs := append([]string(nil), hostIDs...)
+	sort.Strings(sortedIDs)
+	for _, hostID := range sortedIDs {
+		if err := validateSelection(qm.HostSelections[hostID]); err != nil {
+			response.Error = fmt.Errorf("host selection %s: %w", hostID, err)
+			return response
+		}
+	}
+
+	hosts := d.excludeMaintenanceForAlerts(ctx, d.selectHosts(qm, hostIDs))
 	if len(hosts) == 0 {
 		response.Error = fmt.Errorf("no enabled hosts configured")
 		return response
 	}
 
 	// Collect metrics from all hosts with matrix-based filtering
-	allMetrics := make([]metricsWithHost, 0)
-
-	for _, host := range hosts {
+	results := make([]*metricsWithHost, len(hosts))
+	d.forEachHost(ctx, hosts, func(ctx context.Context, i int, host HostConfig) {
 		hostSel, ok := qm.HostSelections[host.ID]
 		if !ok {
-			continue
+			return
 		}
 
 		// Determine which metrics to fetch for this host
 		metricsToFetch := d.getMetricsForHost(hostSel)
 		if len(metricsToFetch) == 0 {
-			continue
+			// Nothing is selected on this host, e.g. a whitelist without containers
+			results[i] = &metricsWithHost{HostID: host.ID, HostName: host.Name, Unmatched: true}
+			return
 		}
 
-		metrics, err := d.fetchMetricsFromHost(ctx, host, query.TimeRange, metricsToFetch)
+		hostCtx, segments := withSegments(withFieldSelection(ctx, fieldSelectionFor(hostSel)))
+		metrics, servedBy, err := d.fetchMetrics(hostCtx, host, query.TimeRange, metricsToFetch)
 		if err != nil {
-			d.logger.Error("Failed to fetch metrics from host",
-				"host", host.Name,
-				"url", host.URL,
-				"error", err,
-			)
-			continue
+			d.logHostError(host, "Failed to fetch metrics from host", err)
+			failed := failedHost(host, err)
+			results[i] = &failed
+			return
 		}
 
 		// Filter metrics based on host selection mode
-		filtered := d.filterMetricsBySelection(metrics, hostSel)
+		var dockerLabels map[string]map[string]string
+		if selectsByLabel(hostSel) {
+			dockerLabels = d.dockerLabelsForHost(ctx, host)
+		}
+		filtered := d.filterMetricsBySelection(metrics, hostSel, dockerLabels)
+		containerLabels := d.containerLabelsForHost(ctx, host)
+		filtered = filterByNamespace(filtered, containerLabels, qm.Namespaces)
+		filtered = filterByLabels(filtered, containerLabels, qm.LabelFilters)
+		filtered, containerLabels = aggregateMetrics(qm.AggregateBy, filtered, containerLabels)
 
 		// Copy hostSel for the pointer
 		hostSelCopy := hostSel
-		allMetrics = append(allMetrics, metricsWithHost{
-			HostID:        host.ID,
-			HostName:      host.Name,
-			Metrics:       filtered,
-			HostSelection: &hostSelCopy,
-		})
-	}
+		results[i] = &metricsWithHost{
+			HostID:          host.ID,
+			HostName:        host.Name,
+			HostLabels:      d.hostLabels(ctx, host),
+			ContainerLabels: containerLabels,
+			Metrics:         filtered,
+			HostSelection:   &hostSelCopy,
+			Fallback:        d.noticeURL(host, fallbackURL(host, servedBy)),
+			Segments:        segments.list(),
+			ClockSkew:       d.hostClockSkew(host),
+			StaleAge:        d.staleAge(query.TimeRange, metrics, time.Now()),
+			Unmatched:       len(metrics) > 0 && len(filtered) == 0,
+		}
+	})
+	allMetrics := collectedHosts(results)
 
 	// Collect all requested metrics across all host selections
 	requestedMetrics := d.collectRequestedMetrics(qm.HostSelections)
 
 	// Build DataFrames
-	frames := d.buildMetricFrames(allMetrics, requestedMetrics)
+	var frames []*data.Frame
+	if qm.Aggregation != "" {
+		frames = d.buildAggregatedFrames(ctx, hosts, allMetrics, requestedMetrics, qm, query.Interval)
+	} else {
+		frames = d.buildMetricFrames(allMetrics, requestedMetrics, qm.Alerting, qm.Identity, downsamplingFor(query, qm), qm.Transforms)
+	}
+	if qm.Alerting {
+		response.Frames = frames
+		reportInvalidPayloads(&response, allMetrics)
+		d.reportFailedHosts(&response, allMetrics, len(hosts), true)
+		return response
+	}
+	addFrameNotice(frames, d.scalingNotice(len(hosts)))
+	frames = append(frames, unmatchedFrames(allMetrics)...)
 
 	// Include containers frame for panel state display
 	containersFrame := d.buildContainersFrameFiltered(ctx, hosts, qm.HostSelections)
 	if containersFrame != nil {
 		frames = append(frames, containersFrame)
 	}
+	if qm.Snapshot {
+		frames = d.addSnapshotFrames(ctx, frames, hosts)
+	}
 
 	response.Frames = frames
+	reportInvalidPayloads(&response, allMetrics)
+	d.reportFailedHosts(&response, allMetrics, len(hosts), false)
 	return response
 }
 
 // getMetricsForHost determines which metrics to fetch for a host based on selection
 func (d *Datasource) getMetricsForHost(hostSel HostSelection) []string {
+	if explicitSelection(hostSel) {
+		return selectionFetchMetrics(hostSel)
+	}
+
 	// Both modes use containerMetrics for per-container metric selection
 	// Collect unique metrics from containerMetrics
 	metricsSet := make(map[string]bool)
@@ -396,30 +887,15 @@ func (d *Datasource) getMetricsForHost(hostSel HostSelection) []string {
 	return metrics
 }
 
-// filterMetricsBySelection filters metrics based on host selection mode
-func (d *Datasource) filterMetricsBySelection(metrics []ContainerMetric, hostSel HostSelection) []ContainerMetric {
+// filterMetricsBySelection filters metrics based on host selection mode and label
+// selectors; dockerLabels maps container IDs to their Docker labels
+func (d *Datasource) filterMetricsBySelection(metrics []ContainerMetric, hostSel HostSelection, dockerLabels map[string]map[string]string) []ContainerMetric {
 	filtered := make([]ContainerMetric, 0)
-
-	containerSet := make(map[string]bool)
-	for _, cid := range hostSel.ContainerIDs {
-		containerSet[cid] = true
-	}
-
 	for _, m := range metrics {
-		if hostSel.Mode == "whitelist" {
-			// Whitelist: only include if container is in the list
-			if !containerSet[m.ContainerID] {
-				continue
-			}
-		} else {
-			// Blacklist: exclude if container is in the list
-			if containerSet[m.ContainerID] {
-				continue
-			}
+		if hostSel.selects(m.ContainerID, dockerLabels[m.ContainerID]) {
+			filtered = append(filtered, m)
 		}
-		filtered = append(filtered, m)
 	}
-
 	return filtered
 }
 
@@ -428,7 +904,11 @@ func (d *Datasource) collectRequestedMetrics(hostSelections map[string]HostSelec
 	metricsSet := make(map[string]bool)
 
 	for _, hostSel := range hostSelections {
-		if hostSel.Mode == "blacklist" {
+		if explicitSelection(hostSel) {
+			for _, m := range selectionFetchMetrics(hostSel) {
+				metricsSet[m] = true
+			}
+		} else if hostSel.Mode == "blacklist" {
 			// Blacklist mode: use selected metrics or all
 			metricsToAdd := hostSel.Metrics
 			if len(metricsToAdd) == 0 {
@@ -458,25 +938,18 @@ func (d *Datasource) collectRequestedMetrics(hostSelections map[string]HostSelec
 	return metrics
 }
 
-// buildContainersFrameFiltered builds containers frame filtered by host selections
-func (d *Datasource) buildContainersFrameFiltered(ctx context.Context, hosts []HostConfig, hostSelections map[string]HostSelection) *data.Frame {
-	containerIDs := make([]string, 0)
-	containerNames := make([]string, 0)
-	hostIDs := make([]string, 0)
-	hostNames := make([]string, 0)
-	states := make([]string, 0)
-	healthStatuses := make([]string, 0)
-	isRunningList := make([]bool, 0)
-	isPausedList := make([]bool, 0)
-	isUnhealthyList := make([]bool, 0)
-	agentVersions := make([]string, 0)
-
-	for _, host := range hosts {
-		hostSel, ok := hostSelections[host.ID]
-		if !ok {
-			continue
-		}
+// listedContainers is a host's containers with the version of the agent that listed them
+type listedContainers struct {
+	host         HostConfig
+	agentVersion string
+	containers   []ContainerInfo
+}
 
+// listHostContainers lists the containers of every host concurrently, each within the host
+// timeout. Results stay in host order; hosts that couldn't be listed are nil.
+func (d *Datasource) listHostContainers(ctx context.Context, hosts []HostConfig) []*listedContainers {
+	results := make([]*listedContainers, len(hosts))
+	d.forEachHost(ctx, hosts, func(ctx context.Context, i int, host HostConfig) {
 		// Fetch agent info to get version
 		agentVersion := ""
 		agentInfo, err := d.fetchAgentInfoFromHost(ctx, host)
@@ -495,23 +968,42 @@ func (d *Datasource) buildContainersFrameFiltered(ctx context.Context, hosts []H
 				"host", host.Name,
 				"error", err,
 			)
-			continue
+			return
 		}
+		results[i] = &listedContainers{host: host, agentVersion: agentVersion, containers: containers}
+	})
+	return results
+}
 
-		containerSet := make(map[string]bool)
-		for _, cid := range hostSel.ContainerIDs {
-			containerSet[cid] = true
-		}
+// buildContainersFrameFiltered builds containers frame filtered by host selections
+func (d *Datasource) buildContainersFrameFiltered(ctx context.Context, hosts []HostConfig, hostSelections map[string]HostSelection) *data.Frame {
+	containerIDs := make([]string, 0)
+	containerNames := make([]string, 0)
+	hostIDs := make([]string, 0)
+	hostNames := make([]string, 0)
+	states := make([]string, 0)
+	healthStatuses := make([]string, 0)
+	isRunningList := make([]bool, 0)
+	isPausedList := make([]bool, 0)
+	isUnhealthyList := make([]bool, 0)
+	pods := make([]string, 0)
+	namespaces := make([]string, 0)
+	agentVersions := make([]string, 0)
 
-		for _, c := range containers {
-			include := false
-			if hostSel.Mode == "whitelist" {
-				include = containerSet[c.ContainerID]
-			} else {
-				include = !containerSet[c.ContainerID]
-			}
+	selected := make([]HostConfig, 0, len(hosts))
+	for _, host := range hosts {
+		if _, ok := hostSelections[host.ID]; ok {
+			selected = append(selected, host)
+		}
+	}
 
-			if include {
+	for _, listed := range d.listHostContainers(ctx, selected) {
+		if listed == nil {
+			continue
+		}
+		host, hostSel, agentVersion := listed.host, hostSelections[listed.host.ID], listed.agentVersion
+		for _, c := range listed.containers {
+			if hostSel.selects(c.ContainerID, c.Labels) {
 				containerIDs = append(containerIDs, c.ContainerID)
 				containerNames = append(containerNames, c.ContainerName)
 				hostIDs = append(hostIDs, host.ID)
@@ -521,6 +1013,8 @@ func (d *Datasource) buildContainersFrameFiltered(ctx context.Context, hosts []H
 				isRunningList = append(isRunningList, c.IsRunning)
 				isPausedList = append(isPausedList, c.IsPaused)
 				isUnhealthyList = append(isUnhealthyList, c.IsUnhealthy)
+				pods = append(pods, c.Pod)
+				namespaces = append(namespaces, c.Namespace)
 				agentVersions = append(agentVersions, agentVersion)
 			}
 		}
@@ -540,10 +1034,13 @@ func (d *Datasource) buildContainersFrameFiltered(ctx context.Context, hosts []H
 		data.NewField("isRunning", nil, isRunningList),
 		data.NewField("isPaused", nil, isPausedList),
 		data.NewField("isUnhealthy", nil, isUnhealthyList),
+		data.NewField("pod", nil, pods),
+		data.NewField("namespace", nil, namespaces),
 		data.NewField("agentVersion", nil, agentVersions),
 	)
 
 	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
 		Custom: map[string]interface{}{
 			"queryType": "containers",
 		},
@@ -563,31 +1060,16 @@ func (d *Datasource) buildContainersFrame(ctx context.Context, hosts []HostConfi
 	isRunningList := make([]bool, 0)
 	isPausedList := make([]bool, 0)
 	isUnhealthyList := make([]bool, 0)
+	pods := make([]string, 0)
+	namespaces := make([]string, 0)
 	agentVersions := make([]string, 0)
 
-	for _, host := range hosts {
-		// Fetch agent info to get version
-		agentVersion := ""
-		agentInfo, err := d.fetchAgentInfoFromHost(ctx, host)
-		if err != nil {
-			d.logger.Warn("Failed to fetch agent info",
-				"host", host.Name,
-				"error", err,
-			)
-		} else {
-			agentVersion = agentInfo.AgentVersion
-		}
-
-		containers, err := d.fetchContainersFromHost(ctx, host)
-		if err != nil {
-			d.logger.Warn("Failed to fetch containers for metrics response",
-				"host", host.Name,
-				"error", err,
-			)
+	for _, listed := range d.listHostContainers(ctx, hosts) {
+		if listed == nil {
 			continue
 		}
-
-		for _, c := range containers {
+		host, agentVersion := listed.host, listed.agentVersion
+		for _, c := range listed.containers {
 			containerIDs = append(containerIDs, c.ContainerID)
 			containerNames = append(containerNames, c.ContainerName)
 			hostIDs = append(hostIDs, host.ID)
@@ -597,6 +1079,8 @@ func (d *Datasource) buildContainersFrame(ctx context.Context, hosts []HostConfi
 			isRunningList = append(isRunningList, c.IsRunning)
 			isPausedList = append(isPausedList, c.IsPaused)
 			isUnhealthyList = append(isUnhealthyList, c.IsUnhealthy)
+			pods = append(pods, c.Pod)
+			namespaces = append(namespaces, c.Namespace)
 			agentVersions = append(agentVersions, agentVersion)
 		}
 	}
@@ -615,11 +1099,14 @@ func (d *Datasource) buildContainersFrame(ctx context.Context, hosts []HostConfi
 		data.NewField("isRunning", nil, isRunningList),
 		data.NewField("isPaused", nil, isPausedList),
 		data.NewField("isUnhealthy", nil, isUnhealthyList),
+		data.NewField("pod", nil, pods),
+		data.NewField("namespace", nil, namespaces),
 		data.NewField("agentVersion", nil, agentVersions),
 	)
 
 	// Mark this frame with custom metadata so panel can identify it
 	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
 		Custom: map[string]interface{}{
 			"queryType": "containers",
 		},
@@ -628,51 +1115,84 @@ func (d *Datasource) buildContainersFrame(ctx context.Context, hosts []HostConfi
 	return frame
 }
 
-// fetchMetricsFromHost fetches metrics from a single Docker agent
-func (d *Datasource) fetchMetricsFromHost(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, error) {
+// fetchMetricsFromHost fetches metrics from a single Docker agent, correcting the host's
+// clock skew if enabled. It also returns the agent base URL that served the request.
+func (d *Datasource) fetchMetricsFromHost(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, string, error) {
+	skew := d.skewCorrection(host)
+	samples, servedBy, err := d.fetchHostSamples(ctx, host, shiftTimeRange(timeRange, skew), metrics)
+	if err != nil {
+		return nil, "", err
+	}
+	return correctClockSkew(samples, skew), servedBy, nil
+}
+
+// fetchHostSamples fetches metrics for a time range on the host's own clock
+func (d *Datasource) fetchHostSamples(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, string, error) {
+	switch host.Mode {
+	case HostModeCAdvisor:
+		return d.fetchCAdvisorMetrics(ctx, host, timeRange)
+	case HostModePrometheus:
+		return d.fetchScrapedMetrics(ctx, host, timeRange)
+	}
+
 	// Build URL
 	params := url.Values{}
 	params.Set("from", timeRange.From.Format(time.RFC3339))
 	params.Set("to", timeRange.To.Format(time.RFC3339))
-	params.Set("fields", strings.Join(metrics, ","))
+	params.Set("fields", strings.Join(agentFields(metrics), ","))
+	// Older agents ignore this and keep sending RFC3339 strings, which SampleTime also reads
+	params.Set("timestamps", "epoch")
+	fieldSelectionFrom(ctx).encode(params)
 
-	targetURL := fmt.Sprintf("%s/api/metrics?%s", strings.TrimSuffix(host.URL, "/"), params.Encode())
+	path := "/api/metrics?" + params.Encode()
 
-	d.logger.Debug("Fetching metrics from host", "url", targetURL)
+	d.log(ctx).Debug("Fetching metrics from host", "host", host.Name, "path", path)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	resp, servedBy, err := d.getWithRetry(ctx, host, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("unexpected status %d (failed to read body: %w)", resp.StatusCode, err)
+			return nil, "", fmt.Errorf("unexpected status %d (failed to read body: %w)", resp.StatusCode, err)
 		}
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		return nil, "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var metricsResp MetricsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&metricsResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if err := validateMetrics(host, metricsResp); err != nil {
+		return nil, "", err
 	}
 
-	return metricsResp.Metrics, nil
+	samples, dropped := normalizeTimestamps(host, metricsResp.Metrics)
+	if dropped > 0 {
+		d.log(ctx).Warn("Dropped samples with unrecognized timestamps", "host", host.Name, "dropped", dropped)
+	}
+	return samples, servedBy, nil
 }
 
 // metricsWithHost groups metrics by host
 type metricsWithHost struct {
-	HostID        string
-	HostName      string
-	Metrics       []ContainerMetric
-	HostSelection *HostSelection // For per-container metric filtering
+	HostID     string
+	HostName   string
+	HostLabels map[string]string
+	// ContainerLabels holds per-container series labels (pod, ...) keyed by container ID
+	ContainerLabels map[string]map[string]string
+	Metrics         []ContainerMetric
+	HostSelection   *HostSelection // For per-container metric filtering
+	Fallback        string         // Fallback agent URL if the primary was unreachable
+	Segments        []dataSegment  // sources of the range when the retention store served part of it
+	Invalid         *payloadError  // the host's response failed validation, Metrics is empty
+	ClockSkew       time.Duration  // agent clock skew beyond the warning threshold, 0 otherwise
+	StaleAge        time.Duration  // age of the host's newest sample if it is stale, 0 otherwise
+	Unmatched       bool           // the host sent samples but none matched the query's selection
+	Err             error          // the host's agents couldn't be read, Metrics is empty
 }
 
 // containerKey identifies a container across hosts
@@ -683,63 +1203,91 @@ type containerKey struct {
 
 // containerData holds container info and metrics
 type containerData struct {
-	hostName      string
-	containerName string
-	metrics       []ContainerMetric
-	hostSelection *HostSelection // For per-container metric filtering
+	containerID     string // first container seen for the series identity
+	hostName        string
+	hostLabels      map[string]string
+	containerLabels map[string]string
+	containerName   string
+	seriesName      string // containerName, or the duplicate name pattern if other hosts use it too
+	duplicateName   bool
+	metrics         []ContainerMetric
+	hostSelection   *HostSelection // For per-container metric filtering
+	fallback        string
+	segments        []dataSegment
+	clockSkew       time.Duration
+	staleAge        time.Duration
 }
 
-// buildMetricFrames converts metrics into Grafana DataFrames
-func (d *Datasource) buildMetricFrames(allMetrics []metricsWithHost, requestedMetrics []string) []*data.Frame {
+// buildMetricFrames converts metrics into Grafana DataFrames, one series per container
+// identity (see seriesIdentity). Frames are ordered by host, container and metric so legend
+// colors and alert rule series stay stable between refreshes. Each series is downsampled to
+// what the panel can draw.
+func (d *Datasource) buildMetricFrames(allMetrics []metricsWithHost, requestedMetrics []string, alerting bool, identity string, sampling downsampling, transforms map[string]string) []*data.Frame {
 	// Group metrics by container
 	byContainer := make(map[containerKey]*containerData)
 
 	for _, mwh := range allMetrics {
 		for _, m := range mwh.Metrics {
-			key := containerKey{hostID: mwh.HostID, containerID: m.ContainerID}
+			key := containerKey{hostID: mwh.HostID, containerID: seriesIdentity(identity, m, mwh.ContainerLabels[m.ContainerID])}
 			if byContainer[key] == nil {
 				byContainer[key] = &containerData{
-					hostName:      mwh.HostName,
-					containerName: m.ContainerName,
-					metrics:       make([]ContainerMetric, 0),
-					hostSelection: mwh.HostSelection,
+					containerID:     m.ContainerID,
+					hostName:        mwh.HostName,
+					hostLabels:      mwh.HostLabels,
+					containerLabels: mwh.ContainerLabels[m.ContainerID],
+					containerName:   m.ContainerName,
+					metrics:         make([]ContainerMetric, 0),
+					hostSelection:   mwh.HostSelection,
+					fallback:        mwh.Fallback,
+					segments:        mwh.Segments,
+					clockSkew:       mwh.ClockSkew,
+					staleAge:        mwh.StaleAge,
 				}
 			}
 			byContainer[key].metrics = append(byContainer[key].metrics, m)
 		}
 	}
 
+	d.assignSeriesNames(byContainer)
+
+	keys := make([]containerKey, 0, len(byContainer))
+	for key := range byContainer {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := byContainer[keys[i]], byContainer[keys[j]]
+		if a.hostName != b.hostName {
+			return a.hostName < b.hostName
+		}
+		if a.containerName != b.containerName {
+			return a.containerName < b.containerName
+		}
+		if keys[i].hostID != keys[j].hostID {
+			return keys[i].hostID < keys[j].hostID
+		}
+		return keys[i].containerID < keys[j].containerID
+	})
+
+	sortedMetrics := append([]string(nil), requestedMetrics...)
+	sort.Strings(sortedMetrics)
+
 	// Create frames - one per container per metric
 	frames := make([]*data.Frame, 0)
 
-	for key, cd := range byContainer {
+	for _, key := range keys {
+		cd := byContainer[key]
 		// Sort metrics by timestamp
 		sortMetricsByTime(cd.metrics)
 
 		// Determine which metrics to include for this container
-		containerMetrics := d.getMetricsForContainer(cd.hostSelection, key.containerID)
-
-		d.logger.Debug("buildMetricFrames: processing container",
-			"containerID", key.containerID,
-			"containerName", cd.containerName,
-			"containerMetricsCount", len(containerMetrics),
-			"requestedMetricsCount", len(requestedMetrics),
-		)
+		containerMetrics := d.getMetricsForContainer(cd.hostSelection, cd.containerID)
 
-		for _, metricName := range requestedMetrics {
+		for _, metricName := range sortedMetrics {
 			// Skip if this metric is not selected for this container
 			if !contains(containerMetrics, metricName) {
-				d.logger.Debug("buildMetricFrames: SKIPPING metric (not in containerMetrics)",
-					"containerID", key.containerID,
-					"metric", metricName,
-				)
 				continue
 			}
-			d.logger.Debug("buildMetricFrames: BUILDING metric frame",
-				"containerID", key.containerID,
-				"metric", metricName,
-			)
-			frame := d.buildSingleMetricFrame(key, cd, metricName)
+			frame := d.buildSingleMetricFrame(key, cd, metricName, alerting, sampling, transforms[metricName])
 			if frame != nil {
 				frames = append(frames, frame)
 			}
@@ -753,24 +1301,17 @@ func (d *Datasource) buildMetricFrames(allMetrics []metricsWithHost, requestedMe
 func (d *Datasource) getMetricsForContainer(hostSel *HostSelection, containerID string) []string {
 	// If no host selection, return all metrics (legacy mode)
 	if hostSel == nil {
-		d.logger.Debug("getMetricsForContainer: hostSel is nil, returning AllMetrics", "containerID", containerID)
 		return AllMetrics
 	}
+	if explicitSelection(*hostSel) {
+		return selectionMetrics(*hostSel, containerID)
+	}
 
 	// Check if container has specific metrics defined
 	if metrics, ok := hostSel.ContainerMetrics[containerID]; ok && len(metrics) > 0 {
-		d.logger.Debug("getMetricsForContainer: found custom metrics",
-			"containerID", containerID,
-			"metricsCount", len(metrics),
-			"metrics", metrics,
-		)
 		return metrics
 	}
 
-	d.logger.Debug("getMetricsForContainer: no custom metrics, returning AllMetrics",
-		"containerID", containerID,
-		"containerMetricsKeys", hostSel.ContainerMetrics,
-	)
 	// Default: return all metrics
 	return AllMetrics
 }
@@ -791,6 +1332,8 @@ var metricDisplayNames = map[string]string{
 	"memoryPressureFull": "Memory Pressure (full)",
 	"ioPressureSome":     "I/O Pressure (some)",
 	"ioPressureFull":     "I/O Pressure (full)",
+	"isRunning":          "Running",
+	"isHealthy":          "Healthy",
 }
 
 // metricUnits maps internal metric names to units
@@ -809,146 +1352,176 @@ var metricUnits = map[string]string{
 	"memoryPressureFull": "percent",
 	"ioPressureSome":     "percent",
 	"ioPressureFull":     "percent",
+	"isRunning":          "bool",
+	"isHealthy":          "bool",
 }
 
-// buildSingleMetricFrame creates a DataFrame for a single metric
-func (d *Datasource) buildSingleMetricFrame(key containerKey, cd *containerData, metricName string) *data.Frame {
+// buildSingleMetricFrame creates a DataFrame for a single metric. The transform is applied to
+// the samples before downsampling, so rates span the agent's sample interval.
+func (d *Datasource) buildSingleMetricFrame(key containerKey, cd *containerData, metricName string, alerting bool, sampling downsampling, transform string) *data.Frame {
+	// Samples without the metric (PSI on hosts that don't report it) or with a NaN from the
+	// agent are null, not zero, so graphs show gaps and alert rules see no data. Missing
+	// samples beyond the heartbeat are null too.
+	scale, unit, decimals := d.metricFormat(metricName)
 	times := make([]time.Time, 0, len(cd.metrics))
-	values := make([]float64, 0, len(cd.metrics))
-
-	const bytesToMB = 1024.0 * 1024.0
-
+	values := make([]*float64, 0, len(cd.metrics))
+	present := false
 	for _, m := range cd.metrics {
-		t, err := time.Parse(time.RFC3339, m.Timestamp)
-		if err != nil {
-			continue
-		}
-
-		var value float64
-
-		switch metricName {
-		case "cpuPercent":
-			value = m.CPUPercent
-		case "memoryBytes":
-			value = m.MemoryBytes / bytesToMB
-		case "memoryPercent":
-			value = m.MemoryPercent
-		case "networkRxBytes":
-			value = m.NetworkRxBytes / bytesToMB
-		case "networkTxBytes":
-			value = m.NetworkTxBytes / bytesToMB
-		case "diskReadBytes":
-			value = m.DiskReadBytes / bytesToMB
-		case "diskWriteBytes":
-			value = m.DiskWriteBytes / bytesToMB
-		case "uptimeSeconds":
-			value = m.UptimeSeconds
-		case "cpuPressureSome":
-			if m.CPUPressure != nil {
-				value = m.CPUPressure.Some10
-			}
-		case "cpuPressureFull":
-			if m.CPUPressure != nil {
-				value = m.CPUPressure.Full10
-			}
-		case "memoryPressureSome":
-			if m.MemoryPressure != nil {
-				value = m.MemoryPressure.Some10
-			}
-		case "memoryPressureFull":
-			if m.MemoryPressure != nil {
-				value = m.MemoryPressure.Full10
-			}
-		case "ioPressureSome":
-			if m.IOPressure != nil {
-				value = m.IOPressure.Some10
-			}
-		case "ioPressureFull":
-			if m.IOPressure != nil {
-				value = m.IOPressure.Full10
-			}
-		default:
-			continue
+		times = append(times, m.Timestamp.Time)
+		if value, ok := displayMetricValue(m, metricName); ok {
+			value *= scale
+			values = append(values, &value)
+			present = true
+		} else {
+			values = append(values, nil)
 		}
-
-		times = append(times, t)
-		values = append(values, value)
 	}
 
-	if len(times) == 0 {
+	if !present {
 		return nil
 	}
+	times, values = insertGaps(times, values, d.heartbeat())
+	values = applyTransform(transform, times, values)
+	times, values = sampling.apply(times, values)
 
-	// Get display name and unit
+	// Get display name
 	displayName := metricDisplayNames[metricName]
 	if displayName == "" {
 		displayName = metricName
 	}
-	unit := metricUnits[metricName]
+	unit, displayName = transformFormat(transform, unit, displayName)
 
-	// Create value field with proper config
-	valueField := data.NewField(displayName, data.Labels{
-		"containerId":   key.containerID,
-		"containerName": cd.containerName,
-		"hostName":      cd.hostName,
-	}, values)
+	// Create value field with proper config. Host and container labels never override the built-in ones.
+	labels := data.Labels{}
+	for k, v := range cd.hostLabels {
+		labels[k] = v
+	}
+	for k, v := range cd.containerLabels {
+		labels[k] = v
+	}
+	labels["containerName"] = cd.containerName
+	labels["hostName"] = cd.hostName
+	if cd.duplicateName {
+		labels["hostId"] = key.hostID
+	}
+
+	if alerting {
+		valueField := data.NewField(metricName, labels, values)
+		valueField.Config = &data.FieldConfig{Unit: unit, Decimals: decimals}
+		frame := data.NewFrame(metricName, data.NewField("time", nil, times), valueField)
+		frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeGraph}
+		if notice := staleNotice(cd.hostName, cd.staleAge); notice != nil {
+			frame.Meta.Notices = append(frame.Meta.Notices, *notice)
+		}
+		return frame
+	}
+	labels["containerId"] = key.containerID
+
+	valueField := data.NewField(displayName, labels, values)
 
 	// Set field config for proper display in Grafana
 	valueField.Config = &data.FieldConfig{
-		DisplayName: fmt.Sprintf("%s - %s", cd.containerName, displayName),
+		DisplayName: fmt.Sprintf("%s - %s", cd.seriesName, displayName),
 		Unit:        unit,
+		Decimals:    decimals,
+		Links:       d.containerLinks(),
 	}
 
 	// Create frame
 	frame := data.NewFrame(
-		fmt.Sprintf("%s - %s", cd.containerName, displayName),
+		fmt.Sprintf("%s - %s", cd.seriesName, displayName),
 		data.NewField("time", nil, times),
 		valueField,
 	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeGraph}
+
+	if cd.fallback != "" {
+		frame.Meta.Custom = map[string]interface{}{
+			"servedBy": cd.fallback,
+		}
+		frame.Meta.Notices = []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Host %s served by fallback agent %s", cd.hostName, cd.fallback),
+		}}
+	}
+	if len(cd.segments) > 0 {
+		if frame.Meta.Custom == nil {
+			frame.Meta.Custom = map[string]interface{}{}
+		}
+		frame.Meta.Custom.(map[string]interface{})["segments"] = cd.segments
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Host %s: samples before %s served by the local retention store", cd.hostName, cd.segments[0].To.UTC().Format(time.RFC3339)),
+		})
+	}
+	if notice := d.clockSkewNotice(cd.hostName, cd.clockSkew); notice != nil {
+		frame.Meta.Notices = append(frame.Meta.Notices, *notice)
+	}
+	if notice := staleNotice(cd.hostName, cd.staleAge); notice != nil {
+		frame.Meta.Notices = append(frame.Meta.Notices, *notice)
+		frame.Fields = append(frame.Fields, ageField(cd.staleAge, len(times)))
+	}
 
 	return frame
 }
 
 // ContainerInfo for container list queries
 type ContainerInfo struct {
-	ContainerID   string `json:"containerId"`
-	ContainerName string `json:"containerName"`
-	State         string `json:"state"`
-	HealthStatus  string `json:"healthStatus"`
-	IsRunning     bool   `json:"isRunning"`
-	IsPaused      bool   `json:"isPaused"`
-	IsUnhealthy   bool   `json:"isUnhealthy"`
+	ContainerID   string            `json:"containerId"`
+	ContainerName string            `json:"containerName"`
+	State         string            `json:"state"`
+	HealthStatus  string            `json:"healthStatus"`
+	IsRunning     bool              `json:"isRunning"`
+	IsPaused      bool              `json:"isPaused"`
+	IsUnhealthy   bool              `json:"isUnhealthy"`
+	Image         string            `json:"image"`
+	Pod           string            `json:"pod"`
+	Namespace     string            `json:"namespace"` // containerd namespace
+	Labels        map[string]string `json:"labels"`
+	Networks      []string          `json:"networks"` // networks the container is attached to, empty for older agents
+	Links         []string          `json:"links"`    // names of the containers it links to with legacy --link
 }
 
 // AgentInfo represents information returned from /api/info endpoint
 type AgentInfo struct {
-	AgentVersion    string `json:"agentVersion"`
-	DockerVersion   string `json:"dockerVersion"`
-	DockerConnected bool   `json:"dockerConnected"`
-	PsiSupported    bool   `json:"psiSupported"`
+	Hostname         string             `json:"hostname"`
+	AgentVersion     string             `json:"agentVersion"`
+	DockerVersion    string             `json:"dockerVersion"`
+	DockerConnected  bool               `json:"dockerConnected"`
+	PsiSupported     bool               `json:"psiSupported"`
+	OS               string             `json:"os"`
+	KernelVersion    string             `json:"kernelVersion"`
+	Architecture     string             `json:"architecture"`
+	Runtime          string             `json:"runtime"`          // docker, podman, containerd; empty for older agents
+	Orchestrator     string             `json:"orchestrator"`     // ecs or nomad when running under an orchestrator
+	InstanceID       string             `json:"instanceId"`       // random per agent process, empty for older agents
+	CPUCount         int                `json:"cpuCount"`         // host CPU cores, 0 when not reported
+	MemoryTotalBytes int64              `json:"memoryTotalBytes"` // host memory, 0 when not reported
+	Capabilities     *AgentCapabilities `json:"capabilities"`
 }
 
 // queryContainers returns a list of containers for variable queries
 func (d *Datasource) queryContainers(ctx context.Context, qm QueryModel) backend.DataResponse {
 	var response backend.DataResponse
 
-	hosts := d.getEnabledHosts(qm.HostIDs)
+	if qm.Limit < 0 || qm.Offset < 0 {
+		response.Error = fmt.Errorf("limit and offset must not be negative")
+		return response
+	}
+	paged := qm.Limit > 0 || qm.Offset > 0
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
 	if len(hosts) == 0 {
 		response.Error = fmt.Errorf("no enabled hosts configured")
 		return response
 	}
 
 	// Collect containers from all hosts
-	containerIDs := make([]string, 0)
-	containerNames := make([]string, 0)
-	hostIDs := make([]string, 0)
-	hostNames := make([]string, 0)
-	states := make([]string, 0)
-	healthStatuses := make([]string, 0)
-	isRunningList := make([]bool, 0)
-	isPausedList := make([]bool, 0)
-	isUnhealthyList := make([]bool, 0)
-
+	type containerRow struct {
+		host HostConfig
+		c    ContainerInfo
+	}
+	rows := make([]containerRow, 0)
 	for _, host := range hosts {
 		containers, err := d.fetchContainersFromHost(ctx, host)
 		if err != nil {
@@ -959,17 +1532,58 @@ func (d *Datasource) queryContainers(ctx context.Context, qm QueryModel) backend
 			continue
 		}
 
+		hostRows := make([]containerRow, 0, len(containers))
 		for _, c := range containers {
-			containerIDs = append(containerIDs, c.ContainerID)
-			containerNames = append(containerNames, c.ContainerName)
-			hostIDs = append(hostIDs, host.ID)
-			hostNames = append(hostNames, host.Name)
-			states = append(states, c.State)
-			healthStatuses = append(healthStatuses, c.HealthStatus)
-			isRunningList = append(isRunningList, c.IsRunning)
-			isPausedList = append(isPausedList, c.IsPaused)
-			isUnhealthyList = append(isUnhealthyList, c.IsUnhealthy)
+			if len(qm.Namespaces) > 0 && !contains(qm.Namespaces, c.Namespace) {
+				continue
+			}
+			if !matchesLabelFilters(containerSeriesLabels(c), qm.LabelFilters) {
+				continue
+			}
+			hostRows = append(hostRows, containerRow{host: host, c: c})
 		}
+		if paged {
+			// Pages need an order that doesn't depend on the agent's listing order
+			sort.Slice(hostRows, func(i, j int) bool {
+				a, b := hostRows[i].c, hostRows[j].c
+				if a.ContainerName != b.ContainerName {
+					return a.ContainerName < b.ContainerName
+				}
+				return a.ContainerID < b.ContainerID
+			})
+		}
+		rows = append(rows, hostRows...)
+	}
+
+	total := len(rows)
+	rows = rows[min(qm.Offset, total):]
+	if qm.Limit > 0 && qm.Limit < len(rows) {
+		rows = rows[:qm.Limit]
+	}
+
+	containerIDs := make([]string, len(rows))
+	containerNames := make([]string, len(rows))
+	hostIDs := make([]string, len(rows))
+	hostNames := make([]string, len(rows))
+	states := make([]string, len(rows))
+	healthStatuses := make([]string, len(rows))
+	isRunningList := make([]bool, len(rows))
+	isPausedList := make([]bool, len(rows))
+	isUnhealthyList := make([]bool, len(rows))
+	pods := make([]string, len(rows))
+	namespaces := make([]string, len(rows))
+	for i, row := range rows {
+		containerIDs[i] = row.c.ContainerID
+		containerNames[i] = row.c.ContainerName
+		hostIDs[i] = row.host.ID
+		hostNames[i] = row.host.Name
+		states[i] = row.c.State
+		healthStatuses[i] = row.c.HealthStatus
+		isRunningList[i] = row.c.IsRunning
+		isPausedList[i] = row.c.IsPaused
+		isUnhealthyList[i] = row.c.IsUnhealthy
+		pods[i] = row.c.Pod
+		namespaces[i] = row.c.Namespace
 	}
 
 	// Build frame for variable query
@@ -983,14 +1597,26 @@ func (d *Datasource) queryContainers(ctx context.Context, qm QueryModel) backend
 		data.NewField("isRunning", nil, isRunningList),
 		data.NewField("isPaused", nil, isPausedList),
 		data.NewField("isUnhealthy", nil, isUnhealthyList),
+		data.NewField("pod", nil, pods),
+		data.NewField("namespace", nil, namespaces),
 	)
 
 	// Mark with custom metadata for identification
 	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
 		Custom: map[string]interface{}{
 			"queryType": "containers",
 		},
 	}
+	if paged {
+		// totalCount is the number of matching containers across all pages
+		frame.Meta.Custom = map[string]interface{}{
+			"queryType":  "containers",
+			"totalCount": total,
+			"offset":     qm.Offset,
+			"limit":      qm.Limit,
+		}
+	}
 
 	response.Frames = append(response.Frames, frame)
 	return response
@@ -998,41 +1624,68 @@ func (d *Datasource) queryContainers(ctx context.Context, qm QueryModel) backend
 
 // fetchContainersFromHost gets container list from a Docker agent
 func (d *Datasource) fetchContainersFromHost(ctx context.Context, host HostConfig) ([]ContainerInfo, error) {
-	targetURL := fmt.Sprintf("%s/api/containers?all=true", strings.TrimSuffix(host.URL, "/"))
-
-	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
-	if err != nil {
-		return nil, err
+	cache := containerCacheFrom(ctx)
+	if cache != nil {
+		cache.mu.Lock()
+		containers, ok := cache.entries[host.ID]
+		cache.mu.Unlock()
+		if ok {
+			return relabelContainers(host, d.excludeContainers(ctx, scopeContainers(ctx, host, containers))), nil
+		}
 	}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	var containers []ContainerInfo
+	switch host.Mode {
+	case HostModeCAdvisor:
+		list, err := d.fetchCAdvisorContainerList(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		containers = list
+	case HostModePrometheus:
+		list, err := d.fetchScrapedContainerList(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		containers = list
+	default:
+		resp, _, err := d.getWithRetry(ctx, host, "/api/containers?all=true")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
 
-	var containers []ContainerInfo
-	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
-		return nil, err
+		if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+			return nil, err
+		}
+		if err := validateContainers(host, containers); err != nil {
+			return nil, err
+		}
 	}
+	d.recordInventory(host, containers)
 
-	return containers, nil
+	if cache != nil {
+		cache.mu.Lock()
+		cache.entries[host.ID] = containers
+		cache.mu.Unlock()
+	}
+	return relabelContainers(host, d.excludeContainers(ctx, scopeContainers(ctx, host, containers))), nil
 }
 
 // fetchAgentInfoFromHost gets agent info from a Docker agent's /api/info endpoint
 func (d *Datasource) fetchAgentInfoFromHost(ctx context.Context, host HostConfig) (*AgentInfo, error) {
-	targetURL := fmt.Sprintf("%s/api/info", strings.TrimSuffix(host.URL, "/"))
-
-	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
-	if err != nil {
-		return nil, err
+	switch host.Mode {
+	case HostModeCAdvisor:
+		return d.fetchCAdvisorInfo(ctx, host)
+	case HostModePrometheus:
+		return scrapedHostInfo(host), nil
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, _, err := d.doHostRequest(ctx, host, http.MethodGet, "/api/info", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1046,15 +1699,22 @@ func (d *Datasource) fetchAgentInfoFromHost(ctx context.Context, host HostConfig
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
 		return nil, err
 	}
+	if err := validateAgentInfo(host, info); err != nil {
+		return nil, err
+	}
 
 	return &info, nil
 }
 
-// getEnabledHosts returns enabled hosts, optionally filtered by IDs
+// getEnabledHosts returns enabled hosts, optionally filtered by IDs. Hosts that reach the
+// same agent instance as an earlier host are skipped so their containers aren't counted twice.
 func (d *Datasource) getEnabledHosts(filterIDs []string) []HostConfig {
-	result := make([]HostConfig, 0)
-	for _, h := range d.settings.Hosts {
-		if !h.Enabled {
+	enabled := d.allEnabledHosts()
+	duplicates := d.duplicateAgents(enabled)
+
+	result := make([]HostConfig, 0, len(enabled))
+	for _, h := range enabled {
+		if _, ok := duplicates[h.ID]; ok {
 			continue
 		}
 		if len(filterIDs) > 0 && !contains(filterIDs, h.ID) {
@@ -1065,6 +1725,17 @@ func (d *Datasource) getEnabledHosts(filterIDs []string) []HostConfig {
 	return result
 }
 
+// allEnabledHosts returns enabled hosts including duplicate agents
+func (d *Datasource) allEnabledHosts() []HostConfig {
+	result := make([]HostConfig, 0)
+	for _, h := range d.hosts.all() {
+		if h.Enabled {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
 // CheckHealth performs a health check
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	hosts := d.getEnabledHosts(nil)
@@ -1072,115 +1743,214 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 	if len(hosts) == 0 {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: "No hosts configured. Add Docker Metrics Collector agents in the data source settings.",
+			Message: "No hosts configured. Add Docker Metrics Collector agents in the data source settings. Plugin " + BuildInfo(),
 		}, nil
 	}
 
-	// Test connectivity to each host
-	healthyHosts := 0
-	var lastError string
-
+	// Hosts under planned maintenance are not probed and never fail the health check
+	probed := make([]HostConfig, 0, len(hosts))
 	for _, host := range hosts {
-		targetURL := fmt.Sprintf("%s/api/info", strings.TrimSuffix(host.URL, "/"))
-		req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
-		if err != nil {
-			lastError = fmt.Sprintf("%s: %v", host.Name, err)
-			continue
-		}
-
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			lastError = fmt.Sprintf("%s: %v", host.Name, err)
-			continue
+		if !d.maintenance.active(host.ID) {
+			probed = append(probed, host)
 		}
+	}
+	maintenanceNote := ""
+	if inMaintenance := len(hosts) - len(probed); inMaintenance > 0 {
+		maintenanceNote = fmt.Sprintf(" (%d host(s) in maintenance)", inMaintenance)
+	}
+	hosts = probed
 
-		statusCode := resp.StatusCode
-		resp.Body.Close()
+	// Large fleets only probe a rotating sample of hosts per check
+	sampleNote := ""
+	if sample := d.healthSample(hosts); len(sample) < len(hosts) {
+		sampleNote = fmt.Sprintf(" (sampled %d of %d hosts)", len(sample), len(hosts))
+		hosts = sample
+	}
+	enabled := d.allEnabledHosts()
+	warningNote := settingsWarningNote(append(append([]string(nil), d.hostWarnings...), duplicateAgentWarnings(enabled, d.duplicateAgents(enabled))...))
+	versionNote := ". Plugin " + BuildInfo()
 
-		if statusCode == http.StatusOK {
+	// Test connectivity to all hosts in parallel within the health check budget
+	results := d.probeHosts(ctx, hosts)
+	details := healthDetails(results)
+	healthyHosts := 0
+	var firstError string
+	for _, r := range results {
+		if r.Healthy {
 			healthyHosts++
-		} else {
-			lastError = fmt.Sprintf("%s: status %d", host.Name, statusCode)
+		} else if firstError == "" {
+			firstError = fmt.Sprintf("%s: %s", r.HostName, r.Error)
 		}
 	}
 
 	if healthyHosts == len(hosts) {
 		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusOk,
-			Message: fmt.Sprintf("Connected to %d Docker Metrics Collector agent(s)", healthyHosts),
+			Status:      backend.HealthStatusOk,
+			Message:     fmt.Sprintf("Connected to %d Docker Metrics Collector agent(s)%s%s%s%s", healthyHosts, sampleNote, maintenanceNote, warningNote, versionNote),
+			JSONDetails: details,
 		}, nil
 	}
 
 	if healthyHosts > 0 {
 		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusOk,
-			Message: fmt.Sprintf("Connected to %d/%d hosts. Last error: %s", healthyHosts, len(hosts), lastError),
+			Status:      backend.HealthStatusOk,
+			Message:     fmt.Sprintf("Connected to %d/%d hosts%s%s. First error: %s%s%s", healthyHosts, len(hosts), sampleNote, maintenanceNote, firstError, warningNote, versionNote),
+			JSONDetails: details,
 		}, nil
 	}
 
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusError,
-		Message: fmt.Sprintf("Failed to connect to any host. Last error: %s", lastError),
+		Status:      backend.HealthStatusError,
+		Message:     fmt.Sprintf("Failed to connect to any host%s. First error: %s%s%s", sampleNote, firstError, warningNote, versionNote),
+		JSONDetails: details,
 	}, nil
 }
 
 // ValidControlActions lists all supported container control actions
-var ValidControlActions = []string{"start", "stop", "restart", "pause", "unpause"}
+var ValidControlActions = []string{"start", "stop", "restart", "pause", "unpause", "kill", "remove", "recreate"}
 
-// queryControl executes a container control action via the Docker agent
-func (d *Datasource) queryControl(ctx context.Context, qm QueryModel) backend.DataResponse {
-	var response backend.DataResponse
+// DestructiveControlActions are only allowed when AllowedControlActions lists them, an empty
+// list allows every other action
+var DestructiveControlActions = []string{"kill", "remove", "recreate"}
 
-	// Validate that controls are enabled in datasource settings
-	if !d.settings.EnableContainerControls {
-		response.Error = fmt.Errorf("container controls are disabled in datasource settings")
-		return response
+// controlActionAllowed checks an action against the datasource's allowed list
+func (d *Datasource) controlActionAllowed(action string) bool {
+	if len(d.settings.AllowedControlActions) == 0 {
+		return !contains(DestructiveControlActions, action)
 	}
+	return contains(d.settings.AllowedControlActions, action)
+}
 
+// controlParams returns the agent parameters of a control action
+func controlParams(step ControlStep) url.Values {
+	params := url.Values{}
+	if step.ControlAction == "remove" {
+		if step.Force {
+			params.Set("force", "true")
+		}
+		if step.RemoveVolumes {
+			params.Set("volumes", "true")
+		}
+	}
+	return params
+}
+
+// controlTarget validates an action and its target, returning the host to send it to
+func (d *Datasource) controlTarget(step ControlStep) (HostConfig, error) {
 	// Validate action is provided
-	if qm.ControlAction == "" {
-		response.Error = fmt.Errorf("controlAction is required")
-		return response
+	if step.ControlAction == "" {
+		return HostConfig{}, fmt.Errorf("controlAction is required")
 	}
 
 	// Validate action is in the global valid list
-	if !contains(ValidControlActions, qm.ControlAction) {
-		response.Error = fmt.Errorf("invalid control action: %s", qm.ControlAction)
-		return response
+	if !contains(ValidControlActions, step.ControlAction) {
+		return HostConfig{}, fmt.Errorf("invalid control action: %s", step.ControlAction)
 	}
 
 	// Validate action is in the datasource's allowed list
-	if len(d.settings.AllowedControlActions) > 0 && !contains(d.settings.AllowedControlActions, qm.ControlAction) {
-		response.Error = fmt.Errorf("action '%s' is not allowed by datasource settings", qm.ControlAction)
-		return response
+	if !d.controlActionAllowed(step.ControlAction) {
+		return HostConfig{}, fmt.Errorf("action '%s' is not allowed by datasource settings", step.ControlAction)
 	}
 
 	// Validate target container and host are provided
-	if qm.TargetContainer == "" {
-		response.Error = fmt.Errorf("targetContainer is required")
-		return response
+	if step.TargetContainer == "" {
+		return HostConfig{}, fmt.Errorf("targetContainer is required")
 	}
-	if qm.TargetHost == "" {
-		response.Error = fmt.Errorf("targetHost is required")
-		return response
+	if step.TargetHost == "" {
+		return HostConfig{}, fmt.Errorf("targetHost is required")
 	}
 
 	// Find the host by ID
-	var targetHost *HostConfig
-	for _, h := range d.settings.Hosts {
-		if h.ID == qm.TargetHost && h.Enabled {
-			targetHost = &h
-			break
+	targetHost, ok := d.hosts.find(step.TargetHost)
+	if !ok || !targetHost.Enabled {
+		return HostConfig{}, fmt.Errorf("host '%s' not found or not enabled", step.TargetHost)
+	}
+	if targetHost.Mode != HostModeAgent {
+		return HostConfig{}, fmt.Errorf("host '%s' is a %s endpoint and does not support container controls", targetHost.Name, targetHost.Mode)
+	}
+	return targetHost, nil
+}
+
+// controlsAllowed refuses control actions on read-only datasources and when controls are off
+func (d *Datasource) controlsAllowed() error {
+	if d.settings.ReadOnly {
+		return errReadOnly
+	}
+	if !d.settings.EnableContainerControls {
+		return fmt.Errorf("container controls are disabled in datasource settings")
+	}
+	return nil
+}
+
+// queryControl executes a container control action via the Docker agent
+func (d *Datasource) queryControl(ctx context.Context, user *backend.User, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	if len(qm.ControlSteps) > 0 {
+		return d.queryControlSequence(ctx, user, qm)
+	}
+
+	// Every attempt is audited, refused ones included
+	entry := AuditEntry{Time: time.Now(), Action: qm.ControlAction, ContainerID: qm.TargetContainer, HostID: qm.TargetHost}
+	if user != nil {
+		entry.User, entry.Role = user.Login, user.Role
+	}
+	executed := false
+	defer func() {
+		switch {
+		case response.Error == nil:
+			entry.Result = "success"
+		case executed:
+			entry.Result = "failed"
+		default:
+			entry.Result = "refused"
 		}
+		if response.Error != nil {
+			entry.Error = response.Error.Error()
+		}
+		d.recordAudit(entry)
+	}()
+
+	if err := d.controlsAllowed(); err != nil {
+		response.Error = err
+		return response
+	}
+
+	// Validate the user's role and teams
+	if err := d.authorizeControl(ctx, user); err != nil {
+		d.logger.Warn("Refused control action",
+			"action", qm.ControlAction,
+			"container", qm.TargetContainer,
+			"host", qm.TargetHost,
+			"error", err,
+		)
+		response.Error = err
+		return response
 	}
 
-	if targetHost == nil {
-		response.Error = fmt.Errorf("host '%s' not found or not enabled", qm.TargetHost)
+	step := ControlStep{
+		ControlAction:   qm.ControlAction,
+		TargetContainer: qm.TargetContainer,
+		TargetHost:      qm.TargetHost,
+		Force:           qm.Force,
+		RemoveVolumes:   qm.RemoveVolumes,
+	}
+	targetHost, err := d.controlTarget(step)
+	if err != nil {
+		response.Error = err
 		return response
 	}
+	entry.HostName = targetHost.Name
 
 	// Execute the control action
-	result, err := d.executeControlAction(ctx, *targetHost, qm.TargetContainer, qm.ControlAction)
+	actionCtx, done, ok := d.actions.start(ctx, describeAction(targetHost, qm.TargetContainer, qm.ControlAction))
+	if !ok {
+		response.Error = fmt.Errorf("datasource is shutting down, control action not started")
+		return response
+	}
+	executed = true
+	result, err := d.executeControlAction(actionCtx, targetHost, qm.TargetContainer, qm.ControlAction, controlParams(step))
+	done(d.logger, err)
 	if err != nil {
 		d.logger.Error("Control action failed",
 			"action", qm.ControlAction,
@@ -1197,7 +1967,9 @@ func (d *Datasource) queryControl(ctx context.Context, qm QueryModel) backend.Da
 		"container", qm.TargetContainer,
 		"host", targetHost.Name,
 		"success", result.Success,
+		"newContainer", result.NewContainerID,
 	)
+	entry.NewContainerID = result.NewContainerID
 
 	// Return result as DataFrame
 	frame := data.NewFrame("control_result",
@@ -1206,8 +1978,13 @@ func (d *Datasource) queryControl(ctx context.Context, qm QueryModel) backend.Da
 		data.NewField("containerId", nil, []string{result.ContainerID}),
 		data.NewField("error", nil, []string{result.Error}),
 	)
+	if result.NewContainerID != "" {
+		// recreate replaces the container, panels follow it by the new ID
+		frame.Fields = append(frame.Fields, data.NewField("newContainerId", nil, []string{result.NewContainerID}))
+	}
 
 	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
 		Custom: map[string]interface{}{
 			"queryType": "control",
 		},
@@ -1223,26 +2000,22 @@ type ControlActionResult struct {
 	Action      string `json:"action"`
 	ContainerID string `json:"containerId"`
 	Error       string `json:"error"`
+	// NewContainerID is the ID of the container a recreate created
+	NewContainerID string `json:"newContainerId,omitempty"`
 }
 
 // executeControlAction sends a control action request to the Docker agent
-func (d *Datasource) executeControlAction(ctx context.Context, host HostConfig, containerID, action string) (*ControlActionResult, error) {
-	targetURL := fmt.Sprintf("%s/api/containers/%s/%s",
-		strings.TrimSuffix(host.URL, "/"),
-		url.PathEscape(containerID),
-		action,
-	)
-
-	d.logger.Debug("Executing control action", "url", targetURL, "action", action)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+func (d *Datasource) executeControlAction(ctx context.Context, host HostConfig, containerID, action string, params url.Values) (*ControlActionResult, error) {
+	path := fmt.Sprintf("/api/containers/%s/%s", url.PathEscape(containerID), action)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
 	}
 
-	resp, err := httpClient.Do(req)
+	d.logger.Debug("Executing control action", "host", host.Name, "path", path, "action", action)
+
+	resp, _, err := d.doHostRequest(ctx, host, http.MethodPost, path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -1272,8 +2045,6 @@ func contains(slice []string, item string) bool {
 
 func sortMetricsByTime(metrics []ContainerMetric) {
 	sort.Slice(metrics, func(i, j int) bool {
-		t1, _ := time.Parse(time.RFC3339, metrics[i].Timestamp)
-		t2, _ := time.Parse(time.RFC3339, metrics[j].Timestamp)
-		return t1.Before(t2)
+		return metrics[i].Timestamp.Before(metrics[j].Timestamp.Time)
 	})
 }
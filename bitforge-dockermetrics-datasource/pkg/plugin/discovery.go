@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultDiscoveryInterval is used when the settings do not specify one
+const defaultDiscoveryInterval = 60 * time.Second
+
+// DiscoverySettings configures automatic maintenance of the host list
+type DiscoverySettings struct {
+	Mode            string `json:"mode"`            // "" (disabled) | "dns-srv" | "swarm" | "consul" | "etcd"
+	IntervalSeconds int    `json:"intervalSeconds"` // how often to re-run discovery
+	Scheme          string `json:"scheme"`          // scheme used to build agent URLs, default "http"
+
+	// DNS SRV discovery
+	SRVRecord string `json:"srvRecord"` // e.g. _dockermetrics._tcp.example.com
+
+	// Swarm node discovery
+	SwarmManagerURL      string `json:"swarmManagerUrl"`      // agent running on a Swarm manager
+	SwarmNodeURLTemplate string `json:"swarmNodeUrlTemplate"` // e.g. http://{addr}:5000
+
+	// Consul service discovery (ACL token is read from secureJsonData.consulToken)
+	ConsulAddress    string `json:"consulAddress"`
+	ConsulService    string `json:"consulService"`
+	ConsulDatacenter string `json:"consulDatacenter"`
+	ConsulTag        string `json:"consulTag"`
+
+	// etcd prefix discovery (password is read from secureJsonData.etcdPassword)
+	EtcdEndpoint string `json:"etcdEndpoint"`
+	EtcdPrefix   string `json:"etcdPrefix"`
+	EtcdUsername string `json:"etcdUsername"`
+
+	// Subnet scan (resource-triggered only, results are proposals and never auto-enabled)
+	ScanCIDR string `json:"scanCidr"`
+	ScanPort int    `json:"scanPort"`
+}
+
+// discoverer resolves the current set of agent hosts from an external source
+type discoverer interface {
+	name() string
+	discover(ctx context.Context) ([]HostConfig, error)
+}
+
+// newDiscoverer builds the discoverer configured in settings, or nil when discovery is disabled
+func newDiscoverer(s DiscoverySettings, secrets map[string]string) (discoverer, error) {
+	switch s.Mode {
+	case "":
+		return nil, nil
+	case "dns-srv":
+		if s.SRVRecord == "" {
+			return nil, fmt.Errorf("discovery mode dns-srv requires srvRecord")
+		}
+		return &dnsSRVDiscoverer{record: s.SRVRecord, scheme: s.scheme()}, nil
+	case "swarm":
+		if s.SwarmManagerURL == "" {
+			return nil, fmt.Errorf("discovery mode swarm requires swarmManagerUrl")
+		}
+		return &swarmDiscoverer{managerURL: s.SwarmManagerURL, urlTemplate: s.SwarmNodeURLTemplate}, nil
+	case "consul":
+		if s.ConsulAddress == "" || s.ConsulService == "" {
+			return nil, fmt.Errorf("discovery mode consul requires consulAddress and consulService")
+		}
+		return &consulDiscoverer{
+			address:    s.ConsulAddress,
+			service:    s.ConsulService,
+			datacenter: s.ConsulDatacenter,
+			tag:        s.ConsulTag,
+			token:      secrets["consulToken"],
+			scheme:     s.scheme(),
+		}, nil
+	case "etcd":
+		if s.EtcdEndpoint == "" || s.EtcdPrefix == "" {
+			return nil, fmt.Errorf("discovery mode etcd requires etcdEndpoint and etcdPrefix")
+		}
+		return &etcdDiscoverer{
+			endpoint: s.EtcdEndpoint,
+			prefix:   s.EtcdPrefix,
+			username: s.EtcdUsername,
+			password: secrets["etcdPassword"],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s", s.Mode)
+	}
+}
+
+func (s DiscoverySettings) interval() time.Duration {
+	if s.IntervalSeconds <= 0 {
+		return defaultDiscoveryInterval
+	}
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+func (s DiscoverySettings) scheme() string {
+	if s.Scheme == "" {
+		return "http"
+	}
+	return s.Scheme
+}
+
+// startDiscovery launches the background discovery loop if discovery is configured
+func (d *Datasource) startDiscovery() error {
+	if !d.featureEnabled(FeatureDiscovery) {
+		if d.settings.Discovery.Mode != "" {
+			d.logger.Info("Host discovery is configured but disabled by feature toggle", "mode", d.settings.Discovery.Mode)
+		}
+		return nil
+	}
+	disc, err := newDiscoverer(d.settings.Discovery, d.secrets)
+	if err != nil || disc == nil {
+		return err
+	}
+
+	interval := d.settings.Discovery.interval()
+	d.logger.Info("Starting host discovery", "source", disc.name(), "interval", interval)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.runDiscoverer(disc, interval)
+	}()
+	return nil
+}
+
+// runDiscoverer refreshes discovered hosts until the datasource is disposed
+func (d *Datasource) runDiscoverer(disc discoverer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.refreshDiscovered(disc)
+
+		select {
+		case <-d.bgCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshDiscovered runs one discovery pass. Failed passes keep the previous host list.
+func (d *Datasource) refreshDiscovered(disc discoverer) {
+	ctx, cancel := context.WithTimeout(d.bgCtx, 30*time.Second)
+	defer cancel()
+
+	hosts, err := disc.discover(ctx)
+	if err != nil {
+		d.logger.Warn("Host discovery failed", "source", disc.name(), "error", err)
+		return
+	}
+
+	added, removed := d.hosts.setDiscovered(disc.name(), hosts)
+	if added > 0 || removed > 0 {
+		d.logger.Info("Discovered hosts changed",
+			"source", disc.name(),
+			"added", added,
+			"removed", removed,
+			"total", len(hosts),
+		)
+	}
+}
+
+var hostIDUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// discoveredHostID builds a stable host ID from the discovery source and address
+func discoveredHostID(source, address string) string {
+	return source + "-" + strings.Trim(hostIDUnsafeChars.ReplaceAllString(address, "-"), "-")
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// dnsSRVDiscoverer resolves agents from a DNS SRV record
+type dnsSRVDiscoverer struct {
+	record   string
+	scheme   string
+	resolver *net.Resolver // nil for the system resolver
+}
+
+func (s *dnsSRVDiscoverer) name() string {
+	return "dns"
+}
+
+func (s *dnsSRVDiscoverer) discover(ctx context.Context) ([]HostConfig, error) {
+	resolver := s.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, "", "", s.record)
+	if err != nil {
+		return nil, fmt.Errorf("SRV lookup for %s failed: %w", s.record, err)
+	}
+
+	hosts := make([]HostConfig, 0, len(records))
+	for _, rec := range records {
+		target := strings.TrimSuffix(rec.Target, ".")
+		address := net.JoinHostPort(target, strconv.Itoa(int(rec.Port)))
+		hosts = append(hosts, HostConfig{
+			ID:      discoveredHostID(s.name(), address),
+			Name:    target,
+			URL:     fmt.Sprintf("%s://%s", s.scheme, address),
+			Enabled: true,
+		})
+	}
+
+	return hosts, nil
+}
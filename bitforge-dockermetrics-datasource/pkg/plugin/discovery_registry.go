@@ -0,0 +1,223 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// consulServiceEntry is an entry from Consul's /v1/health/service/{service} endpoint
+type consulServiceEntry struct {
+	Node struct {
+		Node       string `json:"Node"`
+		Address    string `json:"Address"`
+		Datacenter string `json:"Datacenter"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Service string            `json:"Service"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Tags    []string          `json:"Tags"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+}
+
+// consulDiscoverer resolves agents registered as a Consul service
+type consulDiscoverer struct {
+	address    string
+	service    string
+	datacenter string
+	tag        string
+	token      string
+	scheme     string
+}
+
+func (c *consulDiscoverer) name() string {
+	return "consul"
+}
+
+func (c *consulDiscoverer) discover(ctx context.Context) ([]HostConfig, error) {
+	params := url.Values{}
+	params.Set("passing", "true")
+	if c.datacenter != "" {
+		params.Set("dc", c.datacenter)
+	}
+	if c.tag != "" {
+		params.Set("tag", c.tag)
+	}
+
+	targetURL := fmt.Sprintf("%s/v1/health/service/%s?%s",
+		strings.TrimSuffix(c.address, "/"),
+		url.PathEscape(c.service),
+		params.Encode(),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	hosts := make([]HostConfig, 0, len(entries))
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		address := net.JoinHostPort(addr, strconv.Itoa(e.Service.Port))
+
+		labels := map[string]string{
+			"datacenter": e.Node.Datacenter,
+			"node":       e.Node.Node,
+		}
+		if len(e.Service.Tags) > 0 {
+			labels["tags"] = strings.Join(e.Service.Tags, ",")
+		}
+		for k, v := range e.Service.Meta {
+			labels[k] = v
+		}
+
+		hosts = append(hosts, HostConfig{
+			ID:      discoveredHostID(c.name(), e.Service.ID),
+			Name:    e.Node.Node,
+			URL:     fmt.Sprintf("%s://%s", c.scheme, address),
+			Enabled: true,
+			Labels:  labels,
+		})
+	}
+
+	return hosts, nil
+}
+
+// etcdHostEntry is the JSON value stored under the etcd prefix for each agent.
+// Plain URL strings are accepted as well.
+type etcdHostEntry struct {
+	Name   string            `json:"name"`
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels"`
+}
+
+// etcdDiscoverer resolves agents listed under a key prefix via the etcd v3 JSON gateway
+type etcdDiscoverer struct {
+	endpoint string
+	prefix   string
+	username string
+	password string
+}
+
+func (e *etcdDiscoverer) name() string {
+	return "etcd"
+}
+
+func (e *etcdDiscoverer) discover(ctx context.Context) ([]HostConfig, error) {
+	body, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(e.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(etcdPrefixEnd(e.prefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	targetURL := fmt.Sprintf("%s/v3/kv/range", strings.TrimSuffix(e.endpoint, "/"))
+	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var rangeResp struct {
+		Kvs []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rangeResp); err != nil {
+		return nil, fmt.Errorf("failed to decode etcd response: %w", err)
+	}
+
+	hosts := make([]HostConfig, 0, len(rangeResp.Kvs))
+	for _, kv := range rangeResp.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			continue
+		}
+
+		entry := etcdHostEntry{}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			entry.URL = strings.TrimSpace(string(value))
+		}
+		if entry.URL == "" {
+			continue
+		}
+
+		keySuffix := strings.TrimPrefix(string(key), e.prefix)
+		if entry.Name == "" {
+			entry.Name = strings.Trim(keySuffix, "/")
+		}
+
+		hosts = append(hosts, HostConfig{
+			ID:      discoveredHostID(e.name(), keySuffix),
+			Name:    entry.Name,
+			URL:     entry.URL,
+			Enabled: true,
+			Labels:  entry.Labels,
+		})
+	}
+
+	return hosts, nil
+}
+
+// etcdPrefixEnd returns the range end that matches every key with the given prefix
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Prefix is all 0xff bytes: range to the end of the keyspace
+	return []byte{0}
+}
@@ -0,0 +1,218 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxScanAddresses bounds scans to a /22 so a typo can't probe a whole /8
+	maxScanAddresses = 1024
+	scanConcurrency  = 32
+	scanProbeTimeout = 2 * time.Second
+	defaultScanPort  = 5000
+)
+
+// scanClient uses a short timeout since most probed addresses won't answer. It never goes
+// through a proxy: scanned addresses are on the local network.
+var scanClient = &http.Client{
+	Timeout:   scanProbeTimeout,
+	Transport: &http.Transport{MaxIdleConnsPerHost: 1},
+}
+
+// ScanResult is returned by the discovery/scan resource
+type ScanResult struct {
+	CIDR      string       `json:"cidr"`
+	Port      int          `json:"port"`
+	Scanned   int          `json:"scanned"`
+	Proposals []HostConfig `json:"proposals"`
+}
+
+// scanAddresses expands a CIDR into host addresses, skipping network and broadcast addresses for IPv4
+func scanAddresses(cidr string) ([]net.IP, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 10 {
+		return nil, fmt.Errorf("CIDR %s is too large to scan (max %d addresses)", cidr, maxScanAddresses)
+	}
+
+	addresses := make([]net.IP, 0)
+	for cur := ip.Mask(ipNet.Mask); ipNet.Contains(cur); cur = nextIP(cur) {
+		addresses = append(addresses, cur)
+	}
+
+	if ip.To4() != nil && len(addresses) > 2 {
+		addresses = addresses[1 : len(addresses)-1]
+	}
+	return addresses, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// scanSubnet probes every address in the CIDR for an agent and proposes the ones that
+// respond and are not configured yet. Proposals are always disabled.
+func (d *Datasource) scanSubnet(ctx context.Context, cidr string, port int, scheme string) (*ScanResult, error) {
+	addresses, err := scanAddresses(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, h := range d.hosts.all() {
+		known[normalizeHostURL(h.URL)] = true
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		proposals = make([]HostConfig, 0)
+		sem       = make(chan struct{}, scanConcurrency)
+	)
+
+	for _, addr := range addresses {
+		address := net.JoinHostPort(addr.String(), strconv.Itoa(port))
+		baseURL := fmt.Sprintf("%s://%s", scheme, address)
+		if known[normalizeHostURL(baseURL)] {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(address, baseURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := probeAgent(ctx, baseURL)
+			if err != nil {
+				return
+			}
+
+			name := info.Hostname
+			if name == "" {
+				name = address
+			}
+
+			mu.Lock()
+			proposals = append(proposals, HostConfig{
+				ID:      discoveredHostID("scan", address),
+				Name:    name,
+				URL:     baseURL,
+				Enabled: false,
+			})
+			mu.Unlock()
+		}(address, baseURL)
+	}
+	wg.Wait()
+
+	sortHosts(proposals)
+
+	d.logger.Info("Subnet scan finished",
+		"cidr", cidr,
+		"port", port,
+		"scanned", len(addresses),
+		"found", len(proposals),
+	)
+
+	return &ScanResult{
+		CIDR:      cidr,
+		Port:      port,
+		Scanned:   len(addresses),
+		Proposals: proposals,
+	}, nil
+}
+
+// probeAgent checks whether a Docker Metrics agent answers at baseURL
+func probeAgent(ctx context.Context, baseURL string) (*AgentInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := scanClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var info AgentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	// Anything that answers /api/info without an agent version is not our agent
+	if info.AgentVersion == "" {
+		return nil, fmt.Errorf("not a Docker Metrics agent")
+	}
+	return &info, nil
+}
+
+// handleDiscoveryScan runs a subnet scan over the configured (or requested) CIDR
+func (d *Datasource) handleDiscoveryScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !isAdminRequest(r) {
+		writeError(w, http.StatusForbidden, "subnet scan requires the Admin role")
+		return
+	}
+
+	cidr := d.settings.Discovery.ScanCIDR
+	port := d.settings.Discovery.ScanPort
+
+	// The config editor may scan a CIDR before it is saved in settings
+	var body struct {
+		CIDR string `json:"cidr"`
+		Port int    `json:"port"`
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+	}
+	if body.CIDR != "" {
+		cidr = body.CIDR
+	}
+	if body.Port > 0 {
+		port = body.Port
+	}
+	if cidr == "" {
+		writeError(w, http.StatusBadRequest, "no CIDR configured for subnet scan")
+		return
+	}
+	if port <= 0 {
+		port = defaultScanPort
+	}
+
+	result, err := d.scanSubnet(r.Context(), cidr, port, d.settings.Discovery.scheme())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultSwarmNodeURLTemplate builds per-node agent URLs when none is configured
+const defaultSwarmNodeURLTemplate = "http://{addr}:5000"
+
+// SwarmNode is a node entry returned by the manager agent's /api/swarm/nodes endpoint
+type SwarmNode struct {
+	ID           string `json:"id"`
+	Hostname     string `json:"hostname"`
+	Addr         string `json:"addr"`
+	Role         string `json:"role"`
+	State        string `json:"state"`
+	Availability string `json:"availability"`
+}
+
+// swarmDiscoverer asks an agent on a Swarm manager for the node list and
+// derives one agent host per ready node from a URL template
+type swarmDiscoverer struct {
+	managerURL  string
+	urlTemplate string
+}
+
+func (s *swarmDiscoverer) name() string {
+	return "swarm"
+}
+
+func (s *swarmDiscoverer) discover(ctx context.Context) ([]HostConfig, error) {
+	targetURL := fmt.Sprintf("%s/api/swarm/nodes", strings.TrimSuffix(s.managerURL, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var nodes []SwarmNode
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode node list: %w", err)
+	}
+
+	hosts := make([]HostConfig, 0, len(nodes))
+	for _, node := range nodes {
+		// Down and drained nodes have no running agent to talk to
+		if !strings.EqualFold(node.State, "ready") || strings.EqualFold(node.Availability, "drain") {
+			continue
+		}
+
+		name := node.Hostname
+		if name == "" {
+			name = node.ID
+		}
+
+		hosts = append(hosts, HostConfig{
+			ID:      discoveredHostID(s.name(), node.ID),
+			Name:    name,
+			URL:     expandSwarmNodeURL(s.urlTemplate, node),
+			Enabled: true,
+		})
+	}
+
+	return hosts, nil
+}
+
+// expandSwarmNodeURL substitutes {id}, {hostname} and {addr} placeholders
+func expandSwarmNodeURL(template string, node SwarmNode) string {
+	if template == "" {
+		template = defaultSwarmNodeURLTemplate
+	}
+	return strings.NewReplacer(
+		"{id}", node.ID,
+		"{hostname}", node.Hostname,
+		"{addr}", node.Addr,
+	).Replace(template)
+}
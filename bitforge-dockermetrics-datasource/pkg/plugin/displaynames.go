@@ -0,0 +1,42 @@
+package plugin
+
+import "strings"
+
+// defaultDuplicateNamePattern names series of containers whose name exists on several hosts
+const defaultDuplicateNamePattern = "{container} ({host})"
+
+// duplicateNamePattern returns the configured pattern for duplicate container names
+func (d *Datasource) duplicateNamePattern() string {
+	if d.settings.DuplicateNamePattern != "" {
+		return d.settings.DuplicateNamePattern
+	}
+	return defaultDuplicateNamePattern
+}
+
+// assignSeriesNames sets the name each container's series are displayed with. Containers
+// whose name is unique keep it; names found on more than one host are expanded with the
+// duplicate name pattern ({container}, {host}, {hostId}) and get a hostId label, so neither
+// legends nor label sets collide.
+func (d *Datasource) assignSeriesNames(byContainer map[containerKey]*containerData) {
+	hostsByName := make(map[string]map[string]bool)
+	for key, cd := range byContainer {
+		if hostsByName[cd.containerName] == nil {
+			hostsByName[cd.containerName] = make(map[string]bool)
+		}
+		hostsByName[cd.containerName][key.hostID] = true
+	}
+
+	pattern := d.duplicateNamePattern()
+	for key, cd := range byContainer {
+		cd.seriesName = cd.containerName
+		if len(hostsByName[cd.containerName]) < 2 {
+			continue
+		}
+		cd.seriesName = strings.NewReplacer(
+			"{container}", cd.containerName,
+			"{host}", cd.hostName,
+			"{hostId}", key.hostID,
+		).Replace(pattern)
+		cd.duplicateName = true
+	}
+}
@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Downsampling modes for QueryModel.Downsample
+const (
+	DownsampleAvg  = "avg" // default: mean of each time bucket
+	DownsampleMax  = "max" // maximum of each time bucket, keeps spikes visible
+	DownsampleLTTB = "lttb"
+	DownsampleNone = "none"
+)
+
+// downsampling caps the points of each series to what the panel can draw
+type downsampling struct {
+	mode      string
+	maxPoints int           // query.MaxDataPoints, 0 for no cap
+	interval  time.Duration // query.Interval, the narrowest useful point spacing
+}
+
+// validateDownsample checks the query's downsampling mode
+func validateDownsample(qm QueryModel) error {
+	switch qm.Downsample {
+	case "", DownsampleAvg, DownsampleMax, DownsampleLTTB, DownsampleNone:
+		return nil
+	}
+	return fmt.Errorf("downsample must be avg, max, lttb or none")
+}
+
+// downsamplingFor returns the downsampling of a validated query
+func downsamplingFor(query backend.DataQuery, qm QueryModel) downsampling {
+	mode := qm.Downsample
+	if mode == "" {
+		mode = DownsampleAvg
+	}
+	return downsampling{mode: mode, maxPoints: int(query.MaxDataPoints), interval: query.Interval}
+}
+
+// target returns how many points a series of n samples spanning span should keep
+func (s downsampling) target(n int, span time.Duration) int {
+	target := n
+	if s.maxPoints > 0 && s.maxPoints < target {
+		target = s.maxPoints
+	}
+	if s.interval > 0 {
+		if byInterval := int(span/s.interval) + 1; byInterval < target {
+			target = byInterval
+		}
+	}
+	return max(target, 1)
+}
+
+// apply downsamples one series; times are sorted and values may be null
+func (s downsampling) apply(times []time.Time, values []*float64) ([]time.Time, []*float64) {
+	if s.mode == DownsampleNone || len(times) < 3 {
+		return times, values
+	}
+	span := times[len(times)-1].Sub(times[0])
+	target := s.target(len(times), span)
+	if target >= len(times) {
+		return times, values
+	}
+	if s.mode == DownsampleLTTB {
+		return lttb(times, values, target)
+	}
+	return bucketSeries(times, values, span, target, s.mode == DownsampleMax)
+}
+
+// bucketSeries merges samples into at most target buckets aligned to multiples of the bucket
+// width, so points stay put between refreshes. Buckets without values are null.
+func bucketSeries(times []time.Time, values []*float64, span time.Duration, target int, useMax bool) ([]time.Time, []*float64) {
+	// Aligned buckets can straddle both ends of the span, hence target-1
+	width := span / time.Duration(max(target-1, 1))
+	width = (width + time.Millisecond - 1).Truncate(time.Millisecond)
+	if width <= 0 {
+		return times, values
+	}
+	outTimes := make([]time.Time, 0, target+1)
+	outValues := make([]*float64, 0, target+1)
+
+	var sum, peak float64
+	count := 0
+	flush := func(start time.Time) {
+		outTimes = append(outTimes, start)
+		if count == 0 {
+			outValues = append(outValues, nil)
+			return
+		}
+		v := sum / float64(count)
+		if useMax {
+			v = peak
+		}
+		outValues = append(outValues, &v)
+	}
+
+	bucket := times[0].Truncate(width)
+	for i, t := range times {
+		if start := t.Truncate(width); !start.Equal(bucket) {
+			flush(bucket)
+			bucket, sum, peak, count = start, 0, math.Inf(-1), 0
+		}
+		if values[i] == nil {
+			continue
+		}
+		if count == 0 {
+			peak = *values[i]
+		}
+		sum += *values[i]
+		peak = math.Max(peak, *values[i])
+		count++
+	}
+	flush(bucket)
+	return outTimes, outValues
+}
+
+// lttb keeps target points with the largest-triangle-three-buckets algorithm, which keeps
+// the shape of the series. Null samples don't compete for points, but kept points with nulls
+// between them get one null back so gaps aren't bridged.
+func lttb(times []time.Time, values []*float64, target int) ([]time.Time, []*float64) {
+	xs := make([]float64, 0, len(times))
+	ys := make([]*float64, 0, len(values))
+	ts := make([]time.Time, 0, len(times))
+	var nulls []time.Time
+	for i, v := range values {
+		if v != nil {
+			ts = append(ts, times[i])
+			xs = append(xs, float64(times[i].UnixMilli()))
+			ys = append(ys, v)
+		} else {
+			nulls = append(nulls, times[i])
+		}
+	}
+	last := len(ts) - 1
+	switch {
+	case len(ts) <= target:
+		return restoreGaps(ts, ys, nulls)
+	case target == 1:
+		return ts[last:], ys[last:]
+	case target == 2:
+		return restoreGaps([]time.Time{ts[0], ts[last]}, []*float64{ys[0], ys[last]}, nulls)
+	}
+
+	outTimes := make([]time.Time, 0, target)
+	outValues := make([]*float64, 0, target)
+	outTimes, outValues = append(outTimes, ts[0]), append(outValues, ys[0])
+
+	every := float64(len(ts)-2) / float64(target-2)
+	a := 0
+	for i := 0; i < target-2; i++ {
+		// Average of the next bucket is the third triangle point
+		nextStart := int(float64(i+1)*every) + 1
+		nextEnd := min(int(float64(i+2)*every)+1, len(ts))
+		var avgX, avgY float64
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += xs[j]
+			avgY += *ys[j]
+		}
+		if n := float64(nextEnd - nextStart); n > 0 {
+			avgX /= n
+			avgY /= n
+		}
+
+		start := int(float64(i)*every) + 1
+		end := int(float64(i+1)*every) + 1
+		best, bestArea := start, -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((xs[a]-avgX)*(*ys[j]-*ys[a]) - (xs[a]-xs[j])*(avgY-*ys[a]))
+			if area > bestArea {
+				best, bestArea = j, area
+			}
+		}
+		outTimes, outValues = append(outTimes, ts[best]), append(outValues, ys[best])
+		a = best
+	}
+
+	return restoreGaps(append(outTimes, ts[last]), append(outValues, ys[last]), nulls)
+}
+
+// restoreGaps inserts the first of the sorted null times between each pair of kept points
+// that has any
+func restoreGaps(times []time.Time, values []*float64, nulls []time.Time) ([]time.Time, []*float64) {
+	if len(nulls) == 0 || len(times) < 2 {
+		return times, values
+	}
+	outTimes := make([]time.Time, 0, len(times)+len(nulls))
+	outValues := make([]*float64, 0, len(values)+len(nulls))
+	n := 0
+	for i, t := range times {
+		if i > 0 {
+			for n < len(nulls) && !nulls[n].After(times[i-1]) {
+				n++
+			}
+			if n < len(nulls) && nulls[n].Before(t) {
+				outTimes = append(outTimes, nulls[n])
+				outValues = append(outValues, nil)
+			}
+		}
+		outTimes = append(outTimes, t)
+		outValues = append(outValues, values[i])
+	}
+	return outTimes, outValues
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Load balancing strategies for hosts with multiple agent replicas
+const (
+	LoadBalanceRoundRobin    = "round-robin"
+	LoadBalanceLeastFailures = "least-failures"
+)
+
+// endpointTracker keeps per-host round-robin positions and per-URL failure counts
+type endpointTracker struct {
+	mu       sync.Mutex
+	next     map[string]int // host ID -> next round-robin offset
+	failures map[string]int // normalized URL -> consecutive failures
+}
+
+func newEndpointTracker() *endpointTracker {
+	return &endpointTracker{
+		next:     make(map[string]int),
+		failures: make(map[string]int),
+	}
+}
+
+func (t *endpointTracker) recordSuccess(baseURL string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, normalizeHostURL(baseURL))
+}
+
+func (t *endpointTracker) recordFailure(baseURL string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures[normalizeHostURL(baseURL)]++
+}
+
+// order arranges replica URLs for a read request according to the strategy
+func (t *endpointTracker) order(hostID, strategy string, replicas []string) []string {
+	if len(replicas) < 2 {
+		return replicas
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ordered := make([]string, len(replicas))
+	switch strategy {
+	case LoadBalanceLeastFailures:
+		copy(ordered, replicas)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return t.failures[normalizeHostURL(ordered[i])] < t.failures[normalizeHostURL(ordered[j])]
+		})
+	default:
+		offset := t.next[hostID] % len(replicas)
+		t.next[hostID] = offset + 1
+		for i := range replicas {
+			ordered[i] = replicas[(offset+i)%len(replicas)]
+		}
+	}
+	return ordered
+}
+
+// hostReplicas returns the primary URL followed by any additional replica URLs, deduplicated
+func hostReplicas(host HostConfig) []string {
+	replicas := make([]string, 0, 1+len(host.URLs))
+	seen := make(map[string]bool)
+	for _, u := range append([]string{host.URL}, host.URLs...) {
+		u = strings.TrimSuffix(u, "/")
+		if u == "" || seen[normalizeHostURL(u)] {
+			continue
+		}
+		seen[normalizeHostURL(u)] = true
+		replicas = append(replicas, u)
+	}
+	return replicas
+}
+
+// hostEndpoints returns the agent base URLs for a host in the order they should be tried.
+// Reads are spread across replicas; writes always go to replicas in configured order.
+// The fallback URL, if any, is always tried last.
+func (d *Datasource) hostEndpoints(host HostConfig, method string) []string {
+	urls := hostReplicas(host)
+	if method == http.MethodGet {
+		urls = d.endpoints.order(host.ID, host.LoadBalancing, urls)
+	}
+	if host.FallbackURL != "" {
+		urls = append(urls, strings.TrimSuffix(host.FallbackURL, "/"))
+	}
+	return urls
+}
+
+// hostTokenPrefix starts the secureJsonData key of a host's agent token: hostToken.<hostId>
+const hostTokenPrefix = "hostToken."
+
+// hostToken returns the bearer token sent to a host's agents, "" when none is configured.
+// Self-registered agents fall back to the token they registered with.
+func (d *Datasource) hostToken(host HostConfig) string {
+	if token := d.secrets[hostTokenPrefix+host.ID]; token != "" {
+		return token
+	}
+	return d.registrations.agentToken(host.ID)
+}
+
+// doHostRequest sends a request to a host's agent and returns the response together with
+// the base URL that served it. If an endpoint is unreachable (or answers 5xx to a GET) the
+// next endpoint is tried, so replicas and a secondary agent keep dashboards alive during upgrades.
+// Other methods only fail over when the connection couldn't be made: after a timeout or reset the
+// agent may have acted already, and a control action must not run twice.
+func (d *Datasource) doHostRequest(ctx context.Context, host HostConfig, method, path string, body func() io.Reader) (*http.Response, string, error) {
+	endpoints := d.hostEndpoints(host, method)
+
+	var lastErr error
+	for i, baseURL := range endpoints {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = body()
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, baseURL+path, reqBody)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create request: %w", err)
+		}
+		if token := d.hostToken(host); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if host.PublicKey != "" {
+			req.Header.Set(nonceHeader, newAgentNonce())
+		}
+
+		sent := time.Now()
+		resp, err := d.chaos.do(d.agentClient, host, req)
+		if err != nil {
+			lastErr = fmt.Errorf("request failed: %w", err)
+			d.endpoints.recordFailure(baseURL)
+			if method != http.MethodGet && !isDialError(err) {
+				break
+			}
+		} else if method == http.MethodGet && resp.StatusCode >= 500 && i < len(endpoints)-1 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+			d.endpoints.recordFailure(baseURL)
+		} else if resp.StatusCode == http.StatusUnauthorized {
+			// Replicas share the host's token, trying them would fail the same way
+			resp.Body.Close()
+			recordReachability(ctx, true)
+			if d.hostToken(host) == "" {
+				return nil, "", &agentAuthError{fmt.Sprintf("agent requires a token, set secure setting %s%s", hostTokenPrefix, host.ID)}
+			}
+			return nil, "", &agentAuthError{"agent rejected the host token"}
+		} else if err := d.verifyAgentResponse(host, req, resp); err != nil {
+			resp.Body.Close()
+			recordReachability(ctx, true)
+			d.logger.Warn("Rejected agent response", "host", host.Name, "url", baseURL, "error", err)
+			return nil, "", err
+		} else {
+			d.endpoints.recordSuccess(baseURL)
+			recordReachability(ctx, true)
+			d.instances.record(host, baseURL, resp)
+			d.skews.record(host, baseURL, resp, sent, time.Now())
+			if i > 0 {
+				d.logger.Warn("Host served by alternate agent",
+					"host", host.Name,
+					"url", baseURL,
+					"previousError", lastErr,
+				)
+			}
+			return resp, baseURL, nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	recordReachability(ctx, false)
+	return nil, "", lastErr
+}
+
+// isDialError reports whether a request failed before a connection to the agent was made
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// fallbackURL returns baseURL if it is the host's fallback agent, otherwise ""
+func fallbackURL(host HostConfig, baseURL string) string {
+	if baseURL == "" || host.FallbackURL == "" || normalizeHostURL(baseURL) != normalizeHostURL(host.FallbackURL) {
+		return ""
+	}
+	return baseURL
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hostsEnvVar holds a JSON array of hosts, e.g.
+// [{"name":"web-1","url":"http://10.0.0.5:5000"},{"id":"db","url":"http://10.0.0.6:5000","group":"db"}]
+const hostsEnvVar = "DOCKERMETRICS_HOSTS"
+
+// envHostConfig is a HostConfig whose id, name and enabled flag may be omitted
+type envHostConfig struct {
+	HostConfig
+	Enabled *bool `json:"enabled"`
+	// OrgID limits the host to datasources of one Grafana org; 0 adds it in every org
+	OrgID int64 `json:"orgId"`
+}
+
+// parseEnvHosts decodes hosts from the DOCKERMETRICS_HOSTS format for a datasource in orgID.
+// Missing IDs are derived from the URL, missing names from the URL's hostname, and hosts are
+// enabled unless stated. Hosts bound to another org are left out.
+func parseEnvHosts(value string, orgID int64) ([]HostConfig, error) {
+	var entries []envHostConfig
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", hostsEnvVar, err)
+	}
+
+	hosts := make([]HostConfig, 0, len(entries))
+	for i, e := range entries {
+		if e.OrgID != 0 && e.OrgID != orgID {
+			continue
+		}
+		h := e.HostConfig
+		h.URL = strings.TrimSuffix(h.URL, "/")
+
+		parsed, err := url.Parse(h.URL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid %s: host %d has no valid url", hostsEnvVar, i)
+		}
+		if h.ID == "" {
+			h.ID = discoveredHostID("env", parsed.Host)
+		}
+		if h.Name == "" {
+			h.Name = parsed.Hostname()
+		}
+		h.Enabled = e.Enabled == nil || *e.Enabled
+
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// mergeEnvHosts appends hosts from the environment to the UI-configured hosts.
+// UI-configured hosts win when both define the same ID or URL.
+func mergeEnvHosts(configured, env []HostConfig) []HostConfig {
+	seenIDs := make(map[string]bool, len(configured))
+	seenURLs := make(map[string]bool, len(configured))
+	for _, h := range configured {
+		seenIDs[h.ID] = true
+		seenURLs[normalizeHostURL(h.URL)] = true
+	}
+
+	merged := append([]HostConfig(nil), configured...)
+	for _, h := range env {
+		if seenIDs[h.ID] || seenURLs[normalizeHostURL(h.URL)] {
+			continue
+		}
+		seenIDs[h.ID] = true
+		seenURLs[normalizeHostURL(h.URL)] = true
+		merged = append(merged, h)
+	}
+	return merged
+}
+
+// loadEnvHosts reads the org's hosts from DOCKERMETRICS_HOSTS, if set
+func loadEnvHosts(orgID int64) ([]HostConfig, error) {
+	value := strings.TrimSpace(os.Getenv(hostsEnvVar))
+	if value == "" {
+		return nil, nil
+	}
+	return parseEnvHosts(value, orgID)
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// compileExclusions compiles container name patterns; invalid ones are skipped with a warning
+func compileExclusions(patterns []string) ([]*regexp.Regexp, []string) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	warnings := make([]string, 0)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring container exclusion %q: %v", p, err))
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, warnings
+}
+
+type exclusionsKey struct{}
+
+// withExclusions overrides the datasource's container exclusions for one query
+func withExclusions(ctx context.Context, exclusions []*regexp.Regexp) context.Context {
+	return context.WithValue(ctx, exclusionsKey{}, exclusions)
+}
+
+// exclusionsFor returns the exclusions of the running query, the datasource's by default
+func (d *Datasource) exclusionsFor(ctx context.Context) []*regexp.Regexp {
+	if exclusions, ok := ctx.Value(exclusionsKey{}).([]*regexp.Regexp); ok {
+		return exclusions
+	}
+	return d.exclusions
+}
+
+// queryExclusions applies a query's excludeContainers, which replace the datasource's list
+// (an empty list shows every container)
+func queryExclusions(ctx context.Context, qm QueryModel) (context.Context, error) {
+	if qm.ExcludeContainers == nil {
+		return ctx, nil
+	}
+	exclusions := make([]*regexp.Regexp, 0, len(qm.ExcludeContainers))
+	for _, p := range qm.ExcludeContainers {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return ctx, fmt.Errorf("invalid container exclusion %q: %w", p, err)
+		}
+		exclusions = append(exclusions, re)
+	}
+	return withExclusions(ctx, exclusions), nil
+}
+
+func excludedName(exclusions []*regexp.Regexp, containerName string) bool {
+	for _, re := range exclusions {
+		if re.MatchString(containerName) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeMetrics drops samples of excluded containers
+func (d *Datasource) excludeMetrics(ctx context.Context, metrics []ContainerMetric) []ContainerMetric {
+	exclusions := d.exclusionsFor(ctx)
+	if len(exclusions) == 0 {
+		return metrics
+	}
+	result := make([]ContainerMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if !excludedName(exclusions, m.ContainerName) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// excludeContainers drops excluded containers from a container list
+func (d *Datasource) excludeContainers(ctx context.Context, containers []ContainerInfo) []ContainerInfo {
+	exclusions := d.exclusionsFor(ctx)
+	if len(exclusions) == 0 {
+		return containers
+	}
+	result := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		if !excludedName(exclusions, c.ContainerName) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
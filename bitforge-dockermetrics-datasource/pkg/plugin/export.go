@@ -0,0 +1,244 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const defaultExportInterval = 30 * time.Second
+
+// ExportSettings configures background exporters that forward agent samples to other systems
+type ExportSettings struct {
+	IntervalSeconds int                 `json:"intervalSeconds"`
+	RemoteWrite     RemoteWriteSettings `json:"remoteWrite"`
+	OTLP            OTLPSettings        `json:"otlp"`
+}
+
+func (s ExportSettings) interval() time.Duration {
+	if s.IntervalSeconds > 0 {
+		return time.Duration(s.IntervalSeconds) * time.Second
+	}
+	return defaultExportInterval
+}
+
+// exportBatch holds new samples from one host
+type exportBatch struct {
+	host    HostConfig
+	labels  map[string]string
+	images  map[string]string // container ID -> image, when the agent reports it
+	metrics []ContainerMetric
+}
+
+// exporter forwards batches of samples to an external system
+type exporter interface {
+	name() string
+	export(ctx context.Context, batches []exportBatch) error
+}
+
+// newExporters builds the exporters enabled in settings
+func (d *Datasource) newExporters() []exporter {
+	exporters := make([]exporter, 0)
+	if rw := d.settings.Export.RemoteWrite; rw.Enabled && rw.URL != "" {
+		exporters = append(exporters, newRemoteWriteExporter(rw, d.secrets))
+	}
+	if otlp := d.settings.Export.OTLP; otlp.Enabled && otlp.Endpoint != "" {
+		exporters = append(exporters, newOTLPExporter(otlp, d.secrets))
+	}
+	return exporters
+}
+
+// startExporters launches the export loop if any exporter is enabled
+func (d *Datasource) startExporters() {
+	exporters := d.newExporters()
+	if len(exporters) == 0 {
+		return
+	}
+
+	interval := d.settings.Export.interval()
+	for _, e := range exporters {
+		d.logger.Info("Starting metrics exporter", "exporter", e.name(), "interval", interval)
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// Newest exported sample per host, so each tick only sends new samples
+		lastSample := make(map[string]time.Time)
+		for {
+			select {
+			case <-d.bgCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			d.runExport(exporters, interval, lastSample)
+		}
+	}()
+}
+
+// runExport collects samples newer than the last export from every enabled host and hands
+// them to each exporter. Failed exports are logged and dropped; there is no local buffer.
+func (d *Datasource) runExport(exporters []exporter, interval time.Duration, lastSample map[string]time.Time) {
+	ctx, cancel := context.WithTimeout(d.bgCtx, interval)
+	defer cancel()
+
+	now := time.Now()
+	batches := make([]exportBatch, 0)
+	for _, host := range d.getEnabledHosts(nil) {
+		from, ok := lastSample[host.ID]
+		if !ok {
+			from = now.Add(-interval)
+		}
+
+		metrics, _, err := d.fetchMetricsFromHost(ctx, host, backend.TimeRange{From: from, To: now}, AllMetrics)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch metrics for export", err)
+			continue
+		}
+		metrics = relabelMetrics(host, metrics)
+
+		fresh := make([]ContainerMetric, 0, len(metrics))
+		newest := from
+		for _, m := range metrics {
+			t := m.Timestamp.Time
+			if ok && !t.After(from) {
+				continue
+			}
+			if t.After(newest) {
+				newest = t
+			}
+			fresh = append(fresh, m)
+		}
+		lastSample[host.ID] = newest
+
+		if len(fresh) > 0 {
+			batches = append(batches, exportBatch{
+				host:    host,
+				labels:  hostSeriesLabels(host),
+				images:  d.containerImages(ctx, host),
+				metrics: fresh,
+			})
+		}
+	}
+
+	if len(batches) == 0 {
+		return
+	}
+	for _, e := range exporters {
+		if err := e.export(ctx, batches); err != nil {
+			d.logger.Error("Metrics export failed", "exporter", e.name(), "error", err)
+		}
+	}
+}
+
+// rawMetricValue returns a metric in the agent's units (bytes, not MB).
+// ok is false when the sample doesn't carry the metric, e.g. PSI on hosts without it, or
+// carries a non-finite value.
+func rawMetricValue(m ContainerMetric, metric string) (value float64, ok bool) {
+	value, ok = sampleField(m, metric)
+	return value, ok && isFinite(value)
+}
+
+// sampleField returns the field of a sample holding metric
+func sampleField(m ContainerMetric, metric string) (float64, bool) {
+	switch metric {
+	case "cpuPercent":
+		return m.CPUPercent, true
+	case "memoryBytes":
+		return m.MemoryBytes, true
+	case "memoryPercent":
+		return m.MemoryPercent, true
+	case "networkRxBytes":
+		return m.NetworkRxBytes, true
+	case "networkTxBytes":
+		return m.NetworkTxBytes, true
+	case "diskReadBytes":
+		return m.DiskReadBytes, true
+	case "diskWriteBytes":
+		return m.DiskWriteBytes, true
+	case "uptimeSeconds":
+		return m.UptimeSeconds, true
+	case "cpuPressureSome", "cpuPressureFull":
+		return psiValue(m.CPUPressure, metric)
+	case "memoryPressureSome", "memoryPressureFull":
+		return psiValue(m.MemoryPressure, metric)
+	case "ioPressureSome", "ioPressureFull":
+		return psiValue(m.IOPressure, metric)
+	case "isRunning":
+		return boolValue(m.IsRunning), true
+	case "isHealthy":
+		// Containers without a health check count as healthy
+		return boolValue(!m.IsUnhealthy), true
+	}
+	return 0, false
+}
+
+// boolValue is 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func psiValue(p *PSIMetrics, metric string) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if strings.HasSuffix(metric, "Full") {
+		return p.Full10, true
+	}
+	return p.Some10, true
+}
+
+// exportMetricName converts a metric name to snake case with a docker_container_ prefix,
+// e.g. networkRxBytes -> docker_container_network_rx_bytes
+func exportMetricName(metric string) string {
+	var b strings.Builder
+	b.WriteString("docker_container_")
+	for i, r := range metric {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// exportLabelName makes a label name valid for Prometheus-style systems
+func exportLabelName(name string) string {
+	name = invalidLabelChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// containerImages maps container IDs to images; agents that don't report images yield an empty map
+func (d *Datasource) containerImages(ctx context.Context, host HostConfig) map[string]string {
+	images := make(map[string]string)
+	containers, err := d.fetchContainersFromHost(ctx, host)
+	if err != nil {
+		d.logger.Debug("Failed to fetch container images for export", "host", host.Name, "error", err)
+		return images
+	}
+	for _, c := range containers {
+		if c.Image != "" {
+			images[c.ContainerID] = c.Image
+		}
+	}
+	return images
+}
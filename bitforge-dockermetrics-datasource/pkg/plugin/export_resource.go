@@ -0,0 +1,270 @@
+package plugin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// exportRequest is the body of the export resource
+type exportRequest struct {
+	Query json.RawMessage `json:"query"`
+	From  string          `json:"from"`
+	To    string          `json:"to"`
+}
+
+// exportRow is one sample of a time series frame in long format
+type exportRow struct {
+	Frame  string            `json:"frame"`
+	Time   time.Time         `json:"time"`
+	Field  string            `json:"field"`
+	Value  interface{}       `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// handleExport runs a query and returns its frames as CSV or NDJSON
+// (POST /export?format=csv|ndjson with {"query": {...}, "from": "now-6h", "to": "now"}).
+// Time series frames are flattened to one row per sample with all labels, so the output
+// can be scripted against; table frames (containers, hosts) are exported row by row.
+func (d *Datasource) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "csv" && format != "ndjson" {
+		writeError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	var body exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Query) == 0 {
+		writeError(w, http.StatusBadRequest, "request body must contain a query")
+		return
+	}
+
+	var qm QueryModel
+	if err := json.Unmarshal(body.Query, &qm); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query: %v", err))
+		return
+	}
+	if qm.QueryType == "control" {
+		writeError(w, http.StatusBadRequest, "control queries cannot be exported")
+		return
+	}
+
+	now := time.Now()
+	from, err := parseExportTime(body.From, now.Add(-time.Hour), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseExportTime(body.To, now, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res := d.query(r.Context(), backend.PluginConfigFromContext(r.Context()), backend.DataQuery{
+		RefID:     "export",
+		QueryType: qm.QueryType,
+		JSON:      body.Query,
+		TimeRange: backend.TimeRange{From: from, To: to},
+	})
+	if res.Error != nil {
+		writeError(w, http.StatusBadRequest, res.Error.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("dockermetrics-%s.%s", now.UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		writeExportCSV(w, res.Frames)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	writeExportNDJSON(w, res.Frames)
+}
+
+// parseExportTime accepts RFC3339, epoch milliseconds, "now" and "now-<duration>" (e.g. now-6h, now-7d)
+func parseExportTime(value string, def, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return def, nil
+	case value == "now":
+		return now, nil
+	case strings.HasPrefix(value, "now-"):
+		spec := strings.TrimPrefix(value, "now-")
+		if days, ok := strings.CutSuffix(spec, "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time %q", value)
+			}
+			return now.AddDate(0, 0, -n), nil
+		}
+		dur, err := time.ParseDuration(spec)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", value)
+		}
+		return now.Add(-dur), nil
+	}
+
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+// isTimeSeriesFrame reports whether a frame starts with a time field
+func isTimeSeriesFrame(frame *data.Frame) bool {
+	return len(frame.Fields) > 1 && frame.Fields[0].Type().Time()
+}
+
+// frameRows flattens a time series frame into long format rows
+func frameRows(frame *data.Frame) []exportRow {
+	rows := make([]exportRow, 0, frame.Rows())
+	timeField := frame.Fields[0]
+	for _, field := range frame.Fields[1:] {
+		for i := 0; i < field.Len(); i++ {
+			t, ok := timeField.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			value, _ := field.ConcreteAt(i)
+			rows = append(rows, exportRow{
+				Frame:  frame.Name,
+				Time:   t.(time.Time),
+				Field:  field.Name,
+				Value:  value,
+				Labels: field.Labels,
+			})
+		}
+	}
+	return rows
+}
+
+func writeExportNDJSON(w http.ResponseWriter, frames data.Frames) {
+	enc := json.NewEncoder(w)
+	for _, frame := range frames {
+		if isTimeSeriesFrame(frame) {
+			for _, row := range frameRows(frame) {
+				if err := enc.Encode(row); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		for i := 0; i < frame.Rows(); i++ {
+			row := map[string]interface{}{"frame": frame.Name}
+			for _, field := range frame.Fields {
+				row[field.Name], _ = field.ConcreteAt(i)
+			}
+			if err := enc.Encode(row); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeExportCSV writes time series frames in long format with one column per label.
+// Responses without time series (containers, hosts queries) are written as a plain table.
+func writeExportCSV(w http.ResponseWriter, frames data.Frames) {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	rows := make([]exportRow, 0)
+	labelSet := make(map[string]bool)
+	for _, frame := range frames {
+		if !isTimeSeriesFrame(frame) {
+			continue
+		}
+		for _, row := range frameRows(frame) {
+			for k := range row.Labels {
+				labelSet[k] = true
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	if len(rows) == 0 {
+		for _, frame := range frames {
+			if len(frame.Fields) > 0 {
+				writeTableCSV(cw, frame)
+				return
+			}
+		}
+		return
+	}
+
+	labels := make([]string, 0, len(labelSet))
+	for k := range labelSet {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+
+	_ = cw.Write(append([]string{"time", "frame", "field", "value"}, labels...))
+	for _, row := range rows {
+		record := []string{row.Time.UTC().Format(time.RFC3339Nano), row.Frame, row.Field, exportCell(row.Value)}
+		for _, k := range labels {
+			record = append(record, row.Labels[k])
+		}
+		if err := cw.Write(record); err != nil {
+			return
+		}
+	}
+}
+
+func writeTableCSV(cw *csv.Writer, frame *data.Frame) {
+	header := make([]string, 0, len(frame.Fields))
+	for _, field := range frame.Fields {
+		header = append(header, field.Name)
+	}
+	_ = cw.Write(header)
+
+	for i := 0; i < frame.Rows(); i++ {
+		record := make([]string, 0, len(frame.Fields))
+		for _, field := range frame.Fields {
+			value, _ := field.ConcreteAt(i)
+			record = append(record, exportCell(value))
+		}
+		if err := cw.Write(record); err != nil {
+			return
+		}
+	}
+}
+
+func exportCell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Feature toggles for DatasourceSettings.FeatureToggles
+const (
+	FeatureDiscovery = "discovery" // background host discovery
+	FeatureCaching   = "caching"   // per-request container list cache
+	FeatureStreaming = "streaming" // live streaming (experimental)
+	FeatureLogs      = "logs"      // container logs (experimental)
+	FeaturePrefetch  = "prefetch"  // warm-up prefetch of frequent dashboard queries (experimental)
+)
+
+// featureDefaults lists the known toggles and whether they are on when not configured.
+// Experimental subsystems ship off and are enabled per datasource.
+var featureDefaults = map[string]bool{
+	FeatureDiscovery: true,
+	FeatureCaching:   true,
+	FeatureStreaming: false,
+	FeatureLogs:      false,
+	FeaturePrefetch:  false,
+}
+
+// featureEnabled reports whether a feature is on for this datasource
+func (d *Datasource) featureEnabled(name string) bool {
+	if enabled, ok := d.settings.FeatureToggles[name]; ok {
+		return enabled
+	}
+	return featureDefaults[name]
+}
+
+// validateFeatureToggles warns about toggles this version doesn't know
+func validateFeatureToggles(toggles map[string]bool) []string {
+	warnings := make([]string, 0)
+	for name := range toggles {
+		if _, ok := featureDefaults[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown feature toggle %q", name))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
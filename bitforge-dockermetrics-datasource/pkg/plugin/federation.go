@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// federatedUIDs returns the configured federated datasources, without this instance and duplicates
+func (d *Datasource) federatedUIDs() []string {
+	uids := make([]string, 0, len(d.settings.FederatedDatasourceUIDs))
+	for _, uid := range d.settings.FederatedDatasourceUIDs {
+		if uid == "" || uid == d.uid || contains(uids, uid) {
+			continue
+		}
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// validateFederation reports federated datasources that can't be queried
+func validateFederation(uids []string, secrets map[string]string) []string {
+	if len(uids) > 0 && secrets["grafanaApiToken"] == "" {
+		return []string{"federated datasources need a grafanaApiToken, they are not queried"}
+	}
+	return nil
+}
+
+// federate runs the query on every federated datasource through Grafana's /api/ds/query and
+// merges their frames into res, with a datasource label on their series. A datasource that
+// fails adds a warning notice instead of failing the query, the query fails only when this
+// instance and every federated datasource fail. Like the read-through, control queries and
+// queries forwarded by another instance are not federated. Neither are audit queries nor
+// queries this instance refused for the user's role or scope, since the federated sites
+// would answer them with the service account's privileges.
+func (d *Datasource) federate(ctx context.Context, q backend.DataQuery, res backend.DataResponse) backend.DataResponse {
+	uids := d.federatedUIDs()
+	token := d.secrets["grafanaApiToken"]
+	if len(uids) == 0 || token == "" {
+		return res
+	}
+	var rq readThroughQuery
+	if err := json.Unmarshal(q.JSON, &rq); err != nil || rq.QueryType == "control" || rq.QueryType == "audit" || rq.ReadThrough {
+		return res
+	}
+	var scopeErr *scopeError
+	var roleErr *roleError
+	if errors.As(res.Error, &scopeErr) || errors.As(res.Error, &roleErr) {
+		return res
+	}
+
+	results := make([]backend.DataResponse, len(uids))
+	errs := make([]error, len(uids))
+	var wg sync.WaitGroup
+	for i, uid := range uids {
+		i, uid := i, uid
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = d.querySecondary(ctx, uid, token, q)
+		}()
+	}
+	wg.Wait()
+
+	merged := res
+	notices := make([]data.Notice, 0)
+	answered := res.Error == nil
+	if !answered {
+		merged = backend.DataResponse{}
+		// An instance that only federates has no hosts of its own to fail
+		if len(d.hosts.all()) > 0 {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("This datasource: %v", res.Error),
+			})
+		}
+	}
+	for i, uid := range uids {
+		if errs[i] != nil {
+			d.log(ctx).Warn("Federated datasource query failed", "refId", q.RefID, "datasource", uid, "error", errs[i])
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Datasource %s: %v", uid, errs[i]),
+			})
+			continue
+		}
+		labelFederatedFrames(results[i].Frames, uid)
+		merged.Frames = append(merged.Frames, results[i].Frames...)
+		answered = true
+	}
+	if !answered {
+		return res
+	}
+	for i := range notices {
+		addFrameNotice(merged.Frames, &notices[i])
+	}
+	return merged
+}
+
+// labelFederatedFrames adds the datasource UID to the labels of labelled fields, so series of
+// hosts with the same name on different sites stay apart
+func labelFederatedFrames(frames data.Frames, uid string) {
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if len(field.Labels) == 0 {
+				continue
+			}
+			if _, ok := field.Labels["datasource"]; !ok {
+				field.Labels["datasource"] = uid
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// containerFieldSelection is a matrix query's per-container selection, pushed down to the
+// agent so it only sends the fields each container's frames show. Agents that don't know the
+// containerFields parameter ignore it and send the query's fields for every container, which
+// buildMetricFrames filters as before.
+type containerFieldSelection struct {
+	containerIDs []string            // whitelisted containers, empty for every container
+	fields       map[string][]string // container ID -> fields, containers without one get the query's fields
+}
+
+// fieldSelectionFor derives the pushdown for a host selection; blacklisted containers are
+// still filtered after the fetch
+func fieldSelectionFor(hostSel HostSelection) *containerFieldSelection {
+	sel := &containerFieldSelection{fields: make(map[string][]string)}
+	whitelist := hostSel.Mode == "whitelist"
+	if whitelist && len(hostSel.IncludeLabels) == 0 {
+		// Containers selected by label aren't known before the fetch
+		sel.containerIDs = hostSel.ContainerIDs
+	}
+	if explicitSelection(hostSel) {
+		listed := hostSel.ContainerIDs
+		if !whitelist {
+			listed = make([]string, 0, len(hostSel.ContainerMetrics))
+			for containerID := range hostSel.ContainerMetrics {
+				listed = append(listed, containerID)
+			}
+		}
+		for _, containerID := range listed {
+			sel.fields[containerID] = selectionMetrics(hostSel, containerID)
+		}
+		return sel
+	}
+	for containerID, metrics := range hostSel.ContainerMetrics {
+		if len(metrics) == 0 || whitelist != contains(hostSel.ContainerIDs, containerID) {
+			continue
+		}
+		sel.fields[containerID] = metrics
+	}
+	return sel
+}
+
+// agentFieldNames are the agent fields of metrics it sends inside another field: the PSI
+// metrics are read from the agent's pressure objects
+var agentFieldNames = map[string]string{
+	"cpuPressureSome":    "cpuPressure",
+	"cpuPressureFull":    "cpuPressure",
+	"memoryPressureSome": "memoryPressure",
+	"memoryPressureFull": "memoryPressure",
+	"ioPressureSome":     "ioPressure",
+	"ioPressureFull":     "ioPressure",
+}
+
+// agentFields returns the agent fields carrying metrics, in order and without duplicates
+func agentFields(metrics []string) []string {
+	fields := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		if field, ok := agentFieldNames[m]; ok {
+			m = field
+		}
+		if !contains(fields, m) {
+			fields = append(fields, m)
+		}
+	}
+	return fields
+}
+
+// encode adds containerIds and containerFields (id:field,field;id:field) to agent parameters
+func (s *containerFieldSelection) encode(params url.Values) {
+	if s == nil {
+		return
+	}
+	if len(s.containerIDs) > 0 {
+		params.Set("containerIds", strings.Join(s.containerIDs, ","))
+	}
+	if len(s.fields) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(s.fields))
+	for id := range s.fields {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	entries := make([]string, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, id+":"+strings.Join(agentFields(s.fields[id]), ","))
+	}
+	params.Set("containerFields", strings.Join(entries, ";"))
+}
+
+type fieldSelectionKey struct{}
+
+func withFieldSelection(ctx context.Context, sel *containerFieldSelection) context.Context {
+	return context.WithValue(ctx, fieldSelectionKey{}, sel)
+}
+
+// fieldSelectionFrom returns the pushdown of the host being fetched, nil outside matrix queries
+func fieldSelectionFrom(ctx context.Context) *containerFieldSelection {
+	sel, _ := ctx.Value(fieldSelectionKey{}).(*containerFieldSelection)
+	return sel
+}
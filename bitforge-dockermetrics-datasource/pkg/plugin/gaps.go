@@ -0,0 +1,46 @@
+package plugin
+
+import "time"
+
+// heartbeat returns the longest expected spacing of a container's samples; longer gaps get a
+// null so graphs break the line instead of connecting across the missing samples. 0 turns gap
+// detection off.
+func (d *Datasource) heartbeat() time.Duration {
+	switch {
+	case d.settings.HeartbeatSeconds < 0:
+		return 0
+	case d.settings.HeartbeatSeconds > 0:
+		return time.Duration(d.settings.HeartbeatSeconds) * time.Second
+	}
+	return d.staleAfter()
+}
+
+// insertGaps adds a null halfway between samples further apart than heartbeat. Halfway lands
+// in an empty bucket whenever downsampling skips one, so the gap survives it.
+func insertGaps(times []time.Time, values []*float64, heartbeat time.Duration) ([]time.Time, []*float64) {
+	if heartbeat <= 0 || len(times) < 2 {
+		return times, values
+	}
+	gaps := 0
+	for i := 1; i < len(times); i++ {
+		if times[i].Sub(times[i-1]) > heartbeat {
+			gaps++
+		}
+	}
+	if gaps == 0 {
+		return times, values
+	}
+	outTimes := make([]time.Time, 0, len(times)+gaps)
+	outValues := make([]*float64, 0, len(values)+gaps)
+	for i, t := range times {
+		if i > 0 {
+			if span := t.Sub(times[i-1]); span > heartbeat {
+				outTimes = append(outTimes, times[i-1].Add(span/2))
+				outValues = append(outValues, nil)
+			}
+		}
+		outTimes = append(outTimes, t)
+		outValues = append(outValues, values[i])
+	}
+	return outTimes, outValues
+}
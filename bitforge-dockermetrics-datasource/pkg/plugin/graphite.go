@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// graphiteSeries is one entry of a Graphite /render JSON response
+type graphiteSeries struct {
+	Target     string            `json:"target"`
+	Tags       map[string]string `json:"tags"`
+	Datapoints [][2]float64      `json:"datapoints"` // [value, unix seconds]
+}
+
+// graphiteNode makes a host or container name usable as a single Graphite path node
+func graphiteNode(name string) string {
+	return strings.NewReplacer(".", "_", " ", "_", "/", "_").Replace(strings.TrimPrefix(name, "/"))
+}
+
+// handleGraphiteRender emulates a minimal Graphite render API so tools built for Graphite can
+// read container metrics: GET /render?target=<host>.<container>.<metric>&from=-1h&until=now.
+// Each path node may use Graphite's * ? and [...] wildcards; values are returned in raw units.
+// Only format=json is supported.
+func (d *Datasource) handleGraphiteRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// Render requests can't carry a scope token
+	if d.settings.ScopeTokens.Required && !isEditorRequest(r) {
+		writeError(w, http.StatusForbidden, "this datasource requires a scope token for viewer queries")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if format := r.Form.Get("format"); format != "" && format != "json" {
+		writeError(w, http.StatusBadRequest, "only format=json is supported")
+		return
+	}
+
+	targets := r.Form["target"]
+	if len(targets) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one target is required")
+		return
+	}
+	patterns := make([][]string, 0, len(targets))
+	for _, target := range targets {
+		nodes := strings.Split(target, ".")
+		if len(nodes) != 3 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("target %q must have the form <host>.<container>.<metric>", target))
+			return
+		}
+		for _, node := range nodes {
+			if _, err := path.Match(node, ""); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid pattern in target %q", target))
+				return
+			}
+		}
+		patterns = append(patterns, nodes)
+	}
+
+	now := time.Now()
+	from, err := parseGraphiteTime(r.Form.Get("from"), now.Add(-24*time.Hour), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	until, err := parseGraphiteTime(r.Form.Get("until"), now, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeRange := backend.TimeRange{From: from, To: until}
+
+	result := make([]graphiteSeries, 0)
+	for _, host := range d.getEnabledHosts(nil) {
+		hostNode := graphiteNode(host.Name)
+
+		metrics := make([]string, 0)
+		for _, p := range patterns {
+			if !graphiteMatch(p[0], hostNode) {
+				continue
+			}
+			for _, m := range AllMetrics {
+				if graphiteMatch(p[2], m) && !contains(metrics, m) {
+					metrics = append(metrics, m)
+				}
+			}
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+
+		samples, _, err := d.fetchMetrics(r.Context(), host, timeRange, metrics)
+		if err != nil {
+			d.logger.Warn("Failed to fetch metrics for Graphite render", "host", host.Name, "error", err)
+			continue
+		}
+		sortMetricsByTime(samples)
+
+		series := make(map[string]*graphiteSeries)
+		for _, s := range samples {
+			containerNode := graphiteNode(s.ContainerName)
+			t := s.Timestamp.Time
+			for _, metric := range metrics {
+				name := hostNode + "." + containerNode + "." + metric
+				if !graphiteMatchAny(patterns, []string{hostNode, containerNode, metric}) {
+					continue
+				}
+				value, ok := rawMetricValue(s, metric)
+				if !ok {
+					continue
+				}
+				entry, exists := series[name]
+				if !exists {
+					entry = &graphiteSeries{
+						Target:     name,
+						Tags:       map[string]string{"name": name, "host": host.Name, "container": strings.TrimPrefix(s.ContainerName, "/"), "metric": metric},
+						Datapoints: make([][2]float64, 0),
+					}
+					series[name] = entry
+				}
+				entry.Datapoints = append(entry.Datapoints, [2]float64{value, float64(t.Unix())})
+			}
+		}
+
+		names := make([]string, 0, len(series))
+		for name := range series {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, *series[name])
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func graphiteMatch(pattern, node string) bool {
+	ok, _ := path.Match(pattern, node)
+	return ok
+}
+
+func graphiteMatchAny(patterns [][]string, nodes []string) bool {
+	for _, p := range patterns {
+		if graphiteMatch(p[0], nodes[0]) && graphiteMatch(p[1], nodes[1]) && graphiteMatch(p[2], nodes[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// graphiteUnits are the relative time units accepted by Graphite's from/until
+var graphiteUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"min", time.Minute},
+	{"mon", 30 * 24 * time.Hour},
+	{"s", time.Second},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// parseGraphiteTime accepts "now", relative times such as -1h, -30min or -7d, and epoch seconds
+func parseGraphiteTime(value string, def, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return def, nil
+	case value == "now":
+		return now, nil
+	case strings.HasPrefix(value, "-"):
+		spec := strings.TrimPrefix(value, "-")
+		for _, u := range graphiteUnits {
+			if n, ok := strings.CutSuffix(spec, u.suffix); ok {
+				count, err := strconv.Atoi(n)
+				if err != nil {
+					break
+				}
+				return now.Add(-time.Duration(count) * u.unit), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Unix(secs, 0), nil
+}
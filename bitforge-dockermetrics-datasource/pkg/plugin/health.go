@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout is the overall budget for probing hosts in CheckHealth
+const healthCheckTimeout = 5 * time.Second
+
+// HostHealth is the probe result for one host, returned in the health check details
+type HostHealth struct {
+	HostID    string `json:"hostId"`
+	HostName  string `json:"hostName"`
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// probeHosts checks all hosts in parallel within healthCheckTimeout. Results are in host order;
+// hosts that don't answer in time are reported as timed out.
+func (d *Datasource) probeHosts(ctx context.Context, hosts []HostConfig) []HostHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	results := make([]HostHealth, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host HostConfig) {
+			defer wg.Done()
+			results[i] = d.probeHost(ctx, host)
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+func (d *Datasource) probeHost(ctx context.Context, host HostConfig) HostHealth {
+	result := HostHealth{HostID: host.ID, HostName: host.Name}
+	start := time.Now()
+	resp, _, err := d.doHostRequest(ctx, host, http.MethodGet, hostInfoPath(host), nil)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Error = fmt.Sprintf("no answer within %s", healthCheckTimeout)
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("status %d", resp.StatusCode)
+		return result
+	}
+	result.Healthy = true
+	return result
+}
+
+// healthDetails encodes the per-host results for CheckHealthResult.JSONDetails
+func healthDetails(results []HostHealth) []byte {
+	details, err := json.Marshal(map[string]interface{}{"hosts": results})
+	if err != nil {
+		return nil
+	}
+	return details
+}
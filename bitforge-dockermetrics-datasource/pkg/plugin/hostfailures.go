@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// failedHost records a host whose agents couldn't be read, so the query can say so
+func failedHost(host HostConfig, err error) metricsWithHost {
+	if invalid, ok := invalidPayloadHost(host, err); ok {
+		return invalid
+	}
+	return metricsWithHost{HostID: host.ID, HostName: host.Name, Err: err}
+}
+
+// reportFailedHosts adds a warning notice per failed host and a "2 of 5 hosts unreachable"
+// summary to a metrics response, so missing series don't just vanish. Hosts in maintenance
+// are expected to fail and aren't reported. When every host failed and nothing was returned
+// the query fails with a bad gateway (timeout when every host timed out) status; alert
+// queries keep their empty response so rules evaluate their no-data state.
+func (d *Datasource) reportFailedHosts(response *backend.DataResponse, collected []metricsWithHost, hosts int, alerting bool) {
+	notices := make([]data.Notice, 0)
+	messages := make([]string, 0)
+	timeouts := 0
+	for _, mwh := range collected {
+		if mwh.Err == nil || d.maintenance.active(mwh.HostID) {
+			continue
+		}
+		if errors.Is(mwh.Err, context.DeadlineExceeded) {
+			timeouts++
+		}
+		text := fmt.Sprintf("Host %s: %v", mwh.HostName, mwh.Err)
+		messages = append(messages, text)
+		notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+	}
+	if len(notices) == 0 {
+		return
+	}
+
+	summary := fmt.Sprintf("%d of %d hosts unreachable", len(notices), hosts)
+	if len(response.Frames) == 0 {
+		if alerting {
+			return
+		}
+		if len(notices) == hosts {
+			response.Error = fmt.Errorf("%s: %s", summary, strings.Join(messages, "; "))
+			response.Status = backend.StatusBadGateway
+			if timeouts == len(notices) {
+				response.Status = backend.StatusTimeout
+			}
+			return
+		}
+		// The other hosts answered without series, the notices still need a frame
+		response.Frames = data.Frames{data.NewFrame("")}
+	}
+	response.Status = backend.StatusOK
+	addFrameNotice(response.Frames, &data.Notice{Severity: data.NoticeSeverityWarning, Text: summary})
+	for _, notice := range notices {
+		addFrameNotice(response.Frames, &notice)
+	}
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// HostMetadataFields lists the /api/info fields that can be attached as series labels
+var HostMetadataFields = []string{"os", "kernel", "dockerVersion", "architecture", "agentVersion"}
+
+// hostMetadataCache holds /api/info responses, fetched once per host (see sharedState)
+type hostMetadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*AgentInfo
+}
+
+func newHostMetadataCache() *hostMetadataCache {
+	return &hostMetadataCache{entries: make(map[string]*AgentInfo)}
+}
+
+func (c *hostMetadataCache) invalidate(hostID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, hostID)
+}
+
+func (c *hostMetadataCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*AgentInfo)
+}
+
+// hostMetadata returns the cached agent info for a host, fetching it on first use.
+// Failures are not cached so the next query retries.
+func (d *Datasource) hostMetadata(ctx context.Context, host HostConfig) (*AgentInfo, error) {
+	d.metadata.mu.Lock()
+	info, ok := d.metadata.entries[host.ID]
+	d.metadata.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err := d.fetchAgentInfoFromHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.metadata.mu.Lock()
+	d.metadata.entries[host.ID] = info
+	d.metadata.mu.Unlock()
+	return info, nil
+}
+
+// metadataValue returns a single metadata field from agent info
+func metadataValue(info *AgentInfo, field string) string {
+	switch field {
+	case "os":
+		return info.OS
+	case "kernel":
+		return info.KernelVersion
+	case "dockerVersion":
+		return info.DockerVersion
+	case "architecture":
+		return info.Architecture
+	case "agentVersion":
+		return info.AgentVersion
+	}
+	return ""
+}
+
+// hostLabels returns the series labels for a host including the configured metadata fields
+func (d *Datasource) hostLabels(ctx context.Context, host HostConfig) map[string]string {
+	labels := hostSeriesLabels(host)
+	if len(d.settings.HostMetadataLabels) == 0 {
+		return labels
+	}
+
+	info, err := d.hostMetadata(ctx, host)
+	if err != nil {
+		d.logger.Warn("Failed to fetch host metadata", "host", host.Name, "error", err)
+		return labels
+	}
+
+	for _, field := range d.settings.HostMetadataLabels {
+		if v := metadataValue(info, field); v != "" {
+			labels[field] = v
+		}
+	}
+	return labels
+}
+
+// queryHosts returns one row per host with its metadata, for fleet overview tables
+func (d *Datasource) queryHosts(ctx context.Context, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	response.Frames = append(response.Frames, d.buildHostsFrame(ctx, hosts))
+	return response
+}
+
+// buildHostsFrame builds the "hosts" table of the given hosts
+func (d *Datasource) buildHostsFrame(ctx context.Context, hosts []HostConfig) *data.Frame {
+	hostIDs := make([]string, 0, len(hosts))
+	hostNames := make([]string, 0, len(hosts))
+	groups := make([]string, 0, len(hosts))
+	reachable := make([]bool, 0, len(hosts))
+	values := make(map[string][]string)
+
+	for _, host := range hosts {
+		hostIDs = append(hostIDs, host.ID)
+		hostNames = append(hostNames, host.Name)
+		groups = append(groups, host.Group)
+
+		info, err := d.hostMetadata(ctx, host)
+		reachable = append(reachable, err == nil)
+		for _, field := range HostMetadataFields {
+			v := ""
+			if err == nil {
+				v = metadataValue(info, field)
+			}
+			values[field] = append(values[field], v)
+		}
+	}
+
+	frame := data.NewFrame("hosts",
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("hostName", nil, hostNames),
+		data.NewField("hostGroup", nil, groups),
+		data.NewField("reachable", nil, reachable),
+	)
+	for _, field := range HostMetadataFields {
+		frame.Fields = append(frame.Fields, data.NewField(field, nil, values[field]))
+	}
+
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Custom: map[string]interface{}{
+			"queryType": "hosts",
+		},
+	}
+	return frame
+}
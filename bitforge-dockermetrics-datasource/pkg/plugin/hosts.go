@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Host modes: which API a host's URL speaks
+const (
+	HostModeAgent      = "" // Docker Metrics Collector agent (default)
+	HostModeCAdvisor   = "cadvisor"
+	HostModePrometheus = "prometheus" // Prometheus exposition with cAdvisor container_* series
+)
+
+// hostModes lists the supported host modes
+var hostModes = []string{HostModeAgent, HostModeCAdvisor, HostModePrometheus}
+
+// hostRegistry holds the configured hosts plus hosts contributed by discovery sources
+type hostRegistry struct {
+	mu         sync.RWMutex
+	static     []HostConfig
+	discovered map[string][]HostConfig // keyed by discovery source name
+	overrides  *hostOverrides
+	relabeling []relabelRule
+}
+
+func newHostRegistry(static []HostConfig, overrides *hostOverrides, relabeling []relabelRule) *hostRegistry {
+	return &hostRegistry{
+		static:     static,
+		discovered: make(map[string][]HostConfig),
+		overrides:  overrides,
+		relabeling: relabeling,
+	}
+}
+
+// all returns configured hosts followed by discovered hosts, with runtime overrides and
+// relabeling rules applied.
+// Discovered hosts whose ID or URL duplicates an earlier entry are skipped.
+func (r *hostRegistry) all() []HostConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]HostConfig, 0, len(r.static))
+	seenIDs := make(map[string]bool)
+	seenURLs := make(map[string]bool)
+
+	add := func(h HostConfig) {
+		url := normalizeHostURL(h.URL)
+		if seenIDs[h.ID] || seenURLs[url] {
+			return
+		}
+		seenIDs[h.ID] = true
+		seenURLs[url] = true
+		result = append(result, relabelHost(r.relabeling, r.overrides.apply(h)))
+	}
+
+	for _, h := range r.static {
+		add(h)
+	}
+
+	sources := make([]string, 0, len(r.discovered))
+	for source := range r.discovered {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		for _, h := range r.discovered[source] {
+			add(h)
+		}
+	}
+
+	return result
+}
+
+// find returns the host with the given ID
+func (r *hostRegistry) find(id string) (HostConfig, bool) {
+	for _, h := range r.all() {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return HostConfig{}, false
+}
+
+// setDiscovered replaces the hosts contributed by a discovery source and
+// returns how many host IDs were added and removed compared to the previous run
+func (r *hostRegistry) setDiscovered(source string, hosts []HostConfig) (added, removed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := make(map[string]bool)
+	for _, h := range r.discovered[source] {
+		previous[h.ID] = true
+	}
+
+	current := make(map[string]bool)
+	for _, h := range hosts {
+		current[h.ID] = true
+		if !previous[h.ID] {
+			added++
+		}
+	}
+	for id := range previous {
+		if !current[id] {
+			removed++
+		}
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	r.discovered[source] = hosts
+	return added, removed
+}
+
+// selectHosts returns enabled hosts filtered by IDs and the query's group/tag selection
+func (d *Datasource) selectHosts(qm QueryModel, filterIDs []string) []HostConfig {
+	result := make([]HostConfig, 0)
+	for _, h := range d.getEnabledHosts(filterIDs) {
+		if len(qm.HostGroups) > 0 && !contains(qm.HostGroups, h.Group) {
+			continue
+		}
+		if !hasAllTags(h, qm.HostTags) {
+			continue
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+func hasAllTags(h HostConfig, tags []string) bool {
+	for _, t := range tags {
+		if !contains(h.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostSeriesLabels returns the labels attached to every series from a host
+func hostSeriesLabels(h HostConfig) map[string]string {
+	labels := make(map[string]string, len(h.Labels)+2)
+	for k, v := range h.Labels {
+		labels[k] = v
+	}
+	if h.Group != "" {
+		labels["hostGroup"] = h.Group
+	}
+	if len(h.Tags) > 0 {
+		labels["hostTags"] = strings.Join(h.Tags, ",")
+	}
+	return labels
+}
+
+// sortHosts orders hosts by name, then ID, for stable output
+func sortHosts(hosts []HostConfig) {
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Name != hosts[j].Name {
+			return hosts[i].Name < hosts[j].Name
+		}
+		return hosts[i].ID < hosts[j].ID
+	})
+}
+
+// hostInfoPath returns the path probed by health checks for a host
+func hostInfoPath(host HostConfig) string {
+	switch host.Mode {
+	case HostModeCAdvisor:
+		return "/api/v2.0/attributes"
+	case HostModePrometheus:
+		return scrapePath(host)
+	default:
+		return "/api/info"
+	}
+}
+
+// normalizeHostURL makes URLs comparable by trimming trailing slashes and case
+func normalizeHostURL(u string) string {
+	return strings.ToLower(strings.TrimSuffix(u, "/"))
+}
@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// hostOverrides holds runtime Enabled overrides set through the hosts resource (see sharedState)
+type hostOverrides struct {
+	mu      sync.Mutex
+	enabled map[string]bool
+}
+
+func newHostOverrides() *hostOverrides {
+	return &hostOverrides{enabled: make(map[string]bool)}
+}
+
+func (o *hostOverrides) set(hostID string, enabled bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enabled[hostID] = enabled
+}
+
+// apply returns the host with any runtime override of Enabled applied
+func (o *hostOverrides) apply(h HostConfig) HostConfig {
+	if o == nil {
+		return h
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if enabled, ok := o.enabled[h.ID]; ok {
+		h.Enabled = enabled
+	}
+	return h
+}
+
+// HostStateResult is returned by the hosts/{id}/enabled resource
+type HostStateResult struct {
+	HostID    string `json:"hostId"`
+	Enabled   bool   `json:"enabled"`
+	Persisted bool   `json:"persisted"`
+	Message   string `json:"message,omitempty"`
+}
+
+// handleHostState toggles a host on or off at runtime (POST /hosts/{id}/enabled {"enabled": bool}).
+// The change takes effect immediately and is written back to the datasource settings through
+// the Grafana API when secureJsonData.grafanaApiToken is set. Admin only.
+func (d *Datasource) handleHostState(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/hosts"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "enabled" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !isAdminRequest(r) {
+		writeError(w, http.StatusForbidden, "changing host state requires the Admin role")
+		return
+	}
+
+	hostID := parts[0]
+	if _, ok := d.hosts.find(hostID); !ok {
+		writeError(w, http.StatusNotFound, "host not found: "+hostID)
+		return
+	}
+
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "request body must be {\"enabled\": true|false}")
+		return
+	}
+
+	d.overrides.set(hostID, *body.Enabled)
+	result := HostStateResult{HostID: hostID, Enabled: *body.Enabled}
+
+	persisted, err := d.persistHostEnabled(r.Context(), hostID, *body.Enabled)
+	switch {
+	case err != nil:
+		result.Message = fmt.Sprintf("applied at runtime only, saving settings failed: %v", err)
+		d.logger.Error("Failed to persist host state", "hostId", hostID, "error", err)
+	case !persisted:
+		result.Message = "applied at runtime only"
+	}
+	result.Persisted = persisted
+
+	login := ""
+	if user := httpadapter.UserFromContext(r.Context()); user != nil {
+		login = user.Login
+	}
+	d.logger.Info("Host state changed",
+		"hostId", hostID,
+		"enabled", *body.Enabled,
+		"persisted", persisted,
+		"setBy", login,
+	)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// persistHostEnabled writes a host's Enabled flag back to the datasource's jsonData.
+// It returns false without error when there is nothing to persist to: no API token is
+// configured, or the host is not a configured host (e.g. discovered).
+func (d *Datasource) persistHostEnabled(ctx context.Context, hostID string, enabled bool) (bool, error) {
+	token := d.secrets["grafanaApiToken"]
+	if token == "" || d.uid == "" {
+		return false, nil
+	}
+
+	appURL, err := backend.GrafanaConfigFromContext(ctx).AppURL()
+	if err != nil {
+		return false, fmt.Errorf("grafana app URL unavailable: %w", err)
+	}
+	dsURL := fmt.Sprintf("%s/api/datasources/uid/%s", strings.TrimSuffix(appURL, "/"), url.PathEscape(d.uid))
+
+	var ds map[string]interface{}
+	if err := grafanaAPIRequest(ctx, http.MethodGet, dsURL, token, nil, &ds); err != nil {
+		return false, err
+	}
+
+	jsonData, _ := ds["jsonData"].(map[string]interface{})
+	hosts, _ := jsonData["hosts"].([]interface{})
+	found := false
+	for _, h := range hosts {
+		if host, ok := h.(map[string]interface{}); ok && host["id"] == hostID {
+			host["enabled"] = enabled
+			found = true
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(ds)
+	if err != nil {
+		return false, err
+	}
+	if err := grafanaAPIRequest(ctx, http.MethodPut, dsURL, token, payload, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// grafanaAPIRequest calls the Grafana HTTP API with a service account token
+func grafanaAPIRequest(ctx context.Context, method, targetURL, token string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana API returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
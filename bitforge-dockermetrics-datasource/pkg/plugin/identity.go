@@ -0,0 +1,24 @@
+package plugin
+
+// Series identity modes for QueryModel.Identity
+const (
+	IdentityContainer = ""        // container ID: a recreated container starts a new series
+	IdentityName      = "name"    // container name
+	IdentityService   = "service" // compose project/service, falling back to the container name
+)
+
+// seriesIdentity returns the key a container's samples are grouped under. With the name and
+// service modes a recreated container (new ID, same name or compose service) continues the
+// series of its predecessor instead of starting a new one on every deploy.
+func seriesIdentity(mode string, m ContainerMetric, labels map[string]string) string {
+	switch mode {
+	case IdentityService:
+		if service := labels["composeService"]; service != "" {
+			return "service:" + labels["composeProject"] + "/" + service
+		}
+		return "name:" + m.ContainerName
+	case IdentityName:
+		return "name:" + m.ContainerName
+	}
+	return m.ContainerID
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// agentInstanceHeader carries the agent's instance ID on every response
+const agentInstanceHeader = "X-Agent-Instance-Id"
+
+// agentInstances remembers which agent instances answered for each host, so two hosts that
+// reach the same agent (a typo'd duplicate, or a discovered host that is also configured
+// under another URL) can be detected
+type agentInstances struct {
+	mu   sync.Mutex
+	seen map[string]map[string]string // host ID -> replica URL -> instance ID
+}
+
+func newAgentInstances() *agentInstances {
+	return &agentInstances{seen: make(map[string]map[string]string)}
+}
+
+// record notes the instance ID from an agent response. Responses from the host's fallback
+// agent are ignored, since that agent legitimately serves another host too.
+func (a *agentInstances) record(host HostConfig, baseURL string, resp *http.Response) {
+	instance := resp.Header.Get(agentInstanceHeader)
+	if instance == "" || fallbackURL(host, baseURL) != "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen[host.ID] == nil {
+		a.seen[host.ID] = make(map[string]string)
+	}
+	a.seen[host.ID][normalizeHostURL(baseURL)] = instance
+}
+
+// instances returns the instance IDs last seen on the host's current replicas, in stable order
+func (a *agentInstances) instances(host HostConfig) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]string, 0)
+	for _, replica := range hostReplicas(host) {
+		if instance, ok := a.seen[host.ID][normalizeHostURL(replica)]; ok && !contains(result, instance) {
+			result = append(result, instance)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// duplicateAgents maps hosts that reach the same agent instance as an earlier host in the
+// list to that earlier host. Earlier hosts win, so configured hosts beat discovered ones.
+func (d *Datasource) duplicateAgents(hosts []HostConfig) map[string]HostConfig {
+	owners := make(map[string]HostConfig)
+	duplicates := make(map[string]HostConfig)
+	for _, h := range hosts {
+		ids := d.instances.instances(h)
+		for _, id := range ids {
+			if owner, ok := owners[id]; ok {
+				duplicates[h.ID] = owner
+				break
+			}
+		}
+		if _, ok := duplicates[h.ID]; ok {
+			continue
+		}
+		for _, id := range ids {
+			owners[id] = h
+		}
+	}
+	return duplicates
+}
+
+// duplicateAgentWarnings describes hosts skipped because they duplicate another host's agent
+func duplicateAgentWarnings(hosts []HostConfig, duplicates map[string]HostConfig) []string {
+	warnings := make([]string, 0, len(duplicates))
+	for _, h := range hosts {
+		if owner, ok := duplicates[h.ID]; ok {
+			warnings = append(warnings, fmt.Sprintf("host %s reaches the same agent as %s and is skipped", h.Name, owner.Name))
+		}
+	}
+	return warnings
+}
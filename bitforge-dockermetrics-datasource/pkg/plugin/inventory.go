@@ -0,0 +1,362 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// maxInventorySnapshots caps the in-memory history per host when no retention store is configured
+	maxInventorySnapshots = 1440
+	// inventoryLiveWindow is how close to now a time point is answered with the live container list
+	inventoryLiveWindow = time.Minute
+)
+
+// Changes an "inventoryDiff" query reports
+const (
+	inventoryAdded        = "added"
+	inventoryRemoved      = "removed"
+	inventoryImageChanged = "imageChanged"
+	inventoryRecreated    = "recreated" // same name and image, new container ID
+)
+
+var inventoryBucket = []byte("inventory")
+
+// inventoryEntry is one container of a recorded container list
+type inventoryEntry struct {
+	ContainerID   string `json:"containerId"`
+	ContainerName string `json:"containerName"`
+	Image         string `json:"image"`
+}
+
+// inventorySnapshot is a host's container list as fetched at Time
+type inventorySnapshot struct {
+	Time       time.Time        `json:"time"`
+	Containers []inventoryEntry `json:"containers"`
+}
+
+// inventoryOf takes a fetched container list, sorted by ID so unchanged lists compare equal
+func inventoryOf(containers []ContainerInfo) []inventoryEntry {
+	entries := make([]inventoryEntry, len(containers))
+	for i, c := range containers {
+		entries[i] = inventoryEntry{ContainerID: c.ContainerID, ContainerName: c.ContainerName, Image: c.Image}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ContainerID < entries[j].ContainerID })
+	return entries
+}
+
+func sameInventory(a, b []inventoryEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inventoryHistory keeps recent container lists per host in memory (see sharedState). Only
+// lists that differ from the previous one are kept, so the history spans long quiet periods.
+type inventoryHistory struct {
+	mu        sync.Mutex
+	snapshots map[string][]inventorySnapshot // host ID -> snapshots, oldest first
+}
+
+func newInventoryHistory() *inventoryHistory {
+	return &inventoryHistory{snapshots: make(map[string][]inventorySnapshot)}
+}
+
+// add records a container list, reporting whether it differs from the previous one
+func (h *inventoryHistory) add(hostID string, s inventorySnapshot) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshots := h.snapshots[hostID]
+	if n := len(snapshots); n > 0 && sameInventory(snapshots[n-1].Containers, s.Containers) {
+		return false
+	}
+	snapshots = append(snapshots, s)
+	if len(snapshots) > maxInventorySnapshots {
+		snapshots = snapshots[len(snapshots)-maxInventorySnapshots:]
+	}
+	h.snapshots[hostID] = snapshots
+	return true
+}
+
+// at returns the newest snapshot taken at or before t
+func (h *inventoryHistory) at(hostID string, t time.Time) (inventorySnapshot, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshots := h.snapshots[hostID]
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].Time.After(t) })
+	if i == 0 {
+		return inventorySnapshot{}, false
+	}
+	return snapshots[i-1], true
+}
+
+// putInventory persists a container list under inventory/<hostId>/<unix nanos>
+func (s *retentionStore) putInventory(hostID string, snapshot inventorySnapshot) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(inventoryBucket)
+		if err != nil {
+			return err
+		}
+		hostBucket, err := root.CreateBucketIfNotExists([]byte(hostID))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		return hostBucket.Put(timeKey(snapshot.Time), value)
+	})
+}
+
+// inventoryAt returns the newest persisted container list taken at or before t
+func (s *retentionStore) inventoryAt(hostID string, t time.Time) (inventorySnapshot, bool, error) {
+	var snapshot inventorySnapshot
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(inventoryBucket)
+		if root == nil || root.Bucket([]byte(hostID)) == nil {
+			return nil
+		}
+		c := root.Bucket([]byte(hostID)).Cursor()
+		k, value := c.Seek(timeKey(t.Add(time.Nanosecond)))
+		if k == nil {
+			k, value = c.Last()
+		} else {
+			k, value = c.Prev()
+		}
+		if k == nil {
+			return nil
+		}
+		if err := json.Unmarshal(value, &snapshot); err != nil {
+			return err
+		}
+		found = true
+		return nil
+	})
+	return snapshot, found, err
+}
+
+// pruneInventory removes container lists older than cutoff, keeping the newest of them per
+// host since it is still the inventory at the cutoff
+func (s *retentionStore) pruneInventory(cutoff time.Time) (int, error) {
+	removed := 0
+	limit := string(timeKey(cutoff))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(inventoryBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEachBucket(func(hostID []byte) error {
+			c := root.Bucket(hostID).Cursor()
+			var stale [][]byte
+			for k, _ := c.First(); k != nil && string(k) < limit; k, _ = c.Next() {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			if len(stale) > 0 {
+				stale = stale[:len(stale)-1]
+			}
+			for _, k := range stale {
+				if err := root.Bucket(hostID).Delete(k); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+	})
+	return removed, err
+}
+
+// recordInventory keeps a freshly fetched container list of a host, before relabeling,
+// exclusions and scopes, which are applied when the history is read
+func (d *Datasource) recordInventory(host HostConfig, containers []ContainerInfo) {
+	snapshot := inventorySnapshot{Time: time.Now(), Containers: inventoryOf(containers)}
+	if !d.inventory.add(host.ID, snapshot) || d.retention == nil {
+		return
+	}
+	if err := d.retention.putInventory(host.ID, snapshot); err != nil {
+		d.logger.Error("Failed to persist container inventory", "host", host.Name, "error", err)
+	}
+}
+
+// inventoryAt returns a host's containers at t: the live list close to now, otherwise the
+// newest recorded list taken at or before t, from the retention store when it is enabled
+func (d *Datasource) inventoryAt(ctx context.Context, host HostConfig, t time.Time) ([]ContainerInfo, error) {
+	if time.Since(t) < inventoryLiveWindow {
+		return d.fetchContainersFromHost(ctx, host)
+	}
+
+	snapshot, ok := d.inventory.at(host.ID, t)
+	if d.retention != nil {
+		stored, found, err := d.retention.inventoryAt(host.ID, t)
+		if err != nil {
+			d.logger.Warn("Failed to read container inventory", "host", host.Name, "error", err)
+		} else if found && (!ok || stored.Time.After(snapshot.Time)) {
+			snapshot, ok = stored, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("no container inventory recorded before %s", t.UTC().Format(time.RFC3339))
+	}
+
+	containers := make([]ContainerInfo, len(snapshot.Containers))
+	for i, e := range snapshot.Containers {
+		containers[i] = ContainerInfo{ContainerID: e.ContainerID, ContainerName: e.ContainerName, Image: e.Image}
+	}
+	return relabelContainers(host, d.excludeContainers(ctx, scopeContainers(ctx, host, containers))), nil
+}
+
+// inventoryChange is one row of an "inventoryDiff" query
+type inventoryChange struct {
+	host                    HostConfig
+	containerName, change   string
+	containerID, previousID string
+	image, previousImage    string
+}
+
+// diffInventory compares two container lists by name, since recreated containers keep their
+// name but not their ID
+func diffInventory(host HostConfig, before, after []ContainerInfo) []inventoryChange {
+	previous := make(map[string]ContainerInfo, len(before))
+	for _, c := range before {
+		previous[c.ContainerName] = c
+	}
+
+	changes := make([]inventoryChange, 0)
+	seen := make(map[string]bool, len(after))
+	for _, c := range after {
+		seen[c.ContainerName] = true
+		change := inventoryChange{host: host, containerName: c.ContainerName, containerID: c.ContainerID, image: c.Image}
+		p, ok := previous[c.ContainerName]
+		switch {
+		case !ok:
+			change.change = inventoryAdded
+		case p.Image != c.Image:
+			change.change = inventoryImageChanged
+		case p.ContainerID != c.ContainerID:
+			change.change = inventoryRecreated
+		default:
+			continue
+		}
+		change.previousID, change.previousImage = p.ContainerID, p.Image
+		changes = append(changes, change)
+	}
+	for _, c := range before {
+		if !seen[c.ContainerName] {
+			changes = append(changes, inventoryChange{host: host, containerName: c.ContainerName, change: inventoryRemoved, previousID: c.ContainerID, previousImage: c.Image})
+		}
+	}
+	return changes
+}
+
+// queryInventoryDiff returns the containers added, removed, recreated or moved to another
+// image on each selected host between the start and the end of the query range, for
+// change-review panels. Past inventories come from the recorded container lists.
+func (d *Datasource) queryInventoryDiff(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	changes := make([]inventoryChange, 0)
+	notices := make([]data.Notice, 0)
+	var lastErr error
+	diffed := 0
+	for _, host := range hosts {
+		before, err := d.inventoryAt(ctx, host, query.TimeRange.From)
+		var after []ContainerInfo
+		if err == nil {
+			after, err = d.inventoryAt(ctx, host, query.TimeRange.To)
+		}
+		if err != nil {
+			d.logHostError(host, "Failed to diff container inventory of host", err)
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+		diffed++
+		for _, c := range diffInventory(host, before, after) {
+			if containerPattern == nil || containerPattern.MatchString(c.containerName) {
+				changes = append(changes, c)
+			}
+		}
+	}
+	if diffed == 0 {
+		response.Error = lastErr
+		return response
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].host.Name != changes[j].host.Name {
+			return changes[i].host.Name < changes[j].host.Name
+		}
+		return changes[i].containerName < changes[j].containerName
+	})
+
+	n := len(changes)
+	hostIDs := make([]string, n)
+	hostNames := make([]string, n)
+	names := make([]string, n)
+	kinds := make([]string, n)
+	ids := make([]string, n)
+	previousIDs := make([]string, n)
+	images := make([]string, n)
+	previousImages := make([]string, n)
+	for i, c := range changes {
+		hostIDs[i] = c.host.ID
+		hostNames[i] = c.host.Name
+		names[i] = c.containerName
+		kinds[i] = c.change
+		ids[i] = c.containerID
+		previousIDs[i] = c.previousID
+		images[i] = c.image
+		previousImages[i] = c.previousImage
+	}
+
+	frame := data.NewFrame("inventoryDiff",
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("hostName", nil, hostNames),
+		data.NewField("containerName", nil, names),
+		data.NewField("change", nil, kinds),
+		data.NewField("containerId", nil, ids),
+		data.NewField("previousContainerId", nil, previousIDs),
+		data.NewField("image", nil, images),
+		data.NewField("previousImage", nil, previousImages),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable, Notices: notices}
+	response.Frames = data.Frames{frame}
+	return response
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// legacyQueryKeys are the keys of queries written by the old panel and pre-hostSelections
+// query editors, plus the ones Grafana adds to every query
+var legacyQueryKeys = map[string]bool{
+	"queryType": true, "metrics": true, "metric": true,
+	"containerIds": true, "containerId": true, "containerNamePattern": true,
+	"hostIds": true, "hostId": true,
+	"controlAction": true, "targetContainer": true, "targetHost": true,
+	"refId": true, "datasource": true, "datasourceId": true, "hide": true, "key": true,
+	"intervalMs": true, "maxDataPoints": true,
+}
+
+// legacyQuery is the singular form some old panel versions stored
+type legacyQuery struct {
+	Metric      string `json:"metric"`
+	ContainerID string `json:"containerId"`
+	HostID      string `json:"hostId"`
+}
+
+// adaptLegacyQuery reports whether a query uses only the old panel's fields and folds its
+// singular metric, containerId and hostId into the current lists. Such queries get frames
+// named the old way (see legacyFrames); any newer field opts the query out.
+func adaptLegacyQuery(raw json.RawMessage, qm *QueryModel) bool {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return false
+	}
+	for k := range keys {
+		if !legacyQueryKeys[k] {
+			return false
+		}
+	}
+
+	var old legacyQuery
+	if err := json.Unmarshal(raw, &old); err != nil {
+		return false
+	}
+	if old.Metric != "" && !contains(qm.Metrics, old.Metric) {
+		qm.Metrics = append(qm.Metrics, old.Metric)
+	}
+	if old.ContainerID != "" && !contains(qm.ContainerIDs, old.ContainerID) {
+		qm.ContainerIDs = append(qm.ContainerIDs, old.ContainerID)
+	}
+	if old.HostID != "" && !contains(qm.HostIDs, old.HostID) {
+		qm.HostIDs = append(qm.HostIDs, old.HostID)
+	}
+	return true
+}
+
+// legacyFrames renames metric frames the way the old panel expects them: frame and display
+// name "<container> - <metric>" and only the containerId, containerName and hostName labels,
+// so overrides and regexes in provisioned dashboards keep matching
+func legacyFrames(frames data.Frames) {
+	for _, frame := range frames {
+		if len(frame.Fields) != 2 || frame.Fields[1].Labels["containerName"] == "" {
+			continue
+		}
+		value := frame.Fields[1]
+		name := fmt.Sprintf("%s - %s", value.Labels["containerName"], value.Name)
+		value.Labels = data.Labels{
+			"containerId":   value.Labels["containerId"],
+			"containerName": value.Labels["containerName"],
+			"hostName":      value.Labels["hostName"],
+		}
+		frame.Name = name
+		if value.Config == nil {
+			value.Config = &data.FieldConfig{}
+		}
+		value.Config.DisplayName = name
+	}
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Container states of a "lifecycle" query. The agent only samples running and paused
+// containers, so exited is time without samples.
+const (
+	lifecycleRunning = "running"
+	lifecyclePaused  = "paused"
+	lifecycleExited  = "exited"
+)
+
+// lifecycleState returns the state a sample shows
+func lifecycleState(m ContainerMetric) string {
+	switch {
+	case m.IsPaused:
+		return lifecyclePaused
+	case m.IsRunning:
+		return lifecycleRunning
+	}
+	return lifecycleExited
+}
+
+// lifecycleStats is the time a container spent per state over a query range
+type lifecycleStats struct {
+	running, paused, exited time.Duration
+	transitions             int64
+	state                   string // newest state
+}
+
+func (s *lifecycleStats) add(state string, d time.Duration) {
+	switch state {
+	case lifecycleRunning:
+		s.running += d
+	case lifecyclePaused:
+		s.paused += d
+	default:
+		s.exited += d
+	}
+}
+
+func (s *lifecycleStats) enter(state string) {
+	if s.state != "" && s.state != state {
+		s.transitions++
+	}
+	s.state = state
+}
+
+// lifecycleOf walks a container's samples (sorted by time) up to end. Each sample's state
+// lasts until the next sample; gaps longer than maxGap count as exited, as does the time after
+// the last sample when it is longer than maxGap. Time before the first sample is unknown.
+func lifecycleOf(samples []ContainerMetric, end time.Time, maxGap time.Duration) lifecycleStats {
+	var s lifecycleStats
+	for i, m := range samples {
+		state := lifecycleState(m)
+		if i > 0 {
+			span := m.Timestamp.Sub(samples[i-1].Timestamp.Time)
+			if span > maxGap {
+				s.add(lifecycleExited, span)
+				s.enter(lifecycleExited)
+			} else {
+				s.add(s.state, span)
+			}
+		}
+		s.enter(state)
+	}
+	if len(samples) == 0 {
+		return s
+	}
+	if tail := end.Sub(samples[len(samples)-1].Timestamp.Time); tail > maxGap {
+		s.add(lifecycleExited, tail)
+		s.enter(lifecycleExited)
+	} else if tail > 0 {
+		s.add(s.state, tail)
+	}
+	return s
+}
+
+// lifecycleRow is one container of a "lifecycle" query
+type lifecycleRow struct {
+	hostID, hostName           string
+	containerID, containerName string
+	stats                      lifecycleStats
+}
+
+// queryLifecycle returns one row per container with the time it spent running, paused and
+// exited over the range and its number of state transitions, for stability reports
+func (d *Datasource) queryLifecycle(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	end := query.TimeRange.To
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	maxGap := d.staleAfter()
+
+	// States are per container, aggregated series would mix them
+	qm.AggregateBy = ""
+	collected := d.collectMetrics(ctx, hosts, qm, query.TimeRange, []string{"uptimeSeconds"})
+	rows := make([]lifecycleRow, 0)
+	for _, mwh := range collected {
+		byContainer := make(map[string][]ContainerMetric)
+		for _, m := range mwh.Metrics {
+			byContainer[m.ContainerID] = append(byContainer[m.ContainerID], m)
+		}
+		for containerID, samples := range byContainer {
+			sortMetricsByTime(samples)
+			rows = append(rows, lifecycleRow{
+				hostID:        mwh.HostID,
+				hostName:      mwh.HostName,
+				containerID:   containerID,
+				containerName: samples[len(samples)-1].ContainerName,
+				stats:         lifecycleOf(samples, end, maxGap),
+			})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.hostName != b.hostName {
+			return a.hostName < b.hostName
+		}
+		if a.containerName != b.containerName {
+			return a.containerName < b.containerName
+		}
+		return a.containerID < b.containerID
+	})
+
+	n := len(rows)
+	hostIDs := make([]string, n)
+	hostNames := make([]string, n)
+	containerIDs := make([]string, n)
+	containerNames := make([]string, n)
+	running := make([]float64, n)
+	paused := make([]float64, n)
+	exited := make([]float64, n)
+	transitions := make([]int64, n)
+	availability := make([]*float64, n)
+	states := make([]string, n)
+	for i, r := range rows {
+		hostIDs[i] = r.hostID
+		hostNames[i] = r.hostName
+		containerIDs[i] = r.containerID
+		containerNames[i] = r.containerName
+		running[i] = r.stats.running.Seconds()
+		paused[i] = r.stats.paused.Seconds()
+		exited[i] = r.stats.exited.Seconds()
+		transitions[i] = r.stats.transitions
+		if observed := r.stats.running + r.stats.paused + r.stats.exited; observed > 0 {
+			p := r.stats.running.Seconds() / observed.Seconds() * 100
+			availability[i] = &p
+		}
+		states[i] = r.stats.state
+	}
+
+	withUnit := func(field *data.Field, unit string) *data.Field {
+		field.Config = &data.FieldConfig{Unit: unit}
+		return field
+	}
+	frame := data.NewFrame("lifecycle",
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("hostName", nil, hostNames),
+		data.NewField("containerId", nil, containerIDs),
+		data.NewField("containerName", nil, containerNames),
+		withUnit(data.NewField("runningSeconds", nil, running), "s"),
+		withUnit(data.NewField("pausedSeconds", nil, paused), "s"),
+		withUnit(data.NewField("exitedSeconds", nil, exited), "s"),
+		data.NewField("transitions", nil, transitions),
+		withUnit(data.NewField("runningPercent", nil, availability), "percent"),
+		data.NewField("lastState", nil, states),
+	)
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Custom:                 map[string]interface{}{"queryType": "lifecycle"},
+	}
+	response.Frames = data.Frames{frame}
+	reportInvalidPayloads(&response, collected)
+	return response
+}
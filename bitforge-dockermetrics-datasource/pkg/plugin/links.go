@@ -0,0 +1,27 @@
+package plugin
+
+import "github.com/grafana/grafana-plugin-sdk-go/data"
+
+// DataLink is a click-through link attached to every container series, e.g. to a drill-down
+// dashboard. URL can use Grafana's field variables such as ${__field.labels.containerId},
+// ${__field.labels.containerName} and ${__field.labels.hostName}.
+type DataLink struct {
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	TargetBlank bool   `json:"targetBlank"`
+}
+
+// containerLinks returns the configured data links for container series
+func (d *Datasource) containerLinks() []data.DataLink {
+	links := make([]data.DataLink, 0, len(d.settings.DataLinks))
+	for _, l := range d.settings.DataLinks {
+		if l.URL == "" {
+			continue
+		}
+		links = append(links, data.DataLink{Title: l.Title, URL: l.URL, TargetBlank: l.TargetBlank})
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return links
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logLevels maps the logLevel setting to SDK levels
+var logLevels = map[string]log.Level{
+	"debug": log.Debug,
+	"info":  log.Info,
+	"warn":  log.Warn,
+	"error": log.Error,
+}
+
+// defaultLogLevel keeps per-query debug output out of production logs unless asked for
+const defaultLogLevel = log.Info
+
+// levelLogger drops messages below the datasource's configured level. Grafana's own plugin
+// log level still applies on top.
+type levelLogger struct {
+	next  log.Logger
+	level log.Level
+}
+
+// newLevelLogger wraps logger with the level named by setting ("debug", "info", "warn", "error")
+func newLevelLogger(logger log.Logger, setting string) log.Logger {
+	level, ok := logLevels[setting]
+	if !ok {
+		level = defaultLogLevel
+	}
+	return &levelLogger{next: logger, level: level}
+}
+
+func (l *levelLogger) Debug(msg string, args ...interface{}) {
+	if l.level <= log.Debug {
+		l.next.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, args ...interface{}) {
+	if l.level <= log.Info {
+		l.next.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, args ...interface{}) {
+	if l.level <= log.Warn {
+		l.next.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, args ...interface{}) {
+	l.next.Error(msg, args...)
+}
+
+func (l *levelLogger) With(args ...interface{}) log.Logger {
+	return &levelLogger{next: l.next.With(args...), level: l.level}
+}
+
+func (l *levelLogger) Level() log.Level {
+	return l.level
+}
+
+func (l *levelLogger) FromContext(ctx context.Context) log.Logger {
+	return &levelLogger{next: l.next.FromContext(ctx), level: l.level}
+}
+
+type requestIDKey struct{}
+
+// withRequestID tags a query's context with an ID for its log lines and frames: the trace ID
+// when Grafana traces the request, otherwise a random one
+func withRequestID(ctx context.Context) (context.Context, string) {
+	id := ""
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		id = sc.TraceID().String()
+	} else {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// requestID returns the ID set by withRequestID, or ""
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// log returns the datasource logger, carrying the query's request ID when there is one
+func (d *Datasource) log(ctx context.Context) log.Logger {
+	if id := requestID(ctx); id != "" {
+		return d.logger.With("requestId", id)
+	}
+	return d.logger
+}
+
+// tagFrames records the request ID and plugin version in each frame's custom meta, so a
+// panel's "Query inspector" can be matched with backend log lines and the plugin release
+func tagFrames(frames data.Frames, id string) {
+	for _, f := range frames {
+		if f.Meta == nil {
+			f.Meta = &data.FrameMeta{}
+		}
+		custom, ok := f.Meta.Custom.(map[string]interface{})
+		if !ok {
+			if f.Meta.Custom != nil {
+				continue
+			}
+			custom = make(map[string]interface{})
+		}
+		custom["requestId"] = id
+		custom["pluginVersion"] = Version
+		f.Meta.Custom = custom
+	}
+}
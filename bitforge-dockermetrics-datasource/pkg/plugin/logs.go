@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultLogLimit = 1000
+	maxLogLimit     = 5000
+)
+
+// logLevelPattern finds the level keyword of a log line, e.g. "level=warn" or "[ERROR]"
+var logLevelPattern = regexp.MustCompile(`(?i)\b(fatal|panic|crit|critical|error|err|warn|warning|info|debug|trace)\b`)
+
+// logLevelNames maps level keywords to the levels Grafana's logs panel knows
+var logLevelNames = map[string]string{
+	"fatal": "critical", "panic": "critical", "crit": "critical", "critical": "critical",
+	"error": "error", "err": "error",
+	"warn": "warning", "warning": "warning",
+	"info":  "info",
+	"debug": "debug",
+	"trace": "trace",
+}
+
+// LogEntry is one stdout/stderr line as returned by the agent's /api/logs endpoint
+type LogEntry struct {
+	ContainerID   string     `json:"containerId"`
+	ContainerName string     `json:"containerName"`
+	Timestamp     SampleTime `json:"timestamp"`
+	Stream        string     `json:"stream"` // stdout or stderr
+	Message       string     `json:"message"`
+}
+
+// LogsResponse from the agent API
+type LogsResponse struct {
+	Logs []LogEntry `json:"logs"`
+}
+
+// logLevel returns the level keyword found in the line, otherwise error for stderr and info
+// for stdout
+func logLevel(entry LogEntry) string {
+	if m := logLevelPattern.FindStringSubmatch(entry.Message); m != nil {
+		return logLevelNames[strings.ToLower(m[1])]
+	}
+	if entry.Stream == "stderr" {
+		return "error"
+	}
+	return "info"
+}
+
+// logLimit returns the query's line limit, default 1000 and at most 5000
+func logLimit(qm QueryModel) int {
+	switch {
+	case qm.LogLimit <= 0:
+		return defaultLogLimit
+	case qm.LogLimit > maxLogLimit:
+		return maxLogLimit
+	}
+	return qm.LogLimit
+}
+
+// fetchLogsFromHost fetches up to limit log lines, newest first, correcting the host's clock
+// skew like metrics
+func (d *Datasource) fetchLogsFromHost(ctx context.Context, host HostConfig, timeRange backend.TimeRange, containerIDs []string, limit int) ([]LogEntry, error) {
+	skew := d.skewCorrection(host)
+	timeRange = shiftTimeRange(timeRange, skew)
+
+	params := url.Values{}
+	params.Set("from", timeRange.From.Format(time.RFC3339))
+	params.Set("to", timeRange.To.Format(time.RFC3339))
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("timestamps", "epoch")
+	if len(containerIDs) > 0 {
+		params.Set("containerIds", strings.Join(containerIDs, ","))
+	}
+
+	resp, _, err := d.doHostRequest(ctx, host, http.MethodGet, "/api/logs?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var logsResp LogsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&logsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if logsResp.Logs == nil {
+		return nil, &payloadError{host: host.Name, field: "logs", reason: "is missing"}
+	}
+
+	loc := hostLocation(host)
+	entries := logsResp.Logs[:0]
+	for _, e := range logsResp.Logs {
+		if e.Timestamp.IsZero() {
+			continue
+		}
+		t := e.Timestamp.Time
+		if e.Timestamp.zoneless {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+		e.Timestamp = SampleTime{Time: t.UTC().Add(-skew)}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// queryLogs returns container stdout/stderr as a logs frame (timestamp, body, level, labels),
+// newest first. Hosts whose agent doesn't serve logs are skipped with a notice.
+func (d *Datasource) queryLogs(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	if !d.featureEnabled(FeatureLogs) {
+		response.Error = fmt.Errorf("container logs are disabled, enable the %q feature toggle", FeatureLogs)
+		return response
+	}
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	limit := logLimit(qm)
+	type hostEntry struct {
+		LogEntry
+		labels json.RawMessage
+	}
+	entries := make([]hostEntry, 0)
+	notices := make([]data.Notice, 0)
+	failed := 0
+	var lastErr error
+	for _, host := range hosts {
+		if !d.getHostCapabilities(ctx, host).SupportsLogs {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s agent does not serve container logs", host.Name),
+			})
+			continue
+		}
+
+		logs, err := d.fetchLogsFromHost(ctx, host, query.TimeRange, qm.ContainerIDs, limit)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch logs from host", err)
+			failed++
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+
+		hostLabels := d.hostLabels(ctx, host)
+		containerLabels := d.containerLabelsForHost(ctx, host)
+		scope := queryScopeFrom(ctx)
+		exclusions := d.exclusionsFor(ctx)
+		for _, e := range logs {
+			if containerPattern != nil && !containerPattern.MatchString(e.ContainerName) {
+				continue
+			}
+			if !scope.allowsContainer(e.ContainerID, e.ContainerName) || excludedName(exclusions, e.ContainerName) {
+				continue
+			}
+			labels := map[string]string{}
+			for k, v := range hostLabels {
+				labels[k] = v
+			}
+			for k, v := range containerLabels[e.ContainerID] {
+				labels[k] = v
+			}
+			labels["hostName"] = host.Name
+			labels["containerId"] = e.ContainerID
+			labels["containerName"] = e.ContainerName
+			labels["stream"] = e.Stream
+			raw, err := json.Marshal(labels)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, hostEntry{LogEntry: e, labels: raw})
+		}
+	}
+
+	if failed == len(hosts) {
+		response.Error = lastErr
+		return response
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.After(entries[j].Timestamp.Time) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	times := make([]time.Time, len(entries))
+	bodies := make([]string, len(entries))
+	levels := make([]string, len(entries))
+	labels := make([]json.RawMessage, len(entries))
+	for i, e := range entries {
+		times[i] = e.Timestamp.Time
+		bodies[i] = e.Message
+		levels[i] = logLevel(e.LogEntry)
+		labels[i] = e.labels
+	}
+
+	frame := data.NewFrame("logs",
+		data.NewField("timestamp", nil, times),
+		data.NewField("body", nil, bodies),
+		data.NewField("level", nil, levels),
+		data.NewField("labels", nil, labels),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeLogs, Notices: notices}
+	response.Frames = data.Frames{frame}
+	return response
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// MaintenanceWindow marks a host as under planned maintenance
+type MaintenanceWindow struct {
+	HostID string     `json:"hostId"`
+	Reason string     `json:"reason,omitempty"`
+	SetBy  string     `json:"setBy,omitempty"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"` // nil = until cleared
+}
+
+// maintenanceStore holds maintenance windows for one datasource (see sharedState)
+type maintenanceStore struct {
+	mu      sync.Mutex
+	windows map[string]MaintenanceWindow
+}
+
+func newMaintenanceStore() *maintenanceStore {
+	return &maintenanceStore{windows: make(map[string]MaintenanceWindow)}
+}
+
+// active reports whether the host is in maintenance, dropping expired windows
+func (s *maintenanceStore) active(hostID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[hostID]
+	if !ok {
+		return false
+	}
+	if w.Until != nil && time.Now().After(*w.Until) {
+		delete(s.windows, hostID)
+		return false
+	}
+	return true
+}
+
+func (s *maintenanceStore) set(w MaintenanceWindow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows[w.HostID] = w
+}
+
+func (s *maintenanceStore) clear(hostID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.windows[hostID]; !ok {
+		return false
+	}
+	delete(s.windows, hostID)
+	return true
+}
+
+func (s *maintenanceStore) list() []MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result := make([]MaintenanceWindow, 0, len(s.windows))
+	for id, w := range s.windows {
+		if w.Until != nil && now.After(*w.Until) {
+			delete(s.windows, id)
+			continue
+		}
+		result = append(result, w)
+	}
+	return result
+}
+
+type alertQueryKey struct{}
+
+// withAlertQuery marks the context as serving a Grafana alert rule evaluation
+func withAlertQuery(ctx context.Context, fromAlert bool) context.Context {
+	return context.WithValue(ctx, alertQueryKey{}, fromAlert)
+}
+
+func isAlertQuery(ctx context.Context) bool {
+	fromAlert, _ := ctx.Value(alertQueryKey{}).(bool)
+	return fromAlert
+}
+
+// excludeMaintenanceForAlerts drops hosts in maintenance when the query feeds an alert rule,
+// so planned downtime does not fire alerts
+func (d *Datasource) excludeMaintenanceForAlerts(ctx context.Context, hosts []HostConfig) []HostConfig {
+	if !isAlertQuery(ctx) {
+		return hosts
+	}
+	result := make([]HostConfig, 0, len(hosts))
+	for _, h := range hosts {
+		if d.maintenance.active(h.ID) {
+			continue
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+// noticeURL suppresses per-host frame annotations while the host is in maintenance
+func (d *Datasource) noticeURL(host HostConfig, u string) string {
+	if d.maintenance.active(host.ID) {
+		return ""
+	}
+	return u
+}
+
+// logHostError logs a per-host failure, downgraded to Debug while the host is in maintenance
+func (d *Datasource) logHostError(host HostConfig, msg string, err error) {
+	if d.maintenance.active(host.ID) {
+		d.logger.Debug(msg, "host", host.Name, "url", host.URL, "error", err, "maintenance", true)
+		return
+	}
+	d.logger.Error(msg, "host", host.Name, "url", host.URL, "error", err)
+}
+
+// handleMaintenance lists windows (GET /maintenance), starts one (POST /maintenance/{hostId}
+// with optional {reason, durationSeconds}) or ends one (DELETE /maintenance/{hostId}).
+// Changing maintenance requires the Editor or Admin role.
+func (d *Datasource) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	hostID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/maintenance"), "/")
+
+	if hostID == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, d.maintenance.list())
+		return
+	}
+
+	if !isEditorRequest(r) {
+		writeError(w, http.StatusForbidden, "changing maintenance requires the Editor or Admin role")
+		return
+	}
+	if _, ok := d.hosts.find(hostID); !ok {
+		writeError(w, http.StatusNotFound, "host not found: "+hostID)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			Reason          string `json:"reason"`
+			DurationSeconds int    `json:"durationSeconds"`
+		}
+		if r.Body != nil && r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+		}
+
+		window := MaintenanceWindow{
+			HostID: hostID,
+			Reason: body.Reason,
+			Since:  time.Now(),
+		}
+		if user := httpadapter.UserFromContext(r.Context()); user != nil {
+			window.SetBy = user.Login
+		}
+		if body.DurationSeconds > 0 {
+			until := window.Since.Add(time.Duration(body.DurationSeconds) * time.Second)
+			window.Until = &until
+		}
+
+		d.maintenance.set(window)
+		d.logger.Info("Host maintenance started", "hostId", hostID, "setBy", window.SetBy, "until", window.Until)
+		writeJSON(w, http.StatusOK, window)
+
+	case http.MethodDelete:
+		if !d.maintenance.clear(hostID) {
+			writeError(w, http.StatusNotFound, "host is not in maintenance: "+hostID)
+			return
+		}
+		d.logger.Info("Host maintenance ended", "hostId", hostID)
+		writeJSON(w, http.StatusOK, map[string]string{"hostId": hostID})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+// nonFiniteLiterals are the strings JSON encoders that allow them (System.Text.Json with
+// named floating point literals) write for numbers JSON can't represent, e.g. a CPU percentage
+// computed without a previous sample. They decode to NaN and infinities, which become nulls.
+var nonFiniteLiterals = map[string]float64{
+	`"NaN"`:       math.NaN(),
+	`"Infinity"`:  math.Inf(1),
+	`"-Infinity"`: math.Inf(-1),
+}
+
+// isFinite reports whether v is neither NaN nor an infinity
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// nonFiniteLiteral returns the string written for a non-finite v
+func nonFiniteLiteral(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return `"NaN"`
+	case v > 0:
+		return `"Infinity"`
+	}
+	return `"-Infinity"`
+}
+
+// decodeNonFinite runs decode on an object whose float fields (by JSON name) may hold
+// non-finite literals, which are stored in fields after the rest is decoded
+func decodeNonFinite(b []byte, fields map[string]*float64, decode func([]byte) error) error {
+	if !bytes.Contains(b, []byte(`NaN"`)) && !bytes.Contains(b, []byte(`Infinity"`)) {
+		return decode(b)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return decode(b)
+	}
+	found := make(map[string]float64)
+	for name, value := range raw {
+		if v, ok := nonFiniteLiterals[string(bytes.TrimSpace(value))]; ok && fields[name] != nil {
+			found[name] = v
+			delete(raw, name)
+		}
+	}
+	if len(found) == 0 {
+		return decode(b)
+	}
+	stripped, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := decode(stripped); err != nil {
+		return err
+	}
+	for name, v := range found {
+		*fields[name] = v
+	}
+	return nil
+}
+
+// encodeNonFinite runs encode on a copy whose non-finite fields were zeroed by zero, then
+// writes the literals of those fields into the object
+func encodeNonFinite(fields map[string]*float64, encode func() ([]byte, error)) ([]byte, error) {
+	found := make(map[string]float64)
+	for name, field := range fields {
+		if !isFinite(*field) {
+			found[name] = *field
+			*field = 0
+		}
+	}
+	b, err := encode()
+	if err != nil || len(found) == 0 {
+		return b, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	for name, v := range found {
+		raw[name] = json.RawMessage(nonFiniteLiteral(v))
+	}
+	return json.Marshal(raw)
+}
+
+// floatFields maps the JSON names of m's float fields to the fields
+func (m *ContainerMetric) floatFields() map[string]*float64 {
+	return map[string]*float64{
+		"cpuPercent":     &m.CPUPercent,
+		"memoryBytes":    &m.MemoryBytes,
+		"memoryPercent":  &m.MemoryPercent,
+		"networkRxBytes": &m.NetworkRxBytes,
+		"networkTxBytes": &m.NetworkTxBytes,
+		"diskReadBytes":  &m.DiskReadBytes,
+		"diskWriteBytes": &m.DiskWriteBytes,
+		"uptimeSeconds":  &m.UptimeSeconds,
+	}
+}
+
+type plainContainerMetric ContainerMetric
+
+// UnmarshalJSON leaves metrics the sample doesn't carry, or carries as null, NaN, so they
+// read as no value instead of a real 0
+func (m *ContainerMetric) UnmarshalJSON(b []byte) error {
+	fields := m.floatFields()
+	for _, field := range fields {
+		*field = math.NaN()
+	}
+	return decodeNonFinite(b, fields, func(b []byte) error {
+		return json.Unmarshal(b, (*plainContainerMetric)(m))
+	})
+}
+
+func (m ContainerMetric) MarshalJSON() ([]byte, error) {
+	return encodeNonFinite(m.floatFields(), func() ([]byte, error) {
+		return json.Marshal(plainContainerMetric(m))
+	})
+}
+
+// floatFields maps the JSON names of p's fields to the fields
+func (p *PSIMetrics) floatFields() map[string]*float64 {
+	return map[string]*float64{
+		"some10":  &p.Some10,
+		"some60":  &p.Some60,
+		"some300": &p.Some300,
+		"full10":  &p.Full10,
+		"full60":  &p.Full60,
+		"full300": &p.Full300,
+	}
+}
+
+type plainPSIMetrics PSIMetrics
+
+func (p *PSIMetrics) UnmarshalJSON(b []byte) error {
+	fields := p.floatFields()
+	for _, field := range fields {
+		*field = math.NaN()
+	}
+	return decodeNonFinite(b, fields, func(b []byte) error {
+		return json.Unmarshal(b, (*plainPSIMetrics)(p))
+	})
+}
+
+func (p PSIMetrics) MarshalJSON() ([]byte, error) {
+	return encodeNonFinite(p.floatFields(), func() ([]byte, error) {
+		return json.Marshal(plainPSIMetrics(p))
+	})
+}
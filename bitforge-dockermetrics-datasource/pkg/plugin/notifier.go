@@ -0,0 +1,268 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNotifierInterval      = 60 * time.Second
+	defaultRestartLoopThreshold  = 3
+	defaultRestartLoopWindowMins = 10
+)
+
+// Alert names raised by the notifier
+const (
+	alertContainerUnhealthy   = "ContainerUnhealthy"
+	alertContainerRestartLoop = "ContainerRestartLoop"
+)
+
+// NotifierSettings configures the built-in watcher that alerts on unhealthy or
+// restart-looping containers without Grafana alert rules
+type NotifierSettings struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"intervalSeconds"`
+
+	// AlertmanagerURL is the Alertmanager base URL; alerts are posted to /api/v2/alerts
+	AlertmanagerURL string `json:"alertmanagerUrl"`
+	// WebhookURL receives {"status": "firing"|"resolved", "alerts": [...]} on every change
+	WebhookURL string `json:"webhookUrl"`
+
+	// A container restart-loops when it starts RestartThreshold times within RestartWindowMinutes
+	RestartThreshold     int `json:"restartThreshold"`
+	RestartWindowMinutes int `json:"restartWindowMinutes"`
+}
+
+func (s NotifierSettings) interval() time.Duration {
+	if s.IntervalSeconds > 0 {
+		return time.Duration(s.IntervalSeconds) * time.Second
+	}
+	return defaultNotifierInterval
+}
+
+func (s NotifierSettings) restartThreshold() int {
+	if s.RestartThreshold > 0 {
+		return s.RestartThreshold
+	}
+	return defaultRestartLoopThreshold
+}
+
+func (s NotifierSettings) restartWindow() time.Duration {
+	if s.RestartWindowMinutes > 0 {
+		return time.Duration(s.RestartWindowMinutes) * time.Minute
+	}
+	return defaultRestartLoopWindowMins * time.Minute
+}
+
+// notifierAlert is an alert in Alertmanager's v2 API format
+type notifierAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// containerWatch is what the notifier remembers about one container between ticks
+type containerWatch struct {
+	running bool
+	starts  []time.Time
+	firing  map[string]time.Time // alert name -> since
+}
+
+// startNotifier launches the container watcher if it is enabled
+func (d *Datasource) startNotifier() {
+	cfg := d.settings.Notifier
+	if !cfg.Enabled || (cfg.AlertmanagerURL == "" && cfg.WebhookURL == "") {
+		return
+	}
+
+	interval := cfg.interval()
+	d.logger.Info("Starting container notifier", "interval", interval)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		watches := make(map[string]*containerWatch)
+		for {
+			select {
+			case <-d.bgCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			d.runNotifier(cfg, interval, watches)
+		}
+	}()
+}
+
+// runNotifier evaluates every container once. Firing alerts are re-sent to Alertmanager each
+// tick so they don't time out; the webhook only hears about changes. Hosts that can't be
+// reached keep their containers' previous state.
+func (d *Datasource) runNotifier(cfg NotifierSettings, interval time.Duration, watches map[string]*containerWatch) {
+	ctx, cancel := context.WithTimeout(d.bgCtx, interval)
+	defer cancel()
+
+	now := time.Now()
+	firing := make([]notifierAlert, 0)
+	changed := make([]notifierAlert, 0)
+	resolved := make([]notifierAlert, 0)
+	seen := make(map[string]bool)
+
+	for _, host := range d.getEnabledHosts(nil) {
+		if d.maintenance.active(host.ID) {
+			continue
+		}
+		containers, err := d.fetchContainersFromHost(ctx, host)
+		if err != nil {
+			d.logger.Warn("Notifier failed to list containers", "host", host.Name, "error", err)
+			for key := range watches {
+				if strings.HasPrefix(key, host.ID+"/") {
+					seen[key] = true
+				}
+			}
+			continue
+		}
+
+		for _, c := range containers {
+			key := host.ID + "/" + c.ContainerID
+			seen[key] = true
+
+			w, ok := watches[key]
+			if !ok {
+				w = &containerWatch{running: c.IsRunning, firing: make(map[string]time.Time)}
+				watches[key] = w
+			}
+			if c.IsRunning && !w.running {
+				w.starts = append(w.starts, now)
+			}
+			w.running = c.IsRunning
+
+			cutoff := now.Add(-cfg.restartWindow())
+			for len(w.starts) > 0 && w.starts[0].Before(cutoff) {
+				w.starts = w.starts[1:]
+			}
+
+			conditions := map[string]bool{
+				alertContainerUnhealthy:   c.IsUnhealthy,
+				alertContainerRestartLoop: strings.EqualFold(c.State, "restarting") || len(w.starts) >= cfg.restartThreshold(),
+			}
+			for name, active := range conditions {
+				alert := containerAlert(name, host, c, now)
+				since, wasFiring := w.firing[name]
+				switch {
+				case active && !wasFiring:
+					w.firing[name] = now
+					changed = append(changed, alert)
+					firing = append(firing, alert)
+				case active:
+					alert.StartsAt = since
+					firing = append(firing, alert)
+				case wasFiring:
+					delete(w.firing, name)
+					alert.StartsAt = since
+					alert.EndsAt = &now
+					resolved = append(resolved, alert)
+				}
+			}
+		}
+	}
+
+	// Containers that disappeared resolve their alerts
+	for key, w := range watches {
+		if seen[key] {
+			continue
+		}
+		for name, since := range w.firing {
+			hostID, containerID, _ := strings.Cut(key, "/")
+			resolved = append(resolved, notifierAlert{
+				Labels:      map[string]string{"alertname": name, "hostId": hostID, "containerId": containerID},
+				Annotations: map[string]string{"summary": "container removed"},
+				StartsAt:    since,
+				EndsAt:      &now,
+			})
+		}
+		delete(watches, key)
+	}
+
+	if cfg.AlertmanagerURL != "" && len(firing)+len(resolved) > 0 {
+		url := strings.TrimSuffix(cfg.AlertmanagerURL, "/") + "/api/v2/alerts"
+		if err := postNotifierJSON(ctx, url, append(firing, resolved...)); err != nil {
+			d.logger.Warn("Failed to send alerts to Alertmanager", "error", err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if len(changed) > 0 {
+			if err := postNotifierJSON(ctx, cfg.WebhookURL, map[string]interface{}{"status": "firing", "alerts": changed}); err != nil {
+				d.logger.Warn("Failed to send firing alerts to webhook", "error", err)
+			}
+		}
+		if len(resolved) > 0 {
+			if err := postNotifierJSON(ctx, cfg.WebhookURL, map[string]interface{}{"status": "resolved", "alerts": resolved}); err != nil {
+				d.logger.Warn("Failed to send resolved alerts to webhook", "error", err)
+			}
+		}
+	}
+}
+
+// containerAlert builds the alert for a container condition
+func containerAlert(name string, host HostConfig, c ContainerInfo, now time.Time) notifierAlert {
+	labels := map[string]string{
+		"alertname":   name,
+		"severity":    "warning",
+		"host":        host.Name,
+		"hostId":      host.ID,
+		"container":   strings.TrimPrefix(c.ContainerName, "/"),
+		"containerId": c.ContainerID,
+	}
+	for k, v := range hostSeriesLabels(host) {
+		if _, exists := labels[k]; !exists {
+			labels[k] = v
+		}
+	}
+
+	summary := fmt.Sprintf("Container %s on %s is unhealthy", labels["container"], host.Name)
+	if name == alertContainerRestartLoop {
+		summary = fmt.Sprintf("Container %s on %s is restarting repeatedly", labels["container"], host.Name)
+	}
+
+	return notifierAlert{
+		Labels:      labels,
+		Annotations: map[string]string{"summary": summary, "state": c.State},
+		StartsAt:    now,
+	}
+}
+
+func postNotifierJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(msg))
+	}
+	return nil
+}
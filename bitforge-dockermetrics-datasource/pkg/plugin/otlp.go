@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OTLPSettings configures the OTLP/HTTP metrics exporter. An Authorization header value
+// can be stored in secureJsonData.otlpAuthorization.
+type OTLPSettings struct {
+	Enabled  bool              `json:"enabled"`
+	Endpoint string            `json:"endpoint"` // e.g. http://otel-collector:4318
+	Headers  map[string]string `json:"headers"`
+}
+
+// otlpUnits maps metrics to UCUM units
+var otlpUnits = map[string]string{
+	"cpuPercent":         "%",
+	"memoryBytes":        "By",
+	"memoryPercent":      "%",
+	"networkRxBytes":     "By",
+	"networkTxBytes":     "By",
+	"diskReadBytes":      "By",
+	"diskWriteBytes":     "By",
+	"uptimeSeconds":      "s",
+	"cpuPressureSome":    "%",
+	"cpuPressureFull":    "%",
+	"memoryPressureSome": "%",
+	"memoryPressureFull": "%",
+	"ioPressureSome":     "%",
+	"ioPressureFull":     "%",
+}
+
+// otlpExporter pushes samples as OTLP/HTTP JSON, one resource per container
+type otlpExporter struct {
+	url           string
+	headers       map[string]string
+	authorization string
+}
+
+func newOTLPExporter(s OTLPSettings, secrets map[string]string) *otlpExporter {
+	endpoint := strings.TrimSuffix(s.Endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/metrics") {
+		endpoint += "/v1/metrics"
+	}
+	return &otlpExporter{
+		url:           endpoint,
+		headers:       s.Headers,
+		authorization: secrets["otlpAuthorization"],
+	}
+}
+
+func (e *otlpExporter) name() string {
+	return "otlp"
+}
+
+// OTLP JSON encoding of ExportMetricsServiceRequest (only the parts used here)
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   map[string]string `json:"scope"`
+	Metrics []otlpMetric      `json:"metrics"`
+}
+
+// otlpMetric carries either a gauge or, for the byte counters, a cumulative sum
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+// AGGREGATION_TEMPORALITY_CUMULATIVE
+const otlpCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
+
+func (e *otlpExporter) export(ctx context.Context, batches []exportBatch) error {
+	payload, err := json.Marshal(buildOTLPRequest(batches))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	if e.authorization != "" {
+		req.Header.Set("Authorization", e.authorization)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// buildOTLPRequest groups samples into one resource per container with
+// host, container and image resource attributes
+func buildOTLPRequest(batches []exportBatch) otlpRequest {
+	request := otlpRequest{ResourceMetrics: make([]otlpResourceMetrics, 0)}
+
+	for _, batch := range batches {
+		byContainer := make(map[string][]ContainerMetric)
+		order := make([]string, 0)
+		for _, m := range batch.metrics {
+			if _, ok := byContainer[m.ContainerID]; !ok {
+				order = append(order, m.ContainerID)
+			}
+			byContainer[m.ContainerID] = append(byContainer[m.ContainerID], m)
+		}
+
+		for _, containerID := range order {
+			samples := byContainer[containerID]
+			sortMetricsByTime(samples)
+
+			attrs := []otlpAttribute{
+				stringAttribute("host.name", batch.host.Name),
+				stringAttribute("host.id", batch.host.ID),
+				stringAttribute("container.id", containerID),
+				stringAttribute("container.name", strings.TrimPrefix(samples[0].ContainerName, "/")),
+			}
+			if image := batch.images[containerID]; image != "" {
+				attrs = append(attrs, stringAttribute("container.image.name", image))
+			}
+			labelKeys := make([]string, 0, len(batch.labels))
+			for k := range batch.labels {
+				labelKeys = append(labelKeys, k)
+			}
+			sort.Strings(labelKeys)
+			for _, k := range labelKeys {
+				attrs = append(attrs, stringAttribute(k, batch.labels[k]))
+			}
+
+			metrics := make([]otlpMetric, 0, len(AllMetrics))
+			for _, metric := range AllMetrics {
+				points := make([]otlpDataPoint, 0, len(samples))
+				for _, m := range samples {
+					value, ok := rawMetricValue(m, metric)
+					if !ok {
+						continue
+					}
+					t := m.Timestamp.Time
+					points = append(points, otlpDataPoint{
+						TimeUnixNano: strconv.FormatInt(t.UnixNano(), 10),
+						AsDouble:     value,
+					})
+				}
+				if len(points) == 0 {
+					continue
+				}
+				m := otlpMetric{
+					Name: strings.Replace(exportMetricName(metric), "docker_container_", "docker.container.", 1),
+					Unit: otlpUnits[metric],
+				}
+				if contains(counterMetrics, metric) {
+					m.Sum = &otlpSum{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+				} else {
+					m.Gauge = &otlpGauge{DataPoints: points}
+				}
+				metrics = append(metrics, m)
+			}
+
+			request.ResourceMetrics = append(request.ResourceMetrics, otlpResourceMetrics{
+				Resource: otlpResource{Attributes: attrs},
+				ScopeMetrics: []otlpScopeMetrics{{
+					Scope:   map[string]string{"name": "bitforge-dockermetrics-datasource"},
+					Metrics: metrics,
+				}},
+			})
+		}
+	}
+	return request
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}}
+}
@@ -0,0 +1,39 @@
+package plugin
+
+// MetricFormat overrides how a metric is presented: values are multiplied by Scale and shown
+// with Decimals decimal places in Unit (Grafana unit ID). Zero values keep the defaults.
+type MetricFormat struct {
+	Decimals *uint16 `json:"decimals"`
+	Scale    float64 `json:"scale"`
+	Unit     string  `json:"unit"`
+}
+
+// defaultDecimals are the decimal places per unit when a metric has no override
+var defaultDecimals = map[string]uint16{
+	"percent":   1,
+	"decmbytes": 1,
+	"s":         0,
+}
+
+// metricFormat returns the scale factor, unit and decimals for a metric
+func (d *Datasource) metricFormat(metric string) (scale float64, unit string, decimals *uint16) {
+	scale, unit = 1, metricUnits[metric]
+	if dec, ok := defaultDecimals[unit]; ok {
+		decimals = &dec
+	}
+
+	f, ok := d.settings.MetricFormats[metric]
+	if !ok {
+		return scale, unit, decimals
+	}
+	if f.Scale != 0 {
+		scale = f.Scale
+	}
+	if f.Unit != "" {
+		unit = f.Unit
+	}
+	if f.Decimals != nil {
+		decimals = f.Decimals
+	}
+	return scale, unit, decimals
+}
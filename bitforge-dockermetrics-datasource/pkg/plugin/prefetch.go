@@ -0,0 +1,274 @@
+package plugin
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	prefetchTick = time.Second
+	// prefetchLead is how long before a dashboard's expected refresh its queries are prefetched
+	prefetchLead = 3 * time.Second
+	// prefetchMaxAge is how long prefetched samples are served; newer samples are fetched on top
+	prefetchMaxAge = 2 * prefetchLead
+	// prefetchMinHits is how often a query must repeat before it is prefetched
+	prefetchMinHits = 3
+	// minRefreshInterval ignores gaps between panels of one dashboard refresh
+	minRefreshInterval = 5 * time.Second
+	// prefetchIdle forgets queries of dashboards nobody has open anymore
+	prefetchIdle = 10 * time.Minute
+	// maxTrackedQueries bounds the tracker, new queries aren't tracked beyond it
+	maxTrackedQueries = 512
+	// maxPrefetchesPerTick limits the agent requests of one tick to the most frequent queries
+	maxPrefetchesPerTick = 8
+	// prefetchNowSlack is how far before now a range may end and still count as relative to now
+	prefetchNowSlack = 5 * time.Second
+)
+
+// prefetchSpans are the dashboard time picker ranges whose queries are tracked
+var prefetchSpans = []time.Duration{
+	5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 48 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// prefetchKey identifies a repeated query of one host
+type prefetchKey struct {
+	hostID    string
+	metrics   string // sorted, comma separated
+	selection string // encoded field selection, empty outside matrix queries
+	span      time.Duration
+}
+
+// prefetchKeyFor returns the key of a fetch; ok is false unless the range is a picker range
+// ending now, the only kind a refreshing dashboard asks for again
+func prefetchKeyFor(hostID string, timeRange backend.TimeRange, metrics []string, sel *containerFieldSelection, now time.Time) (prefetchKey, bool) {
+	if timeRange.To.After(now) || now.Sub(timeRange.To) > prefetchNowSlack {
+		return prefetchKey{}, false
+	}
+	span := timeRange.To.Sub(timeRange.From)
+	for _, s := range prefetchSpans {
+		if diff := span - s; diff > -time.Second && diff < time.Second {
+			sorted := append([]string(nil), metrics...)
+			sort.Strings(sorted)
+			params := url.Values{}
+			sel.encode(params)
+			return prefetchKey{hostID: hostID, metrics: strings.Join(sorted, ","), selection: params.Encode(), span: s}, true
+		}
+	}
+	return prefetchKey{}, false
+}
+
+// prefetchEntry is what the tracker knows about one repeated query
+type prefetchEntry struct {
+	hostID    string
+	metrics   []string
+	selection *containerFieldSelection
+	hits      int
+	lastSeen  time.Time
+	interval  time.Duration // learned refresh interval, 0 until two refreshes were seen
+	fetching  bool
+
+	// Result of the last prefetch, covering span up to fetchedAt
+	samples   []ContainerMetric
+	fetchedAt time.Time
+}
+
+// prefetchJob is a prefetch the background loop runs
+type prefetchJob struct {
+	key       prefetchKey
+	hostID    string
+	metrics   []string
+	selection *containerFieldSelection
+}
+
+// prefetched is a cached result handed to a query
+type prefetched struct {
+	samples   []ContainerMetric
+	fetchedAt time.Time
+}
+
+// prefetcher tracks the instance's most frequent queries and their refresh intervals and
+// keeps the samples prefetched for them
+type prefetcher struct {
+	mu      sync.Mutex
+	entries map[prefetchKey]*prefetchEntry
+}
+
+func newPrefetcher() *prefetcher {
+	return &prefetcher{entries: make(map[prefetchKey]*prefetchEntry)}
+}
+
+// observe counts a query and returns its prefetched samples if they are recent enough
+func (p *prefetcher) observe(key prefetchKey, timeRange backend.TimeRange, metrics []string, sel *containerFieldSelection, now time.Time) (prefetched, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		if len(p.entries) >= maxTrackedQueries {
+			return prefetched{}, false
+		}
+		e = &prefetchEntry{hostID: key.hostID, metrics: append([]string(nil), metrics...), selection: sel}
+		p.entries[key] = e
+	}
+	if gap := now.Sub(e.lastSeen); !e.lastSeen.IsZero() && gap >= minRefreshInterval {
+		switch {
+		case gap > prefetchIdle:
+			e.hits, e.interval = 0, 0
+		case e.interval == 0:
+			e.interval = gap
+		default:
+			e.interval = (3*e.interval + gap) / 4
+		}
+	}
+	e.hits++
+	e.lastSeen = now
+
+	if e.samples == nil || now.Sub(e.fetchedAt) > prefetchMaxAge || e.fetchedAt.After(timeRange.To) {
+		return prefetched{}, false
+	}
+	return prefetched{samples: e.samples, fetchedAt: e.fetchedAt}, true
+}
+
+// due returns the most frequent queries whose next refresh is within prefetchLead and that
+// weren't prefetched for it yet, and forgets idle ones
+func (p *prefetcher) due(now time.Time) []prefetchJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	due := make([]*prefetchEntry, 0)
+	keys := make(map[*prefetchEntry]prefetchKey)
+	for key, e := range p.entries {
+		if now.Sub(e.lastSeen) > prefetchIdle {
+			delete(p.entries, key)
+			continue
+		}
+		if e.fetching || e.hits < prefetchMinHits || e.interval == 0 {
+			continue
+		}
+		next := e.lastSeen.Add(e.interval)
+		start := next.Add(-prefetchLead)
+		if now.Before(start) || now.After(next) || !e.fetchedAt.Before(start) {
+			continue
+		}
+		due = append(due, e)
+		keys[e] = key
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].hits > due[j].hits })
+	if len(due) > maxPrefetchesPerTick {
+		due = due[:maxPrefetchesPerTick]
+	}
+
+	jobs := make([]prefetchJob, 0, len(due))
+	for _, e := range due {
+		e.fetching = true
+		jobs = append(jobs, prefetchJob{key: keys[e], hostID: e.hostID, metrics: e.metrics, selection: e.selection})
+	}
+	return jobs
+}
+
+// done stores a prefetch result; failed prefetches keep the previous one, which expires
+func (p *prefetcher) done(key prefetchKey, result *prefetched) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	e.fetching = false
+	if result != nil {
+		e.samples, e.fetchedAt = result.samples, result.fetchedAt
+	}
+}
+
+// startPrefetch launches the prefetch loop if the feature is on
+func (d *Datasource) startPrefetch() {
+	if !d.featureEnabled(FeaturePrefetch) {
+		return
+	}
+	d.prefetches = newPrefetcher()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(prefetchTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.bgCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			d.runPrefetch(time.Now())
+		}
+	}()
+}
+
+// runPrefetch fetches the queries that are due, one after the other
+func (d *Datasource) runPrefetch(now time.Time) {
+	for _, job := range d.prefetches.due(now) {
+		host, ok := d.hosts.find(job.hostID)
+		if !ok || !host.Enabled || d.maintenance.active(host.ID) {
+			d.prefetches.done(job.key, nil)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(withFieldSelection(d.bgCtx, job.selection), d.hostTimeout())
+		timeRange := backend.TimeRange{From: now.Add(-job.key.span), To: now}
+		samples, _, err := d.fetchRetainedMetrics(ctx, host, timeRange, job.metrics)
+		cancel()
+		if err != nil {
+			d.logger.Debug("Failed to prefetch metrics", "host", host.Name, "span", job.key.span, "error", err)
+			d.prefetches.done(job.key, nil)
+			continue
+		}
+		if samples == nil {
+			samples = []ContainerMetric{}
+		}
+		d.prefetches.done(job.key, &prefetched{samples: samples, fetchedAt: now})
+	}
+}
+
+// fetchPrefetched fetches metrics for a query. With the prefetch feature on, recently
+// prefetched samples of a repeated query are served and only newer ones are fetched.
+func (d *Datasource) fetchPrefetched(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, string, error) {
+	if d.prefetches == nil {
+		return d.fetchRetainedMetrics(ctx, host, timeRange, metrics)
+	}
+	sel := fieldSelectionFrom(ctx)
+	key, ok := prefetchKeyFor(host.ID, timeRange, metrics, sel, time.Now())
+	if !ok {
+		return d.fetchRetainedMetrics(ctx, host, timeRange, metrics)
+	}
+	cached, ok := d.prefetches.observe(key, timeRange, metrics, sel, time.Now())
+	if !ok {
+		return d.fetchRetainedMetrics(ctx, host, timeRange, metrics)
+	}
+
+	recent, servedBy, err := d.fetchRetainedMetrics(ctx, host, backend.TimeRange{From: cached.fetchedAt, To: timeRange.To}, metrics)
+	if err != nil {
+		return nil, "", err
+	}
+	d.log(ctx).Debug("Serving prefetched metrics", "host", host.Name, "age", time.Since(cached.fetchedAt))
+	samples := make([]ContainerMetric, 0, len(cached.samples)+len(recent))
+	for _, m := range cached.samples {
+		if !m.Timestamp.Before(timeRange.From) {
+			samples = append(samples, m)
+		}
+	}
+	for _, m := range recent {
+		if m.Timestamp.After(cached.fetchedAt) {
+			samples = append(samples, m)
+		}
+	}
+	return samples, servedBy, nil
+}
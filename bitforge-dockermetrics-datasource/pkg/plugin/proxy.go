@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newAgentClient returns the client for agent requests. Without a proxy URL it is the shared
+// client, which honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY. An explicit proxy URL (http,
+// https or socks5, credentials in the URL) is used for all agents except NO_PROXY matches.
+func newAgentClient(proxyURL string) (*http.Client, error) {
+	if proxyURL == "" {
+		return httpClient, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return httpClient, fmt.Errorf("invalid proxy URL %q, using the environment's proxy settings", proxyURL)
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxyFunc := (&httpproxy.Config{HTTPProxy: u.String(), HTTPSProxy: u.String(), NoProxy: noProxy}).ProxyFunc()
+
+	transport := httpClient.Transport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+	return &http.Client{Timeout: httpClient.Timeout, Transport: transport}, nil
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// labelHashLength is the number of hex characters kept of a hashed label value
+const labelHashLength = 12
+
+// LabelPruning removes or shortens high-cardinality labels on emitted fields
+type LabelPruning struct {
+	Drop []string `json:"drop"` // labels removed from every field
+	Hash []string `json:"hash"` // labels whose values are replaced by a short stable hash
+}
+
+// validateLabelPruning warns about labels that are both dropped and hashed
+func validateLabelPruning(p LabelPruning) []string {
+	warnings := make([]string, 0)
+	for _, label := range p.Hash {
+		if contains(p.Drop, label) {
+			warnings = append(warnings, fmt.Sprintf("label %q is both dropped and hashed, dropping it", label))
+		}
+	}
+	return warnings
+}
+
+// hashLabelValue returns the first labelHashLength hex characters of the value's SHA-256
+func hashLabelValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:labelHashLength]
+}
+
+// pruneLabels applies the label pruning settings to every field of the frames
+func (d *Datasource) pruneLabels(frames data.Frames) {
+	p := d.settings.LabelPruning
+	if len(p.Drop) == 0 && len(p.Hash) == 0 {
+		return
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if len(field.Labels) == 0 {
+				continue
+			}
+			for _, label := range p.Drop {
+				delete(field.Labels, label)
+			}
+			for _, label := range p.Hash {
+				if value, ok := field.Labels[label]; ok {
+					field.Labels[label] = hashLabelValue(value)
+				}
+			}
+		}
+	}
+}
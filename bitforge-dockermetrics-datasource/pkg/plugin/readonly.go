@@ -0,0 +1,22 @@
+package plugin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errReadOnly is the error of every write refused while the datasource is read-only
+var errReadOnly = errors.New("datasource is read-only, write actions are disabled")
+
+// writeGuard refuses requests other than GET and HEAD while the readOnly setting is on, so
+// handlers that change hosts, registrations or maintenance can't be reached whatever the
+// caller's role
+func (d *Datasource) writeGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.settings.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusForbidden, errReadOnly.Error())
+			return
+		}
+		next(w, r)
+	}
+}
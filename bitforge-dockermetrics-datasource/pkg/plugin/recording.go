@@ -0,0 +1,425 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultRecordingInterval = 60 * time.Second
+	// maxRecordedPoints caps the in-memory history per rule when no retention store is configured
+	maxRecordedPoints = 1440
+)
+
+var recordingsBucket = []byte("recordings")
+
+// RecordingRule is a named expression evaluated on a schedule, e.g.
+// {"name": "project_cpu", "expr": "sum(cpuPercent) by (composeProject)"}
+type RecordingRule struct {
+	Name            string `json:"name"`
+	Expr            string `json:"expr"`
+	IntervalSeconds int    `json:"intervalSeconds"`
+}
+
+func (r RecordingRule) interval() time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return defaultRecordingInterval
+}
+
+// recordingExpr is a parsed rule expression: agg(metric{label="value",...}) by (label,...)
+type recordingExpr struct {
+	agg      string
+	metric   string
+	matchers map[string]string
+	by       []string
+}
+
+var (
+	recordingExprPattern    = regexp.MustCompile(`^\s*(sum|avg|min|max|count)\s*\(\s*(\w+)\s*(?:\{([^}]*)\})?\s*\)\s*(?:by\s*\(([^)]*)\))?\s*$`)
+	recordingMatcherPattern = regexp.MustCompile(`^\s*(\w+)\s*=\s*"([^"]*)"\s*$`)
+)
+
+// parseRecordingExpr parses a rule expression. Labels available for matching and grouping are
+// hostName, containerName and the host and container series labels (e.g. composeProject).
+func parseRecordingExpr(expr string) (recordingExpr, error) {
+	m := recordingExprPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return recordingExpr{}, fmt.Errorf("expression must look like sum(metric) by (label)")
+	}
+	if !contains(AllMetrics, m[2]) {
+		return recordingExpr{}, fmt.Errorf("unknown metric %q", m[2])
+	}
+
+	parsed := recordingExpr{agg: m[1], metric: m[2], matchers: make(map[string]string)}
+	if strings.TrimSpace(m[3]) != "" {
+		for _, part := range strings.Split(m[3], ",") {
+			mm := recordingMatcherPattern.FindStringSubmatch(part)
+			if mm == nil {
+				return recordingExpr{}, fmt.Errorf("invalid label matcher %q", strings.TrimSpace(part))
+			}
+			parsed.matchers[mm[1]] = mm[2]
+		}
+	}
+	for _, label := range strings.Split(m[4], ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			parsed.by = append(parsed.by, label)
+		}
+	}
+	return parsed, nil
+}
+
+// recordedSeries is one output series of a rule evaluation
+type recordedSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// recordedPoint is the result of one rule evaluation
+type recordedPoint struct {
+	Time   time.Time        `json:"time"`
+	Series []recordedSeries `json:"series"`
+}
+
+// recordingCache keeps recent rule results in memory (see sharedState)
+type recordingCache struct {
+	mu     sync.Mutex
+	points map[string][]recordedPoint // rule name -> points, oldest first
+}
+
+func newRecordingCache() *recordingCache {
+	return &recordingCache{points: make(map[string][]recordedPoint)}
+}
+
+func (c *recordingCache) add(rule string, p recordedPoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	points := append(c.points[rule], p)
+	if len(points) > maxRecordedPoints {
+		points = points[len(points)-maxRecordedPoints:]
+	}
+	c.points[rule] = points
+}
+
+func (c *recordingCache) rangeQuery(rule string, from, to time.Time) []recordedPoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]recordedPoint, 0)
+	for _, p := range c.points[rule] {
+		if !p.Time.Before(from) && p.Time.Before(to) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// putRecording persists a rule result under recordings/<rule>/<unix nanos>
+func (s *retentionStore) putRecording(rule string, p recordedPoint) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(recordingsBucket)
+		if err != nil {
+			return err
+		}
+		ruleBucket, err := root.CreateBucketIfNotExists([]byte(rule))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return ruleBucket.Put(timeKey(p.Time), value)
+	})
+}
+
+// recordingRange returns persisted rule results with from <= time < to
+func (s *retentionStore) recordingRange(rule string, from, to time.Time) ([]recordedPoint, error) {
+	result := make([]recordedPoint, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(recordingsBucket)
+		if root == nil || root.Bucket([]byte(rule)) == nil {
+			return nil
+		}
+		end := string(timeKey(to))
+		c := root.Bucket([]byte(rule)).Cursor()
+		for k, value := c.Seek(timeKey(from)); k != nil && string(k) < end; k, value = c.Next() {
+			var p recordedPoint
+			if err := json.Unmarshal(value, &p); err != nil {
+				continue
+			}
+			result = append(result, p)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// validRecordingRules parses the configured rules, returning the usable ones and a warning per invalid rule
+func validRecordingRules(rules []RecordingRule) (map[string]recordingExpr, []string) {
+	valid := make(map[string]recordingExpr)
+	warnings := make([]string, 0)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			warnings = append(warnings, "recording rule without a name is ignored")
+			continue
+		}
+		expr, err := parseRecordingExpr(rule.Expr)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("recording rule %s: %v", rule.Name, err))
+			continue
+		}
+		valid[rule.Name] = expr
+	}
+	return valid, warnings
+}
+
+// startRecordingRules launches the rule evaluation loop if any valid rule is configured.
+// Results are persisted in the retention store when it is enabled, otherwise kept in memory.
+func (d *Datasource) startRecordingRules() {
+	if len(d.recordingExprs) == 0 {
+		return
+	}
+
+	tick := time.Duration(math.MaxInt64)
+	for _, rule := range d.settings.RecordingRules {
+		if _, ok := d.recordingExprs[rule.Name]; ok && rule.interval() < tick {
+			tick = rule.interval()
+		}
+	}
+	d.logger.Info("Starting recording rules", "rules", len(d.recordingExprs), "tick", tick)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		lastRun := make(map[string]time.Time)
+		for {
+			select {
+			case <-d.bgCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			for _, rule := range d.settings.RecordingRules {
+				expr, ok := d.recordingExprs[rule.Name]
+				if !ok || now.Sub(lastRun[rule.Name]) < rule.interval() {
+					continue
+				}
+				lastRun[rule.Name] = now
+				d.evaluateRecordingRule(rule, expr, now)
+			}
+		}
+	}()
+}
+
+// evaluateRecordingRule aggregates the latest sample of every matching container
+func (d *Datasource) evaluateRecordingRule(rule RecordingRule, expr recordingExpr, now time.Time) {
+	ctx, cancel := context.WithTimeout(withContainerCache(d.bgCtx), rule.interval())
+	defer cancel()
+
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+
+	window := backend.TimeRange{From: now.Add(-rule.interval()), To: now}
+	for _, host := range d.getEnabledHosts(nil) {
+		metrics, _, err := d.fetchMetricsFromHost(ctx, host, window, []string{expr.metric})
+		if err != nil {
+			d.logHostError(host, "Failed to evaluate recording rule", err)
+			continue
+		}
+		metrics = relabelMetrics(host, metrics)
+		hostLabels := d.hostLabels(ctx, host)
+		containerLabels := d.containerLabelsForHost(ctx, host)
+
+		for _, m := range latestPerContainer(metrics) {
+			value, ok := rawMetricValue(m, expr.metric)
+			if !ok {
+				continue
+			}
+			labels := make(map[string]string)
+			for k, v := range hostLabels {
+				labels[k] = v
+			}
+			for k, v := range containerLabels[m.ContainerID] {
+				labels[k] = v
+			}
+			labels["hostName"] = host.Name
+			labels["containerName"] = m.ContainerName
+			if !matchesLabelFilters(labels, singleValueFilters(expr.matchers)) {
+				continue
+			}
+
+			groupLabels := make(map[string]string, len(expr.by))
+			keyParts := make([]string, 0, len(expr.by))
+			for _, name := range expr.by {
+				groupLabels[name] = labels[name]
+				keyParts = append(keyParts, name+"="+labels[name])
+			}
+			key := strings.Join(keyParts, ",")
+			if groups[key] == nil {
+				groups[key] = &group{labels: groupLabels}
+			}
+			groups[key].values = append(groups[key].values, value)
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	point := recordedPoint{Time: now, Series: make([]recordedSeries, 0, len(keys))}
+	for _, k := range keys {
+		g := groups[k]
+		point.Series = append(point.Series, recordedSeries{Labels: g.labels, Value: aggregateValues(expr.agg, g.values)})
+	}
+
+	d.recordings.add(rule.Name, point)
+	if d.retention != nil {
+		if err := d.retention.putRecording(rule.Name, point); err != nil {
+			d.logger.Error("Failed to persist recording rule result", "rule", rule.Name, "error", err)
+		}
+	}
+}
+
+// latestPerContainer keeps the newest sample of each container
+func latestPerContainer(metrics []ContainerMetric) []ContainerMetric {
+	latest := make(map[string]ContainerMetric)
+	for _, m := range metrics {
+		if prev, ok := latest[m.ContainerID]; !ok || m.Timestamp.After(prev.Timestamp.Time) {
+			latest[m.ContainerID] = m
+		}
+	}
+	result := make([]ContainerMetric, 0, len(latest))
+	for _, m := range latest {
+		result = append(result, m)
+	}
+	return result
+}
+
+func singleValueFilters(matchers map[string]string) map[string][]string {
+	filters := make(map[string][]string, len(matchers))
+	for k, v := range matchers {
+		filters[k] = []string{v}
+	}
+	return filters
+}
+
+func aggregateValues(agg string, values []float64) float64 {
+	if agg == "count" {
+		return float64(len(values))
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		switch agg {
+		case "sum", "avg":
+			result += v
+		case "min":
+			result = math.Min(result, v)
+		case "max":
+			result = math.Max(result, v)
+		}
+	}
+	if agg == "avg" {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// queryRecording returns the recorded series of the requested rules, one frame per series
+func (d *Datasource) queryRecording(query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+	if len(qm.Rules) == 0 {
+		response.Error = fmt.Errorf("rules is required for recording queries")
+		return response
+	}
+
+	for _, name := range qm.Rules {
+		expr, ok := d.recordingExprs[name]
+		if !ok {
+			response.Error = fmt.Errorf("unknown recording rule: %s", name)
+			return response
+		}
+
+		points := d.recordings.rangeQuery(name, query.TimeRange.From, query.TimeRange.To)
+		if d.retention != nil {
+			stored, err := d.retention.recordingRange(name, query.TimeRange.From, query.TimeRange.To)
+			if err != nil {
+				d.logger.Warn("Failed to read recorded results", "rule", name, "error", err)
+			} else {
+				points = stored
+			}
+		}
+
+		response.Frames = append(response.Frames, recordingFrames(name, expr, points)...)
+	}
+	return response
+}
+
+// recordingFrames pivots rule results into one time series frame per label set
+func recordingFrames(name string, expr recordingExpr, points []recordedPoint) data.Frames {
+	type series struct {
+		labels map[string]string
+		times  []time.Time
+		values []float64
+	}
+	bySeries := make(map[string]*series)
+	order := make([]string, 0)
+
+	for _, p := range points {
+		for _, s := range p.Series {
+			key := data.Labels(s.Labels).String()
+			if bySeries[key] == nil {
+				bySeries[key] = &series{labels: s.Labels}
+				order = append(order, key)
+			}
+			bySeries[key].times = append(bySeries[key].times, p.Time)
+			bySeries[key].values = append(bySeries[key].values, s.Value)
+		}
+	}
+	sort.Strings(order)
+
+	unit := metricUnits[expr.metric]
+	if strings.HasSuffix(expr.metric, "Bytes") {
+		unit = "bytes"
+	}
+	if expr.agg == "count" {
+		unit = "none"
+	}
+
+	frames := make(data.Frames, 0, len(order))
+	for _, key := range order {
+		s := bySeries[key]
+		labels := data.Labels{"rule": name}
+		for k, v := range s.labels {
+			labels[k] = v
+		}
+		valueField := data.NewField(name, labels, s.values)
+		valueField.Config = &data.FieldConfig{Unit: unit}
+		frames = append(frames, data.NewFrame(name, data.NewField("time", nil, s.times), valueField).
+			SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeGraph}))
+	}
+	return frames
+}
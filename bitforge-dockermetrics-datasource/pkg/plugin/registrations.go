@@ -0,0 +1,313 @@
+package plugin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registration states
+const (
+	RegistrationPending  = "pending"
+	RegistrationApproved = "approved"
+	RegistrationRejected = "rejected"
+)
+
+// Registration is an agent that registered itself through the register resource
+type Registration struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Status       string    `json:"status"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastSeen     time.Time `json:"lastSeen"`
+
+	// agentToken is the token the agent presented for itself; never returned to the frontend
+	agentToken string
+}
+
+// storedRegistration is a registration as saved to the registrations file, with its agent token
+type storedRegistration struct {
+	Registration
+	AgentToken string `json:"agentToken,omitempty"`
+}
+
+// registrationStore holds registrations for one datasource (see sharedState).
+// Once opened, every change is saved to a JSON file so registrations survive restarts.
+type registrationStore struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Registration
+}
+
+func newRegistrationStore() *registrationStore {
+	return &registrationStore{entries: make(map[string]*Registration)}
+}
+
+// registrationsPath returns the registrations file, defaulting to one file per datasource
+// in the temp directory
+func (s DatasourceSettings) registrationsPath(orgID int64, uid string) string {
+	if s.RegistrationsPath != "" {
+		return s.RegistrationsPath
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dockermetrics-registrations-%s.json", discoveredHostID("registrations", fmt.Sprintf("%d-%s", orgID, uid))))
+}
+
+// open loads the registrations saved at path and saves later changes there.
+// Registrations already held in memory are kept.
+func (s *registrationStore) open(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == path {
+		return nil
+	}
+	s.path = path
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Created by the first registration
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read registrations %s: %w", path, err)
+	}
+	var stored []storedRegistration
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return fmt.Errorf("failed to decode registrations %s: %w", path, err)
+	}
+	for _, entry := range stored {
+		if _, ok := s.entries[entry.ID]; ok {
+			continue
+		}
+		reg := entry.Registration
+		reg.agentToken = entry.AgentToken
+		s.entries[reg.ID] = &reg
+	}
+	return s.save()
+}
+
+// save writes the registrations to the store's file; s.mu must be held
+func (s *registrationStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	stored := make([]storedRegistration, 0, len(s.entries))
+	for _, reg := range s.entries {
+		stored = append(stored, storedRegistration{Registration: *reg, AgentToken: reg.agentToken})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed so a crash never leaves a truncated file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to save registrations: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save registrations: %w", err)
+	}
+	return nil
+}
+
+// upsert records a registration; re-registering the same URL refreshes it but keeps its status
+func (s *registrationStore) upsert(name, agentURL, agentToken string) (Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := discoveredHostID("registered", agentURL)
+	now := time.Now()
+
+	reg, ok := s.entries[id]
+	if !ok {
+		reg = &Registration{
+			ID:           id,
+			Status:       RegistrationPending,
+			RegisteredAt: now,
+		}
+		s.entries[id] = reg
+	}
+	reg.Name = name
+	reg.URL = agentURL
+	reg.agentToken = agentToken
+	reg.LastSeen = now
+
+	return *reg, s.save()
+}
+
+// agentToken returns the token a registered agent presented for itself, "" for other hosts
+func (s *registrationStore) agentToken(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reg, ok := s.entries[id]; ok {
+		return reg.agentToken
+	}
+	return ""
+}
+
+func (s *registrationStore) setStatus(id, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	reg.Status = status
+	return true, s.save()
+}
+
+func (s *registrationStore) remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return false, nil
+	}
+	delete(s.entries, id)
+	return true, s.save()
+}
+
+func (s *registrationStore) list() []Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Registration, 0, len(s.entries))
+	for _, reg := range s.entries {
+		result = append(result, *reg)
+	}
+	return result
+}
+
+// hosts converts registrations into host entries; pending registrations stay disabled
+func (s *registrationStore) hosts() []HostConfig {
+	regs := s.list()
+	hosts := make([]HostConfig, 0, len(regs))
+	for _, reg := range regs {
+		hosts = append(hosts, HostConfig{
+			ID:      reg.ID,
+			Name:    reg.Name,
+			URL:     reg.URL,
+			Enabled: reg.Status == RegistrationApproved,
+			Labels:  map[string]string{"registration": reg.Status},
+		})
+	}
+	return hosts
+}
+
+// syncRegisteredHosts publishes the registration store into the host registry
+func (d *Datasource) syncRegisteredHosts() {
+	d.hosts.setDiscovered("registered", d.registrations.hosts())
+}
+
+// handleRegister lets an agent register itself. Callers must present the datasource's
+// registration token (secureJsonData.registrationToken) in the body's registrationToken field;
+// the Authorization header belongs to Grafana's own authentication.
+func (d *Datasource) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	expected := d.secrets["registrationToken"]
+	if expected == "" {
+		writeError(w, http.StatusForbidden, "agent self-registration is not enabled")
+		return
+	}
+
+	var body struct {
+		Name              string `json:"name"`
+		URL               string `json:"url"`
+		Token             string `json:"token"`
+		RegistrationToken string `json:"registrationToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(body.RegistrationToken), []byte(expected)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid registration token")
+		return
+	}
+
+	parsed, err := url.Parse(body.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	if body.Name == "" {
+		body.Name = parsed.Hostname()
+	}
+
+	reg, err := d.registrations.upsert(body.Name, strings.TrimSuffix(body.URL, "/"), body.Token)
+	if err != nil {
+		d.logger.Error("Registration kept in memory only", "id", reg.ID, "error", err)
+	}
+	d.syncRegisteredHosts()
+
+	d.logger.Info("Agent registered", "id", reg.ID, "name", reg.Name, "url", reg.URL, "status", reg.Status)
+	writeJSON(w, http.StatusOK, reg)
+}
+
+// handleRegistrations lists registrations (GET /registrations) and approves, rejects or
+// removes them (POST /registrations/{id}/approve|reject|remove). Admin only.
+func (d *Datasource) handleRegistrations(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		writeError(w, http.StatusForbidden, "managing registrations requires the Admin role")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/registrations"), "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, d.registrations.list())
+		return
+	}
+
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, action := parts[0], parts[1]
+	var ok bool
+	var err error
+	switch action {
+	case "approve":
+		ok, err = d.registrations.setStatus(id, RegistrationApproved)
+	case "reject":
+		ok, err = d.registrations.setStatus(id, RegistrationRejected)
+	case "remove":
+		ok, err = d.registrations.remove(id)
+	default:
+		writeError(w, http.StatusNotFound, "unknown action: "+action)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "registration not found: "+id)
+		return
+	}
+	if err != nil {
+		d.logger.Error("Registration change kept in memory only", "id", id, "action", action, "error", err)
+	}
+
+	d.syncRegisteredHosts()
+	d.logger.Info("Registration updated", "id", id, "action", action)
+	writeJSON(w, http.StatusOK, map[string]string{"id": id, "action": action})
+}
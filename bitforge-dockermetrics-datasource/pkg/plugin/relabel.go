@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RelabelRule rewrites the hosts it matches and the names of their containers, so fleets
+// with inconsistent naming show alike on dashboards. Rules run in order, each one seeing the
+// result of the rules before it.
+type RelabelRule struct {
+	HostIDs     []string `json:"hostIds"`     // hosts the rule applies to
+	HostPattern string   `json:"hostPattern"` // regex on host names; with neither, every host
+	// HostName renames matching hosts; with hostPattern it replaces the match, $1 expands groups
+	HostName string `json:"hostName"`
+	// ContainerPattern rewrites matching container names to ContainerReplacement, $1 expands groups
+	ContainerPattern     string `json:"containerPattern"`
+	ContainerReplacement string `json:"containerReplacement"`
+	// Labels are attached to every series of matching hosts, replacing host labels of the same name
+	Labels map[string]string `json:"labels"`
+}
+
+// relabelRule is a RelabelRule with its patterns compiled
+type relabelRule struct {
+	RelabelRule
+	hostPattern      *regexp.Regexp
+	containerPattern *regexp.Regexp
+}
+
+// containerRewrite is a container name rewrite attached to a relabeled host
+type containerRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// compileRelabeling compiles relabeling rules; rules with invalid patterns or nothing to do
+// are skipped with a warning
+func compileRelabeling(rules []RelabelRule) ([]relabelRule, []string) {
+	compiled := make([]relabelRule, 0, len(rules))
+	warnings := make([]string, 0)
+	for i, rule := range rules {
+		c := relabelRule{RelabelRule: rule}
+		var err error
+		if rule.HostPattern != "" {
+			if c.hostPattern, err = regexp.Compile(rule.HostPattern); err != nil {
+				warnings = append(warnings, fmt.Sprintf("ignoring relabeling rule %d: invalid host pattern: %v", i+1, err))
+				continue
+			}
+		}
+		if rule.ContainerPattern != "" {
+			if c.containerPattern, err = regexp.Compile(rule.ContainerPattern); err != nil {
+				warnings = append(warnings, fmt.Sprintf("ignoring relabeling rule %d: invalid container pattern: %v", i+1, err))
+				continue
+			}
+		}
+		if rule.HostName == "" && c.containerPattern == nil && len(rule.Labels) == 0 {
+			warnings = append(warnings, fmt.Sprintf("ignoring relabeling rule %d: it sets no host name, container pattern or labels", i+1))
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, warnings
+}
+
+// matches reports whether the rule applies to a host
+func (r relabelRule) matches(h HostConfig) bool {
+	if len(r.HostIDs) == 0 && r.hostPattern == nil {
+		return true
+	}
+	return contains(r.HostIDs, h.ID) || (r.hostPattern != nil && r.hostPattern.MatchString(h.Name))
+}
+
+// relabelHost applies the rules to a host: its name and labels change here, container name
+// rewrites are kept on the host for relabelMetrics and relabelContainers
+func relabelHost(rules []relabelRule, h HostConfig) HostConfig {
+	for _, rule := range rules {
+		if !rule.matches(h) {
+			continue
+		}
+		switch {
+		case rule.HostName != "" && rule.hostPattern != nil:
+			h.Name = rule.hostPattern.ReplaceAllString(h.Name, rule.HostName)
+		case rule.HostName != "":
+			h.Name = rule.HostName
+		}
+		if len(rule.Labels) > 0 {
+			labels := make(map[string]string, len(h.Labels)+len(rule.Labels))
+			for k, v := range h.Labels {
+				labels[k] = v
+			}
+			for k, v := range rule.Labels {
+				labels[k] = v
+			}
+			h.Labels = labels
+		}
+		if rule.containerPattern != nil {
+			h.containerRewrites = append(h.containerRewrites, containerRewrite{pattern: rule.containerPattern, replacement: rule.ContainerReplacement})
+		}
+	}
+	return h
+}
+
+// containerName returns a container's name after the host's rewrites
+func (h HostConfig) containerName(name string) string {
+	for _, rw := range h.containerRewrites {
+		name = rw.pattern.ReplaceAllString(name, rw.replacement)
+	}
+	return name
+}
+
+// relabelMetrics rewrites container names of samples. Samples may be shared with caches, so
+// rewritten ones are copies.
+func relabelMetrics(host HostConfig, metrics []ContainerMetric) []ContainerMetric {
+	if len(host.containerRewrites) == 0 {
+		return metrics
+	}
+	result := make([]ContainerMetric, len(metrics))
+	for i, m := range metrics {
+		m.ContainerName = host.containerName(m.ContainerName)
+		result[i] = m
+	}
+	return result
+}
+
+// relabelContainers rewrites container names of a container list
+func relabelContainers(host HostConfig, containers []ContainerInfo) []ContainerInfo {
+	if len(host.containerRewrites) == 0 {
+		return containers
+	}
+	result := make([]ContainerInfo, len(containers))
+	for i, c := range containers {
+		c.ContainerName = host.containerName(c.ContainerName)
+		result[i] = c
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"reflect"
+	"time"
+)
+
+// reload hands state from the previous instance to a new one. Grafana re-creates the instance
+// when the datasource's Updated timestamp changes; instead of cold-starting, caches are kept for
+// hosts whose configuration is unchanged and discovered hosts carry over until the next refresh.
+// It returns whether this is a settings update and the IDs of hosts whose config changed.
+func (s *sharedState) reload(updated time.Time, settings DatasourceSettings, hosts *hostRegistry) (bool, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prevSettings, prevHosts := s.settings, s.hosts
+	isUpdate := prevHosts != nil && updated.After(s.updated)
+
+	s.updated = updated
+	s.settings = settings
+	s.hosts = hosts
+
+	if prevHosts == nil {
+		return false, nil
+	}
+
+	// Discovery keeps running on the old instance until it is disposed; reuse its last
+	// results so discovered hosts don't disappear between the save and the first refresh
+	if reflect.DeepEqual(prevSettings.Discovery, settings.Discovery) {
+		hosts.inheritDiscovered(prevHosts)
+	}
+
+	previous := make(map[string]HostConfig, len(prevSettings.Hosts))
+	for _, h := range prevSettings.Hosts {
+		previous[h.ID] = h
+	}
+
+	changed := make([]string, 0)
+	for _, h := range settings.Hosts {
+		if prev, ok := previous[h.ID]; ok && reflect.DeepEqual(prev, h) {
+			delete(previous, h.ID)
+			continue
+		}
+		delete(previous, h.ID)
+		changed = append(changed, h.ID)
+	}
+	for id := range previous {
+		changed = append(changed, id)
+	}
+
+	for _, id := range changed {
+		s.capabilities.invalidate(id)
+		s.metadata.invalidate(id)
+	}
+	if !reflect.DeepEqual(prevSettings.HostMetadataLabels, settings.HostMetadataLabels) {
+		s.metadata.clear()
+	}
+
+	return isUpdate, changed
+}
+
+// inheritDiscovered copies discovered hosts from a previous registry
+func (r *hostRegistry) inheritDiscovered(prev *hostRegistry) {
+	prev.mu.RLock()
+	defer prev.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for source, hosts := range prev.discovered {
+		r.discovered[source] = append([]HostConfig(nil), hosts...)
+	}
+}
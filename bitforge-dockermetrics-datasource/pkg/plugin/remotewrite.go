@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteSettings configures the Prometheus remote-write exporter.
+// The password or bearer token lives in secureJsonData (remoteWritePassword, remoteWriteBearerToken).
+type RemoteWriteSettings struct {
+	Enabled  bool   `json:"enabled"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+}
+
+// remoteWriteExporter pushes samples to a Prometheus remote-write (v1) endpoint
+type remoteWriteExporter struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+}
+
+func newRemoteWriteExporter(s RemoteWriteSettings, secrets map[string]string) *remoteWriteExporter {
+	return &remoteWriteExporter{
+		url:         s.URL,
+		username:    s.Username,
+		password:    secrets["remoteWritePassword"],
+		bearerToken: secrets["remoteWriteBearerToken"],
+	}
+}
+
+func (e *remoteWriteExporter) name() string {
+	return "remote-write"
+}
+
+func (e *remoteWriteExporter) export(ctx context.Context, batches []exportBatch) error {
+	payload := snappy.Encode(nil, encodeWriteRequest(batches))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case e.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+e.bearerToken)
+	case e.username != "":
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+type promLabel struct {
+	name, value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// encodeWriteRequest builds a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(batches []exportBatch) []byte {
+	var out []byte
+	for _, batch := range batches {
+		for _, series := range batchSeries(batch) {
+			var ts []byte
+			for _, l := range series.labels {
+				var lb []byte
+				lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+				lb = protowire.AppendString(lb, l.name)
+				lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+				lb = protowire.AppendString(lb, l.value)
+				ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+				ts = protowire.AppendBytes(ts, lb)
+			}
+			for _, s := range series.samples {
+				var sb []byte
+				sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+				sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+				sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+				sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+				ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+				ts = protowire.AppendBytes(ts, sb)
+			}
+			out = protowire.AppendTag(out, 1, protowire.BytesType)
+			out = protowire.AppendBytes(out, ts)
+		}
+	}
+	return out
+}
+
+type promSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// batchSeries groups a host's samples into one series per container and metric,
+// with labels sorted by name as remote-write requires
+func batchSeries(batch exportBatch) []promSeries {
+	type seriesKey struct {
+		containerID, metric string
+	}
+	index := make(map[seriesKey]int)
+	series := make([]promSeries, 0)
+
+	hostLabelKeys := make([]string, 0, len(batch.labels))
+	for k := range batch.labels {
+		hostLabelKeys = append(hostLabelKeys, k)
+	}
+	sort.Strings(hostLabelKeys)
+
+	sortMetricsByTime(batch.metrics)
+	for _, m := range batch.metrics {
+		t := m.Timestamp.Time
+		for _, metric := range AllMetrics {
+			value, ok := rawMetricValue(m, metric)
+			if !ok {
+				continue
+			}
+
+			key := seriesKey{m.ContainerID, metric}
+			i, exists := index[key]
+			if !exists {
+				labels := []promLabel{
+					{"__name__", exportMetricName(metric)},
+					{"host", batch.host.Name},
+					{"host_id", batch.host.ID},
+					{"container_id", m.ContainerID},
+					{"container_name", m.ContainerName},
+				}
+				if image := batch.images[m.ContainerID]; image != "" {
+					labels = append(labels, promLabel{"image", image})
+				}
+				// Host labels can't override the built-in labels, or each other once made valid
+				names := make(map[string]bool, len(labels)+len(batch.labels))
+				for _, l := range labels {
+					names[l.name] = true
+				}
+				for _, k := range hostLabelKeys {
+					name := exportLabelName(k)
+					if !names[name] {
+						names[name] = true
+						labels = append(labels, promLabel{name, batch.labels[k]})
+					}
+				}
+				sort.Slice(labels, func(a, b int) bool { return labels[a].name < labels[b].name })
+
+				i = len(series)
+				index[key] = i
+				series = append(series, promSeries{labels: labels})
+			}
+			series[i].samples = append(series[i].samples, promSample{value: value, timestamp: t.UnixMilli()})
+		}
+	}
+	return series
+}
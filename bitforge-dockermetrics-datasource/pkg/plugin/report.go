@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// reportMetrics are the samples a report is computed from
+var reportMetrics = []string{"cpuPercent", "memoryBytes", "uptimeSeconds"}
+
+// reportContainer is one container of an SLA report
+type reportContainer struct {
+	HostID         string  `json:"hostId"`
+	HostName       string  `json:"hostName"`
+	ContainerID    string  `json:"containerId"`
+	ContainerName  string  `json:"containerName"`
+	UptimePercent  float64 `json:"uptimePercent"` // running time over the observed time
+	RunningSeconds float64 `json:"runningSeconds"`
+	PausedSeconds  float64 `json:"pausedSeconds"`
+	ExitedSeconds  float64 `json:"exitedSeconds"`
+	Restarts       int64   `json:"restarts"` // drops of uptimeSeconds between samples
+	Transitions    int64   `json:"transitions"`
+	AvgCPUPercent  float64 `json:"avgCpuPercent"`
+	MaxCPUPercent  float64 `json:"maxCpuPercent"`
+	AvgMemoryBytes float64 `json:"avgMemoryBytes"`
+	MaxMemoryBytes float64 `json:"maxMemoryBytes"`
+	LastState      string  `json:"lastState"`
+}
+
+// slaReport is the JSON body of the report resource
+type slaReport struct {
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	GeneratedAt   time.Time         `json:"generatedAt"`
+	UptimePercent float64           `json:"uptimePercent"` // over every container's observed time
+	Restarts      int64             `json:"restarts"`
+	Containers    []reportContainer `json:"containers"`
+	Warnings      []string          `json:"warnings,omitempty"`
+}
+
+// reportContainerOf computes a container's report row from its samples, sorted by time
+func reportContainerOf(hostID, hostName string, samples []ContainerMetric, end time.Time, maxGap time.Duration) reportContainer {
+	stats := lifecycleOf(samples, end, maxGap)
+	last := samples[len(samples)-1]
+	row := reportContainer{
+		HostID:         hostID,
+		HostName:       hostName,
+		ContainerID:    last.ContainerID,
+		ContainerName:  last.ContainerName,
+		RunningSeconds: stats.running.Seconds(),
+		PausedSeconds:  stats.paused.Seconds(),
+		ExitedSeconds:  stats.exited.Seconds(),
+		Transitions:    stats.transitions,
+		LastState:      stats.state,
+	}
+	if observed := stats.running + stats.paused + stats.exited; observed > 0 {
+		row.UptimePercent = stats.running.Seconds() / observed.Seconds() * 100
+	}
+	var cpu, memory float64
+	var cpuSamples, memorySamples int
+	for i, m := range samples {
+		if i > 0 && m.UptimeSeconds < samples[i-1].UptimeSeconds {
+			row.Restarts++
+		}
+		if v, ok := rawMetricValue(m, "cpuPercent"); ok {
+			cpu += v
+			cpuSamples++
+			row.MaxCPUPercent = max(row.MaxCPUPercent, v)
+		}
+		if v, ok := rawMetricValue(m, "memoryBytes"); ok {
+			memory += v
+			memorySamples++
+			row.MaxMemoryBytes = max(row.MaxMemoryBytes, v)
+		}
+	}
+	row.AvgCPUPercent = cpu / float64(max(cpuSamples, 1))
+	row.AvgMemoryBytes = memory / float64(max(memorySamples, 1))
+	return row
+}
+
+// buildReport computes the report of the containers a prepared query selects over a range;
+// with hostSelections only the selected hosts and containers are reported, like the panel
+func (d *Datasource) buildReport(ctx context.Context, qm QueryModel, timeRange backend.TimeRange) (slaReport, error) {
+	report := slaReport{From: timeRange.From, To: timeRange.To, GeneratedAt: time.Now(), Containers: make([]reportContainer, 0)}
+	hostIDs := qm.HostIDs
+	if len(qm.HostSelections) > 0 {
+		hostIDs = make([]string, 0, len(qm.HostSelections))
+		for hostID, sel := range qm.HostSelections {
+			if err := validateSelection(sel); err != nil {
+				return report, fmt.Errorf("host selection %s: %w", hostID, err)
+			}
+			hostIDs = append(hostIDs, hostID)
+		}
+	}
+	hosts := d.selectHosts(qm, hostIDs)
+	if len(hosts) == 0 {
+		return report, fmt.Errorf("no enabled hosts configured")
+	}
+
+	end := timeRange.To
+	if end.After(report.GeneratedAt) {
+		end = report.GeneratedAt
+	}
+	maxGap := d.staleAfter()
+
+	// Uptime and usage are per container, aggregated series would mix them
+	qm.AggregateBy = ""
+	var running, observed float64
+	for _, mwh := range d.collectMetrics(ctx, hosts, qm, timeRange, reportMetrics) {
+		if mwh.Err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Host %s: %v", mwh.HostName, mwh.Err))
+			continue
+		}
+		if mwh.Invalid != nil {
+			report.Warnings = append(report.Warnings, mwh.Invalid.Error())
+			continue
+		}
+		samples := mwh.Metrics
+		if sel, ok := qm.HostSelections[mwh.HostID]; ok {
+			var dockerLabels map[string]map[string]string
+			if host, found := d.hosts.find(mwh.HostID); found && selectsByLabel(sel) {
+				dockerLabels = d.dockerLabelsForHost(ctx, host)
+			}
+			samples = d.filterMetricsBySelection(samples, sel, dockerLabels)
+		}
+		byContainer := make(map[string][]ContainerMetric)
+		for _, m := range samples {
+			byContainer[m.ContainerID] = append(byContainer[m.ContainerID], m)
+		}
+		for _, samples := range byContainer {
+			sortMetricsByTime(samples)
+			row := reportContainerOf(mwh.HostID, mwh.HostName, samples, end, maxGap)
+			running += row.RunningSeconds
+			observed += row.RunningSeconds + row.PausedSeconds + row.ExitedSeconds
+			report.Restarts += row.Restarts
+			report.Containers = append(report.Containers, row)
+		}
+	}
+	if observed > 0 {
+		report.UptimePercent = running / observed * 100
+	}
+	sort.Slice(report.Containers, func(i, j int) bool {
+		a, b := report.Containers[i], report.Containers[j]
+		if a.HostName != b.HostName {
+			return a.HostName < b.HostName
+		}
+		if a.ContainerName != b.ContainerName {
+			return a.ContainerName < b.ContainerName
+		}
+		return a.ContainerID < b.ContainerID
+	})
+	return report, nil
+}
+
+// handleReport generates an uptime, restart and resource usage report of the containers a
+// query selects (POST /report?format=json|csv with {"query": {...}, "from": "now-7d", "to": "now"}),
+// for reliability reviews without exporting raw samples. The query is prepared like a panel's,
+// so its filters, host selections, variables and scope token apply; the CSV has one row per
+// container.
+func (d *Datasource) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "csv" && format != "json" {
+		writeError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	var body exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	var qm QueryModel
+	if len(body.Query) > 0 {
+		if err := json.Unmarshal(body.Query, &qm); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query: %v", err))
+			return
+		}
+	}
+	ctx, _, err := d.prepareQuery(r.Context(), backend.PluginConfigFromContext(r.Context()), body.Query, &qm)
+	if err != nil {
+		status := http.StatusBadRequest
+		var refused *scopeError
+		if errors.As(err, &refused) {
+			status = http.StatusForbidden
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+
+	now := time.Now()
+	from, err := parseExportTime(body.From, now.AddDate(0, 0, -7), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseExportTime(body.To, now, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	report, err := d.buildReport(ctx, qm, backend.TimeRange{From: from, To: to})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("dockermetrics-report-%s.%s", now.UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+	_ = cw.Write([]string{"hostId", "hostName", "containerId", "containerName", "uptimePercent", "runningSeconds",
+		"pausedSeconds", "exitedSeconds", "restarts", "transitions", "avgCpuPercent", "maxCpuPercent",
+		"avgMemoryBytes", "maxMemoryBytes", "lastState"})
+	for _, c := range report.Containers {
+		record := []string{c.HostID, c.HostName, c.ContainerID, c.ContainerName, exportCell(c.UptimePercent),
+			exportCell(c.RunningSeconds), exportCell(c.PausedSeconds), exportCell(c.ExitedSeconds),
+			strconv.FormatInt(c.Restarts, 10), strconv.FormatInt(c.Transitions, 10), exportCell(c.AvgCPUPercent),
+			exportCell(c.MaxCPUPercent), exportCell(c.AvgMemoryBytes), exportCell(c.MaxMemoryBytes), c.LastState}
+		if err := cw.Write(record); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// newResourceHandler registers all resource routes served by the datasource
+func (d *Datasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capabilities", d.handleCapabilities)
+	mux.HandleFunc("/discovery/scan", d.handleDiscoveryScan)
+	mux.HandleFunc("/register", d.writeGuard(d.handleRegister))
+	mux.HandleFunc("/registrations", d.writeGuard(d.handleRegistrations))
+	mux.HandleFunc("/registrations/", d.writeGuard(d.handleRegistrations))
+	mux.HandleFunc("/maintenance", d.writeGuard(d.handleMaintenance))
+	mux.HandleFunc("/maintenance/", d.writeGuard(d.handleMaintenance))
+	mux.HandleFunc("/hosts", d.handleHostList)
+	mux.HandleFunc("/hosts/", d.writeGuard(d.handleHosts))
+	mux.HandleFunc("/metrics", d.handleMetricList)
+	mux.HandleFunc("/export", d.handleExport)
+	mux.HandleFunc("/report", d.handleReport)
+	mux.HandleFunc("/render", d.handleGraphiteRender)
+	mux.HandleFunc("/usage", d.handleUsage)
+	mux.HandleFunc("/version", d.handleVersion)
+	mux.HandleFunc("/scope-tokens", d.handleScopeTokens)
+	return httpadapter.New(mux)
+}
+
+// CallResource handles resource calls from the frontend
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if err := d.checkOrg(req.PluginContext); err != nil {
+		body, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusForbidden, Body: body})
+	}
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
+// handleCapabilities returns per-host capabilities, optionally filtered by ?hostId=
+func (d *Datasource) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var filterIDs []string
+	if hostID := r.URL.Query().Get("hostId"); hostID != "" {
+		filterIDs = []string{hostID}
+	}
+
+	result := make(map[string]HostCapabilities)
+	for _, host := range d.getEnabledHosts(filterIDs) {
+		caps := d.getHostCapabilities(r.Context(), host)
+		if d.settings.ReadOnly {
+			caps.SupportsControls = false
+		}
+		result[host.ID] = caps
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// isAdminRequest reports whether the calling Grafana user has the Admin org role
+func isAdminRequest(r *http.Request) bool {
+	user := httpadapter.UserFromContext(r.Context())
+	return user != nil && user.Role == "Admin"
+}
+
+// isEditorRequest reports whether the calling Grafana user has the Editor or Admin org role
+func isEditorRequest(r *http.Request) bool {
+	return isEditorUser(httpadapter.UserFromContext(r.Context()))
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.DefaultLogger.Error("Failed to encode resource response", "error", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,356 @@
+package plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultRetentionDays         = 30
+	defaultRetentionIngestPeriod = 60 * time.Second
+	// defaultAgentRetention matches the collector's MetricsCache default, used when the
+	// agent doesn't report its retention
+	defaultAgentRetention = 6 * time.Hour
+)
+
+var samplesBucket = []byte("samples")
+
+// Sources of a query segment
+const (
+	segmentRetention = "retention"
+	segmentAgent     = "agent"
+)
+
+// dataSegment is the part of a query range one source served
+type dataSegment struct {
+	Source string    `json:"source"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// segmentRecorder collects the segments of one host's fetch
+type segmentRecorder struct {
+	mu       sync.Mutex
+	segments []dataSegment
+}
+
+type segmentsKey struct{}
+
+func withSegments(ctx context.Context) (context.Context, *segmentRecorder) {
+	r := &segmentRecorder{}
+	return context.WithValue(ctx, segmentsKey{}, r), r
+}
+
+// recordSegments notes the sources of a fetch split across the store and the agent, if tracked
+func recordSegments(ctx context.Context, segments ...dataSegment) {
+	r, _ := ctx.Value(segmentsKey{}).(*segmentRecorder)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.segments = append(r.segments, segments...)
+}
+
+// list returns the recorded segments, nil when the agent served the whole range
+func (r *segmentRecorder) list() []dataSegment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.segments
+}
+
+// RetentionSettings configures the optional local retention store. When enabled the backend
+// keeps ingesting samples from every host and serves the part of a query that is older than
+// the agent's own retention window.
+type RetentionSettings struct {
+	Enabled               bool   `json:"enabled"`
+	Path                  string `json:"path"`
+	Days                  int    `json:"days"`
+	IngestIntervalSeconds int    `json:"ingestIntervalSeconds"`
+}
+
+func (s RetentionSettings) days() int {
+	if s.Days > 0 {
+		return s.Days
+	}
+	return defaultRetentionDays
+}
+
+func (s RetentionSettings) interval() time.Duration {
+	if s.IngestIntervalSeconds > 0 {
+		return time.Duration(s.IngestIntervalSeconds) * time.Second
+	}
+	return defaultRetentionIngestPeriod
+}
+
+// path returns the store file, defaulting to one file per datasource in the temp directory.
+// The main org keeps the file name used before stores were separated by org.
+func (s RetentionSettings) path(orgID int64, uid string) string {
+	if s.Path != "" {
+		return s.Path
+	}
+	if orgID > 1 {
+		uid = fmt.Sprintf("%d-%s", orgID, uid)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dockermetrics-%s.db", discoveredHostID("retention", uid)))
+}
+
+// retentionStore is a BoltDB file laid out as samples/<hostId>/<containerId>/<unix nanos> -> sample JSON
+type retentionStore struct {
+	path string
+	db   *bolt.DB
+}
+
+func openRetentionStore(path string) (*retentionStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open retention store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(samplesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize retention store: %w", err)
+	}
+	return &retentionStore{path: path, db: db}, nil
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// put stores samples for a host. Samples are keyed by container and timestamp,
+// so ingesting overlapping ranges is harmless.
+func (s *retentionStore) put(hostID string, metrics []ContainerMetric) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		hostBucket, err := tx.Bucket(samplesBucket).CreateBucketIfNotExists([]byte(hostID))
+		if err != nil {
+			return err
+		}
+		for _, m := range metrics {
+			t := m.Timestamp.Time
+			containerBucket, err := hostBucket.CreateBucketIfNotExists([]byte(m.ContainerID))
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := containerBucket.Put(timeKey(t), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rangeQuery returns a host's stored samples with from <= timestamp < to
+func (s *retentionStore) rangeQuery(hostID string, from, to time.Time) ([]ContainerMetric, error) {
+	result := make([]ContainerMetric, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		hostBucket := tx.Bucket(samplesBucket).Bucket([]byte(hostID))
+		if hostBucket == nil {
+			return nil
+		}
+		end := timeKey(to)
+		return hostBucket.ForEachBucket(func(containerID []byte) error {
+			c := hostBucket.Bucket(containerID).Cursor()
+			for k, value := c.Seek(timeKey(from)); k != nil && string(k) < string(end); k, value = c.Next() {
+				var m ContainerMetric
+				if err := json.Unmarshal(value, &m); err != nil {
+					continue
+				}
+				result = append(result, m)
+			}
+			return nil
+		})
+	})
+	return result, err
+}
+
+// prune deletes samples and recorded rule results older than cutoff and returns how many were removed
+func (s *retentionStore) prune(cutoff time.Time) (int, error) {
+	removed := 0
+	limit := string(timeKey(cutoff))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if recordings := tx.Bucket(recordingsBucket); recordings != nil {
+			err := recordings.ForEachBucket(func(rule []byte) error {
+				c := recordings.Bucket(rule).Cursor()
+				for k, _ := c.First(); k != nil && string(k) < limit; k, _ = c.Next() {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					removed++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(samplesBucket).ForEachBucket(func(hostID []byte) error {
+			hostBucket := tx.Bucket(samplesBucket).Bucket(hostID)
+			return hostBucket.ForEachBucket(func(containerID []byte) error {
+				c := hostBucket.Bucket(containerID).Cursor()
+				for k, _ := c.First(); k != nil && string(k) < limit; k, _ = c.Next() {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					removed++
+				}
+				return nil
+			})
+		})
+	})
+	return removed, err
+}
+
+// startRetention opens the retention store and launches the ingest loop if enabled.
+// A store that can't be opened only disables retention; live queries keep working.
+func (d *Datasource) startRetention(state *sharedState) {
+	settings := d.settings.Retention
+	if !settings.Enabled {
+		return
+	}
+
+	store, err := state.retentionStore(settings.path(d.orgID, d.uid))
+	if err != nil {
+		d.logger.Error("Local retention disabled", "error", err)
+		return
+	}
+	d.retention = store
+
+	interval := settings.interval()
+	d.logger.Info("Starting local retention ingest", "path", store.path, "days", settings.days(), "interval", interval)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastIngest := make(map[string]time.Time)
+		for {
+			d.ingestRetention(lastIngest)
+
+			select {
+			case <-d.bgCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ingestRetention copies new samples from every enabled host into the store and prunes old ones
+func (d *Datasource) ingestRetention(lastIngest map[string]time.Time) {
+	ctx, cancel := context.WithTimeout(d.bgCtx, d.settings.Retention.interval())
+	defer cancel()
+
+	now := time.Now()
+	for _, host := range d.getEnabledHosts(nil) {
+		from, ok := lastIngest[host.ID]
+		if !ok {
+			// First pass picks up whatever history the agent still has
+			from = now.Add(-d.agentRetention(ctx, host))
+		}
+
+		metrics, _, err := d.fetchMetricsFromHost(ctx, host, backend.TimeRange{From: from, To: now}, AllMetrics)
+		if err != nil {
+			d.logHostError(host, "Failed to ingest metrics for local retention", err)
+			continue
+		}
+		if err := d.retention.put(host.ID, metrics); err != nil {
+			d.logger.Error("Failed to write local retention samples", "host", host.Name, "error", err)
+			continue
+		}
+		lastIngest[host.ID] = now
+
+		// Fetching the container list records it, so inventory diffs reach back as far as the store
+		if _, err := d.fetchContainersFromHost(ctx, host); err != nil {
+			d.logHostError(host, "Failed to record container inventory for local retention", err)
+		}
+	}
+
+	removed, err := d.retention.prune(now.AddDate(0, 0, -d.settings.Retention.days()))
+	if err != nil {
+		d.logger.Error("Failed to prune local retention store", "error", err)
+	} else if removed > 0 {
+		d.logger.Debug("Pruned local retention store", "removed", removed)
+	}
+	if removed, err := d.retention.pruneInventory(now.AddDate(0, 0, -d.settings.Retention.days())); err != nil {
+		d.logger.Error("Failed to prune container inventory", "error", err)
+	} else if removed > 0 {
+		d.logger.Debug("Pruned container inventory", "removed", removed)
+	}
+}
+
+// agentRetention returns how far back a host's agent keeps samples
+func (d *Datasource) agentRetention(ctx context.Context, host HostConfig) time.Duration {
+	if caps := d.getHostCapabilities(ctx, host); caps.MaxRetentionSeconds > 0 {
+		return time.Duration(caps.MaxRetentionSeconds) * time.Second
+	}
+	return defaultAgentRetention
+}
+
+// fetchRetainedMetrics fetches metrics for a time range. With local retention enabled, the part of
+// the range older than the agent's retention window is read from the store and the rest from the
+// agent; the split is recorded as segments so frames can show where their samples came from.
+// The remote-write target is write-only and never serves reads.
+func (d *Datasource) fetchRetainedMetrics(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, string, error) {
+	if d.retention == nil {
+		return d.fetchMetricsFromHost(ctx, host, timeRange, metrics)
+	}
+
+	cutoff := time.Now().Add(-d.agentRetention(ctx, host))
+	if !timeRange.From.Before(cutoff) {
+		return d.fetchMetricsFromHost(ctx, host, timeRange, metrics)
+	}
+
+	storedTo := timeRange.To
+	if storedTo.After(cutoff) {
+		storedTo = cutoff
+	}
+	stored, err := d.retention.rangeQuery(host.ID, timeRange.From, storedTo)
+	if err != nil {
+		d.logger.Warn("Failed to read local retention store", "host", host.Name, "error", err)
+		return d.fetchMetricsFromHost(ctx, host, timeRange, metrics)
+	}
+	if !timeRange.To.After(cutoff) {
+		recordSegments(ctx, dataSegment{Source: segmentRetention, From: timeRange.From, To: timeRange.To})
+		return stored, "", nil
+	}
+
+	live, servedBy, err := d.fetchMetricsFromHost(ctx, host, backend.TimeRange{From: cutoff, To: timeRange.To}, metrics)
+	if err != nil {
+		if len(stored) == 0 {
+			return nil, "", err
+		}
+		d.logHostError(host, "Serving stored samples only, failed to fetch recent metrics", err)
+		recordSegments(ctx, dataSegment{Source: segmentRetention, From: timeRange.From, To: cutoff})
+		return stored, "", nil
+	}
+	recordSegments(ctx,
+		dataSegment{Source: segmentRetention, From: timeRange.From, To: cutoff},
+		dataSegment{Source: segmentAgent, From: cutoff, To: timeRange.To},
+	)
+
+	merged := append(stored, live...)
+	sortMetricsByTime(merged)
+	return merged, servedBy, nil
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 2
+	defaultRetryBackoff  = 200 * time.Millisecond
+	maxRetryAttempts     = 5
+	maxRetryBackoff      = 2 * time.Second
+)
+
+// defaultRetryStatusCodes are what proxies and agents answer while an agent restarts
+var defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetrySettings controls how agent reads (metrics and container lists) are retried. The
+// datasource's settings are the defaults, a host's and then a query's fields replace them.
+type RetrySettings struct {
+	Attempts    int   `json:"attempts,omitempty"`    // tries per read including the first, default 2, at most 5; 1 disables retries
+	BackoffMs   int   `json:"backoffMs,omitempty"`   // wait before the first retry, doubled per retry up to 2s, default 200
+	StatusCodes []int `json:"statusCodes,omitempty"` // responses worth retrying besides connection errors, default 502, 503, 504
+}
+
+// merge returns s with the fields o sets
+func (s RetrySettings) merge(o *RetrySettings) RetrySettings {
+	if o == nil {
+		return s
+	}
+	if o.Attempts > 0 {
+		s.Attempts = o.Attempts
+	}
+	if o.BackoffMs > 0 {
+		s.BackoffMs = o.BackoffMs
+	}
+	if o.StatusCodes != nil {
+		s.StatusCodes = o.StatusCodes
+	}
+	return s
+}
+
+func (s RetrySettings) validate() error {
+	if s.Attempts < 0 || s.BackoffMs < 0 {
+		return fmt.Errorf("retry attempts and backoffMs must not be negative")
+	}
+	return nil
+}
+
+type retryKey struct{}
+
+// queryRetry applies a query's retry settings to its agent reads
+func queryRetry(ctx context.Context, qm QueryModel) (context.Context, error) {
+	if qm.Retry == nil {
+		return ctx, nil
+	}
+	if err := qm.Retry.validate(); err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, retryKey{}, qm.Retry), nil
+}
+
+// retryPolicy returns the retry settings of a read from host by the query in ctx
+func (d *Datasource) retryPolicy(ctx context.Context, host HostConfig) RetrySettings {
+	policy := RetrySettings{
+		Attempts:    defaultRetryAttempts,
+		BackoffMs:   int(defaultRetryBackoff / time.Millisecond),
+		StatusCodes: defaultRetryStatusCodes,
+	}
+	query, _ := ctx.Value(retryKey{}).(*RetrySettings)
+	policy = policy.merge(&d.settings.Retry).merge(host.Retry).merge(query)
+	policy.Attempts = min(policy.Attempts, maxRetryAttempts)
+	return policy
+}
+
+// agentAuthError is an agent refusing the host's token; retrying can't fix it
+type agentAuthError struct{ msg string }
+
+func (e *agentAuthError) Error() string { return e.msg }
+
+// getWithRetry sends a GET to a host's agents, retrying connection errors and retryable
+// statuses with exponential backoff so an agent restart doesn't drop the host from a refresh.
+// The host timeout bounds all attempts together.
+func (d *Datasource) getWithRetry(ctx context.Context, host HostConfig, path string) (*http.Response, string, error) {
+	policy := d.retryPolicy(ctx, host)
+	backoff := time.Duration(policy.BackoffMs) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		resp, servedBy, err := d.doHostRequest(ctx, host, http.MethodGet, path, nil)
+		var authErr *agentAuthError
+		var signatureErr *agentSignatureError
+		switch {
+		case errors.As(err, &authErr), errors.As(err, &signatureErr):
+			return nil, "", err
+		case err == nil && !slices.Contains(policy.StatusCodes, resp.StatusCode):
+			return resp, servedBy, nil
+		}
+		if attempt >= policy.Attempts || ctx.Err() != nil {
+			return resp, servedBy, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+			err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+
+		d.log(ctx).Debug("Retrying agent request", "host", host.Name, "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, "", err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// defaultLargeFleetThreshold is the host count above which scaling warnings and lazy health checks kick in
+	defaultLargeFleetThreshold = 25
+	// healthSampleSize is how many hosts CheckHealth probes per call for large fleets
+	healthSampleSize = 10
+)
+
+// largeFleetThreshold returns the configured large fleet threshold
+func (d *Datasource) largeFleetThreshold() int {
+	if d.settings.LargeFleetThreshold > 0 {
+		return d.settings.LargeFleetThreshold
+	}
+	return defaultLargeFleetThreshold
+}
+
+// validateHosts reports configuration problems that are easy to miss in a long host list
+func validateHosts(hosts []HostConfig) []string {
+	warnings := make([]string, 0)
+	seenIDs := make(map[string]string)
+	seenURLs := make(map[string]string)
+
+	for _, h := range hosts {
+		if prev, ok := seenIDs[h.ID]; ok {
+			warnings = append(warnings, fmt.Sprintf("hosts %s and %s share ID %q", prev, h.Name, h.ID))
+		}
+		seenIDs[h.ID] = h.Name
+
+		if !contains(hostModes, h.Mode) {
+			warnings = append(warnings, fmt.Sprintf("host %s has an unknown mode %q", h.Name, h.Mode))
+		}
+		if h.Timezone != "" {
+			if _, err := time.LoadLocation(h.Timezone); err != nil {
+				warnings = append(warnings, fmt.Sprintf("host %s has an unknown timezone %q, using UTC", h.Name, h.Timezone))
+			}
+		}
+		if h.PublicKey != "" {
+			if _, err := parseAgentKey(h.PublicKey); err != nil {
+				warnings = append(warnings, fmt.Sprintf("host %s has an invalid public key, its responses will be rejected: %v", h.Name, err))
+			} else if h.Mode != "" {
+				warnings = append(warnings, fmt.Sprintf("host %s has a public key, but only agents sign responses", h.Name))
+			}
+		}
+
+		parsed, err := url.Parse(h.URL)
+		if h.URL == "" || err != nil || parsed.Host == "" {
+			warnings = append(warnings, fmt.Sprintf("host %s has an invalid URL %q", h.Name, h.URL))
+			continue
+		}
+		if prev, ok := seenURLs[normalizeHostURL(h.URL)]; ok {
+			warnings = append(warnings, fmt.Sprintf("hosts %s and %s point at the same URL", prev, h.Name))
+		}
+		seenURLs[normalizeHostURL(h.URL)] = h.Name
+	}
+	return warnings
+}
+
+// scalingNotice warns when a query fans out to more hosts than the large fleet threshold
+func (d *Datasource) scalingNotice(hostCount int) *data.Notice {
+	if hostCount <= d.largeFleetThreshold() {
+		return nil
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Query spans %d hosts (more than %d); hosts are queried %d at a time, so responses may be slow. Narrow the selection with host groups or tags.",
+			hostCount, d.largeFleetThreshold(), d.fetchConcurrency()),
+	}
+}
+
+// addFrameNotice attaches a notice to the first frame of a response
+func addFrameNotice(frames data.Frames, notice *data.Notice) {
+	if notice == nil || len(frames) == 0 {
+		return
+	}
+	if frames[0].Meta == nil {
+		frames[0].Meta = &data.FrameMeta{}
+	}
+	frames[0].Meta.Notices = append(frames[0].Meta.Notices, *notice)
+}
+
+// healthSample returns the hosts CheckHealth should probe. Large fleets are probed lazily:
+// each call checks a rotating sample so a health check doesn't take minutes.
+func (d *Datasource) healthSample(hosts []HostConfig) []HostConfig {
+	if len(hosts) <= d.largeFleetThreshold() {
+		return hosts
+	}
+
+	offset := int(d.healthCursor.Add(healthSampleSize) - healthSampleSize)
+	sample := make([]HostConfig, 0, healthSampleSize)
+	for i := 0; i < healthSampleSize && i < len(hosts); i++ {
+		sample = append(sample, hosts[(offset+i)%len(hosts)])
+	}
+	return sample
+}
+
+// settingsWarningNote formats configuration warnings for the health check message
+func settingsWarningNote(warnings []string) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+	return ". Settings warnings: " + strings.Join(warnings, "; ")
+}
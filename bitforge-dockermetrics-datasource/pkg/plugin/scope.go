@@ -0,0 +1,280 @@
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// scopeSecretKey is the secureJsonData key holding the scope token signing secret
+const scopeSecretKey = "scopeTokenSecret"
+
+// scopedQueryTypes are the query types a scope token can restrict; control actions and
+// recording rules (which aggregate across hosts) are refused for scoped queries
+var scopedQueryTypes = []string{"metrics", "containers", "hosts", "state", "threshold", "logs", "summary", "lifecycle", "cost", "composeProjects", "inventoryDiff", "swarmServices", "topology"}
+
+// ScopeTokenSettings controls scope tokens (see ScopeClaims)
+type ScopeTokenSettings struct {
+	// Required makes queries from users below the Editor role fail without a valid token
+	Required bool `json:"required"`
+}
+
+// ScopeClaims restrict a query to hosts and containers. They are signed into a scope token
+// by the scope-tokens resource and carried in the query's scopeToken field, so a dashboard
+// for viewers or the public can't be edited into showing other hosts.
+type ScopeClaims struct {
+	Datasource string   `json:"ds"`                   // datasource UID the token is valid for
+	Hosts      []string `json:"hosts,omitempty"`      // host IDs, empty allows every host
+	Containers []string `json:"containers,omitempty"` // container IDs or names, empty allows every container
+	IssuedBy   string   `json:"iss,omitempty"`
+	IssuedAt   int64    `json:"iat"`
+	Expires    int64    `json:"exp,omitempty"` // unix seconds, 0 never expires
+}
+
+// allowsHost reports whether the claims allow a host; nil claims allow everything
+func (c *ScopeClaims) allowsHost(hostID string) bool {
+	return c == nil || len(c.Hosts) == 0 || contains(c.Hosts, hostID)
+}
+
+// allowsContainer reports whether the claims allow a container by ID or name
+func (c *ScopeClaims) allowsContainer(containerID, containerName string) bool {
+	return c == nil || len(c.Containers) == 0 || contains(c.Containers, containerID) || contains(c.Containers, containerName)
+}
+
+// restrict narrows a query to the claimed hosts, refusing query types that can't be scoped
+func (c *ScopeClaims) restrict(qm *QueryModel) error {
+	if !contains(scopedQueryTypes, qm.QueryType) {
+		return fmt.Errorf("scope tokens don't allow %s queries", qm.QueryType)
+	}
+	if len(c.Hosts) == 0 {
+		return nil
+	}
+	matrix := len(qm.HostSelections) > 0
+
+	requested := qm.HostIDs
+	if len(requested) == 0 {
+		requested = c.Hosts
+	}
+	allowed := make([]string, 0, len(requested))
+	for _, id := range requested {
+		if contains(c.Hosts, id) {
+			allowed = append(allowed, id)
+		}
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("scope token doesn't allow the requested hosts")
+	}
+	qm.HostIDs = allowed
+	for id := range qm.HostSelections {
+		if !contains(c.Hosts, id) {
+			delete(qm.HostSelections, id)
+		}
+	}
+	if matrix && len(qm.HostSelections) == 0 {
+		return fmt.Errorf("scope token doesn't allow the requested hosts")
+	}
+	return nil
+}
+
+// signScopeToken encodes the claims as base64url(JSON) "." base64url(HMAC-SHA256)
+func signScopeToken(secret string, claims ScopeClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + scopeSignature(secret, encoded), nil
+}
+
+func scopeSignature(secret, encoded string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseScopeToken verifies a token's signature and expiry and returns its claims
+func parseScopeToken(secret, token string, now time.Time) (*ScopeClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(scopeSignature(secret, encoded))) {
+		return nil, errors.New("invalid scope token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid scope token")
+	}
+	var claims ScopeClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("invalid scope token")
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return nil, errors.New("scope token expired")
+	}
+	return &claims, nil
+}
+
+// isEditorUser reports whether a Grafana user has the Editor or Admin org role
+func isEditorUser(user *backend.User) bool {
+	return user != nil && (user.Role == "Editor" || user.Role == "Admin")
+}
+
+// roleError is a query refused because of the user's Grafana role
+type roleError struct{ msg string }
+
+func (e *roleError) Error() string { return e.msg }
+
+// queryScope returns the verified claims of the query's scope token, nil for unscoped queries.
+// With required tokens, queries from viewers and public dashboards must carry one; alert
+// rule evaluation is exempt.
+func (d *Datasource) queryScope(ctx context.Context, pCtx backend.PluginContext, qm QueryModel) (*ScopeClaims, error) {
+	if qm.ScopeToken == "" {
+		if d.settings.ScopeTokens.Required && !isAlertQuery(ctx) && !isEditorUser(pCtx.User) {
+			return nil, errors.New("this datasource requires a scope token for viewer queries")
+		}
+		return nil, nil
+	}
+
+	secret := d.secrets[scopeSecretKey]
+	if secret == "" {
+		return nil, errors.New("scope tokens are not configured for this datasource")
+	}
+	claims, err := parseScopeToken(secret, qm.ScopeToken, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Datasource != d.uid {
+		return nil, errors.New("scope token was issued for another datasource")
+	}
+	return claims, nil
+}
+
+// scopeError is a query refused by the scope token rules
+type scopeError struct{ err error }
+
+func (e *scopeError) Error() string { return e.err.Error() }
+func (e *scopeError) Unwrap() error { return e.err }
+
+type queryScopeKey struct{}
+
+func withQueryScope(ctx context.Context, claims *ScopeClaims) context.Context {
+	return context.WithValue(ctx, queryScopeKey{}, claims)
+}
+
+// queryScopeFrom returns the scope of the running query, nil when unscoped
+func queryScopeFrom(ctx context.Context) *ScopeClaims {
+	claims, _ := ctx.Value(queryScopeKey{}).(*ScopeClaims)
+	return claims
+}
+
+// scopeMetrics drops samples of hosts and containers outside the query's scope
+func scopeMetrics(ctx context.Context, host HostConfig, metrics []ContainerMetric) []ContainerMetric {
+	claims := queryScopeFrom(ctx)
+	if claims == nil {
+		return metrics
+	}
+	if !claims.allowsHost(host.ID) {
+		return nil
+	}
+	result := make([]ContainerMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if claims.allowsContainer(m.ContainerID, m.ContainerName) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// fetchMetrics fetches a host's metrics for a query, keeping only what the query's scope allows
+func (d *Datasource) fetchMetrics(ctx context.Context, host HostConfig, timeRange backend.TimeRange, metrics []string) ([]ContainerMetric, string, error) {
+	if !queryScopeFrom(ctx).allowsHost(host.ID) {
+		return nil, "", nil
+	}
+	samples, servedBy, err := d.fetchPrefetched(ctx, host, timeRange, metrics)
+	if err != nil {
+		return nil, "", err
+	}
+	return relabelMetrics(host, d.excludeMetrics(ctx, scopeMetrics(ctx, host, samples))), servedBy, nil
+}
+
+// scopeContainers drops containers outside the query's scope
+func scopeContainers(ctx context.Context, host HostConfig, containers []ContainerInfo) []ContainerInfo {
+	claims := queryScopeFrom(ctx)
+	if claims == nil {
+		return containers
+	}
+	if !claims.allowsHost(host.ID) {
+		return nil
+	}
+	result := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		if claims.allowsContainer(c.ContainerID, c.ContainerName) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// handleScopeTokens issues a scope token (POST /scope-tokens with
+// {hosts, containers, expiresInSeconds}). Issuing requires the Editor or Admin role.
+func (d *Datasource) handleScopeTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !isEditorRequest(r) {
+		writeError(w, http.StatusForbidden, "issuing scope tokens requires the Editor or Admin role")
+		return
+	}
+	secret := d.secrets[scopeSecretKey]
+	if secret == "" {
+		writeError(w, http.StatusBadRequest, "set the "+scopeSecretKey+" secure setting to issue scope tokens")
+		return
+	}
+
+	var body struct {
+		Hosts            []string `json:"hosts"`
+		Containers       []string `json:"containers"`
+		ExpiresInSeconds int64    `json:"expiresInSeconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	for _, id := range body.Hosts {
+		if _, ok := d.hosts.find(id); !ok {
+			writeError(w, http.StatusNotFound, "host not found: "+id)
+			return
+		}
+	}
+
+	now := time.Now()
+	claims := ScopeClaims{
+		Datasource: d.uid,
+		Hosts:      body.Hosts,
+		Containers: body.Containers,
+		IssuedAt:   now.Unix(),
+	}
+	if user := httpadapter.UserFromContext(r.Context()); user != nil {
+		claims.IssuedBy = user.Login
+	}
+	if body.ExpiresInSeconds > 0 {
+		claims.Expires = now.Unix() + body.ExpiresInSeconds
+	}
+
+	token, err := signScopeToken(secret, claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	d.logger.Info("Scope token issued", "issuedBy", claims.IssuedBy, "hosts", claims.Hosts, "containers", claims.Containers, "expires", claims.Expires)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"token": token, "claims": claims})
+}
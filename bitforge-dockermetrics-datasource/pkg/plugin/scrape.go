@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// defaultScrapePath is where Prometheus-mode hosts expose their metrics
+const defaultScrapePath = "/metrics"
+
+// scrapeSample is the latest cumulative CPU reading of a scraped container
+type scrapeSample struct {
+	cpuSeconds float64
+	at         time.Time
+}
+
+// scrapeCache remembers the previous scrape per host so CPU counters can be turned into a percentage
+type scrapeCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]scrapeSample // host ID -> container ID -> sample
+}
+
+func newScrapeCache() *scrapeCache {
+	return &scrapeCache{entries: make(map[string]map[string]scrapeSample)}
+}
+
+// cpuPercent records the sample and returns the CPU usage since the previous one
+func (c *scrapeCache) cpuPercent(hostID, containerID string, sample scrapeSample) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples, ok := c.entries[hostID]
+	if !ok {
+		samples = make(map[string]scrapeSample)
+		c.entries[hostID] = samples
+	}
+	prev, ok := samples[containerID]
+	samples[containerID] = sample
+
+	elapsed := sample.at.Sub(prev.at).Seconds()
+	if !ok || elapsed <= 0 || sample.cpuSeconds < prev.cpuSeconds {
+		return 0
+	}
+	return (sample.cpuSeconds - prev.cpuSeconds) / elapsed * 100
+}
+
+// scrapedContainer accumulates cAdvisor-style series for one container
+type scrapedContainer struct {
+	id, name, image string
+	labels          map[string]string
+	values          map[string]float64
+}
+
+// scrapePath returns the metrics path for a Prometheus-mode host
+func scrapePath(host HostConfig) string {
+	if host.MetricsPath != "" {
+		return "/" + strings.TrimPrefix(host.MetricsPath, "/")
+	}
+	return defaultScrapePath
+}
+
+// scrapeHost fetches and parses a host's exposition, grouping cAdvisor container_* series
+// by container. Series without a container name (cgroup roots, system slices) are skipped.
+func (d *Datasource) scrapeHost(ctx context.Context, host HostConfig) ([]*scrapedContainer, string, error) {
+	resp, servedBy, err := d.doHostRequest(ctx, host, http.MethodGet, scrapePath(host), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	byID := make(map[string]*scrapedContainer)
+	for name, family := range families {
+		if !strings.HasPrefix(name, "container_") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["name"] == "" || labels["id"] == "" {
+				continue
+			}
+
+			c, ok := byID[labels["id"]]
+			if !ok {
+				c = &scrapedContainer{
+					id:     scrapedContainerID(labels["id"]),
+					name:   "/" + strings.TrimPrefix(labels["name"], "/"),
+					image:  labels["image"],
+					labels: make(map[string]string),
+					values: make(map[string]float64),
+				}
+				for k, v := range labels {
+					if strings.HasPrefix(k, "container_label_") {
+						c.labels[strings.TrimPrefix(k, "container_label_")] = v
+					}
+				}
+				byID[labels["id"]] = c
+			}
+			// Per-interface and per-device series are summed
+			c.values[name] += sampleValue(family.GetType(), m)
+		}
+	}
+
+	containers := make([]*scrapedContainer, 0, len(byID))
+	for _, c := range byID {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].name < containers[j].name })
+	return containers, servedBy, nil
+}
+
+// scrapedContainerID extracts the container ID from a cgroup path such as
+// /docker/<id> or /system.slice/docker-<id>.scope
+func scrapedContainerID(cgroup string) string {
+	id := cgroup[strings.LastIndex(cgroup, "/")+1:]
+	id = strings.TrimSuffix(id, ".scope")
+	if i := strings.LastIndex(id, "-"); i >= 0 {
+		id = id[i+1:]
+	}
+	return id
+}
+
+func sampleValue(kind dto.MetricType, m *dto.Metric) float64 {
+	switch kind {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// fetchScrapedMetrics converts one scrape into a sample per container at scrape time.
+// Scrapes carry no history, so ranges that don't include now are empty; enable retention
+// to build history for Prometheus-mode hosts.
+func (d *Datasource) fetchScrapedMetrics(ctx context.Context, host HostConfig, timeRange backend.TimeRange) ([]ContainerMetric, string, error) {
+	containers, servedBy, err := d.scrapeHost(ctx, host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if now.Before(timeRange.From) || now.After(timeRange.To) {
+		return []ContainerMetric{}, servedBy, nil
+	}
+
+	result := make([]ContainerMetric, 0, len(containers))
+	for _, c := range containers {
+		v := c.values
+		m := ContainerMetric{
+			ContainerID:    c.id,
+			ContainerName:  c.name,
+			Timestamp:      SampleTime{Time: now.UTC()},
+			CPUPercent:     d.scrapes.cpuPercent(host.ID, c.id, scrapeSample{cpuSeconds: v["container_cpu_usage_seconds_total"], at: now}),
+			MemoryBytes:    v["container_memory_usage_bytes"],
+			NetworkRxBytes: v["container_network_receive_bytes_total"],
+			NetworkTxBytes: v["container_network_transmit_bytes_total"],
+			DiskReadBytes:  v["container_fs_reads_bytes_total"],
+			DiskWriteBytes: v["container_fs_writes_bytes_total"],
+			IsRunning:      true,
+		}
+		if limit := v["container_spec_memory_limit_bytes"]; limit > 0 {
+			m.MemoryPercent = m.MemoryBytes / limit * 100
+		}
+		if start := v["container_start_time_seconds"]; start > 0 {
+			m.UptimeSeconds = float64(now.Unix()) - start
+		}
+		result = append(result, m)
+	}
+
+	return result, servedBy, nil
+}
+
+// fetchScrapedContainerList lists scraped containers in the agent's /api/containers shape
+func (d *Datasource) fetchScrapedContainerList(ctx context.Context, host HostConfig) ([]ContainerInfo, error) {
+	containers, _, err := d.scrapeHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		result = append(result, ContainerInfo{
+			ContainerID:   c.id,
+			ContainerName: c.name,
+			State:         "Running",
+			IsRunning:     true,
+			Image:         c.image,
+			Labels:        c.labels,
+		})
+	}
+	return result, nil
+}
+
+// scrapedHostInfo describes a Prometheus-mode host; exporters are read-only and expose no PSI
+func scrapedHostInfo(host HostConfig) *AgentInfo {
+	noSupport := false
+	return &AgentInfo{
+		Hostname:        host.Name,
+		AgentVersion:    "prometheus",
+		DockerConnected: true,
+		Runtime:         RuntimeDocker,
+		Capabilities: &AgentCapabilities{
+			SupportsLogs:     &noSupport,
+			SupportsControls: &noSupport,
+		},
+	}
+}
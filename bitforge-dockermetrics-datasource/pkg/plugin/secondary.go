@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// reachability counts, per query, the agent requests that were answered and those that
+// reached no endpoint of their host
+type reachability struct {
+	mu          sync.Mutex
+	reached     int
+	unreachable int
+}
+
+type reachabilityKey struct{}
+
+func withReachability(ctx context.Context) (context.Context, *reachability) {
+	r := &reachability{}
+	return context.WithValue(ctx, reachabilityKey{}, r), r
+}
+
+// recordReachability counts an agent request of the query in ctx, if it is tracked
+func recordReachability(ctx context.Context, reached bool) {
+	r, _ := ctx.Value(reachabilityKey{}).(*reachability)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reached {
+		r.reached++
+	} else {
+		r.unreachable++
+	}
+}
+
+// allUnreachable reports whether the query tried agents and none answered
+func (r *reachability) allUnreachable() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reached == 0 && r.unreachable > 0
+}
+
+// readThroughQuery are the query fields the read-through looks at
+type readThroughQuery struct {
+	QueryType   string `json:"queryType"`
+	ReadThrough bool   `json:"readThrough"` // set on queries forwarded by another instance
+}
+
+// readThrough answers a query whose hosts were all unreachable from the secondary
+// datasource, through Grafana's /api/ds/query with the grafanaApiToken service account.
+// Control queries are never forwarded, and neither are queries that were forwarded to this
+// instance, so an active/passive pair pointing at each other can't loop. res is returned
+// unchanged when the secondary can't answer either.
+func (d *Datasource) readThrough(ctx context.Context, q backend.DataQuery, res backend.DataResponse) backend.DataResponse {
+	uid := d.settings.SecondaryDatasourceUID
+	token := d.secrets["grafanaApiToken"]
+	if uid == "" || token == "" || uid == d.uid {
+		return res
+	}
+	var rq readThroughQuery
+	if err := json.Unmarshal(q.JSON, &rq); err != nil || rq.QueryType == "control" || rq.ReadThrough {
+		return res
+	}
+
+	forwarded, err := d.querySecondary(ctx, uid, token, q)
+	if err != nil {
+		d.log(ctx).Warn("Secondary datasource read-through failed", "refId", q.RefID, "secondary", uid, "error", err)
+		return res
+	}
+	addFrameNotice(forwarded.Frames, &data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("No host was reachable, served by secondary datasource %s", uid),
+	})
+	d.log(ctx).Info("Query served by secondary datasource", "refId", q.RefID, "secondary", uid)
+	return forwarded
+}
+
+// querySecondary runs one query on another datasource through the Grafana API
+func (d *Datasource) querySecondary(ctx context.Context, uid, token string, q backend.DataQuery) (backend.DataResponse, error) {
+	appURL, err := backend.GrafanaConfigFromContext(ctx).AppURL()
+	if err != nil {
+		return backend.DataResponse{}, fmt.Errorf("grafana app URL unavailable: %w", err)
+	}
+
+	query := make(map[string]interface{})
+	if err := json.Unmarshal(q.JSON, &query); err != nil {
+		return backend.DataResponse{}, err
+	}
+	query["refId"] = q.RefID
+	query["datasource"] = map[string]string{"uid": uid}
+	query["readThrough"] = true
+	if q.Interval > 0 {
+		query["intervalMs"] = q.Interval.Milliseconds()
+	}
+	if q.MaxDataPoints > 0 {
+		query["maxDataPoints"] = q.MaxDataPoints
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"queries": []interface{}{query},
+		"from":    strconv.FormatInt(q.TimeRange.From.UnixMilli(), 10),
+		"to":      strconv.FormatInt(q.TimeRange.To.UnixMilli(), 10),
+	})
+	if err != nil {
+		return backend.DataResponse{}, err
+	}
+
+	var result backend.QueryDataResponse
+	if err := grafanaAPIRequest(ctx, http.MethodPost, strings.TrimSuffix(appURL, "/")+"/api/ds/query", token, payload, &result); err != nil {
+		return backend.DataResponse{}, err
+	}
+	res, ok := result.Responses[q.RefID]
+	if !ok {
+		return backend.DataResponse{}, fmt.Errorf("secondary returned no result for %s", q.RefID)
+	}
+	if res.Error != nil {
+		return backend.DataResponse{}, res.Error
+	}
+	return res, nil
+}
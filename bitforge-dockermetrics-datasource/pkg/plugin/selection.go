@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selection semantics of a host selection. Without one, the historical rules apply: a
+// container's containerMetrics replace everything, containers without them get every metric,
+// and blacklist mode fetches only what containerMetrics name.
+const (
+	// SelectionUnion shows a container's containerMetrics in addition to metrics
+	SelectionUnion = "union"
+	// SelectionIntersection shows only the metrics in both; an unset side doesn't restrict
+	SelectionIntersection = "intersection"
+)
+
+// explicitSelection reports whether the selection opted into union or intersection semantics
+func explicitSelection(hostSel HostSelection) bool {
+	return hostSel.SelectionSemantics != ""
+}
+
+// validateSelection rejects explicit selections whose fields contradict each other. Legacy
+// selections are left alone so existing dashboards keep working.
+func validateSelection(hostSel HostSelection) error {
+	for _, selector := range append(append([]string(nil), hostSel.IncludeLabels...), hostSel.ExcludeLabels...) {
+		if key, _, _ := strings.Cut(selector, "="); strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid label selector %q, expected key or key=value", selector)
+		}
+	}
+
+	switch hostSel.SelectionSemantics {
+	case "":
+		return nil
+	case SelectionUnion, SelectionIntersection:
+	default:
+		return fmt.Errorf("selectionSemantics must be %s or %s", SelectionUnion, SelectionIntersection)
+	}
+
+	whitelist := hostSel.Mode == "whitelist"
+	if !whitelist && hostSel.Mode != "blacklist" {
+		return fmt.Errorf("mode must be whitelist or blacklist")
+	}
+	for _, m := range hostSel.Metrics {
+		if !contains(AllMetrics, m) {
+			return fmt.Errorf("unknown metric %q", m)
+		}
+	}
+	for containerID, metrics := range hostSel.ContainerMetrics {
+		for _, m := range metrics {
+			if !contains(AllMetrics, m) {
+				return fmt.Errorf("container %s: unknown metric %q", containerID, m)
+			}
+		}
+		listed := contains(hostSel.ContainerIDs, containerID)
+		if whitelist && !listed {
+			return fmt.Errorf("containerMetrics lists %s, which the whitelist doesn't select", containerID)
+		}
+		if !whitelist && listed {
+			return fmt.Errorf("containerMetrics lists %s, which the blacklist excludes", containerID)
+		}
+	}
+
+	if whitelist {
+		if len(hostSel.ContainerIDs) == 0 && len(hostSel.IncludeLabels) == 0 {
+			return fmt.Errorf("the whitelist selects no containers")
+		}
+		for _, containerID := range hostSel.ContainerIDs {
+			if len(selectionMetrics(hostSel, containerID)) == 0 {
+				return fmt.Errorf("container %s selects no metrics", containerID)
+			}
+		}
+		return nil
+	}
+
+	if len(hostSel.Metrics) == 0 {
+		return fmt.Errorf("blacklist mode needs metrics for the containers without containerMetrics")
+	}
+	for containerID := range hostSel.ContainerMetrics {
+		if len(selectionMetrics(hostSel, containerID)) == 0 {
+			return fmt.Errorf("container %s selects no metrics", containerID)
+		}
+	}
+	return nil
+}
+
+// selectionMetrics returns the metrics an explicit selection shows for a container
+func selectionMetrics(hostSel HostSelection, containerID string) []string {
+	own := hostSel.ContainerMetrics[containerID]
+	if hostSel.SelectionSemantics == SelectionUnion {
+		result := append([]string(nil), hostSel.Metrics...)
+		for _, m := range own {
+			if !contains(result, m) {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+
+	switch {
+	case len(own) == 0:
+		return hostSel.Metrics
+	case len(hostSel.Metrics) == 0:
+		return own
+	}
+	result := make([]string, 0, len(own))
+	for _, m := range own {
+		if contains(hostSel.Metrics, m) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// selectionFetchMetrics returns every metric an explicit selection shows for some container
+func selectionFetchMetrics(hostSel HostSelection) []string {
+	result := make([]string, 0, len(hostSel.Metrics))
+	add := func(metrics []string) {
+		for _, m := range metrics {
+			if !contains(result, m) {
+				result = append(result, m)
+			}
+		}
+	}
+	if hostSel.Mode == "whitelist" {
+		for _, containerID := range hostSel.ContainerIDs {
+			add(selectionMetrics(hostSel, containerID))
+		}
+		if len(hostSel.IncludeLabels) > 0 {
+			// Containers selected by label have no containerMetrics of their own
+			add(hostSel.Metrics)
+		}
+		return result
+	}
+	add(hostSel.Metrics)
+	for containerID := range hostSel.ContainerMetrics {
+		add(selectionMetrics(hostSel, containerID))
+	}
+	return result
+}
+
+// selectsByLabel reports whether the selection has label selectors, which need the
+// containers' Docker labels
+func selectsByLabel(hostSel HostSelection) bool {
+	return len(hostSel.IncludeLabels) > 0 || len(hostSel.ExcludeLabels) > 0
+}
+
+// selects reports whether the selection shows a container. A whitelist selects the listed
+// containers and those matching every includeLabels selector; a blacklist selects the
+// containers not listed that match them. Containers matching an excludeLabels selector are
+// left out in both modes.
+func (hostSel HostSelection) selects(containerID string, labels map[string]string) bool {
+	listed := contains(hostSel.ContainerIDs, containerID)
+	included := len(hostSel.IncludeLabels) > 0 && matchesLabelSelectors(labels, hostSel.IncludeLabels)
+	var selected bool
+	if hostSel.Mode == "whitelist" {
+		selected = listed || included
+	} else {
+		selected = !listed && (len(hostSel.IncludeLabels) == 0 || included)
+	}
+	for _, selector := range hostSel.ExcludeLabels {
+		if matchesLabelSelectors(labels, []string{selector}) {
+			return false
+		}
+	}
+	return selected
+}
+
+// matchesLabelSelectors reports whether the Docker labels match every selector, "key=value" or
+// "key" for any value
+func matchesLabelSelectors(labels map[string]string, selectors []string) bool {
+	for _, selector := range selectors {
+		key, value, hasValue := strings.Cut(selector, "=")
+		actual, ok := labels[strings.TrimSpace(key)]
+		if !ok || (hasValue && actual != strings.TrimSpace(value)) {
+			return false
+		}
+	}
+	return true
+}
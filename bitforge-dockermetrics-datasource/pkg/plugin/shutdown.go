@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// controlDrainTimeout bounds how long Dispose waits for in-flight control actions
+const controlDrainTimeout = 15 * time.Second
+
+// inflightActions tracks control actions so Dispose can let them finish instead of cutting a
+// restart off halfway. Actions run detached from the query context and are only cancelled
+// once the drain window is over.
+type inflightActions struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool // set by drain, no new actions start
+	next    int
+	pending map[int]string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newInflightActions() *inflightActions {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &inflightActions{pending: make(map[int]string), ctx: ctx, cancel: cancel}
+}
+
+// start registers an action described by desc. It returns the context the action must run
+// with and a function to call with its outcome; ok is false once shutdown has begun.
+func (a *inflightActions) start(ctx context.Context, desc string) (actionCtx context.Context, done func(logger log.Logger, err error), ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil, nil, false
+	}
+
+	id := a.next
+	a.next++
+	a.pending[id] = desc
+	a.wg.Add(1)
+
+	actionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(a.ctx, cancel)
+
+	return actionCtx, func(logger log.Logger, err error) {
+		stop()
+		cancel()
+		a.mu.Lock()
+		delete(a.pending, id)
+		draining := a.closed
+		a.mu.Unlock()
+		a.wg.Done()
+
+		if draining {
+			if err != nil {
+				logger.Warn("Control action failed during shutdown", "action", desc, "error", err)
+			} else {
+				logger.Info("Control action completed during shutdown", "action", desc)
+			}
+		}
+	}, true
+}
+
+// drain refuses new actions and waits up to timeout for running ones. Actions still running
+// afterwards are cancelled and logged, since their outcome on the agent is unknown.
+func (a *inflightActions) drain(timeout time.Duration, logger log.Logger) {
+	a.mu.Lock()
+	a.closed = true
+	running := len(a.pending)
+	a.mu.Unlock()
+
+	if running > 0 {
+		logger.Info("Waiting for in-flight control actions", "count", running, "timeout", timeout)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		a.mu.Lock()
+		for _, desc := range a.pending {
+			logger.Error("Control action abandoned at shutdown, container state unknown", "action", desc)
+		}
+		a.mu.Unlock()
+	}
+	a.cancel()
+}
+
+// describeAction formats a control action for log lines
+func describeAction(host HostConfig, containerID, action string) string {
+	return fmt.Sprintf("%s %s on %s", action, containerID, host.Name)
+}
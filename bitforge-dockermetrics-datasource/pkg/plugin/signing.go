@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// signatureHeader carries the agent's detached signature of the response: base64 of an
+// ASN.1 ECDSA P-256 signature over the SHA-256 of the signed message, or of an Ed25519 signature.
+// The message is the request method, request URI, signatureTimeHeader and nonceHeader values and
+// the body, joined by newlines, so a response can't be replayed for another or a later request.
+const signatureHeader = "X-Agent-Signature"
+
+// signatureTimeHeader carries the Unix time in seconds the agent signed the response at
+const signatureTimeHeader = "X-Agent-Signature-Time"
+
+// nonceHeader carries a random value sent with each request to a host with a public key
+const nonceHeader = "X-Agent-Nonce"
+
+// maxSignatureAge is how far a signature's time may be from the local clock
+const maxSignatureAge = 5 * time.Minute
+
+// newAgentNonce returns a random request nonce
+func newAgentNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// signedAgentMessage is what an agent signs for a response to req
+func signedAgentMessage(req *http.Request, signedAt string, body []byte) []byte {
+	message := []byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + signedAt + "\n" + req.Header.Get(nonceHeader) + "\n")
+	return append(message, body...)
+}
+
+// agentSignatureError is a response that failed verification against the host's public key.
+// Replicas share the key, so the request isn't retried or sent to another agent.
+type agentSignatureError struct{ msg string }
+
+func (e *agentSignatureError) Error() string { return e.msg }
+
+// parseAgentKey reads a PEM encoded PKIX public key
+func parseAgentKey(pemText string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemText))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	}
+	return nil, errors.New("public key must be ECDSA or Ed25519")
+}
+
+// agentKeys caches parsed host public keys by their PEM text
+type agentKeys struct {
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+func newAgentKeys() *agentKeys {
+	return &agentKeys{keys: make(map[string]crypto.PublicKey)}
+}
+
+func (k *agentKeys) get(pemText string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[pemText]; ok {
+		return key, nil
+	}
+	key, err := parseAgentKey(pemText)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[pemText] = key
+	return key, nil
+}
+
+// verifyAgentResponse checks the signature of a successful response to req from a host with a
+// public key. The body is read and replaced so callers decode it as before.
+func (d *Datasource) verifyAgentResponse(host HostConfig, req *http.Request, resp *http.Response) error {
+	if host.PublicKey == "" || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	key, err := d.agentKeys.get(host.PublicKey)
+	if err != nil {
+		return &agentSignatureError{fmt.Sprintf("invalid public key for host %s: %v", host.Name, err)}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Get(signatureHeader)
+	if header == "" {
+		return &agentSignatureError{"agent response is not signed, check the agent's signing key"}
+	}
+	signature, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return &agentSignatureError{"agent response signature is malformed"}
+	}
+	signedAt := resp.Header.Get(signatureTimeHeader)
+	seconds, err := strconv.ParseInt(signedAt, 10, 64)
+	if err != nil {
+		return &agentSignatureError{"agent response has no signature time, check the agent version"}
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > maxSignatureAge || age < -maxSignatureAge {
+		return &agentSignatureError{fmt.Sprintf("agent response signature is stale (signed at %s), check the agent's clock", time.Unix(seconds, 0).UTC().Format(time.RFC3339))}
+	}
+
+	message := signedAgentMessage(req, signedAt, body)
+	valid := false
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		valid = ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, message, signature)
+	}
+	if !valid {
+		return &agentSignatureError{"agent response signature is invalid, the response may have been tampered with"}
+	}
+	return nil
+}
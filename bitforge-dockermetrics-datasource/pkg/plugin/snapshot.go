@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// addSnapshotFrames completes a metrics response in snapshot mode. The host metadata table
+// is added next to the containers frame, so Grafana snapshots and public dashboards store
+// everything the panel shows. Side frames are marked with "snapshot", telling panels to hide
+// controls that need live agents.
+func (d *Datasource) addSnapshotFrames(ctx context.Context, frames []*data.Frame, hosts []HostConfig) []*data.Frame {
+	frames = append(frames, d.buildHostsFrame(ctx, hosts))
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			continue
+		}
+		if custom, ok := frame.Meta.Custom.(map[string]interface{}); ok && custom["queryType"] != nil {
+			custom["snapshot"] = true
+		}
+	}
+	return frames
+}
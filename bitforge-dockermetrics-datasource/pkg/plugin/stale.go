@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultStaleAfter is six agent collection intervals
+const defaultStaleAfter = 60 * time.Second
+
+// staleAfter returns the configured staleness threshold
+func (d *Datasource) staleAfter() time.Duration {
+	if d.settings.StaleSeconds > 0 {
+		return time.Duration(d.settings.StaleSeconds) * time.Second
+	}
+	return defaultStaleAfter
+}
+
+// staleAge returns how old a host's newest sample is when that exceeds the staleness
+// threshold, otherwise 0. Ranges that end before the threshold are historical and never stale.
+func (d *Datasource) staleAge(timeRange backend.TimeRange, metrics []ContainerMetric, now time.Time) time.Duration {
+	threshold := d.staleAfter()
+	if len(metrics) == 0 || timeRange.To.Before(now.Add(-threshold)) {
+		return 0
+	}
+	var newest time.Time
+	for _, m := range metrics {
+		if m.Timestamp.After(newest) {
+			newest = m.Timestamp.Time
+		}
+	}
+	age := now.Sub(newest)
+	if age < threshold {
+		return 0
+	}
+	return age.Truncate(time.Second)
+}
+
+// staleNotice tells that a host's data stopped updating; nil when it is fresh
+func staleNotice(hostName string, age time.Duration) *data.Notice {
+	if age == 0 {
+		return nil
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Host %s data is stale: newest sample is %s old", hostName, age),
+	}
+}
+
+// ageField repeats a stale host's data age on every row. It is hidden from graphs and legends
+// so only tooltips, tables and field overrides see it.
+func ageField(age time.Duration, rows int) *data.Field {
+	values := make([]float64, rows)
+	for i := range values {
+		values[i] = age.Seconds()
+	}
+	field := data.NewField("ageSeconds", nil, values)
+	field.Config = &data.FieldConfig{
+		Unit: "s",
+		Custom: map[string]interface{}{
+			"hideFrom": map[string]bool{"viz": true, "legend": true, "tooltip": false},
+		},
+	}
+	return field
+}
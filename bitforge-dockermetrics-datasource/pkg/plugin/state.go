@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// sharedState is per-datasource state that must outlive a single instance.
+// Grafana re-creates the instance whenever settings change, so anything set at runtime
+// through resource endpoints, plus caches that should survive a settings save, lives here,
+// keyed by org ID and datasource UID.
+type sharedState struct {
+	registrations *registrationStore
+	maintenance   *maintenanceStore
+	endpoints     *endpointTracker
+	capabilities  *capabilityCache
+	metadata      *hostMetadataCache
+	overrides     *hostOverrides
+	scrapes       *scrapeCache
+	recordings    *recordingCache
+	inventory     *inventoryHistory
+	instances     *agentInstances
+	skews         *clockSkews
+	usage         *usageStats
+
+	// retention is opened by the first instance that enables it; BoltDB allows one handle per file
+	retention *retentionStore
+	// audit is opened like retention, by the first instance that enables the audit trail
+	audit *auditStore
+
+	// Settings and hosts of the latest instance, used to hot-reload (see reload)
+	mu       sync.Mutex
+	updated  time.Time
+	settings DatasourceSettings
+	hosts    *hostRegistry
+}
+
+// stateKey identifies a datasource across instances. UIDs are only unique within an org,
+// so two orgs may hold datasources with the same UID and must not share state.
+type stateKey struct {
+	orgID int64
+	uid   string
+}
+
+var (
+	sharedStatesMu sync.Mutex
+	sharedStates   = make(map[stateKey]*sharedState)
+)
+
+// sharedStateFor returns the shared state for a datasource, creating it on first use
+func sharedStateFor(orgID int64, uid string) *sharedState {
+	sharedStatesMu.Lock()
+	defer sharedStatesMu.Unlock()
+
+	key := stateKey{orgID: orgID, uid: uid}
+	state, ok := sharedStates[key]
+	if !ok {
+		state = &sharedState{
+			registrations: newRegistrationStore(),
+			maintenance:   newMaintenanceStore(),
+			endpoints:     newEndpointTracker(),
+			capabilities:  newCapabilityCache(),
+			metadata:      newHostMetadataCache(),
+			overrides:     newHostOverrides(),
+			scrapes:       newScrapeCache(),
+			recordings:    newRecordingCache(),
+			inventory:     newInventoryHistory(),
+			instances:     newAgentInstances(),
+			skews:         newClockSkews(),
+			usage:         newUsageStats(),
+		}
+		sharedStates[key] = state
+	}
+	return state
+}
+
+// checkOrg refuses requests from an org other than the one the instance was created for.
+// Grafana doesn't route them here; the check keeps one org's hosts and metrics out of
+// another's dashboards even if it ever did.
+func (d *Datasource) checkOrg(pCtx backend.PluginContext) error {
+	if pCtx.OrgID != d.orgID {
+		return fmt.Errorf("datasource belongs to org %d, request is from org %d", d.orgID, pCtx.OrgID)
+	}
+	return nil
+}
+
+// retentionStore returns the datasource's retention store, opening it on first use.
+// Changing the configured path closes the previous store.
+func (s *sharedState) retentionStore(path string) (*retentionStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retention != nil && s.retention.path == path {
+		return s.retention, nil
+	}
+	if s.retention != nil {
+		s.retention.db.Close()
+		s.retention = nil
+	}
+
+	store, err := openRetentionStore(path)
+	if err != nil {
+		return nil, err
+	}
+	s.retention = store
+	return store, nil
+}
+
+// auditStore returns the datasource's audit store, opening it on first use.
+// Changing the configured path closes the previous store.
+func (s *sharedState) auditStore(path string) (*auditStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.audit != nil && s.audit.path == path {
+		return s.audit, nil
+	}
+	if s.audit != nil {
+		s.audit.db.Close()
+		s.audit = nil
+	}
+
+	store, err := openAuditStore(path)
+	if err != nil {
+		return nil, err
+	}
+	s.audit = store
+	return store, nil
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// StateSeries are the container states "state" queries can return as 0/1 series
+var StateSeries = []string{"isRunning", "isUnhealthy", "isPaused"}
+
+func stateValue(m ContainerMetric, state string) float64 {
+	var on bool
+	switch state {
+	case "isRunning":
+		on = m.IsRunning
+	case "isUnhealthy":
+		on = m.IsUnhealthy
+	case "isPaused":
+		on = m.IsPaused
+	}
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// queryState returns one 0/1 series per container and requested state (default isRunning),
+// so uptime panels and alert rules don't have to work with string states
+func (d *Datasource) queryState(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	states := qm.States
+	if len(states) == 0 {
+		states = []string{"isRunning"}
+	}
+	for _, s := range states {
+		if !contains(StateSeries, s) {
+			response.Error = fmt.Errorf("states must be any of %s", strings.Join(StateSeries, ", "))
+			return response
+		}
+	}
+
+	hosts := d.excludeMaintenanceForAlerts(ctx, d.selectHosts(qm, qm.HostIDs))
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	frames := make(data.Frames, 0)
+	// The agent always includes the state flags, so the cheapest metric is enough to fetch them
+	collected := d.collectMetrics(ctx, hosts, qm, query.TimeRange, []string{"uptimeSeconds"})
+	for _, mwh := range collected {
+		byContainer := make(map[string][]ContainerMetric)
+		for _, m := range mwh.Metrics {
+			byContainer[m.ContainerID] = append(byContainer[m.ContainerID], m)
+		}
+
+		for containerID, samples := range byContainer {
+			sortMetricsByTime(samples)
+
+			labels := data.Labels{}
+			for k, v := range mwh.HostLabels {
+				labels[k] = v
+			}
+			for k, v := range mwh.ContainerLabels[containerID] {
+				labels[k] = v
+			}
+			labels["hostName"] = mwh.HostName
+			labels["containerName"] = samples[0].ContainerName
+			if !qm.Alerting {
+				labels["containerId"] = containerID
+			}
+
+			for _, state := range states {
+				times := make([]time.Time, 0, len(samples))
+				values := make([]float64, 0, len(samples))
+				for _, m := range samples {
+					t := m.Timestamp.Time
+					times = append(times, t)
+					values = append(values, stateValue(m, state))
+				}
+				if len(times) == 0 {
+					continue
+				}
+
+				valueField := data.NewField(state, labels, values)
+				valueField.Config = &data.FieldConfig{Min: ptrConfFloat(0), Max: ptrConfFloat(1)}
+				if !qm.Alerting {
+					valueField.Config.DisplayName = fmt.Sprintf("%s - %s", samples[0].ContainerName, state)
+					valueField.Config.Links = d.containerLinks()
+				}
+				frames = append(frames, data.NewFrame(state, data.NewField("time", nil, times), valueField).
+					SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeGraph}))
+			}
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		if frames[i].Name != frames[j].Name {
+			return frames[i].Name < frames[j].Name
+		}
+		return frames[i].Fields[1].Labels.String() < frames[j].Fields[1].Labels.String()
+	})
+	response.Frames = frames
+	reportInvalidPayloads(&response, collected)
+	return response
+}
+
+func ptrConfFloat(v float64) *data.ConfFloat64 {
+	f := data.ConfFloat64(v)
+	return &f
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// streamPathPrefix starts the path of live metrics channels: metrics/<hostId>[/<key>].
+// Subscribers of a channel share one stream that runs with the first subscriber's data, so
+// panels asking for different metrics need different keys (the frontend uses a query hash).
+const streamPathPrefix = "metrics/"
+
+const (
+	defaultStreamInterval = time.Second
+	minStreamInterval     = 500 * time.Millisecond
+	// streamBackfill is the history sent when a stream starts so panels don't open empty
+	streamBackfill = time.Minute
+)
+
+// StreamRequest is the data of a live metrics subscription
+type StreamRequest struct {
+	Metrics      []string `json:"metrics"`      // default cpuPercent and memoryPercent
+	ContainerIDs []string `json:"containerIds"` // empty for every container
+	IntervalMs   int      `json:"intervalMs"`   // how often the agent is polled, default 1000, at least 500
+	Adaptive     *bool    `json:"adaptive"`     // adapt the interval to the host's container churn, default true
+}
+
+// interval returns the agent poll interval of the stream
+func (r StreamRequest) interval() time.Duration {
+	interval := time.Duration(r.IntervalMs) * time.Millisecond
+	switch {
+	case interval <= 0:
+		return defaultStreamInterval
+	case interval < minStreamInterval:
+		return minStreamInterval
+	}
+	return interval
+}
+
+// adaptive reports whether the poll interval follows the host's churn
+func (r StreamRequest) adaptive() bool {
+	return r.Adaptive == nil || *r.Adaptive
+}
+
+// parseStream resolves a channel path and subscription data to the streamed host and request
+func (d *Datasource) parseStream(path string, raw json.RawMessage) (HostConfig, StreamRequest, error) {
+	var req StreamRequest
+	if !strings.HasPrefix(path, streamPathPrefix) {
+		return HostConfig{}, req, fmt.Errorf("unknown stream path %q", path)
+	}
+	hostID, _, _ := strings.Cut(strings.TrimPrefix(path, streamPathPrefix), "/")
+	host, ok := d.hosts.find(hostID)
+	if !ok || !host.Enabled {
+		return HostConfig{}, req, fmt.Errorf("host not found: %s", hostID)
+	}
+
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return HostConfig{}, req, fmt.Errorf("invalid stream data: %w", err)
+		}
+	}
+	if len(req.Metrics) == 0 {
+		req.Metrics = []string{"cpuPercent", "memoryPercent"}
+	}
+	for _, m := range req.Metrics {
+		if !contains(AllMetrics, m) {
+			return HostConfig{}, req, fmt.Errorf("unknown metric %q", m)
+		}
+	}
+	return host, req, nil
+}
+
+// SubscribeStream allows subscriptions to metrics channels of enabled hosts while the
+// streaming feature is on. Scope tokens can't be carried by channels, so with required
+// tokens only editors can subscribe.
+func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !d.featureEnabled(FeatureStreaming) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if err := d.checkOrg(req.PluginContext); err != nil {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+	if d.settings.ScopeTokens.Required && !isEditorUser(req.PluginContext.User) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+	if _, _, err := d.parseStream(req.Path, req.Data); err != nil {
+		d.logger.Debug("Refused stream subscription", "path", req.Path, "error", err)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream refuses publications, metrics channels are read-only
+func (d *Datasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream polls the host's agent and pushes samples newer than the last sent ones until
+// the last subscriber leaves or the instance is disposed. Failed polls are logged and retried
+// on the next tick. New points arrive as fast as the agent collects them. Unless the request
+// turns it off, the interval follows the host's container churn.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	host, sr, err := d.parseStream(req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.bgCtx, cancel)
+	defer stop()
+
+	d.logger.Debug("Starting metrics stream", "host", host.Name, "path", req.Path, "interval", sr.interval())
+	interval := sr.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	churn := newChurnTracker(time.Now())
+
+	sent := make(map[string]time.Time) // container ID -> newest sample sent
+	from := time.Now().Add(-streamBackfill)
+	include := data.IncludeAll
+	for {
+		samples, _, err := d.fetchMetrics(ctx, host, backend.TimeRange{From: from, To: time.Now()}, sr.Metrics)
+		if err != nil && ctx.Err() == nil {
+			d.logHostError(host, "Failed to fetch streamed metrics from host", err)
+		}
+		if err == nil && sr.adaptive() {
+			now := time.Now()
+			churn.observe(samples, now)
+			if next := churn.interval(sr.interval(), now); next != interval {
+				d.logger.Debug("Adapted stream poll interval", "host", host.Name, "path", req.Path, "interval", next)
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+
+		fresh := make([]ContainerMetric, 0, len(samples))
+		for _, m := range samples {
+			if len(sr.ContainerIDs) > 0 && !contains(sr.ContainerIDs, m.ContainerID) {
+				continue
+			}
+			if !m.Timestamp.After(sent[m.ContainerID]) {
+				continue
+			}
+			fresh = append(fresh, m)
+		}
+		if len(fresh) > 0 {
+			sortMetricsByTime(fresh)
+			for _, m := range fresh {
+				if m.Timestamp.After(sent[m.ContainerID]) {
+					sent[m.ContainerID] = m.Timestamp.Time
+				}
+				if m.Timestamp.After(from) {
+					from = m.Timestamp.Time
+				}
+			}
+			if err := sender.SendFrame(d.streamFrame(host, sr.Metrics, fresh), include); err != nil {
+				return err
+			}
+			include = data.IncludeDataOnly
+		}
+
+		select {
+		case <-ctx.Done():
+			d.logger.Debug("Stopped metrics stream", "host", host.Name, "path", req.Path)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamFrame builds the long frame of one stream packet: time, containerId, containerName
+// and one nullable field per metric, formatted like query frames. The schema never changes
+// within a stream, so packets after the first carry data only.
+func (d *Datasource) streamFrame(host HostConfig, metrics []string, samples []ContainerMetric) *data.Frame {
+	times := make([]time.Time, len(samples))
+	ids := make([]string, len(samples))
+	names := make([]string, len(samples))
+	for i, m := range samples {
+		times[i] = m.Timestamp.Time
+		ids[i] = m.ContainerID
+		names[i] = m.ContainerName
+	}
+	frame := data.NewFrame(host.Name,
+		data.NewField("time", nil, times),
+		data.NewField("containerId", nil, ids),
+		data.NewField("containerName", nil, names),
+	)
+
+	for _, metric := range metrics {
+		scale, unit, decimals := d.metricFormat(metric)
+		values := make([]*float64, len(samples))
+		for i, m := range samples {
+			if value, ok := displayMetricValue(m, metric); ok {
+				value *= scale
+				values[i] = &value
+			}
+		}
+		displayName := metricDisplayNames[metric]
+		if displayName == "" {
+			displayName = metric
+		}
+		field := data.NewField(metric, data.Labels{"hostName": host.Name}, values)
+		field.Config = &data.FieldConfig{DisplayNameFromDS: displayName, Unit: unit, Decimals: decimals}
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// hostSummary is one row of a "summary" query
+type hostSummary struct {
+	host                   HostConfig
+	containers             int64
+	running                int64
+	paused                 int64
+	unhealthy              int64
+	restarting             int64
+	cpuPercent, memory     *float64 // summed over the newest sample of each container, nil without samples
+	cpuCapacity, memoryCap *float64 // from /api/info, nil when the agent doesn't report them
+}
+
+// summarizeContainers counts containers by state
+func summarizeContainers(s *hostSummary, containers []ContainerInfo) {
+	for _, c := range containers {
+		s.containers++
+		if c.IsRunning {
+			s.running++
+		}
+		if c.IsPaused {
+			s.paused++
+		}
+		if c.IsUnhealthy {
+			s.unhealthy++
+		}
+		if strings.EqualFold(c.State, "restarting") {
+			s.restarting++
+		}
+	}
+}
+
+// percentOf returns part as a percentage of whole, nil when either is unknown
+func percentOf(part, whole *float64) *float64 {
+	if part == nil || whole == nil || *whole == 0 {
+		return nil
+	}
+	p := *part / *whole * 100
+	return &p
+}
+
+// querySummary returns one row per host with container counts by state and the CPU and
+// memory the containers consume against the host's capacity, for fleet overview stat panels
+func (d *Datasource) querySummary(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	// Usage is summed per container, aggregated series would be counted twice
+	qm.AggregateBy = ""
+	usage := make(map[string]metricsWithHost)
+	for _, mwh := range d.collectMetrics(ctx, hosts, qm, query.TimeRange, []string{"cpuPercent", "memoryBytes"}) {
+		usage[mwh.HostID] = mwh
+	}
+
+	summaries := make([]hostSummary, 0, len(hosts))
+	notices := make([]data.Notice, 0)
+	var lastErr error
+	for _, host := range hosts {
+		containers, err := d.fetchContainersFromHost(ctx, host)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch containers from host", err)
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+
+		filtered := make([]ContainerInfo, 0, len(containers))
+		for _, c := range containers {
+			if containerPattern != nil && !containerPattern.MatchString(c.ContainerName) {
+				continue
+			}
+			if len(qm.ContainerIDs) > 0 && !contains(qm.ContainerIDs, c.ContainerID) {
+				continue
+			}
+			if len(qm.Namespaces) > 0 && !contains(qm.Namespaces, c.Namespace) {
+				continue
+			}
+			if !matchesLabelFilters(containerSeriesLabels(c), qm.LabelFilters) {
+				continue
+			}
+			filtered = append(filtered, c)
+		}
+
+		s := hostSummary{host: host}
+		summarizeContainers(&s, filtered)
+
+		if mwh, ok := usage[host.ID]; ok && len(mwh.Metrics) > 0 {
+			var cpu, memory float64
+			for _, m := range latestPerContainer(mwh.Metrics) {
+				// Values the agent couldn't compute count as nothing
+				if v, ok := rawMetricValue(m, "cpuPercent"); ok {
+					cpu += v
+				}
+				if v, ok := rawMetricValue(m, "memoryBytes"); ok {
+					memory += v
+				}
+			}
+			s.cpuPercent, s.memory = &cpu, &memory
+		}
+
+		if info, err := d.hostMetadata(ctx, host); err == nil {
+			if info.CPUCount > 0 {
+				// cpuPercent is 100 per fully used core
+				capacity := float64(info.CPUCount) * 100
+				s.cpuCapacity = &capacity
+			}
+			if info.MemoryTotalBytes > 0 {
+				capacity := float64(info.MemoryTotalBytes)
+				s.memoryCap = &capacity
+			}
+		}
+		summaries = append(summaries, s)
+	}
+
+	if len(summaries) == 0 {
+		response.Error = lastErr
+		return response
+	}
+
+	n := len(summaries)
+	hostIDs := make([]string, n)
+	hostNames := make([]string, n)
+	total := make([]int64, n)
+	running := make([]int64, n)
+	paused := make([]int64, n)
+	unhealthy := make([]int64, n)
+	restarting := make([]int64, n)
+	cpu := make([]*float64, n)
+	cpuCapacity := make([]*float64, n)
+	cpuUsed := make([]*float64, n)
+	memory := make([]*float64, n)
+	memoryCapacity := make([]*float64, n)
+	memoryUsed := make([]*float64, n)
+	for i, s := range summaries {
+		hostIDs[i] = s.host.ID
+		hostNames[i] = s.host.Name
+		total[i] = s.containers
+		running[i] = s.running
+		paused[i] = s.paused
+		unhealthy[i] = s.unhealthy
+		restarting[i] = s.restarting
+		cpu[i] = s.cpuPercent
+		cpuCapacity[i] = s.cpuCapacity
+		cpuUsed[i] = percentOf(s.cpuPercent, s.cpuCapacity)
+		memory[i] = s.memory
+		memoryCapacity[i] = s.memoryCap
+		memoryUsed[i] = percentOf(s.memory, s.memoryCap)
+	}
+
+	withUnit := func(field *data.Field, unit string) *data.Field {
+		field.Config = &data.FieldConfig{Unit: unit}
+		return field
+	}
+	frame := data.NewFrame("summary",
+		data.NewField("hostId", nil, hostIDs),
+		data.NewField("hostName", nil, hostNames),
+		data.NewField("containers", nil, total),
+		data.NewField("running", nil, running),
+		data.NewField("paused", nil, paused),
+		data.NewField("unhealthy", nil, unhealthy),
+		data.NewField("restarting", nil, restarting),
+		withUnit(data.NewField("cpuPercent", nil, cpu), "percent"),
+		withUnit(data.NewField("cpuCapacityPercent", nil, cpuCapacity), "percent"),
+		withUnit(data.NewField("cpuUsedPercent", nil, cpuUsed), "percent"),
+		withUnit(data.NewField("memoryBytes", nil, memory), "bytes"),
+		withUnit(data.NewField("memoryCapacityBytes", nil, memoryCapacity), "bytes"),
+		withUnit(data.NewField("memoryUsedPercent", nil, memoryUsed), "percent"),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable, Notices: notices}
+	response.Frames = data.Frames{frame}
+	return response
+}
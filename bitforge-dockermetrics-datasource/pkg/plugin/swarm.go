@@ -0,0 +1,305 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// SwarmSettings turns on swarm mode, in which "swarmServices" queries read the services and
+// their replica counts from the agent on a swarm manager
+type SwarmSettings struct {
+	Enabled bool `json:"enabled"`
+	// ManagerHostID is the host whose agent runs on a manager; by default the selected hosts are
+	// asked in order until one answers
+	ManagerHostID string `json:"managerHostId"`
+}
+
+// validateSwarm reports a manager host that isn't configured
+func validateSwarm(s SwarmSettings, hosts []HostConfig) []string {
+	if !s.Enabled || s.ManagerHostID == "" {
+		return nil
+	}
+	for _, h := range hosts {
+		if h.ID == s.ManagerHostID {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("swarm manager host %s is not configured, the selected hosts are asked instead", s.ManagerHostID)}
+}
+
+// SwarmService is a service entry returned by a manager agent's /api/swarm/services endpoint
+type SwarmService struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Mode         string `json:"mode"`         // replicated or global
+	DesiredTasks int64  `json:"desiredTasks"` // replicas, or eligible nodes of a global service
+	RunningTasks int64  `json:"runningTasks"`
+}
+
+// swarmTaskSlot returns the part of a task name that survives reschedules: "<service>.<slot>"
+// for replicated services and "<service>.<node ID>" for global ones, without the task ID
+func swarmTaskSlot(taskName string) string {
+	if i := strings.LastIndex(taskName, "."); i > 0 {
+		return taskName[:i]
+	}
+	return taskName
+}
+
+// fetchSwarmServicesFromHost gets the services known to a host's agent; agents on workers
+// answer 409 because only managers know the services
+func (d *Datasource) fetchSwarmServicesFromHost(ctx context.Context, host HostConfig) ([]SwarmService, error) {
+	resp, _, err := d.getWithRetry(ctx, host, "/api/swarm/services")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	var services []SwarmService
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, fmt.Errorf("failed to decode service list: %w", err)
+	}
+	return services, nil
+}
+
+// fetchSwarmServices asks the configured manager host, or else each of hosts until one answers
+func (d *Datasource) fetchSwarmServices(ctx context.Context, hosts []HostConfig) ([]SwarmService, error) {
+	if id := d.settings.Swarm.ManagerHostID; id != "" {
+		if host, ok := d.hosts.find(id); ok && host.Enabled {
+			hosts = []HostConfig{host}
+		}
+	}
+	err := fmt.Errorf("no swarm manager among the selected hosts")
+	for _, host := range hosts {
+		services, fetchErr := d.fetchSwarmServicesFromHost(ctx, host)
+		if fetchErr == nil {
+			return services, nil
+		}
+		d.logger.Debug("Host did not list swarm services", "host", host.Name, "error", fetchErr)
+		err = fetchErr
+	}
+	return nil, err
+}
+
+// swarmServiceRow is one row of a "swarmServices" query
+type swarmServiceRow struct {
+	name, mode                string
+	desired, replicas         *int64 // from the manager, nil when no manager answered
+	hosts                     map[string]bool
+	tasks, running, unhealthy int64
+	values                    map[string][]float64 // metric -> newest value of each task container
+}
+
+// querySwarmServices returns one row per swarm service: its mode and desired and running
+// replicas as the manager reports them, the nodes among the selected hosts its tasks run on,
+// their containers by state and, per metric (default cpuPercent and memoryBytes), the newest
+// sample of each task container reduced with the query's aggregation (default sum). Tasks are
+// matched by their service label, so rows follow a service across reschedules.
+func (d *Datasource) querySwarmServices(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+	if !d.settings.Swarm.Enabled {
+		response.Error = fmt.Errorf("swarm mode is not enabled for this datasource")
+		return response
+	}
+
+	fn := qm.Aggregation
+	switch fn {
+	case "":
+		fn = AggregationSum
+	case AggregationSum, AggregationAvg, AggregationMin, AggregationMax, AggregationCount:
+	default:
+		response.Error = fmt.Errorf("aggregation must be sum, avg, min, max or count")
+		return response
+	}
+	metrics := qm.Metrics
+	if len(metrics) == 0 {
+		metrics = []string{"cpuPercent", "memoryBytes"}
+	}
+	for _, metric := range metrics {
+		if !contains(AllMetrics, metric) {
+			response.Error = fmt.Errorf("unknown metric %q", metric)
+			return response
+		}
+	}
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	rows := make(map[string]*swarmServiceRow)
+	service := func(name string) *swarmServiceRow {
+		s := rows[name]
+		if s == nil {
+			s = &swarmServiceRow{name: name, hosts: make(map[string]bool), values: make(map[string][]float64)}
+			rows[name] = s
+		}
+		return s
+	}
+
+	notices := make([]data.Notice, 0)
+	services, err := d.fetchSwarmServices(ctx, hosts)
+	if err != nil {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Swarm services: %v, replica counts are unknown", err),
+		})
+	}
+	for _, s := range services {
+		row := service(s.Name)
+		desired, running := s.DesiredTasks, s.RunningTasks
+		row.mode, row.desired, row.replicas = s.Mode, &desired, &running
+	}
+
+	answered := false
+	var lastErr error
+	runningTasks := make(map[string]bool) // host ID + "/" + container ID
+	for _, host := range hosts {
+		containers, err := d.fetchContainersFromHost(ctx, host)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch containers from host", err)
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+		answered = true
+		for _, c := range containers {
+			name := c.Labels[swarmServiceNameLabel]
+			if name == "" {
+				continue
+			}
+			if containerPattern != nil && !containerPattern.MatchString(c.ContainerName) {
+				continue
+			}
+			if !matchesLabelFilters(containerSeriesLabels(c), qm.LabelFilters) {
+				continue
+			}
+			s := service(name)
+			s.hosts[host.ID] = true
+			s.tasks++
+			if c.IsRunning {
+				s.running++
+				runningTasks[host.ID+"/"+c.ContainerID] = true
+			}
+			if c.IsUnhealthy {
+				s.unhealthy++
+			}
+		}
+	}
+	if !answered {
+		response.Error = lastErr
+		return response
+	}
+
+	// Values are per task container, aggregated series would be counted as one task. Swarm
+	// keeps the containers of replaced tasks, their last samples are left out.
+	qm.AggregateBy = ""
+	for _, mwh := range d.collectMetrics(ctx, hosts, qm, query.TimeRange, metrics) {
+		for _, m := range latestPerContainer(mwh.Metrics) {
+			name := mwh.ContainerLabels[m.ContainerID]["swarmService"]
+			if name == "" || !runningTasks[mwh.HostID+"/"+m.ContainerID] {
+				continue
+			}
+			s := service(name)
+			for _, metric := range metrics {
+				if value, ok := displayMetricValue(m, metric); ok {
+					s.values[metric] = append(s.values[metric], value)
+				}
+			}
+		}
+	}
+
+	// The manager lists every service, scoped queries only show those with a task in scope
+	scoped := queryScopeFrom(ctx) != nil
+	sorted := make([]*swarmServiceRow, 0, len(rows))
+	for _, s := range rows {
+		if scoped && s.tasks == 0 {
+			continue
+		}
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+
+	n := len(sorted)
+	names := make([]string, n)
+	modes := make([]string, n)
+	desired := make([]*int64, n)
+	replicas := make([]*int64, n)
+	nodes := make([]int64, n)
+	tasks := make([]int64, n)
+	running := make([]int64, n)
+	unhealthy := make([]int64, n)
+	for i, s := range sorted {
+		names[i] = s.name
+		modes[i] = s.mode
+		desired[i] = s.desired
+		replicas[i] = s.replicas
+		nodes[i] = int64(len(s.hosts))
+		tasks[i] = s.tasks
+		running[i] = s.running
+		unhealthy[i] = s.unhealthy
+	}
+	frame := data.NewFrame("swarmServices",
+		data.NewField("swarmService", nil, names),
+		data.NewField("mode", nil, modes),
+		data.NewField("desiredReplicas", nil, desired),
+		data.NewField("runningReplicas", nil, replicas),
+		data.NewField("nodes", nil, nodes),
+		data.NewField("tasks", nil, tasks),
+		data.NewField("running", nil, running),
+		data.NewField("unhealthy", nil, unhealthy),
+	)
+	for _, metric := range metrics {
+		scale, unit, decimals := d.metricFormat(metric)
+		values := make([]*float64, n)
+		for i, s := range sorted {
+			if samples := s.values[metric]; len(samples) > 0 {
+				value := reduceValues(fn, samples)
+				if fn != AggregationCount {
+					value *= scale
+				}
+				values[i] = &value
+			}
+		}
+		displayName := metricDisplayNames[metric]
+		if displayName == "" {
+			displayName = metric
+		}
+		field := data.NewField(metric, nil, values)
+		field.Config = &data.FieldConfig{DisplayNameFromDS: displayName}
+		if fn != AggregationCount {
+			field.Config.Unit, field.Config.Decimals = unit, decimals
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Notices:                notices,
+		Custom:                 map[string]interface{}{"queryType": "swarmServices"},
+	}
+	response.Frames = data.Frames{frame}
+	return response
+}
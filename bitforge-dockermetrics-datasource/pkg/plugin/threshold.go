@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// thresholdOperators are the comparisons accepted by threshold queries
+var thresholdOperators = []string{">", ">=", "<", "<=", "==", "!="}
+
+// displayMetricValue returns a sample's value in the units shown on dashboards (byte metrics in MB)
+func displayMetricValue(m ContainerMetric, metric string) (float64, bool) {
+	value, ok := rawMetricValue(m, metric)
+	if ok && strings.HasSuffix(metric, "Bytes") {
+		value /= 1024.0 * 1024.0
+	}
+	return value, ok
+}
+
+func compareThreshold(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// queryThreshold returns the containers whose latest sample violates "<metric> <operator> <threshold>",
+// one numeric frame per violating container holding its current value (in dashboard units).
+// Containers within the threshold are left out, so an alert rule sees no series when
+// nothing is breaching.
+func (d *Datasource) queryThreshold(ctx context.Context, query backend.DataQuery, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	if !contains(AllMetrics, qm.ThresholdMetric) {
+		response.Error = fmt.Errorf("thresholdMetric must be one of %s", strings.Join(AllMetrics, ", "))
+		return response
+	}
+	operator := qm.ThresholdOperator
+	if operator == "" {
+		operator = ">"
+	}
+	if !contains(thresholdOperators, operator) {
+		response.Error = fmt.Errorf("thresholdOperator must be one of %s", strings.Join(thresholdOperators, " "))
+		return response
+	}
+
+	hosts := d.excludeMaintenanceForAlerts(ctx, d.selectHosts(qm, qm.HostIDs))
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	frames := make(data.Frames, 0)
+	collected := d.collectMetrics(ctx, hosts, qm, query.TimeRange, []string{qm.ThresholdMetric})
+	for _, mwh := range collected {
+		for _, m := range latestPerContainer(mwh.Metrics) {
+			value, ok := displayMetricValue(m, qm.ThresholdMetric)
+			if !ok || !compareThreshold(value, operator, qm.Threshold) {
+				continue
+			}
+			t := m.Timestamp.Time
+
+			labels := data.Labels{}
+			for k, v := range mwh.HostLabels {
+				labels[k] = v
+			}
+			for k, v := range mwh.ContainerLabels[m.ContainerID] {
+				labels[k] = v
+			}
+			labels["hostName"] = mwh.HostName
+			labels["containerName"] = m.ContainerName
+
+			valueField := data.NewField(qm.ThresholdMetric, labels, []float64{value})
+			valueField.Config = &data.FieldConfig{Unit: metricUnits[qm.ThresholdMetric]}
+			frames = append(frames, data.NewFrame("threshold",
+				data.NewField("time", nil, []time.Time{t}),
+				valueField,
+			).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeTable}))
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Fields[1].Labels.String() < frames[j].Fields[1].Labels.String()
+	})
+	response.Frames = frames
+	reportInvalidPayloads(&response, collected)
+	return response
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localTimestampLayouts are agent timestamp formats without a zone; they are read in the
+// host's configured timezone
+var localTimestampLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// SampleTime is a sample timestamp as sent by the agent: either an RFC3339 string or, when
+// requested with timestamps=epoch, an integer epoch value that needs no string parsing.
+// It is always written back as RFC3339Nano.
+type SampleTime struct {
+	time.Time
+	// zoneless is set for local times without an offset; they are re-read in the host's timezone
+	zoneless bool
+}
+
+// MarshalJSON writes the timestamp as an RFC3339Nano UTC string
+func (t SampleTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+// UnmarshalJSON accepts epoch numbers and timestamp strings. Values that can't be read
+// leave the zero time, so one bad sample doesn't fail the whole response.
+func (t *SampleTime) UnmarshalJSON(b []byte) error {
+	*t = SampleTime{}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if b[0] != '"' {
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			t.Time = epochTime(n)
+		}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil
+	}
+	if parsed, zoneless, err := parseAgentTimestamp(value); err == nil {
+		t.Time, t.zoneless = parsed, zoneless
+	}
+	return nil
+}
+
+// epochTime converts an integer epoch timestamp, guessing the unit from its magnitude:
+// seconds, milliseconds (the agent's epoch format), microseconds or nanoseconds
+func epochTime(n int64) time.Time {
+	switch {
+	case n > 1e17:
+		return time.Unix(0, n)
+	case n > 1e14:
+		return time.UnixMicro(n)
+	case n > 1e11:
+		return time.UnixMilli(n)
+	default:
+		return time.Unix(n, 0)
+	}
+}
+
+// parseAgentTimestamp accepts RFC3339 (with or without fractional seconds), epoch
+// milliseconds and local times without a zone, which are parsed as UTC and flagged zoneless
+func parseAgentTimestamp(value string) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, false, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), false, nil
+	}
+	for _, layout := range localTimestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// hostLocation returns the timezone for zone-less agent timestamps, UTC unless configured
+func hostLocation(host HostConfig) *time.Location {
+	if host.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(host.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// normalizeTimestamps moves agent timestamps to UTC, reading zone-less ones in the host's
+// timezone. Samples whose timestamps couldn't be read are dropped and counted.
+func normalizeTimestamps(host HostConfig, metrics []ContainerMetric) ([]ContainerMetric, int) {
+	loc := hostLocation(host)
+	result := metrics[:0]
+	dropped := 0
+	for _, m := range metrics {
+		if m.Timestamp.IsZero() {
+			dropped++
+			continue
+		}
+		t := m.Timestamp.Time
+		if m.Timestamp.zoneless {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+		m.Timestamp = SampleTime{Time: t.UTC()}
+		result = append(result, m)
+	}
+	return result, dropped
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Kinds of topology nodes and edges
+const (
+	topologyContainer = "container"
+	topologyNetwork   = "network"
+	topologyDependsOn = "depends_on"
+	topologyLink      = "link"
+)
+
+// topologyNode is a container or a network of one host; IDs are prefixed with the host ID so
+// nodes of different hosts never merge
+type topologyNode struct {
+	id, title, subtitle, mainStat string
+	hostName, kind                string
+}
+
+// topologyEdge runs from a container to a network it is attached to, a compose service it
+// depends on or a container it links to
+type topologyEdge struct {
+	source, target, kind string
+}
+
+// hostTopology derives the nodes and edges of a host's containers. Containers on the host and
+// none networks share no network with anything, so those networks are left out.
+func hostTopology(host HostConfig, containers []ContainerInfo) ([]topologyNode, []topologyEdge) {
+	containers = append([]ContainerInfo(nil), containers...)
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].ContainerName != containers[j].ContainerName {
+			return containers[i].ContainerName < containers[j].ContainerName
+		}
+		return containers[i].ContainerID < containers[j].ContainerID
+	})
+	containerNode := func(c ContainerInfo) string { return host.ID + "/" + c.ContainerID }
+
+	nodes := make([]topologyNode, 0, len(containers))
+	byName := make(map[string]ContainerInfo, len(containers))
+	for _, c := range containers {
+		nodes = append(nodes, topologyNode{
+			id:       containerNode(c),
+			title:    c.ContainerName,
+			subtitle: c.Image,
+			mainStat: c.State,
+			hostName: host.Name,
+			kind:     topologyContainer,
+		})
+		byName[strings.TrimPrefix(c.ContainerName, "/")] = c
+	}
+
+	edges := make([]topologyEdge, 0)
+	seen := make(map[topologyEdge]bool)
+	addEdge := func(e topologyEdge) {
+		if e.source != e.target && !seen[e] {
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+	networks := make(map[string]bool)
+	for _, c := range containers {
+		for _, network := range c.Networks {
+			if network == "" || network == "host" || network == "none" {
+				continue
+			}
+			id := host.ID + "/network/" + network
+			if !networks[id] {
+				networks[id] = true
+				nodes = append(nodes, topologyNode{id: id, title: network, subtitle: topologyNetwork, hostName: host.Name, kind: topologyNetwork})
+			}
+			addEdge(topologyEdge{source: containerNode(c), target: id, kind: topologyNetwork})
+		}
+
+		// depends_on entries are service:condition:restart, the services are of the same project
+		if dependsOn := c.Labels[composeDependsOnLabel]; dependsOn != "" {
+			project := c.Labels[composeProjectLabel]
+			for _, entry := range strings.Split(dependsOn, ",") {
+				service, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+				for _, dep := range containers {
+					if service != "" && dep.Labels[composeProjectLabel] == project && dep.Labels[composeServiceLabel] == service {
+						addEdge(topologyEdge{source: containerNode(c), target: containerNode(dep), kind: topologyDependsOn})
+					}
+				}
+			}
+		}
+
+		for _, link := range c.Links {
+			if target, ok := byName[strings.TrimPrefix(link, "/")]; ok {
+				addEdge(topologyEdge{source: containerNode(c), target: containerNode(target), kind: topologyLink})
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// queryTopology returns the container topology of the selected hosts as the nodes and edges
+// frames of Grafana's Node Graph panel. Container filters apply to the container nodes;
+// networks only appear while a selected container is attached to them.
+func (d *Datasource) queryTopology(ctx context.Context, qm QueryModel) backend.DataResponse {
+	var response backend.DataResponse
+
+	var containerPattern *regexp.Regexp
+	if qm.ContainerNamePattern != "" {
+		var err error
+		if containerPattern, err = regexp.Compile(qm.ContainerNamePattern); err != nil {
+			response.Error = fmt.Errorf("invalid container name pattern: %w", err)
+			return response
+		}
+	}
+
+	hosts := d.selectHosts(qm, qm.HostIDs)
+	if len(hosts) == 0 {
+		response.Error = fmt.Errorf("no enabled hosts configured")
+		return response
+	}
+
+	nodes := make([]topologyNode, 0)
+	edges := make([]topologyEdge, 0)
+	notices := make([]data.Notice, 0)
+	answered := false
+	var lastErr error
+	for _, host := range hosts {
+		containers, err := d.fetchContainersFromHost(ctx, host)
+		if err != nil {
+			d.logHostError(host, "Failed to fetch containers from host", err)
+			lastErr = err
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Host %s: %v", host.Name, err),
+			})
+			continue
+		}
+		answered = true
+
+		filtered := make([]ContainerInfo, 0, len(containers))
+		for _, c := range containers {
+			if containerPattern != nil && !containerPattern.MatchString(c.ContainerName) {
+				continue
+			}
+			if len(qm.ContainerIDs) > 0 && !contains(qm.ContainerIDs, c.ContainerID) {
+				continue
+			}
+			if len(qm.Namespaces) > 0 && !contains(qm.Namespaces, c.Namespace) {
+				continue
+			}
+			if !matchesLabelFilters(containerSeriesLabels(c), qm.LabelFilters) {
+				continue
+			}
+			filtered = append(filtered, c)
+		}
+		hostNodes, hostEdges := hostTopology(host, filtered)
+		nodes = append(nodes, hostNodes...)
+		edges = append(edges, hostEdges...)
+	}
+
+	if !answered {
+		response.Error = lastErr
+		return response
+	}
+
+	nodeIDs := make([]string, len(nodes))
+	titles := make([]string, len(nodes))
+	subtitles := make([]string, len(nodes))
+	mainStats := make([]string, len(nodes))
+	hostNames := make([]string, len(nodes))
+	kinds := make([]string, len(nodes))
+	for i, n := range nodes {
+		nodeIDs[i] = n.id
+		titles[i] = n.title
+		subtitles[i] = n.subtitle
+		mainStats[i] = n.mainStat
+		hostNames[i] = n.hostName
+		kinds[i] = n.kind
+	}
+	nodeFrame := data.NewFrame("nodes",
+		data.NewField("id", nil, nodeIDs),
+		data.NewField("title", nil, titles),
+		data.NewField("subtitle", nil, subtitles),
+		data.NewField("mainstat", nil, mainStats),
+		data.NewField("detail__hostName", nil, hostNames),
+		data.NewField("detail__kind", nil, kinds),
+	)
+	nodeFrame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph, Notices: notices}
+
+	edgeIDs := make([]string, len(edges))
+	sources := make([]string, len(edges))
+	targets := make([]string, len(edges))
+	edgeKinds := make([]string, len(edges))
+	for i, e := range edges {
+		edgeIDs[i] = e.source + "->" + e.target + "/" + e.kind
+		sources[i] = e.source
+		targets[i] = e.target
+		edgeKinds[i] = e.kind
+	}
+	edgeFrame := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIDs),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainstat", nil, edgeKinds),
+	)
+	edgeFrame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+
+	response.Frames = data.Frames{nodeFrame, edgeFrame}
+	return response
+}
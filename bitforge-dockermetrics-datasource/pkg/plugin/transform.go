@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transforms for QueryModel.Transforms
+const (
+	TransformNone  = "none"
+	TransformRate  = "rate"  // increase per second
+	TransformDelta = "delta" // increase since the previous sample
+)
+
+// counterMetrics are the cumulative metrics transforms apply to; gauges have no increase
+var counterMetrics = []string{"networkRxBytes", "networkTxBytes", "diskReadBytes", "diskWriteBytes"}
+
+// rateUnits are the per-second units of byte units; rates of other units are unitless
+var rateUnits = map[string]string{
+	"bytes":     "Bps",
+	"decbytes":  "Bps",
+	"deckbytes": "KBs",
+	"decmbytes": "MBs",
+	"decgbytes": "GBs",
+	"kbytes":    "KiBs",
+	"mbytes":    "MiBs",
+	"gbytes":    "GiBs",
+}
+
+// validateTransforms checks the query's transform per metric
+func validateTransforms(qm QueryModel) error {
+	for metric, transform := range qm.Transforms {
+		if !contains(AllMetrics, metric) {
+			return fmt.Errorf("transforms: unknown metric %q", metric)
+		}
+		switch transform {
+		case "", TransformNone:
+		case TransformRate, TransformDelta:
+			if !contains(counterMetrics, metric) {
+				return fmt.Errorf("transforms: %s is not a counter, only %s can be transformed", metric, strings.Join(counterMetrics, ", "))
+			}
+		default:
+			return fmt.Errorf("transforms: %s must be rate, delta or none", metric)
+		}
+	}
+	return nil
+}
+
+// applyTransform turns a series into its increase per sample (delta) or per second (rate).
+// A decrease is a counter reset, e.g. a restarted container, so the new value is the
+// increase since the reset. The first sample and samples next to a null have no increase.
+func applyTransform(transform string, times []time.Time, values []*float64) []*float64 {
+	if transform != TransformRate && transform != TransformDelta {
+		return values
+	}
+	result := make([]*float64, len(values))
+	for i := 1; i < len(values); i++ {
+		prev, cur := values[i-1], values[i]
+		if prev == nil || cur == nil {
+			continue
+		}
+		increase := *cur - *prev
+		if increase < 0 {
+			increase = *cur
+		}
+		if transform == TransformRate {
+			seconds := times[i].Sub(times[i-1]).Seconds()
+			if seconds <= 0 {
+				continue
+			}
+			increase /= seconds
+		}
+		result[i] = &increase
+	}
+	return result
+}
+
+// transformFormat returns the unit and display name of transformed values
+func transformFormat(transform, unit, displayName string) (string, string) {
+	switch transform {
+	case TransformRate:
+		rateUnit := rateUnits[unit]
+		// "Network RX (MB)" becomes "Network RX (MB/s)"
+		if strings.HasSuffix(displayName, ")") {
+			return rateUnit, strings.TrimSuffix(displayName, ")") + "/s)"
+		}
+		return rateUnit, displayName + " /s"
+	case TransformDelta:
+		return unit, displayName + " delta"
+	}
+	return unit, displayName
+}
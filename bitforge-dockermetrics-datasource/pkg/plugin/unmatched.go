@@ -0,0 +1,26 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// unmatchedFrames returns an empty frame for each host that sent samples of which none
+// matched the query's selection, so panels show "No data" with the reason next to it
+// instead of looking broken
+func unmatchedFrames(allMetrics []metricsWithHost) []*data.Frame {
+	frames := make([]*data.Frame, 0)
+	for _, mwh := range allMetrics {
+		if !mwh.Unmatched {
+			continue
+		}
+		frame := data.NewFrame(mwh.HostName)
+		frame.Meta = &data.FrameMeta{Notices: []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("No containers matched selection on host %s", mwh.HostName),
+		}}}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostCountBuckets are the ranges host counts are reported in, so no exact fleet size leaks
+var hostCountBuckets = []struct {
+	max   int
+	label string
+}{
+	{1, "1"},
+	{5, "2-5"},
+	{25, "6-25"},
+	{100, "26-100"},
+}
+
+// usageStats aggregates which query features are used. Only counters are kept: no host,
+// container, user or dashboard identifiers.
+type usageStats struct {
+	mu           sync.Mutex
+	since        time.Time
+	queries      int64
+	queryTypes   map[string]int64
+	metrics      map[string]int64
+	aggregations map[string]int64
+	hostCounts   map[string]int64
+}
+
+func newUsageStats() *usageStats {
+	return &usageStats{
+		since:        time.Now(),
+		queryTypes:   make(map[string]int64),
+		metrics:      make(map[string]int64),
+		aggregations: make(map[string]int64),
+		hostCounts:   make(map[string]int64),
+	}
+}
+
+// UsageReport is the response of the usage resource
+type UsageReport struct {
+	Enabled      bool             `json:"enabled"`
+	Since        time.Time        `json:"since"`
+	Queries      int64            `json:"queries"`
+	QueryTypes   map[string]int64 `json:"queryTypes"`
+	Metrics      map[string]int64 `json:"metrics"`
+	Aggregations map[string]int64 `json:"aggregations"`
+	HostCounts   map[string]int64 `json:"hostCounts"` // queries per bucket of hosts queried
+}
+
+// record counts one query
+func (u *usageStats) record(qm QueryModel, hostCount int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.queries++
+	u.queryTypes[qm.QueryType]++
+	for _, m := range qm.Metrics {
+		if contains(AllMetrics, m) {
+			u.metrics[m]++
+		}
+	}
+	if qm.AggregateBy != AggregateNone {
+		u.aggregations[qm.AggregateBy]++
+	}
+	if qm.Aggregation != "" && validateAggregation(qm) == nil {
+		u.aggregations[qm.Aggregation]++
+	}
+	u.hostCounts[hostCountBucket(hostCount)]++
+}
+
+func hostCountBucket(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	for _, b := range hostCountBuckets {
+		if n <= b.max {
+			return b.label
+		}
+	}
+	return ">100"
+}
+
+// report returns a copy of the counters
+func (u *usageStats) report(enabled bool) UsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	copyCounts := func(m map[string]int64) map[string]int64 {
+		out := make(map[string]int64, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	}
+	return UsageReport{
+		Enabled:      enabled,
+		Since:        u.since,
+		Queries:      u.queries,
+		QueryTypes:   copyCounts(u.queryTypes),
+		Metrics:      copyCounts(u.metrics),
+		Aggregations: copyCounts(u.aggregations),
+		HostCounts:   copyCounts(u.hostCounts),
+	}
+}
+
+// recordUsage counts a query when usage statistics are enabled
+func (d *Datasource) recordUsage(qm QueryModel) {
+	if !d.settings.UsageStats {
+		return
+	}
+	hostCount := len(qm.HostSelections)
+	if hostCount == 0 {
+		hostCount = len(d.selectHosts(qm, qm.HostIDs))
+	}
+	d.usage.record(qm, hostCount)
+}
+
+// handleUsage returns the aggregated usage statistics (GET /usage). Admin only.
+func (d *Datasource) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !isAdminRequest(r) {
+		writeError(w, http.StatusForbidden, "usage statistics require the Admin role")
+		return
+	}
+	writeJSON(w, http.StatusOK, d.usage.report(d.settings.UsageStats))
+}
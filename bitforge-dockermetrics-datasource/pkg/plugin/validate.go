@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// payloadError is an agent response that doesn't match the schema this plugin expects,
+// usually because the agent runs an incompatible version
+type payloadError struct {
+	host   string
+	field  string
+	reason string
+}
+
+func (e *payloadError) Error() string {
+	return fmt.Sprintf("host %s returned an invalid payload: %s %s", e.host, e.field, e.reason)
+}
+
+// validateMetrics checks a decoded /api/metrics response: the metrics list and container IDs
+// must be present, finite values must be in range, and each container's timestamps must
+// be ordered (agents send newest first, older agents oldest first), so interleaved or shuffled
+// samples are caught. Run before normalizeTimestamps so field indexes match the raw response.
+func validateMetrics(host HostConfig, resp MetricsResponse) error {
+	if resp.Metrics == nil {
+		return &payloadError{host.Name, "metrics", "is missing"}
+	}
+
+	type order struct {
+		last      SampleTime
+		direction int // 1 ascending, -1 descending, 0 not known yet
+	}
+	orders := make(map[string]*order)
+	readable := 0
+	for i, m := range resp.Metrics {
+		field := fmt.Sprintf("metrics[%d]", i)
+		if m.ContainerID == "" {
+			return &payloadError{host.Name, field + ".containerId", "is missing"}
+		}
+		if err := validateSample(m); err != nil {
+			err.host, err.field = host.Name, field+"."+err.field
+			return err
+		}
+
+		if m.Timestamp.IsZero() {
+			continue // dropped and counted by normalizeTimestamps
+		}
+		readable++
+		o, ok := orders[m.ContainerID]
+		if !ok {
+			orders[m.ContainerID] = &order{last: m.Timestamp}
+			continue
+		}
+		direction := m.Timestamp.Compare(o.last.Time)
+		if direction != 0 && o.direction != 0 && direction != o.direction {
+			return &payloadError{host.Name, field + ".timestamp",
+				fmt.Sprintf("is out of order for container %s (%s follows %s)", m.ContainerID, m.Timestamp.Format(time.RFC3339), o.last.Format(time.RFC3339))}
+		}
+		if direction != 0 {
+			o.direction = direction
+		}
+		o.last = m.Timestamp
+	}
+	if len(resp.Metrics) > 0 && readable == 0 {
+		return &payloadError{host.Name, "metrics[].timestamp", "is missing or unreadable in every sample"}
+	}
+	return nil
+}
+
+// boundedValue is a numeric sample field and its upper bound
+type boundedValue struct {
+	field string
+	value float64
+	max   float64
+}
+
+// validateSample checks the value ranges of one sample; host and the field prefix are filled
+// in by the caller
+func validateSample(m ContainerMetric) *payloadError {
+	unbounded := math.Inf(1)
+	values := []boundedValue{
+		{"cpuPercent", m.CPUPercent, unbounded}, // above 100 on multi-core hosts
+		{"memoryBytes", m.MemoryBytes, unbounded},
+		{"memoryPercent", m.MemoryPercent, 100},
+		{"networkRxBytes", m.NetworkRxBytes, unbounded},
+		{"networkTxBytes", m.NetworkTxBytes, unbounded},
+		{"diskReadBytes", m.DiskReadBytes, unbounded},
+		{"diskWriteBytes", m.DiskWriteBytes, unbounded},
+		{"uptimeSeconds", m.UptimeSeconds, unbounded},
+	}
+	for _, p := range []struct {
+		name string
+		psi  *PSIMetrics
+	}{{"cpuPressure", m.CPUPressure}, {"memoryPressure", m.MemoryPressure}, {"ioPressure", m.IOPressure}} {
+		if p.psi == nil {
+			continue
+		}
+		values = append(values,
+			boundedValue{p.name + ".some10", p.psi.Some10, 100},
+			boundedValue{p.name + ".some60", p.psi.Some60, 100},
+			boundedValue{p.name + ".some300", p.psi.Some300, 100},
+			boundedValue{p.name + ".full10", p.psi.Full10, 100},
+			boundedValue{p.name + ".full60", p.psi.Full60, 100},
+			boundedValue{p.name + ".full300", p.psi.Full300, 100},
+		)
+	}
+
+	for _, v := range values {
+		// Non-finite values are samples the agent couldn't compute, shown as nulls
+		if isFinite(v.value) && (v.value < 0 || v.value > v.max) {
+			reason := fmt.Sprintf("is %v, expected a value of at least 0", v.value)
+			if v.max != unbounded {
+				reason = fmt.Sprintf("is %v, expected 0 to %v", v.value, v.max)
+			}
+			return &payloadError{field: v.field, reason: reason}
+		}
+	}
+	return nil
+}
+
+// validateContainers checks a decoded /api/containers response
+func validateContainers(host HostConfig, containers []ContainerInfo) error {
+	if containers == nil {
+		return &payloadError{host.Name, "containers", "is not a list"}
+	}
+	for i, c := range containers {
+		if c.ContainerID == "" {
+			return &payloadError{host.Name, fmt.Sprintf("containers[%d].containerId", i), "is missing"}
+		}
+	}
+	return nil
+}
+
+// validateAgentInfo checks a decoded /api/info response; every agent version reports these
+func validateAgentInfo(host HostConfig, info AgentInfo) error {
+	if info.Hostname == "" {
+		return &payloadError{host.Name, "info.hostname", "is missing"}
+	}
+	if info.AgentVersion == "" {
+		return &payloadError{host.Name, "info.agentVersion", "is missing"}
+	}
+	return nil
+}
+
+// invalidPayloadHost returns the placeholder recorded for a host whose metrics failed
+// validation, so the query can report it; ok is false for other errors
+func invalidPayloadHost(host HostConfig, err error) (metricsWithHost, bool) {
+	var invalid *payloadError
+	if !errors.As(err, &invalid) {
+		return metricsWithHost{}, false
+	}
+	return metricsWithHost{HostID: host.ID, HostName: host.Name, Invalid: invalid}, true
+}
+
+// reportInvalidPayloads surfaces hosts whose metrics failed validation: as a warning notice
+// when the query still returned frames, as the query error when it returned nothing
+func reportInvalidPayloads(response *backend.DataResponse, collected []metricsWithHost) {
+	messages := make([]string, 0)
+	for _, mwh := range collected {
+		if mwh.Invalid != nil {
+			messages = append(messages, mwh.Invalid.Error())
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	text := strings.Join(messages, "; ")
+	if len(response.Frames) == 0 {
+		response.Error = errors.New(text)
+		return
+	}
+	addFrameNotice(response.Frames, &data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+}
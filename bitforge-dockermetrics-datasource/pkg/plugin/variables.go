@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// VariableValues are the values of a template variable; JSON accepts a string or a list
+type VariableValues []string
+
+func (v *VariableValues) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*v = VariableValues{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*v = list
+	return nil
+}
+
+// variablePattern matches $name, ${name} and ${name:format}
+var variablePattern = regexp.MustCompile(`\$(?:\{(\w+)(?::(\w+))?\}|(\w+))`)
+
+// formatVariable renders a variable's values like Grafana's interpolation formats: csv, pipe,
+// regex (escaped values, multiple ones as a group) and raw (comma joined)
+func formatVariable(values []string, format string) string {
+	switch format {
+	case "pipe":
+		return strings.Join(values, "|")
+	case "regex":
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = regexp.QuoteMeta(v)
+		}
+		if len(quoted) == 1 {
+			return quoted[0]
+		}
+		return "(" + strings.Join(quoted, "|") + ")"
+	}
+	return strings.Join(values, ",")
+}
+
+// interpolate replaces the variables in s, formatting them with defaultFormat unless the
+// reference names a format. Unknown variables are left as they are.
+func interpolate(s string, vars map[string]VariableValues, defaultFormat string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	return variablePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := variablePattern.FindStringSubmatch(ref)
+		name, format := m[1], m[2]
+		if name == "" {
+			name = m[3]
+		}
+		values, ok := vars[name]
+		if !ok {
+			return ref
+		}
+		if format == "" {
+			format = defaultFormat
+		}
+		return formatVariable(values, format)
+	})
+}
+
+// interpolateList expands variables in list entries. An entry that is just a variable becomes
+// its values; other entries are interpolated as csv and split on commas.
+func interpolateList(list []string, vars map[string]VariableValues) []string {
+	result := make([]string, 0, len(list))
+	for _, entry := range list {
+		if !strings.Contains(entry, "$") {
+			result = append(result, entry)
+			continue
+		}
+		if m := variablePattern.FindStringSubmatch(entry); m != nil && m[0] == entry && m[2] == "" {
+			name := m[1] + m[3]
+			if values, ok := vars[name]; ok {
+				result = append(result, values...)
+				continue
+			}
+		}
+		for _, part := range strings.Split(interpolate(entry, vars, "csv"), ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// interpolateQuery expands template variables in the query's host and container selection, so
+// saved and alert queries whose variables the frontend didn't expand still select. The query's
+// variables override the datasource's defaults.
+func (d *Datasource) interpolateQuery(qm *QueryModel) {
+	if len(d.settings.Variables) == 0 && len(qm.Variables) == 0 {
+		return
+	}
+	vars := make(map[string]VariableValues, len(d.settings.Variables)+len(qm.Variables))
+	for name, values := range d.settings.Variables {
+		vars[name] = values
+	}
+	for name, values := range qm.Variables {
+		vars[name] = values
+	}
+
+	qm.HostIDs = interpolateList(qm.HostIDs, vars)
+	qm.ContainerIDs = interpolateList(qm.ContainerIDs, vars)
+	qm.ContainerNamePattern = interpolate(qm.ContainerNamePattern, vars, "regex")
+	if len(qm.HostSelections) == 0 {
+		return
+	}
+
+	selections := make(map[string]HostSelection, len(qm.HostSelections))
+	for key, sel := range qm.HostSelections {
+		sel.ContainerIDs = interpolateList(sel.ContainerIDs, vars)
+		if len(sel.ContainerMetrics) > 0 {
+			metrics := make(map[string][]string, len(sel.ContainerMetrics))
+			for containerID, m := range sel.ContainerMetrics {
+				for _, id := range interpolateList([]string{containerID}, vars) {
+					metrics[id] = m
+				}
+			}
+			sel.ContainerMetrics = metrics
+		}
+		// A host variable with several values selects each of its hosts the same way
+		for _, hostID := range interpolateList([]string{key}, vars) {
+			hostSel := sel
+			if hostID != key || strings.Contains(sel.HostID, "$") {
+				hostSel.HostID = hostID
+			}
+			selections[hostID] = hostSel
+		}
+	}
+	qm.HostSelections = selections
+}
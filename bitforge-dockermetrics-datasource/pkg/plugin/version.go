@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, stamped by the Magefile and release scripts with
+// -ldflags "-X github.com/bitforge/dockermetrics-datasource/pkg/plugin.Version=1.2.3 ..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+func init() {
+	// Plain go build still records the VCS revision and time
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && Commit == "":
+			Commit = s.Value
+			if len(Commit) > 12 {
+				Commit = Commit[:12]
+			}
+		case s.Key == "vcs.time" && BuildDate == "":
+			BuildDate = s.Value
+		}
+	}
+}
+
+// BuildInfo describes the running binary, e.g. "1.2.3 (commit 0a1b2c3, built 2024-03-01T12:00:00Z)"
+func BuildInfo() string {
+	switch {
+	case Commit != "" && BuildDate != "":
+		return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, BuildDate)
+	case Commit != "":
+		return fmt.Sprintf("%s (commit %s)", Version, Commit)
+	}
+	return Version
+}
+
+// VersionInfo is returned by the version resource
+type VersionInfo struct {
+	Version   string                  `json:"version"`
+	Commit    string                  `json:"commit,omitempty"`
+	BuildDate string                  `json:"buildDate,omitempty"`
+	GoVersion string                  `json:"goVersion"`
+	Agents    map[string]AgentVersion `json:"agents"`
+}
+
+// AgentVersion is the agent version reported by one enabled host
+type AgentVersion struct {
+	HostName     string `json:"hostName"`
+	AgentVersion string `json:"agentVersion,omitempty"`
+	Reachable    bool   `json:"reachable"`
+	Error        string `json:"error,omitempty"`
+}
+
+// handleVersion returns the plugin build and the agent version of every enabled host
+// (GET /version, optionally filtered by ?hostId=)
+func (d *Datasource) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var filterIDs []string
+	if hostID := r.URL.Query().Get("hostId"); hostID != "" {
+		filterIDs = []string{hostID}
+	}
+
+	info := VersionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Agents:    make(map[string]AgentVersion),
+	}
+	for _, host := range d.getEnabledHosts(filterIDs) {
+		caps := d.getHostCapabilities(r.Context(), host)
+		info.Agents[host.ID] = AgentVersion{
+			HostName:     host.Name,
+			AgentVersion: caps.AgentVersion,
+			Reachable:    caps.Reachable,
+			Error:        caps.Error,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
@@ -1,11 +1,35 @@
-import React, { useCallback } from 'react';
+import React, { useCallback, useState } from 'react';
 import { DataSourcePluginOptionsEditorProps, SelectableValue } from '@grafana/data';
-import { InlineField, Input, Button, VerticalGroup, HorizontalGroup, Switch, IconButton, MultiSelect, Alert } from '@grafana/ui';
-import { DockerMetricsDataSourceOptions, HostConfig, ControlAction, ALL_CONTROL_ACTIONS } from '../types';
+import { InlineField, Input, Button, VerticalGroup, HorizontalGroup, Switch, IconButton, MultiSelect, Alert, RadioButtonGroup, SecretInput, TextArea } from '@grafana/ui';
+import { getBackendSrv } from '@grafana/runtime';
+import { DockerMetricsDataSourceOptions, DockerMetricsSecureJsonData, HostConfig, HostMode, ControlAction, ALL_CONTROL_ACTIONS, DESTRUCTIVE_CONTROL_ACTIONS, ScanResult, DataLink, MetricFormat, ALL_METRICS, ControlRole } from '../types';
 import { css } from '@emotion/css';
 import { VersionInfo } from './VersionInfo';
 
-interface Props extends DataSourcePluginOptionsEditorProps<DockerMetricsDataSourceOptions> {}
+interface Props extends DataSourcePluginOptionsEditorProps<DockerMetricsDataSourceOptions, DockerMetricsSecureJsonData> {}
+
+// Empty entries are kept so a trailing comma can be typed; the backend ignores them
+const splitLabels = (value: string): string[] => (value.trim() === '' ? [] : value.split(',').map((s) => s.trim()));
+
+const hostModeOptions: Array<SelectableValue<HostMode>> = [
+  { label: 'Agent', value: '' },
+  { label: 'cAdvisor', value: 'cadvisor' },
+  { label: 'Prometheus', value: 'prometheus' },
+];
+
+const logLevelOptions: Array<SelectableValue<NonNullable<DockerMetricsDataSourceOptions['logLevel']>>> = [
+  { label: 'Debug', value: 'debug' },
+  { label: 'Info', value: 'info' },
+  { label: 'Warn', value: 'warn' },
+  { label: 'Error', value: 'error' },
+];
+
+const minRoleOptions: Array<SelectableValue<ControlRole | ''>> = [
+  { label: 'Any', value: '' },
+  { label: 'Viewer', value: 'Viewer' },
+  { label: 'Editor', value: 'Editor' },
+  { label: 'Admin', value: 'Admin' },
+];
 
 const styles = {
   hostCard: css`
@@ -60,6 +84,12 @@ function getActionDescription(action: ControlAction): string {
       return 'Pause a running container';
     case 'unpause':
       return 'Resume a paused container';
+    case 'kill':
+      return 'Kill a container that does not stop';
+    case 'remove':
+      return 'Remove a container, optionally forced and with its volumes';
+    case 'recreate':
+      return 'Replace a container with a new one of the same config';
   }
 }
 
@@ -67,7 +97,14 @@ export function ConfigEditor(props: Props) {
   const { options, onOptionsChange } = props;
   const hosts = options.jsonData.hosts || [];
   const enableContainerControls = options.jsonData.enableContainerControls || false;
+  const readOnly = options.jsonData.readOnly || false;
   const allowedControlActions = options.jsonData.allowedControlActions || [];
+  const discovery = options.jsonData.discovery || {};
+  const dataLinks = options.jsonData.dataLinks || [];
+  const metricFormats = options.jsonData.metricFormats || {};
+  const [scanning, setScanning] = useState(false);
+  const [scanError, setScanError] = useState<string | null>(null);
+  const [scanResult, setScanResult] = useState<ScanResult | null>(null);
 
   const updateJsonData = useCallback(
     (updates: Partial<DockerMetricsDataSourceOptions>) => {
@@ -122,6 +159,79 @@ export function ConfigEditor(props: Props) {
     [hosts, updateHosts]
   );
 
+  // Host tokens live in secureJsonData under hostToken.<hostId>
+  const updateHostToken = useCallback(
+    (hostId: string, token: string) => {
+      onOptionsChange({
+        ...options,
+        secureJsonData: { ...options.secureJsonData, [`hostToken.${hostId}`]: token },
+      });
+    },
+    [options, onOptionsChange]
+  );
+
+  const resetHostToken = useCallback(
+    (hostId: string) => {
+      onOptionsChange({
+        ...options,
+        secureJsonFields: { ...options.secureJsonFields, [`hostToken.${hostId}`]: false },
+        secureJsonData: { ...options.secureJsonData, [`hostToken.${hostId}`]: '' },
+      });
+    },
+    [options, onOptionsChange]
+  );
+
+  const updateDataLink = useCallback(
+    (index: number, updates: Partial<DataLink>) => {
+      const links = [...dataLinks];
+      links[index] = { ...links[index], ...updates };
+      updateJsonData({ dataLinks: links });
+    },
+    [dataLinks, updateJsonData]
+  );
+
+  // Empty inputs drop the override so the backend default applies
+  const updateMetricFormat = useCallback(
+    (metric: string, updates: Partial<MetricFormat>) => {
+      const format = { ...metricFormats[metric], ...updates };
+      const formats = { ...metricFormats, [metric]: format };
+      if (format.decimals === undefined && format.scale === undefined && !format.unit) {
+        delete formats[metric];
+      }
+      updateJsonData({ metricFormats: formats });
+    },
+    [metricFormats, updateJsonData]
+  );
+
+  // Scan the configured subnet for agents (backend resource, requires a saved datasource)
+  const runScan = useCallback(async () => {
+    setScanning(true);
+    setScanError(null);
+    try {
+      const result = await getBackendSrv().post<ScanResult>(
+        `/api/datasources/uid/${options.uid}/resources/discovery/scan`,
+        { cidr: discovery.scanCidr, port: discovery.scanPort }
+      );
+      setScanResult(result);
+    } catch (err) {
+      const message = (err as { data?: { error?: string } })?.data?.error;
+      setScanError(message || (err instanceof Error ? err.message : 'Scan failed'));
+    } finally {
+      setScanning(false);
+    }
+  }, [options.uid, discovery.scanCidr, discovery.scanPort]);
+
+  // Add a proposed host; proposals are never enabled automatically
+  const addProposal = useCallback(
+    (proposal: HostConfig) => {
+      updateHosts([...hosts, { ...proposal, enabled: false }]);
+      setScanResult((prev) =>
+        prev ? { ...prev, proposals: prev.proposals.filter((p) => p.id !== proposal.id) } : prev
+      );
+    },
+    [hosts, updateHosts]
+  );
+
   return (
     <VerticalGroup spacing="md">
       <VersionInfo />
@@ -167,6 +277,85 @@ export function ConfigEditor(props: Props) {
                 width={40}
               />
             </InlineField>
+
+            <InlineField label="Token" labelWidth={12} tooltip="Bearer token sent to this host's agents (AGENT_TOKEN on the agent), stored encrypted">
+              <SecretInput
+                isConfigured={options.secureJsonFields?.[`hostToken.${host.id}`] ?? false}
+                value={options.secureJsonData?.[`hostToken.${host.id}`] || ''}
+                onChange={(e) => updateHostToken(host.id, e.currentTarget.value)}
+                onReset={() => resetHostToken(host.id)}
+                placeholder="none"
+                width={40}
+              />
+            </InlineField>
+
+            <InlineField label="Public key" labelWidth={12} tooltip="PEM public key of the agent's AGENT_SIGNING_KEY_FILE; unsigned or tampered responses are rejected">
+              <TextArea
+                value={host.publicKey || ''}
+                onChange={(e) => updateHost(index, { publicKey: e.currentTarget.value || undefined })}
+                placeholder="-----BEGIN PUBLIC KEY-----"
+                rows={3}
+                cols={40}
+              />
+            </InlineField>
+
+            <InlineField label="Source" labelWidth={12} tooltip="cAdvisor hosts are read-only: metrics are mapped to the agent's metric names">
+              <RadioButtonGroup
+                options={hostModeOptions}
+                value={host.mode || ''}
+                onChange={(v) => updateHost(index, { mode: v || undefined })}
+              />
+            </InlineField>
+
+            {host.mode === 'prometheus' && (
+              <InlineField label="Metrics path" labelWidth={12} tooltip="Exposition with cAdvisor container_* series">
+                <Input
+                  value={host.metricsPath || ''}
+                  onChange={(e) => updateHost(index, { metricsPath: e.currentTarget.value || undefined })}
+                  placeholder="/metrics"
+                  width={40}
+                />
+              </InlineField>
+            )}
+
+            <InlineField label="Fallback URL" labelWidth={12} tooltip="Secondary agent queried when the primary is unreachable">
+              <Input
+                value={host.fallbackUrl || ''}
+                onChange={(e) => updateHost(index, { fallbackUrl: e.currentTarget.value || undefined })}
+                placeholder="http://192.168.1.101:5000"
+                width={40}
+              />
+            </InlineField>
+
+            <InlineField label="Group" labelWidth={12} tooltip="Queries can select hosts by group, e.g. prod-eu">
+              <Input
+                value={host.group || ''}
+                onChange={(e) => updateHost(index, { group: e.currentTarget.value || undefined })}
+                placeholder="prod-eu"
+                width={40}
+              />
+            </InlineField>
+
+            <InlineField label="Tags" labelWidth={12} tooltip="Comma-separated tags">
+              <Input
+                value={(host.tags || []).join(',')}
+                onChange={(e) => {
+                  const tags = e.currentTarget.value.split(',').map((t) => t.trim()).filter((t) => t !== '');
+                  updateHost(index, { tags: tags.length > 0 ? tags : undefined });
+                }}
+                placeholder="ssd,gpu"
+                width={40}
+              />
+            </InlineField>
+
+            <InlineField label="Timezone" labelWidth={12} tooltip="Zone for agent timestamps without an offset (default UTC)">
+              <Input
+                value={host.timezone || ''}
+                onChange={(e) => updateHost(index, { timezone: e.currentTarget.value || undefined })}
+                placeholder="Europe/Warsaw"
+                width={40}
+              />
+            </InlineField>
           </VerticalGroup>
         </div>
       ))}
@@ -186,23 +375,373 @@ export function ConfigEditor(props: Props) {
         </p>
       )}
 
+      <InlineField
+        label="Duplicate names"
+        labelWidth={16}
+        tooltip="Series name for containers whose name exists on several hosts. Placeholders: {container}, {host}, {hostId}"
+      >
+        <Input
+          value={options.jsonData.duplicateNamePattern || ''}
+          onChange={(e) => updateJsonData({ duplicateNamePattern: e.currentTarget.value || undefined })}
+          placeholder="{container} ({host})"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Log level" labelWidth={16} tooltip="Backend log lines below this level are dropped; log lines carry the query's requestId">
+        <RadioButtonGroup
+          options={logLevelOptions}
+          value={options.jsonData.logLevel || 'info'}
+          onChange={(v) => updateJsonData({ logLevel: v })}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Usage statistics"
+        labelWidth={16}
+        tooltip="Count query types, metrics and host count ranges (no identifiers, nothing is sent). Admins read them from the usage resource."
+      >
+        <Switch
+          value={options.jsonData.usageStats ?? false}
+          onChange={(e) => updateJsonData({ usageStats: e.currentTarget.checked })}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Proxy URL"
+        labelWidth={16}
+        tooltip="Proxy for agent requests, e.g. http://proxy.corp:3128. Empty uses HTTP_PROXY/HTTPS_PROXY; NO_PROXY applies either way."
+      >
+        <Input
+          value={options.jsonData.proxyUrl || ''}
+          onChange={(e) => updateJsonData({ proxyUrl: e.currentTarget.value || undefined })}
+          placeholder="from environment"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Drop labels"
+        labelWidth={16}
+        tooltip="Comma-separated labels removed from every series, e.g. containerId on fleets with thousands of containers"
+      >
+        <Input
+          value={(options.jsonData.labelPruning?.drop || []).join(', ')}
+          onChange={(e) => updateJsonData({ labelPruning: { ...options.jsonData.labelPruning, drop: splitLabels(e.currentTarget.value) } })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Hash labels" labelWidth={16} tooltip="Comma-separated labels whose values are replaced by a short stable hash">
+        <Input
+          value={(options.jsonData.labelPruning?.hash || []).join(', ')}
+          onChange={(e) => updateJsonData({ labelPruning: { ...options.jsonData.labelPruning, hash: splitLabels(e.currentTarget.value) } })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Clock skew (s)" labelWidth={16} tooltip="Warn on series from agents whose clock differs from Grafana's by more than this (default 30)">
+        <Input
+          type="number"
+          value={options.jsonData.clockSkew?.warnSeconds ?? ''}
+          onChange={(e) =>
+            updateJsonData({ clockSkew: { ...options.jsonData.clockSkew, warnSeconds: parseInt(e.currentTarget.value, 10) || undefined } })
+          }
+          placeholder="30"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Correct skew" labelWidth={16} tooltip="Shift timestamps of skewed agents onto Grafana's clock">
+        <Switch
+          value={options.jsonData.clockSkew?.correct ?? false}
+          onChange={(e) => updateJsonData({ clockSkew: { ...options.jsonData.clockSkew, correct: e.currentTarget.checked } })}
+        />
+      </InlineField>
+
+      <InlineField label="Stale after (s)" labelWidth={16} tooltip="Mark a host's series stale when its newest sample is older than this (default 60)">
+        <Input
+          type="number"
+          value={options.jsonData.staleSeconds ?? ''}
+          onChange={(e) => updateJsonData({ staleSeconds: parseInt(e.currentTarget.value, 10) || undefined })}
+          placeholder="60"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Require scope"
+        labelWidth={16}
+        tooltip="Queries from viewers and public dashboards must carry a scope token issued by an editor (needs the scopeTokenSecret secure setting)"
+      >
+        <Switch
+          value={options.jsonData.scopeTokens?.required ?? false}
+          onChange={(e) => updateJsonData({ scopeTokens: { ...options.jsonData.scopeTokens, required: e.currentTarget.checked } })}
+        />
+      </InlineField>
+
+      <InlineField label="Concurrency" labelWidth={16} tooltip="Hosts a query fetches from at once (default 8)">
+        <Input
+          type="number"
+          value={options.jsonData.fetchConcurrency ?? ''}
+          onChange={(e) => updateJsonData({ fetchConcurrency: parseInt(e.currentTarget.value, 10) || undefined })}
+          placeholder="8"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Host timeout (s)" labelWidth={16} tooltip="Leave a host out of a query when it doesn't answer within this time (default 10)">
+        <Input
+          type="number"
+          value={options.jsonData.hostTimeoutSeconds ?? ''}
+          onChange={(e) => updateJsonData({ hostTimeoutSeconds: parseInt(e.currentTarget.value, 10) || undefined })}
+          placeholder="10"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Attempts" labelWidth={16} tooltip="Tries per agent read before a host is left out, retrying 502/503/504 and connection errors (default 2, 1 disables retries)">
+        <Input
+          type="number"
+          value={options.jsonData.retry?.attempts ?? ''}
+          onChange={(e) => updateJsonData({ retry: { ...options.jsonData.retry, attempts: parseInt(e.currentTarget.value, 10) || undefined } })}
+          placeholder="2"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Exclude"
+        labelWidth={16}
+        tooltip="Comma-separated container name regexes hidden from every query, e.g. the agent itself or pause containers. Queries can override the list."
+      >
+        <Input
+          value={(options.jsonData.excludeContainers || []).join(', ')}
+          onChange={(e) => updateJsonData({ excludeContainers: splitLabels(e.currentTarget.value) })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Secondary UID"
+        labelWidth={16}
+        tooltip="Datasource answering queries while none of these hosts is reachable, e.g. a passive collector pair (needs the grafanaApiToken secure setting)"
+      >
+        <Input
+          value={options.jsonData.secondaryDatasourceUid || ''}
+          onChange={(e) => updateJsonData({ secondaryDatasourceUid: e.currentTarget.value || undefined })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Federated UIDs"
+        labelWidth={16}
+        tooltip="Docker Metrics datasources whose results are merged into every query, e.g. per-site datasources for a global dashboard (needs the grafanaApiToken secure setting)"
+      >
+        <Input
+          value={(options.jsonData.federatedDatasourceUids || []).join(', ')}
+          onChange={(e) => updateJsonData({ federatedDatasourceUids: splitLabels(e.currentTarget.value) })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField
+        label="CPU-hour price"
+        labelWidth={16}
+        tooltip="Price of one fully used core for an hour in cost queries. Per-host and per-group rates can be provisioned in costModel."
+      >
+        <Input
+          type="number"
+          value={options.jsonData.costModel?.cpuHour ?? ''}
+          onChange={(e) => updateJsonData({ costModel: { ...options.jsonData.costModel, cpuHour: parseFloat(e.currentTarget.value) || undefined } })}
+          placeholder="0"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="GB-hour price" labelWidth={16} tooltip="Price of one GB of memory held for an hour in cost queries">
+        <Input
+          type="number"
+          value={options.jsonData.costModel?.gbHour ?? ''}
+          onChange={(e) => updateJsonData({ costModel: { ...options.jsonData.costModel, gbHour: parseFloat(e.currentTarget.value) || undefined } })}
+          placeholder="0"
+          width={32}
+        />
+      </InlineField>
+
+      <InlineField label="Currency" labelWidth={16} tooltip="Unit of the cost fields, e.g. USD or €">
+        <Input
+          value={options.jsonData.costModel?.currency || ''}
+          onChange={(e) => updateJsonData({ costModel: { ...options.jsonData.costModel, currency: e.currentTarget.value || undefined } })}
+          placeholder="none"
+          width={32}
+        />
+      </InlineField>
+
+      <div className={styles.securitySection}>
+        <h4>Data Links</h4>
+        <p style={{ color: '#888', fontSize: '12px', marginBottom: '12px' }}>
+          Links added to every container series, e.g. a drill-down dashboard. URLs can use{' '}
+          <code>{'${__field.labels.containerId}'}</code>, <code>{'${__field.labels.containerName}'}</code> and{' '}
+          <code>{'${__field.labels.hostName}'}</code>.
+        </p>
+        {dataLinks.map((link, index) => (
+          <HorizontalGroup key={index}>
+            <Input
+              value={link.title}
+              onChange={(e) => updateDataLink(index, { title: e.currentTarget.value })}
+              placeholder="Title"
+              width={20}
+            />
+            <Input
+              value={link.url}
+              onChange={(e) => updateDataLink(index, { url: e.currentTarget.value })}
+              placeholder="/d/container-detail?var-container=${__field.labels.containerId}"
+              width={60}
+            />
+            <Switch
+              value={link.targetBlank ?? false}
+              onChange={(e) => updateDataLink(index, { targetBlank: e.currentTarget.checked })}
+              label="New tab"
+            />
+            <IconButton
+              name="trash-alt"
+              tooltip="Remove link"
+              onClick={() => updateJsonData({ dataLinks: dataLinks.filter((_, i) => i !== index) })}
+            />
+          </HorizontalGroup>
+        ))}
+        <Button
+          variant="secondary"
+          icon="plus"
+          onClick={() => updateJsonData({ dataLinks: [...dataLinks, { title: '', url: '' }] })}
+          className={styles.addButton}
+        >
+          Add Link
+        </Button>
+      </div>
+
+      <div className={styles.securitySection}>
+        <h4>Metric Formats</h4>
+        <p style={{ color: '#888', fontSize: '12px', marginBottom: '12px' }}>
+          Decimal places, scaling factor and unit per metric. Empty fields keep the defaults (1 decimal for
+          percentages and MB, no scaling).
+        </p>
+        {ALL_METRICS.map((metric) => (
+          <HorizontalGroup key={metric}>
+            <span style={{ width: '160px', display: 'inline-block' }}>{metric}</span>
+            <Input
+              type="number"
+              value={metricFormats[metric]?.decimals ?? ''}
+              onChange={(e) =>
+                updateMetricFormat(metric, { decimals: e.currentTarget.value === '' ? undefined : Number(e.currentTarget.value) })
+              }
+              placeholder="Decimals"
+              width={12}
+            />
+            <Input
+              type="number"
+              value={metricFormats[metric]?.scale ?? ''}
+              onChange={(e) => updateMetricFormat(metric, { scale: Number(e.currentTarget.value) || undefined })}
+              placeholder="Scale"
+              width={12}
+            />
+            <Input
+              value={metricFormats[metric]?.unit ?? ''}
+              onChange={(e) => updateMetricFormat(metric, { unit: e.currentTarget.value || undefined })}
+              placeholder="Unit"
+              width={16}
+            />
+          </HorizontalGroup>
+        ))}
+      </div>
+
+      <div className={styles.securitySection}>
+        <h4>Subnet Scan</h4>
+        <p style={{ color: '#888', fontSize: '12px', marginBottom: '12px' }}>
+          Scan a subnet for responding agents. Found agents are proposed as disabled hosts and must be enabled manually.
+          Save the data source before scanning.
+        </p>
+        <HorizontalGroup>
+          <InlineField label="CIDR" labelWidth={12}>
+            <Input
+              value={discovery.scanCidr || ''}
+              onChange={(e) => updateJsonData({ discovery: { ...discovery, scanCidr: e.currentTarget.value } })}
+              placeholder="192.168.1.0/24"
+              width={24}
+            />
+          </InlineField>
+          <InlineField label="Port" labelWidth={8}>
+            <Input
+              type="number"
+              value={discovery.scanPort || ''}
+              onChange={(e) => updateJsonData({ discovery: { ...discovery, scanPort: Number(e.currentTarget.value) || undefined } })}
+              placeholder="5000"
+              width={10}
+            />
+          </InlineField>
+          <Button variant="secondary" icon="search" onClick={runScan} disabled={scanning || !discovery.scanCidr}>
+            {scanning ? 'Scanning...' : 'Scan'}
+          </Button>
+        </HorizontalGroup>
+
+        {scanError && <Alert title="Scan failed" severity="error">{scanError}</Alert>}
+
+        {scanResult && (
+          <VerticalGroup spacing="sm">
+            <p style={{ color: '#888', fontSize: '12px' }}>
+              Scanned {scanResult.scanned} addresses, found {scanResult.proposals.length} new agent(s).
+            </p>
+            {scanResult.proposals.map((proposal) => (
+              <HorizontalGroup key={proposal.id}>
+                <span>{proposal.name}</span>
+                <span style={{ color: '#888' }}>{proposal.url}</span>
+                <Button size="sm" variant="secondary" icon="plus" onClick={() => addProposal(proposal)}>
+                  Add
+                </Button>
+              </HorizontalGroup>
+            ))}
+          </VerticalGroup>
+        )}
+      </div>
+
       <div className={styles.securitySection}>
         <h4>Container Controls</h4>
         <p style={{ color: '#888', fontSize: '12px', marginBottom: '12px' }}>
-          Enable container control actions (start, stop, restart, pause, unpause) from dashboards.
+          Enable container control actions (start, stop, restart, pause, unpause, kill, remove, recreate) from dashboards.
           This allows users to manage containers directly from panels.
         </p>
 
         <div className={styles.securityCard}>
           <VerticalGroup spacing="md">
+            <HorizontalGroup>
+              <Switch
+                value={readOnly}
+                onChange={(e) => updateJsonData({ readOnly: e.currentTarget.checked })}
+                label="Read-only"
+              />
+              <span style={{ color: '#888', fontSize: '12px' }}>
+                Refuses control actions and host, maintenance and registration changes regardless of the settings below
+              </span>
+            </HorizontalGroup>
+
             <HorizontalGroup>
               <Switch
                 value={enableContainerControls}
+                disabled={readOnly}
                 onChange={(e) => {
                   const enabled = e.currentTarget.checked;
                   updateJsonData({
                     enableContainerControls: enabled,
-                    allowedControlActions: enabled ? ALL_CONTROL_ACTIONS : [],
+                    allowedControlActions: enabled
+                      ? ALL_CONTROL_ACTIONS.filter((a) => !DESTRUCTIVE_CONTROL_ACTIONS.includes(a))
+                      : [],
                   });
                 }}
                 label="Enable Container Controls"
@@ -219,7 +758,7 @@ export function ConfigEditor(props: Props) {
                 <InlineField
                   label="Allowed Actions"
                   labelWidth={16}
-                  tooltip="Select which container actions are permitted. Leave empty to allow all actions."
+                  tooltip="Select which container actions are permitted. Leave empty to allow all but kill, remove and recreate."
                 >
                   <MultiSelect
                     options={controlActionOptions}
@@ -239,6 +778,37 @@ export function ConfigEditor(props: Props) {
                     Warning: No actions selected. All actions will be blocked until you select at least one.
                   </p>
                 )}
+
+                <InlineField
+                  label="Minimum role"
+                  labelWidth={16}
+                  tooltip="Grafana org role a user needs to run control actions, checked by the backend"
+                >
+                  <RadioButtonGroup
+                    options={minRoleOptions}
+                    value={options.jsonData.controlAuthorization?.minRole || ''}
+                    onChange={(v) =>
+                      updateJsonData({ controlAuthorization: { ...options.jsonData.controlAuthorization, minRole: v || undefined } })
+                    }
+                  />
+                </InlineField>
+
+                <InlineField
+                  label="Allowed teams"
+                  labelWidth={16}
+                  tooltip="Comma-separated team names; when set, only their members can run control actions (needs the grafanaApiToken secure setting with user and team read access)"
+                >
+                  <Input
+                    value={(options.jsonData.controlAuthorization?.allowedTeams || []).join(', ')}
+                    onChange={(e) =>
+                      updateJsonData({
+                        controlAuthorization: { ...options.jsonData.controlAuthorization, allowedTeams: splitLabels(e.currentTarget.value) },
+                      })
+                    }
+                    placeholder="any team"
+                    width={40}
+                  />
+                </InlineField>
               </>
             )}
           </VerticalGroup>
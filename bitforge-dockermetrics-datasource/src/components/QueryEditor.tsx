@@ -1,14 +1,20 @@
 import React, { useCallback, useEffect, useState, useMemo } from 'react';
 import { QueryEditorProps } from '@grafana/data';
-import { RadioButtonGroup, Checkbox, useStyles2, Spinner, Dropdown, Menu, IconButton } from '@grafana/ui';
+import { RadioButtonGroup, Checkbox, useStyles2, Spinner, Dropdown, Menu, IconButton, Input } from '@grafana/ui';
 import { css } from '@emotion/css';
-import { getBackendSrv } from '@grafana/runtime';
 import { DockerMetricsDataSource } from '../datasource';
 import {
   DockerMetricsQuery,
   DockerMetricsDataSourceOptions,
   HostSelection,
   HostSelectionMode,
+  HostCapabilities,
+  AggregateBy,
+  SeriesIdentity,
+  Aggregation,
+  AggregationGroup,
+  Downsample,
+  MetricTransform,
   ALL_METRICS,
   DEFAULT_METRICS,
 } from '../types';
@@ -23,6 +29,64 @@ interface ContainerInfo {
   state: string;
 }
 
+// Label filters are edited as "name=value" pairs; repeating a name accepts any of its values
+function parseLabelFilters(text: string): Record<string, string[]> {
+  const filters: Record<string, string[]> = {};
+  for (const pair of text.split(',')) {
+    const [name, value] = pair.split('=').map((s) => s.trim());
+    if (name && value) {
+      filters[name] = [...(filters[name] ?? []), value];
+    }
+  }
+  return filters;
+}
+
+function formatLabelFilters(filters?: Record<string, string[]>): string {
+  return Object.entries(filters ?? {})
+    .flatMap(([name, values]) => values.map((v) => `${name}=${v}`))
+    .join(', ');
+}
+
+// Name and service identities continue a series when a container is recreated with a new ID
+const identityOptions: Array<{ label: string; value: SeriesIdentity }> = [
+  { label: 'Container', value: '' },
+  { label: 'Name', value: 'name' },
+  { label: 'Compose service', value: 'service' },
+];
+
+// Fleet views reduce containers to one series per group; count is the number of reporting containers
+const aggregationOptions: Array<{ label: string; value: Aggregation }> = [
+  { label: 'None', value: '' },
+  { label: 'Sum', value: 'sum' },
+  { label: 'Avg', value: 'avg' },
+  { label: 'Min', value: 'min' },
+  { label: 'Max', value: 'max' },
+  { label: 'Count', value: 'count' },
+];
+
+const groupByOptions: Array<{ label: string; value: AggregationGroup }> = [
+  { label: 'All', value: '' },
+  { label: 'Host', value: 'host' },
+  { label: 'Image', value: 'image' },
+  { label: 'Compose project', value: 'composeProject' },
+];
+
+// Long ranges are thinned to the panel width; max keeps spikes, LTTB keeps the curve's shape
+const downsampleOptions: Array<{ label: string; value: Downsample }> = [
+  { label: 'Avg', value: '' },
+  { label: 'Max', value: 'max' },
+  { label: 'LTTB', value: 'lttb' },
+  { label: 'Off', value: 'none' },
+];
+
+// Network and disk metrics are cumulative counters, rates show bandwidth
+const COUNTER_METRICS = ['networkRxBytes', 'networkTxBytes', 'diskReadBytes', 'diskWriteBytes'];
+const counterOptions: Array<{ label: string; value: MetricTransform }> = [
+  { label: 'Raw', value: 'none' },
+  { label: 'Rate', value: 'rate' },
+  { label: 'Delta', value: 'delta' },
+];
+
 // Metric display config
 const METRIC_CONFIG: Record<string, { label: string; shortLabel: string }> = {
   cpuPercent: { label: 'CPU %', shortLabel: 'CPU' },
@@ -39,6 +103,8 @@ const METRIC_CONFIG: Record<string, { label: string; shortLabel: string }> = {
   memoryPressureFull: { label: 'Mem Press (full)', shortLabel: 'Memf' },
   ioPressureSome: { label: 'I/O Pressure', shortLabel: 'IOp' },
   ioPressureFull: { label: 'I/O Press (full)', shortLabel: 'IOf' },
+  isRunning: { label: 'Running', shortLabel: 'Run' },
+  isHealthy: { label: 'Healthy', shortLabel: 'Hlth' },
 };
 
 const getStyles = () => ({
@@ -259,6 +325,54 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [defaultMetricsExpanded, setDefaultMetricsExpanded] = useState(false);
+  const [capabilities, setCapabilities] = useState<Record<string, HostCapabilities>>({});
+
+  // Fetch per-host capabilities so unsupported metrics can be greyed out
+  useEffect(() => {
+    datasource
+      .getCapabilities()
+      .then((caps) => setCapabilities(caps ?? {}))
+      .catch(() => setCapabilities({}));
+  }, [datasource]);
+
+  // A metric is supported unless the host reported capabilities that exclude it
+  const isMetricSupported = useCallback((hostId: string, metric: string): boolean => {
+    const caps = capabilities[hostId];
+    if (!caps || !caps.reachable) {
+      return true;
+    }
+    return caps.supportedMetrics.includes(metric);
+  }, [capabilities]);
+
+  // Aggregation options depend on the runtimes behind the configured hosts
+  const aggregateOptions = useMemo(() => {
+    const caps = Object.values(capabilities);
+    const options: Array<{ label: string; value: AggregateBy }> = [{ label: 'None', value: '' }];
+    if (caps.some((c) => c.supportsPods)) {
+      options.push({ label: 'Pod', value: 'pod' });
+    }
+    if (caps.some((c) => c.reachable && c.runtime !== 'containerd')) {
+      options.push({ label: 'Compose service', value: 'compose' });
+    }
+    if (caps.some((c) => c.orchestrator === 'ecs')) {
+      options.push({ label: 'ECS task', value: 'ecs-task' });
+      options.push({ label: 'ECS task family', value: 'ecs-family' });
+    }
+    if (caps.some((c) => c.orchestrator === 'nomad')) {
+      options.push({ label: 'Nomad job', value: 'nomad-job' });
+      options.push({ label: 'Nomad allocation', value: 'nomad-alloc' });
+    }
+    if (caps.some((c) => c.supportsSwarm)) {
+      options.push({ label: 'Swarm service', value: 'swarm-service' });
+      options.push({ label: 'Swarm task slot', value: 'swarm-task' });
+    }
+    return options;
+  }, [capabilities]);
+
+  const supportsNamespaces = useMemo(
+    () => Object.values(capabilities).some((c) => c.supportsNamespaces),
+    [capabilities]
+  );
 
   // Fetch containers from backend
   useEffect(() => {
@@ -266,37 +380,30 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
       setLoading(true);
       setError(null);
       try {
-        const response = await getBackendSrv().fetch({
-          url: `/api/ds/query`,
-          method: 'POST',
-          data: {
-            queries: [{
-              refId: 'containers',
-              datasource: { uid: datasource.uid, type: datasource.type },
-              queryType: 'containers',
-            }],
-            from: 'now-1h',
-            to: 'now',
-          },
-        }).toPromise();
-
-        const result = response?.data as { results?: { containers?: { frames?: Array<{ data?: { values?: unknown[][] } }> } } };
-        const frame = result?.results?.containers?.frames?.[0];
-        if (frame?.data?.values) {
-          const [containerIds, containerNames, hostIds, hostNames] = frame.data.values as [string[], string[], string[], string[]];
-          const parsed: ContainerInfo[] = containerIds.map((id, i) => ({
-            containerId: id,
-            containerName: containerNames[i] || id,
-            hostId: hostIds[i] || 'default',
-            hostName: hostNames[i] || 'default',
-            state: 'running',
-          }));
-          setContainers(parsed);
-
-          // Initialize hostSelections if empty
-          if (!query.hostSelections || Object.keys(query.hostSelections).length === 0) {
-            initializeHostSelections(parsed);
-          }
+        // Unreachable hosts are left out, the others still fill the editor
+        const hosts = await datasource.getHosts(query.scopeToken);
+        const perHost = await Promise.all(
+          hosts.map((host) =>
+            datasource
+              .getHostContainers(host.id, query.scopeToken)
+              .then((list) =>
+                list.map((c): ContainerInfo => ({
+                  containerId: c.containerId,
+                  containerName: c.containerName || c.containerId,
+                  hostId: host.id,
+                  hostName: host.name,
+                  state: c.state,
+                }))
+              )
+              .catch(() => [] as ContainerInfo[])
+          )
+        );
+        const parsed = perHost.flat();
+        setContainers(parsed);
+
+        // Initialize hostSelections if empty
+        if (!query.hostSelections || Object.keys(query.hostSelections).length === 0) {
+          initializeHostSelections(parsed);
         }
       } catch (err) {
         setError(err instanceof Error ? err.message : 'Failed to fetch containers');
@@ -689,6 +796,148 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
         )}
       </div>
 
+      {aggregateOptions.length > 1 && (
+        <div className={styles.modeSelector}>
+          <span className={styles.modeLabel}>Aggregate by:</span>
+          <RadioButtonGroup
+            size="sm"
+            options={aggregateOptions}
+            value={query.aggregateBy ?? ''}
+            onChange={(v) => {
+              onChange({ ...query, aggregateBy: v });
+              onRunQuery();
+            }}
+          />
+        </div>
+      )}
+
+      <div className={styles.modeSelector}>
+        <span className={styles.modeLabel}>Combine:</span>
+        <RadioButtonGroup
+          size="sm"
+          options={aggregationOptions}
+          value={query.aggregation ?? ''}
+          onChange={(v) => {
+            onChange({ ...query, aggregation: v || undefined, groupBy: v ? query.groupBy : undefined });
+            onRunQuery();
+          }}
+        />
+      </div>
+
+      {query.aggregation && (
+        <div className={styles.modeSelector}>
+          <span className={styles.modeLabel}>Group by:</span>
+          <RadioButtonGroup
+            size="sm"
+            options={groupByOptions}
+            value={query.groupBy ?? ''}
+            onChange={(v) => {
+              onChange({ ...query, groupBy: v || undefined });
+              onRunQuery();
+            }}
+          />
+        </div>
+      )}
+
+      {!query.aggregation && (
+        <div className={styles.modeSelector}>
+          <span className={styles.modeLabel}>Downsample:</span>
+          <RadioButtonGroup
+            size="sm"
+            options={downsampleOptions}
+            value={query.downsample ?? ''}
+            onChange={(v) => {
+              onChange({ ...query, downsample: v || undefined });
+              onRunQuery();
+            }}
+          />
+        </div>
+      )}
+
+      {!query.aggregation && (
+        <div className={styles.modeSelector}>
+          <span className={styles.modeLabel}>Counters:</span>
+          <RadioButtonGroup
+            size="sm"
+            options={counterOptions}
+            value={query.transforms?.networkRxBytes ?? 'none'}
+            onChange={(v) => {
+              const transforms =
+                v === 'none' ? undefined : Object.fromEntries(COUNTER_METRICS.map((metric) => [metric, v]));
+              onChange({ ...query, transforms });
+              onRunQuery();
+            }}
+          />
+        </div>
+      )}
+
+      <div className={styles.modeSelector}>
+        <span className={styles.modeLabel}>Series per:</span>
+        <RadioButtonGroup
+          size="sm"
+          options={identityOptions}
+          value={query.identity ?? ''}
+          onChange={(v) => {
+            onChange({ ...query, identity: v || undefined });
+            onRunQuery();
+          }}
+        />
+      </div>
+
+      {supportsNamespaces && (
+        <div className={styles.modeSelector}>
+          <span className={styles.modeLabel}>Namespaces:</span>
+          <Input
+            width={40}
+            placeholder="all namespaces (comma separated)"
+            defaultValue={(query.namespaces ?? []).join(', ')}
+            onBlur={(e) => {
+              const namespaces = e.currentTarget.value.split(',').map((n) => n.trim()).filter(Boolean);
+              onChange({ ...query, namespaces: namespaces.length > 0 ? namespaces : undefined });
+              onRunQuery();
+            }}
+          />
+        </div>
+      )}
+
+      <div className={styles.modeSelector}>
+        <span className={styles.modeLabel}>Label filters:</span>
+        <Input
+          width={40}
+          placeholder="e.g. composeService=web, nomadJob=api"
+          defaultValue={formatLabelFilters(query.labelFilters)}
+          onBlur={(e) => {
+            const labelFilters = parseLabelFilters(e.currentTarget.value);
+            onChange({ ...query, labelFilters: Object.keys(labelFilters).length > 0 ? labelFilters : undefined });
+            onRunQuery();
+          }}
+        />
+      </div>
+
+      <div className={styles.modeSelector}>
+        <Checkbox
+          label="Alerting mode"
+          description="Plain numeric frames with stable labels for alert rules and expressions"
+          value={query.alerting ?? false}
+          onChange={(e) => {
+            onChange({ ...query, alerting: e.currentTarget.checked || undefined });
+            onRunQuery();
+          }}
+        />
+      </div>
+
+      <div className={styles.modeSelector}>
+        <Checkbox
+          label="Snapshot mode"
+          description="Include host metadata in the response so snapshots and public dashboards need no live agents"
+          value={query.snapshot ?? false}
+          onChange={(e) => {
+            onChange({ ...query, snapshot: e.currentTarget.checked || undefined });
+            onRunQuery();
+          }}
+        />
+      </div>
+
       {containersByHost.map((host) => {
         const hostSel = getHostSelection(host.hostId);
         const summary = getSelectionSummary(host.hostId);
@@ -763,18 +1012,23 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
                             {container.containerName.replace(/^\//, '')}
                           </span>
                         </td>
-                        {ALL_METRICS.map((metric) => (
-                          <td
-                            key={metric}
-                            className={`${styles.metricCell} ${isExcluded ? styles.metricCellDisabled : ''}`}
-                          >
-                            <Checkbox
-                              value={!isExcluded && containerMetrics.includes(metric)}
-                              disabled={isExcluded}
-                              onChange={(e) => onMetricToggle(host.hostId, container.containerId, metric, e.currentTarget.checked)}
-                            />
-                          </td>
-                        ))}
+                        {ALL_METRICS.map((metric) => {
+                          const supported = isMetricSupported(host.hostId, metric);
+                          const disabled = isExcluded || !supported;
+                          return (
+                            <td
+                              key={metric}
+                              className={`${styles.metricCell} ${disabled ? styles.metricCellDisabled : ''}`}
+                              title={supported ? undefined : 'Not supported by this host'}
+                            >
+                              <Checkbox
+                                value={!disabled && containerMetrics.includes(metric)}
+                                disabled={disabled}
+                                onChange={(e) => onMetricToggle(host.hostId, container.containerId, metric, e.currentTarget.checked)}
+                              />
+                            </td>
+                          );
+                        })}
                         <td className={styles.actionsCell}>
                           <Dropdown
                             overlay={
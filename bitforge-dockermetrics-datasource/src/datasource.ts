@@ -1,12 +1,25 @@
 import {
+  DataQueryResponse,
   DataSourceInstanceSettings,
   CoreApp,
   DataQueryRequest,
+  LiveChannelScope,
+  MetricFindValue,
   TestDataSourceResponse,
 } from '@grafana/data';
-import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
+import { DataSourceWithBackend, getGrafanaLiveSrv, getTemplateSrv } from '@grafana/runtime';
+import { Observable } from 'rxjs';
 
-import { DockerMetricsQuery, DockerMetricsDataSourceOptions, DEFAULT_QUERY } from './types';
+import {
+  DockerMetricsQuery,
+  DockerMetricsDataSourceOptions,
+  DEFAULT_QUERY,
+  HostCapabilities,
+  HostOption,
+  ContainerOption,
+  MetricOption,
+  StreamRequest,
+} from './types';
 
 export class DockerMetricsDataSource extends DataSourceWithBackend<
   DockerMetricsQuery,
@@ -24,22 +37,28 @@ export class DockerMetricsDataSource extends DataSourceWithBackend<
   }
 
   /**
-   * Apply template variables to queries before sending to backend
+   * Send the values of the template variables a query references; the backend interpolates
+   * them, so queries saved with variables also filter in alert rules and public dashboards
    */
   applyTemplateVariables(query: DockerMetricsQuery, scopedVars: Record<string, { value: string }>): DockerMetricsQuery {
     const templateSrv = getTemplateSrv();
+    const text = JSON.stringify(query);
+    const names = new Set([...templateSrv.getVariables().map((v) => v.name), ...Object.keys(scopedVars)]);
 
-    return {
-      ...query,
-      // Replace template variables in container name pattern
-      containerNamePattern: query.containerNamePattern
-        ? templateSrv.replace(query.containerNamePattern, scopedVars)
-        : undefined,
-      // Replace template variables in container IDs (could be a variable like $container)
-      containerIds: query.containerIds?.map((id) => templateSrv.replace(id, scopedVars)),
-      // Replace template variables in host IDs
-      hostIds: query.hostIds?.map((id) => templateSrv.replace(id, scopedVars)),
-    };
+    const variables: Record<string, string | string[]> = { ...query.variables };
+    for (const name of names) {
+      if (!text.includes(`$${name}`) && !text.includes(`\${${name}`)) {
+        continue;
+      }
+      const value = templateSrv.replace(`\${${name}:json}`, scopedVars);
+      try {
+        const parsed = JSON.parse(value);
+        variables[name] = Array.isArray(parsed) ? parsed.map(String) : String(parsed);
+      } catch {
+        variables[name] = value;
+      }
+    }
+    return { ...query, variables };
   }
 
   /**
@@ -61,6 +80,69 @@ export class DockerMetricsDataSource extends DataSourceWithBackend<
     return (query.metrics?.length ?? 0) > 0;
   }
 
+  /**
+   * Fetch per-host capabilities (PSI, logs, controls, retention) from the backend
+   */
+  async getCapabilities(): Promise<Record<string, HostCapabilities>> {
+    return this.getResource('capabilities');
+  }
+
+  /**
+   * Enabled hosts, containers of a host and queryable metrics for editor dropdowns. These go
+   * through the backend, so they also work where the agents aren't reachable from the browser.
+   */
+  async getHosts(scopeToken?: string): Promise<HostOption[]> {
+    return this.getResource('hosts', scopeToken ? { scopeToken } : undefined);
+  }
+
+  async getHostContainers(hostId: string, scopeToken?: string): Promise<ContainerOption[]> {
+    return this.getResource(`hosts/${encodeURIComponent(hostId)}/containers`, scopeToken ? { scopeToken } : undefined);
+  }
+
+  async getMetrics(): Promise<MetricOption[]> {
+    return this.getResource('metrics');
+  }
+
+  /**
+   * Template variable values: `hosts`, `containers` (every host), `containers(<hostId>)` or `metrics`
+   */
+  async metricFindQuery(query: string): Promise<MetricFindValue[]> {
+    const expr = getTemplateSrv().replace(query).trim();
+    if (expr === 'hosts') {
+      return (await this.getHosts()).map((h) => ({ text: h.name, value: h.id }));
+    }
+    if (expr === 'metrics') {
+      return (await this.getMetrics()).map((m) => ({ text: m.label, value: m.value }));
+    }
+    const match = expr.match(/^containers(?:\((.*)\))?$/);
+    if (!match) {
+      throw new Error(`Unknown variable query "${expr}", use hosts, containers, containers(<hostId>) or metrics`);
+    }
+    const hostIds = match[1] ? [match[1].trim()] : (await this.getHosts()).map((h) => h.id);
+    const names = new Set<string>();
+    for (const hostId of hostIds) {
+      for (const c of await this.getHostContainers(hostId)) {
+        names.add(c.containerName);
+      }
+    }
+    return [...names].sort().map((name) => ({ text: name }));
+  }
+
+  /**
+   * Subscribe to live metrics of a host. Subscribers of one key share a stream, so the key
+   * must differ for different requests.
+   */
+  streamMetrics(hostId: string, request: StreamRequest, key = 'default'): Observable<DataQueryResponse> {
+    return getGrafanaLiveSrv().getDataStream({
+      addr: {
+        scope: LiveChannelScope.DataSource,
+        namespace: this.uid,
+        path: `metrics/${hostId}/${key}`,
+        data: request,
+      },
+    });
+  }
+
   /**
    * Test data source connection - delegated to backend health check
    */
@@ -3,12 +3,22 @@ import { DataSourceJsonData, DataQuery } from '@grafana/data';
 /**
  * Container control actions
  */
-export type ControlAction = 'start' | 'stop' | 'restart' | 'pause' | 'unpause';
+export type ControlAction = 'start' | 'stop' | 'restart' | 'pause' | 'unpause' | 'kill' | 'remove' | 'recreate';
+
+/**
+ * Grafana org roles that can be required for control actions
+ */
+export type ControlRole = 'Viewer' | 'Editor' | 'Admin';
 
 /**
  * All valid control actions
  */
-export const ALL_CONTROL_ACTIONS: ControlAction[] = ['start', 'stop', 'restart', 'pause', 'unpause'];
+export const ALL_CONTROL_ACTIONS: ControlAction[] = ['start', 'stop', 'restart', 'pause', 'unpause', 'kill', 'remove', 'recreate'];
+
+/**
+ * Actions that replace or discard a container, only allowed when listed explicitly
+ */
+export const DESTRUCTIVE_CONTROL_ACTIONS: ControlAction[] = ['kill', 'remove', 'recreate'];
 
 /**
  * Host selection mode for container filtering
@@ -28,8 +38,19 @@ export interface HostSelection {
   containerMetrics: Record<string, string[]>;
   // Metrics to fetch (blacklist mode - applies to all included containers)
   metrics: string[];
+  // How metrics and containerMetrics combine; unset keeps the legacy rules
+  selectionSemantics?: SelectionSemantics;
+  // Docker label selectors, 'key=value' or 'key': a whitelist adds containers matching all
+  // includeLabels, a blacklist keeps only them; excludeLabels matches are always left out
+  includeLabels?: string[];
+  excludeLabels?: string[];
 }
 
+/**
+ * 'union': metrics plus a container's containerMetrics; 'intersection': only metrics in both
+ */
+export type SelectionSemantics = 'union' | 'intersection';
+
 /**
  * Docker Metrics query model
  */
@@ -40,13 +61,130 @@ export interface DockerMetricsQuery extends DataQuery {
   // Global default metrics for new containers
   defaultContainerMetrics?: string[];
 
-  // Legacy fields (kept for backward compatibility)
+  // Legacy fields (kept for backward compatibility). Queries with only these fields get the
+  // old frame naming; the backend also reads the singular metric/containerId/hostId of old panels.
   metrics?: string[];
   containerNamePattern?: string;
   containerIds?: string[];
   hostIds?: string[];
+
+  // Select hosts by group (any of) and tags (all of)
+  hostGroups?: string[];
+  hostTags?: string[];
+
+  // Roll containers up into one series per group (counters summed, gauges averaged)
+  aggregateBy?: AggregateBy;
+
+  // Reduce the containers of each groupBy group (all containers when unset) to one series per metric
+  aggregation?: Aggregation;
+  groupBy?: AggregationGroup;
+
+  // How series denser than maxDataPoints or the query interval are thinned, default 'avg'
+  downsample?: Downsample;
+
+  // Per-metric transform of per-container series, e.g. { networkRxBytes: 'rate' } for MB/s
+  transforms?: Record<string, MetricTransform>;
+
+  // Key series by container name or compose service to keep one series across recreation
+  identity?: SeriesIdentity;
+
+  // containerd hosts: only containers in these namespaces
+  namespaces?: string[];
+
+  // Only containers whose series labels (e.g. ecsCluster, composeService) have one of the values
+  labelFilters?: Record<string, string[]>;
+
+  // queryType 'recording': recording rules to return
+  rules?: string[];
+
+  // Plain numeric frames for alert rules: metric-named value field, no containerId label, no containers frame
+  alerting?: boolean;
+
+  // Also return the hosts table so snapshots and public dashboards render without live agents
+  snapshot?: boolean;
+
+  // queryType 'threshold': containers whose latest value violates `<metric> <operator> <threshold>`
+  thresholdMetric?: string;
+  thresholdOperator?: '>' | '>=' | '<' | '<=' | '==' | '!=';
+  threshold?: number;
+
+  // queryType 'state': 0/1 series per container
+  states?: Array<'isRunning' | 'isUnhealthy' | 'isPaused'>;
+
+  // queryType 'logs': container stdout/stderr lines, default 1000, at most 5000
+  logLimit?: number;
+
+  // queryType 'cost' also takes groupBy 'composeProject' for one row per project
+
+  // queryType 'containers': page size and start; the frame meta then carries totalCount
+  limit?: number;
+  offset?: number;
+
+  // Signed token from the `scope-tokens` resource limiting the hosts and containers this query may read
+  scopeToken?: string;
+
+  // Container name regexes hidden from this query; unset uses the datasource's excludeContainers, [] shows everything
+  excludeContainers?: string[];
+
+  // Overrides the host's retry settings for this query
+  retry?: RetrySettings;
+
+  // Template variable values the backend interpolates into hostIds, containerIds,
+  // containerNamePattern and hostSelections; filled from the dashboard at query time
+  variables?: Record<string, string | string[]>;
 }
 
+/**
+ * Data of a live metrics subscription on channel `metrics/<hostId>/<key>` (streaming feature)
+ */
+export interface StreamRequest {
+  metrics?: string[];
+  containerIds?: string[];
+  // Agent poll interval, default 1000, at least 500
+  intervalMs?: number;
+  // Poll busy hosts more and quiet hosts less often than intervalMs, default true
+  adaptive?: boolean;
+}
+
+/**
+ * Container grouping for series aggregation ('' = no aggregation)
+ */
+export type AggregateBy =
+  | ''
+  | 'pod'
+  | 'compose'
+  | 'ecs-task'
+  | 'ecs-family'
+  | 'nomad-job'
+  | 'nomad-alloc'
+  | 'swarm-service'
+  | 'swarm-task';
+
+/**
+ * Function reducing containers to one series per group ('' = one series per container)
+ */
+export type Aggregation = '' | 'sum' | 'avg' | 'min' | 'max' | 'count';
+
+/**
+ * Groups of a cross-container aggregation ('' = every container)
+ */
+export type AggregationGroup = '' | 'host' | 'image' | 'composeProject';
+
+/**
+ * Downsampling of metric series ('' = avg): bucket mean, bucket max, LTTB or raw samples
+ */
+export type Downsample = '' | 'avg' | 'max' | 'lttb' | 'none';
+
+/**
+ * Metric transform: raw values, increase per second or increase per sample (counter resets handled)
+ */
+export type MetricTransform = 'none' | 'rate' | 'delta';
+
+/**
+ * Series identity ('' = container ID, a recreated container starts a new series)
+ */
+export type SeriesIdentity = '' | 'name' | 'service';
+
 /**
  * Host configuration for Docker Metrics Collector agents
  */
@@ -55,8 +193,140 @@ export interface HostConfig {
   name: string;
   url: string;      // e.g., http://192.168.74.202:5000
   enabled: boolean;
+  urls?: string[];       // additional agent replicas, reads are balanced across url + urls
+  loadBalancing?: 'round-robin' | 'least-failures';
+  fallbackUrl?: string;  // secondary agent used when the primary is unreachable
+  group?: string;
+  tags?: string[];
+  labels?: Record<string, string>;
+  mode?: HostMode;
+  metricsPath?: string;  // prometheus mode, defaults to /metrics
+  timezone?: string;     // IANA zone for agent timestamps without an offset, default UTC
+  retry?: RetrySettings; // overrides the data source's retry settings for this host
+  publicKey?: string; // PEM public key (ECDSA P-256 or Ed25519) the agent signs responses with
+}
+
+/**
+ * Retries of agent reads (metrics and container lists); unset fields keep the outer settings
+ */
+export interface RetrySettings {
+  attempts?: number; // tries including the first, default 2, at most 5; 1 disables retries
+  backoffMs?: number; // wait before the first retry, doubled per retry up to 2s, default 200
+  statusCodes?: number[]; // statuses retried besides connection errors, default 502, 503, 504
+}
+
+export interface ChaosSettings {
+  enabled?: boolean;
+  latencyMs?: number; // delay of up to this long per request
+  errorRate?: number; // share of requests answered with errorStatus (default 503), 0 to 1
+  errorStatus?: number;
+  malformedRate?: number; // share of payloads cut in half, 0 to 1
+  hostIds?: string[]; // empty for every host
+  paths?: string[]; // agent path prefixes, empty for every request
 }
 
+/**
+ * API spoken by a host's URL ('' = Docker Metrics Collector agent)
+ */
+export type HostMode = '' | 'cadvisor' | 'prometheus';
+
+/**
+ * Automatic host discovery mode
+ */
+export type DiscoveryMode = '' | 'dns-srv' | 'swarm' | 'consul' | 'etcd';
+
+/**
+ * Automatic host discovery configuration
+ */
+export interface DiscoverySettings {
+  mode?: DiscoveryMode;
+  intervalSeconds?: number;
+  scheme?: 'http' | 'https';
+  // DNS SRV discovery, e.g. _dockermetrics._tcp.example.com
+  srvRecord?: string;
+  // Swarm node discovery: agent on a manager node plus a per-node URL template ({id}, {hostname}, {addr})
+  swarmManagerUrl?: string;
+  swarmNodeUrlTemplate?: string;
+  // Consul service discovery
+  consulAddress?: string;
+  consulService?: string;
+  consulDatacenter?: string;
+  consulTag?: string;
+  // etcd prefix discovery (v3 JSON gateway)
+  etcdEndpoint?: string;
+  etcdPrefix?: string;
+  etcdUsername?: string;
+  // Subnet scan (triggered from the config editor, results must be added manually)
+  scanCidr?: string;
+  scanPort?: number;
+}
+
+/**
+ * Optional local retention store: the backend keeps ingesting samples and serves
+ * ranges older than the agent's own retention window
+ */
+export interface RetentionSettings {
+  enabled?: boolean;
+  path?: string;                  // BoltDB file, defaults to the temp directory
+  days?: number;                  // default 30
+  ingestIntervalSeconds?: number; // default 60
+}
+
+/**
+ * Background exporters forwarding agent samples to other systems
+ */
+export interface ExportSettings {
+  intervalSeconds?: number; // default 30
+  remoteWrite?: {
+    enabled?: boolean;
+    url?: string;           // e.g. http://mimir:9009/api/v1/push
+    username?: string;      // basic auth; password in secureJsonData.remoteWritePassword
+  };
+  otlp?: {
+    enabled?: boolean;
+    endpoint?: string;      // OTLP/HTTP base URL, e.g. http://otel-collector:4318
+    headers?: Record<string, string>;
+  };
+}
+
+/**
+ * Built-in watcher alerting on unhealthy or restart-looping containers
+ */
+export interface NotifierSettings {
+  enabled?: boolean;
+  intervalSeconds?: number;      // default 60
+  alertmanagerUrl?: string;      // alerts are posted to /api/v2/alerts
+  webhookUrl?: string;           // receives {status: 'firing' | 'resolved', alerts}
+  restartThreshold?: number;     // starts within the window that count as a restart loop, default 3
+  restartWindowMinutes?: number; // default 10
+}
+
+/**
+ * Named expression evaluated on a schedule, e.g. sum(cpuPercent) by (composeProject)
+ */
+export interface RecordingRule {
+  name: string;
+  expr: string;
+  intervalSeconds?: number; // default 60
+}
+
+/**
+ * Rewrites host names, container names and labels of the hosts it selects; rules run in order
+ */
+export interface RelabelRule {
+  hostIds?: string[];
+  hostPattern?: string; // regex on host names; with neither selector, every host
+  hostName?: string; // replaces the host name, or the hostPattern match ($1 expands groups)
+  containerPattern?: string;
+  containerReplacement?: string;
+  labels?: Record<string, string>; // static labels such as env=prod
+}
+
+/**
+ * Host metadata fields reported by the agent's /api/info endpoint
+ */
+export type HostMetadataField = 'os' | 'kernel' | 'dockerVersion' | 'architecture' | 'agentVersion';
+
 /**
  * Data source instance settings (stored in Grafana)
  */
@@ -64,13 +334,243 @@ export interface DockerMetricsDataSourceOptions extends DataSourceJsonData {
   hosts?: HostConfig[];
   enableContainerControls?: boolean;
   allowedControlActions?: ControlAction[];
+  // Refuses control queries and resource writes whatever the other settings
+  readOnly?: boolean;
+  // Role and team membership control queries require; unset lets any user through
+  controlAuthorization?: { minRole?: ControlRole; allowedTeams?: string[] };
+  // Persistent trail of control actions served by queryType 'audit'; path defaults to the temp directory, days to 365
+  audit?: { enabled?: boolean; path?: string; days?: number };
+  discovery?: DiscoverySettings;
+  // File agent self-registrations are saved to, defaults to the temp directory
+  registrationsPath?: string;
+  // Agent /api/info fields attached as labels on every series
+  hostMetadataLabels?: HostMetadataField[];
+  // Above this many hosts queries carry a latency warning and health checks sample hosts (default 25)
+  largeFleetThreshold?: number;
+  retention?: RetentionSettings;
+  export?: ExportSettings;
+  notifier?: NotifierSettings;
+  recordingRules?: RecordingRule[];
+  // Series name for containers whose name exists on several hosts: {container}, {host}, {hostId}
+  duplicateNamePattern?: string;
+  dataLinks?: DataLink[];
+  // Per-metric display overrides, keyed by metric name (e.g. cpuPercent)
+  metricFormats?: Record<string, MetricFormat>;
+  // Minimum backend log level for this data source (default info)
+  logLevel?: 'debug' | 'info' | 'warn' | 'error';
+  // Subsystem switches; discovery and caching default on, streaming, logs and prefetch (experimental) off
+  featureToggles?: Partial<Record<FeatureToggle, boolean>>;
+  // Opt-in: count query types, metrics and host counts (no identifiers), see the `usage` resource
+  usageStats?: boolean;
+  // Proxy for agent requests (http, https or socks5); unset uses HTTP_PROXY/HTTPS_PROXY, NO_PROXY always applies
+  proxyUrl?: string;
+  // High-cardinality labels removed from (drop) or shortened to a 12 character hash on (hash) every field
+  labelPruning?: { drop?: string[]; hash?: string[] };
+  // Host and container renames plus static labels, applied to everything read from matching hosts
+  relabeling?: RelabelRule[];
+  // Agents whose clock is off by more than warnSeconds (default 30) get a notice; correct shifts their timestamps
+  clockSkew?: { warnSeconds?: number; correct?: boolean };
+  // Queries from viewers and public dashboards must carry a scope token
+  scopeTokens?: { required?: boolean };
+  // A host whose newest sample is older than this is marked stale (notice plus ageSeconds field), default 60
+  staleSeconds?: number;
+  // Samples further apart than this get a null between them so lines break, default staleSeconds, negative to connect
+  heartbeatSeconds?: number;
+  // Hosts a query fetches from at once, default 8
+  fetchConcurrency?: number;
+  // Time a query waits for one host before leaving it out, default 10
+  hostTimeoutSeconds?: number;
+  // Container name regexes hidden from every query unless the query overrides them, e.g. ^pause$
+  excludeContainers?: string[];
+  // Datasource queried through the Grafana API when none of this instance's hosts is reachable
+  secondaryDatasourceUid?: string;
+  // Docker Metrics datasources whose results are merged into every query through the Grafana API
+  federatedDatasourceUids?: string[];
+  // Prices for queryType 'cost'
+  costModel?: CostModel;
+  // Swarm mode for queryType 'swarmServices'; without managerHostId the selected hosts are asked
+  swarm?: { enabled?: boolean; managerHostId?: string };
+  // Retries of agent reads; hosts and queries can override them
+  retry?: RetrySettings;
+  // Fault injection for testing dashboards, ignored unless the server sets DOCKERMETRICS_ALLOW_CHAOS
+  chaos?: ChaosSettings;
+  // Default template variable values for queries run without a dashboard (alert rules)
+  variables?: Record<string, string | string[]>;
+}
+
+/**
+ * Prices per hour of use. A host's entry replaces its group's, which replaces the default rates.
+ */
+export interface CostRates {
+  cpuHour?: number; // one fully used core
+  gbHour?: number; // one GB of memory
+}
+
+export interface CostModel extends CostRates {
+  currency?: string; // unit of the cost fields, e.g. USD or €
+  groups?: Record<string, CostRates>; // host group -> rates
+  hosts?: Record<string, CostRates>; // host ID -> rates
+}
+
+/**
+ * Subsystems that can be switched per data source
+ */
+export type FeatureToggle = 'discovery' | 'caching' | 'streaming' | 'logs' | 'prefetch';
+
+/**
+ * Display override for a metric: values are multiplied by scale and shown with decimals in unit
+ */
+export interface MetricFormat {
+  decimals?: number;
+  scale?: number;
+  unit?: string; // Grafana unit ID, e.g. percent, decgbytes
+}
+
+/**
+ * Click-through link attached to every container series, e.g.
+ * /d/container-detail?var-container=${__field.labels.containerId}
+ */
+export interface DataLink {
+  title: string;
+  url: string;
+  targetBlank?: boolean;
 }
 
 /**
  * Secure JSON data (stored encrypted)
  */
 export interface DockerMetricsSecureJsonData {
-  // Reserved for future use (e.g., API keys for agents)
+  // Shared secret agents must present to the `register` resource
+  registrationToken?: string;
+  consulToken?: string;
+  etcdPassword?: string;
+  // Service account token used to save runtime host enable/disable back to the datasource and to
+  // query the secondary datasource
+  grafanaApiToken?: string;
+  remoteWritePassword?: string;
+  remoteWriteBearerToken?: string;
+  // Authorization header value sent to the OTLP endpoint
+  otlpAuthorization?: string;
+  // HMAC secret signing scope tokens; rotating it revokes every issued token
+  scopeTokenSecret?: string;
+  // Bearer token sent to a host's agents, keyed by host ID
+  [hostToken: `hostToken.${string}`]: string | undefined;
+}
+
+/**
+ * Result of the backend `discovery/scan` resource
+ */
+export interface ScanResult {
+  cidr: string;
+  port: number;
+  scanned: number;
+  proposals: HostConfig[];
+}
+
+/**
+ * Agent self-registration returned by the `registrations` resource
+ */
+export interface Registration {
+  id: string;
+  name: string;
+  url: string;
+  status: 'pending' | 'approved' | 'rejected';
+  registeredAt: string;
+  lastSeen: string;
+}
+
+/**
+ * Result of the backend `hosts/{id}/enabled` resource
+ */
+export interface HostStateResult {
+  hostId: string;
+  enabled: boolean;
+  persisted: boolean;
+  message?: string;
+}
+
+/**
+ * Planned maintenance window returned by the `maintenance` resource
+ */
+export interface MaintenanceWindow {
+  hostId: string;
+  reason?: string;
+  setBy?: string;
+  since: string;
+  until?: string;
+}
+
+/**
+ * Entries of the `hosts`, `hosts/<id>/containers` and `metrics` resources behind editor dropdowns
+ */
+export interface HostOption {
+  id: string;
+  name: string;
+  group?: string;
+  tags?: string[];
+}
+
+export interface ContainerOption {
+  containerId: string;
+  containerName: string;
+  state: string;
+  image?: string;
+}
+
+export interface MetricOption {
+  value: string;
+  label: string;
+  unit?: string;
+}
+
+/**
+ * Per-host capabilities reported by the backend `capabilities` resource
+ */
+export interface HostCapabilities {
+  hostId: string;
+  hostName: string;
+  reachable: boolean;
+  error?: string;
+  agentVersion: string;
+  supportsPsi: boolean;
+  supportsLogs: boolean;
+  supportsControls: boolean;
+  runtime: 'docker' | 'podman' | 'containerd' | string;
+  orchestrator?: 'ecs' | 'nomad' | string;
+  instanceId?: string;
+  supportsPods: boolean;
+  supportsSwarm: boolean;
+  supportsNamespaces: boolean;
+  maxRetentionSeconds: number;
+  supportedMetrics: string[];
+  fetchedAt: string;
+}
+
+/**
+ * Result of the backend `version` resource
+ */
+export interface PluginVersionInfo {
+  version: string;
+  commit?: string;
+  buildDate?: string;
+  goVersion: string;
+  // Keyed by host ID
+  agents: Record<string, { hostName: string; agentVersion?: string; reachable: boolean; error?: string }>;
+}
+
+/**
+ * Result of the backend `scope-tokens` resource
+ */
+export interface ScopeTokenResult {
+  token: string;
+  claims: {
+    ds: string;
+    hosts?: string[];
+    containers?: string[];
+    iss?: string;
+    iat: number;
+    exp?: number;
+  };
 }
 
 /**
@@ -84,6 +584,7 @@ export const ALL_METRICS = [
   'cpuPressureSome', 'cpuPressureFull',
   'memoryPressureSome', 'memoryPressureFull',
   'ioPressureSome', 'ioPressureFull',
+  'isRunning', 'isHealthy',
 ];
 
 /**
@@ -40,7 +40,7 @@ const styles = {
   `,
 };
 
-const dangerousActions: ControlAction[] = ['stop', 'restart'];
+const dangerousActions: ControlAction[] = ['stop', 'restart', 'kill', 'remove', 'recreate'];
 
 interface ActionConfig {
   icon: string;
@@ -80,6 +80,24 @@ const actionConfigs: Record<ControlAction, ActionConfig> = {
     showWhen: (state) => state === 'paused',
     variant: 'primary',
   },
+  kill: {
+    icon: 'times',
+    tooltip: 'Kill container',
+    showWhen: (state) => state === 'running' || state === 'paused' || state === 'restarting',
+    variant: 'destructive',
+  },
+  remove: {
+    icon: 'trash-alt',
+    tooltip: 'Remove container',
+    showWhen: (state) => state === 'exited' || state === 'created' || state === 'dead',
+    variant: 'destructive',
+  },
+  recreate: {
+    icon: 'history',
+    tooltip: 'Recreate container',
+    showWhen: (state) => state !== 'undefined' && state !== 'invalid' && state !== 'removing',
+    variant: 'destructive',
+  },
 };
 
 export const ContainerControls: React.FC<ContainerControlsProps> = ({
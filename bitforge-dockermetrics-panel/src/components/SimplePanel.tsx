@@ -1,6 +1,6 @@
 import React, { useMemo, useCallback } from 'react';
 import { PanelProps, DataFrame, FieldType } from '@grafana/data';
-import { SimpleOptions, ContainerMetrics, ContainerInfo, AVAILABLE_METRICS, MetricDefinition, ContainerState, ContainerHealthStatus, getStateDisplay, getPulseType, ControlAction, ALL_CONTROL_ACTIONS } from 'types';
+import { SimpleOptions, ContainerMetrics, ContainerInfo, AVAILABLE_METRICS, MetricDefinition, ContainerState, ContainerHealthStatus, getStateDisplay, getPulseType, ControlAction, ALL_CONTROL_ACTIONS, DESTRUCTIVE_CONTROL_ACTIONS } from 'types';
 import { css, cx, keyframes } from '@emotion/css';
 import { useStyles2, Alert } from '@grafana/ui';
 import { normalizeContainerState, normalizeHealthStatus, isStateRunning, isStatePaused, isHealthUnhealthy } from '../utils/datasource';
@@ -687,7 +687,7 @@ export const SimplePanel: React.FC<Props> = ({ width, height, options, data }) =
   const containersPerRow = options.containersPerRow || 0;
   const metricsPerRow = options.metricsPerRow || 0;
   const enableControls = options.enableControls || false;
-  const allowedActions = options.allowedActions || ALL_CONTROL_ACTIONS;
+  const allowedActions = options.allowedActions || ALL_CONTROL_ACTIONS.filter((a) => !DESTRUCTIVE_CONTROL_ACTIONS.includes(a));
   const confirmDangerousActions = options.confirmDangerousActions ?? true;
   const styles = useStyles2(getStyles);
 
@@ -759,6 +759,12 @@ export const SimplePanel: React.FC<Props> = ({ width, height, options, data }) =
     return [];
   }, [data?.series, hasQueryData]);
 
+  // Snapshot-mode responses are rendered without live agents, so controls can't act
+  const isSnapshot = useMemo(
+    () => (data?.series ?? []).some((frame) => frame.meta?.custom?.snapshot === true),
+    [data?.series]
+  );
+
   // Parse metrics from props.data.series (from Grafana's query runner)
   const { allMetrics, presentMetricsMap } = useMemo(() => {
     if (!hasQueryData) {
@@ -1075,7 +1081,7 @@ export const SimplePanel: React.FC<Props> = ({ width, height, options, data }) =
               {formatUptime(container.latest.uptimeSeconds)}
             </span>
           )}
-          {enableControls && datasourceUid && (
+          {enableControls && datasourceUid && !isSnapshot && (
             <ContainerControls
               containerId={container.containerId}
               containerName={container.containerName}
@@ -1,5 +1,5 @@
 import { PanelPlugin } from '@grafana/data';
-import { SimpleOptions, ALL_CONTROL_ACTIONS } from './types';
+import { SimpleOptions, ALL_CONTROL_ACTIONS, DESTRUCTIVE_CONTROL_ACTIONS } from './types';
 import { SimplePanel } from './components/SimplePanel';
 import { VersionInfoEditor } from './components/VersionInfoEditor';
 
@@ -39,7 +39,7 @@ export const plugin = new PanelPlugin<SimpleOptions>(SimplePanel).setPanelOption
     .addBooleanSwitch({
       path: 'enableControls',
       name: 'Enable Container Controls',
-      description: 'Show start/stop/restart/pause/unpause buttons on container cards',
+      description: 'Show start/stop/restart/pause/unpause/kill/remove/recreate buttons on container cards',
       defaultValue: false,
       category: ['Controls'],
     })
@@ -47,7 +47,7 @@ export const plugin = new PanelPlugin<SimpleOptions>(SimplePanel).setPanelOption
       path: 'allowedActions',
       name: 'Allowed Actions',
       description: 'Which container actions to show (requires datasource-level permission)',
-      defaultValue: ALL_CONTROL_ACTIONS,
+      defaultValue: ALL_CONTROL_ACTIONS.filter((a) => !DESTRUCTIVE_CONTROL_ACTIONS.includes(a)),
       category: ['Controls'],
       settings: {
         options: [
@@ -56,6 +56,9 @@ export const plugin = new PanelPlugin<SimpleOptions>(SimplePanel).setPanelOption
           { label: 'Restart', value: 'restart', description: 'Restart containers' },
           { label: 'Pause', value: 'pause', description: 'Pause running containers' },
           { label: 'Unpause', value: 'unpause', description: 'Resume paused containers' },
+          { label: 'Kill', value: 'kill', description: 'Kill containers that do not stop' },
+          { label: 'Remove', value: 'remove', description: 'Remove stopped containers' },
+          { label: 'Recreate', value: 'recreate', description: 'Replace containers with new ones of the same config' },
         ],
       },
       showIf: (config) => config.enableControls === true,
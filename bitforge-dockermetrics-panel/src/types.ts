@@ -1,10 +1,13 @@
 import { DataQuery } from '@grafana/data';
 
 // Container control actions
-export type ControlAction = 'start' | 'stop' | 'restart' | 'pause' | 'unpause';
+export type ControlAction = 'start' | 'stop' | 'restart' | 'pause' | 'unpause' | 'kill' | 'remove' | 'recreate';
 
 // All valid control actions
-export const ALL_CONTROL_ACTIONS: ControlAction[] = ['start', 'stop', 'restart', 'pause', 'unpause'];
+export const ALL_CONTROL_ACTIONS: ControlAction[] = ['start', 'stop', 'restart', 'pause', 'unpause', 'kill', 'remove', 'recreate'];
+
+// Actions that replace or discard a container, only allowed when listed explicitly
+export const DESTRUCTIVE_CONTROL_ACTIONS: ControlAction[] = ['kill', 'remove', 'recreate'];
 
 // Custom query type for Docker Metrics data source
 export interface DockerMetricsQuery extends DataQuery {
@@ -16,6 +19,8 @@ export interface DockerMetricsQuery extends DataQuery {
   controlAction?: ControlAction;
   targetContainer?: string;
   targetHost?: string;
+  force?: boolean; // remove: remove a running container
+  removeVolumes?: boolean; // remove: also remove anonymous volumes
 }
 
 // Container state enum - matches C# ContainerState
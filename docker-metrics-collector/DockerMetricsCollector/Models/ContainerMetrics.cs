@@ -67,6 +67,24 @@ public static class ContainerHealthStatusExtensions
         };
     }
 
+    /// <summary>
+    /// Parse the health suffix of a container list Status text, e.g. "Up 5 minutes (unhealthy)".
+    /// </summary>
+    public static ContainerHealthStatus ParseDockerStatusText(string? status)
+    {
+        if (string.IsNullOrEmpty(status))
+            return ContainerHealthStatus.None;
+
+        var text = status.ToLowerInvariant();
+        if (text.Contains("(unhealthy)"))
+            return ContainerHealthStatus.Unhealthy;
+        if (text.Contains("(healthy)"))
+            return ContainerHealthStatus.Healthy;
+        if (text.Contains("(health: starting)"))
+            return ContainerHealthStatus.Starting;
+        return ContainerHealthStatus.None;
+    }
+
     /// <summary>
     /// Check if container is unhealthy.
     /// </summary>
@@ -98,6 +116,10 @@ public static class ContainerStateExtensions
             "dead" => ContainerState.Dead,
             "undefined" => ContainerState.Undefined,
             "invalid" => ContainerState.Invalid,
+            // Podman-specific states
+            "configured" or "initialized" => ContainerState.Created,
+            "stopping" => ContainerState.Running,
+            "stopped" => ContainerState.Exited,
             _ => ContainerState.Invalid // Unknown state from Docker = Invalid
         };
     }
@@ -175,7 +197,12 @@ public record ContainerInfo(
     string ContainerId,
     string ContainerName,
     ContainerState State,
-    ContainerHealthStatus HealthStatus
+    ContainerHealthStatus HealthStatus,
+    string? Image = null,
+    string? Pod = null,
+    Dictionary<string, string>? Labels = null,
+    List<string>? Networks = null,
+    List<string>? Links = null
 )
 {
     public bool IsRunning => State.IsRunning();
@@ -183,6 +210,29 @@ public record ContainerInfo(
     public bool IsUnhealthy => HealthStatus.IsUnhealthy();
 }
 
+/// <summary>
+/// Swarm service with its task counts, as a manager reports it.
+/// </summary>
+public record SwarmService(
+    string Id,
+    string Name,
+    string Mode,
+    long DesiredTasks,
+    long RunningTasks
+);
+
+/// <summary>
+/// Swarm node with its address and scheduling state, as a manager reports it.
+/// </summary>
+public record SwarmNode(
+    string Id,
+    string Hostname,
+    string Addr,
+    string Role,
+    string State,
+    string Availability
+);
+
 /// <summary>
 /// Real-time container status.
 /// </summary>
@@ -202,5 +252,13 @@ public record AgentInfo(
     string AgentVersion,
     string DockerVersion,
     bool DockerConnected,
-    bool PsiSupported
+    bool PsiSupported,
+    string? Os = null,
+    string? Architecture = null,
+    string? KernelVersion = null,
+    string? Runtime = null,
+    string? Orchestrator = null,
+    string? InstanceId = null,
+    int? CpuCount = null,
+    long? MemoryTotalBytes = null
 );
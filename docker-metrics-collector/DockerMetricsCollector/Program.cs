@@ -8,6 +8,27 @@ var hostname = Environment.GetEnvironmentVariable("HOSTNAME") ??
                Environment.GetEnvironmentVariable("COMPUTERNAME") ??
                System.Net.Dns.GetHostName();
 
+// Detect the orchestrator the agent runs under (the ECS agent and Nomad inject these into every task)
+string? orchestrator = null;
+if (Environment.GetEnvironmentVariable("ECS_CONTAINER_METADATA_URI_V4") != null ||
+    Environment.GetEnvironmentVariable("ECS_CONTAINER_METADATA_URI") != null)
+{
+    orchestrator = "ecs";
+}
+else if (Environment.GetEnvironmentVariable("NOMAD_ALLOC_ID") != null)
+{
+    orchestrator = "nomad";
+}
+
+// Instance ID sent with every response, so the data source can spot two hosts reaching the same agent
+var instanceId = Environment.GetEnvironmentVariable("AGENT_INSTANCE_ID") ?? Guid.NewGuid().ToString("N");
+
+// Bearer token every request must carry; unset leaves the API open
+var agentToken = Environment.GetEnvironmentVariable("AGENT_TOKEN");
+
+// PEM private key (ECDSA P-256) signing every response; unset sends unsigned responses
+var signingKeyFile = Environment.GetEnvironmentVariable("AGENT_SIGNING_KEY_FILE");
+
 // Register services
 builder.Services.AddSingleton<PsiReader>();
 builder.Services.AddSingleton<LocalDockerClient>();
@@ -30,7 +51,68 @@ var app = builder.Build();
 // Enable CORS
 app.UseCors();
 
+app.Use(async (context, next) =>
+{
+    context.Response.Headers["X-Agent-Instance-Id"] = instanceId;
+    await next();
+});
+
+if (!string.IsNullOrEmpty(signingKeyFile))
+{
+    var signingKey = System.Security.Cryptography.ECDsa.Create();
+    signingKey.ImportFromPem(File.ReadAllText(signingKeyFile));
+    app.Use(async (context, next) =>
+    {
+        // The signature header has to go out before the body, so responses are buffered
+        var original = context.Response.Body;
+        using var buffer = new MemoryStream();
+        context.Response.Body = buffer;
+        try
+        {
+            await next();
+        }
+        finally
+        {
+            context.Response.Body = original;
+        }
+
+        // The signature covers the request and the time too, so a response can't be replayed
+        var body = buffer.ToArray();
+        var signedAt = DateTimeOffset.UtcNow.ToUnixTimeSeconds().ToString();
+        var request = context.Request;
+        var header = System.Text.Encoding.UTF8.GetBytes(request.Method + "\n" +
+            request.Path.ToUriComponent() + request.QueryString.ToUriComponent() + "\n" +
+            signedAt + "\n" + request.Headers["X-Agent-Nonce"].ToString() + "\n");
+        var signature = signingKey.SignData(header.Concat(body).ToArray(), System.Security.Cryptography.HashAlgorithmName.SHA256,
+            System.Security.Cryptography.DSASignatureFormat.Rfc3279DerSequence);
+        context.Response.Headers["X-Agent-Signature-Time"] = signedAt;
+        context.Response.Headers["X-Agent-Signature"] = Convert.ToBase64String(signature);
+        context.Response.ContentLength = body.Length;
+        await original.WriteAsync(body);
+    });
+}
+
+if (!string.IsNullOrEmpty(agentToken))
+{
+    var expected = System.Text.Encoding.UTF8.GetBytes("Bearer " + agentToken);
+    app.Use(async (context, next) =>
+    {
+        // CORS preflights never carry credentials; / stays open for container health checks
+        var presented = System.Text.Encoding.UTF8.GetBytes(context.Request.Headers.Authorization.ToString());
+        if (!HttpMethods.IsOptions(context.Request.Method) && context.Request.Path != "/" &&
+            !System.Security.Cryptography.CryptographicOperations.FixedTimeEquals(presented, expected))
+        {
+            context.Response.StatusCode = StatusCodes.Status401Unauthorized;
+            await context.Response.WriteAsJsonAsync(new { error = "invalid or missing token" });
+            return;
+        }
+        await next();
+    });
+}
+
 const string AgentVersion = "1.2.22-dev.20260125.224931";
+// Projected fields when /api/metrics is called without a fields filter
+const string AllMetricFields = "cpupercent,memorybytes,memorypercent,networkrxbytes,networktxbytes,diskreadbytes,diskwritebytes,uptimeseconds,cpupressure,memorypressure,iopressure";
 
 // =====================
 // Health & Info Endpoints
@@ -53,7 +135,15 @@ app.MapGet("/api/info", async (LocalDockerClient docker, PsiReader psi) =>
         AgentVersion: AgentVersion,
         DockerVersion: docker.DockerVersion ?? "unknown",
         DockerConnected: connected,
-        PsiSupported: psi.IsPsiSupported
+        PsiSupported: psi.IsPsiSupported,
+        Os: docker.Os,
+        Architecture: docker.Architecture,
+        KernelVersion: docker.KernelVersion,
+        Runtime: docker.Runtime,
+        Orchestrator: orchestrator,
+        InstanceId: instanceId,
+        CpuCount: docker.CpuCount,
+        MemoryTotalBytes: docker.MemoryTotalBytes
     ));
 });
 
@@ -79,6 +169,32 @@ app.MapGet("/api/containers/{containerId}/status", async (LocalDockerClient dock
     return Results.Ok(status);
 });
 
+// =====================
+// Swarm Endpoints
+// =====================
+
+// List swarm services with their replica counts; only managers know them
+app.MapGet("/api/swarm/services", async (LocalDockerClient docker) =>
+{
+    var services = await docker.GetSwarmServicesAsync();
+    if (services == null)
+    {
+        return Results.Conflict(new { error = "This node is not a swarm manager" });
+    }
+    return Results.Ok(services);
+});
+
+// List swarm nodes with their addresses, for agent discovery; only managers know them
+app.MapGet("/api/swarm/nodes", async (LocalDockerClient docker) =>
+{
+    var nodes = await docker.GetSwarmNodesAsync();
+    if (nodes == null)
+    {
+        return Results.Conflict(new { error = "This node is not a swarm manager" });
+    }
+    return Results.Ok(nodes);
+});
+
 // =====================
 // Metrics Endpoints
 // =====================
@@ -89,10 +205,12 @@ app.MapGet("/api/metrics", (
     string? containerId,      // Single container (legacy support)
     string? containerIds,     // Comma-separated container IDs
     string? fields,           // Comma-separated field names to include
+    string? containerFields,  // Per-container fields overriding `fields`: id:field,field;id:field
     DateTimeOffset? from,
     DateTimeOffset? to,
     int? limit,               // Max points per container
-    bool? latest) =>          // Return only latest point per container
+    bool? latest,             // Return only latest point per container
+    string? timestamps) =>    // "epoch" for Unix milliseconds instead of ISO 8601 strings
 {
     // Parse container IDs (support both single and multiple)
     IEnumerable<string>? containerIdList = null;
@@ -107,21 +225,25 @@ app.MapGet("/api/metrics", (
 
     var result = cache.GetMetrics(containerIdList, from, to, limit, latest ?? false);
 
+    var epochTimestamps = string.Equals(timestamps, "epoch", StringComparison.OrdinalIgnoreCase);
+
     // If fields filter specified, project to only those fields
-    if (!string.IsNullOrEmpty(fields))
+    if (!string.IsNullOrEmpty(fields) || !string.IsNullOrEmpty(containerFields) || epochTimestamps)
     {
-        var fieldSet = fields.Split(',', StringSplitOptions.RemoveEmptyEntries)
-            .Select(f => f.Trim().ToLowerInvariant())
-            .ToHashSet();
-
-        // Always include these base fields
-        fieldSet.Add("containerid");
-        fieldSet.Add("containername");
-        fieldSet.Add("timestamp");
-        fieldSet.Add("isrunning");
-        fieldSet.Add("ispaused");
-
-        var projected = result.Metrics.Select(m => ProjectFields(m, fieldSet)).ToList();
+        var fieldSet = ParseFieldSet(fields ?? AllMetricFields);
+        var perContainer = new Dictionary<string, HashSet<string>>();
+        foreach (var entry in (containerFields ?? "").Split(';', StringSplitOptions.RemoveEmptyEntries))
+        {
+            var separator = entry.IndexOf(':');
+            if (separator > 0)
+            {
+                perContainer[entry[..separator]] = ParseFieldSet(entry[(separator + 1)..]);
+            }
+        }
+
+        var projected = result.Metrics
+            .Select(m => ProjectFields(m, perContainer.GetValueOrDefault(m.ContainerId, fieldSet), epochTimestamps))
+            .ToList();
         return Results.Ok(new
         {
             metrics = projected,
@@ -148,14 +270,29 @@ app.MapGet("/api/metrics", (
     });
 });
 
-// Helper to project only selected fields
-static Dictionary<string, object?> ProjectFields(ContainerMetrics m, HashSet<string> fields)
+// Helper to parse a comma-separated field list; base fields are always included
+static HashSet<string> ParseFieldSet(string fields)
+{
+    var fieldSet = fields.Split(',', StringSplitOptions.RemoveEmptyEntries)
+        .Select(f => f.Trim().ToLowerInvariant())
+        .ToHashSet();
+
+    fieldSet.Add("containerid");
+    fieldSet.Add("containername");
+    fieldSet.Add("timestamp");
+    fieldSet.Add("isrunning");
+    fieldSet.Add("ispaused");
+    return fieldSet;
+}
+
+// Helper to project only selected fields; epoch timestamps are Unix milliseconds
+static Dictionary<string, object?> ProjectFields(ContainerMetrics m, HashSet<string> fields, bool epochTimestamps)
 {
     var result = new Dictionary<string, object?>
     {
         ["containerId"] = m.ContainerId,
         ["containerName"] = m.ContainerName,
-        ["timestamp"] = m.Timestamp,
+        ["timestamp"] = epochTimestamps ? m.Timestamp.ToUnixTimeMilliseconds() : m.Timestamp,
         ["isRunning"] = m.IsRunning,
         ["isPaused"] = m.IsPaused,
         ["isUnhealthy"] = m.IsUnhealthy,
@@ -189,9 +326,9 @@ app.MapGet("/api/metrics/latest", (MetricsCache cache) =>
 // =====================
 
 // Helper for container action responses
-static IResult HandleContainerAction(string action, bool success, string? error, string containerId) =>
+static IResult HandleContainerAction(string action, bool success, string? error, string containerId, string? newContainerId = null) =>
     success
-        ? Results.Ok(new { success = true, action, containerId })
+        ? Results.Ok(new { success = true, action, containerId, newContainerId })
         : Results.BadRequest(new { success = false, error });
 
 app.MapPost("/api/containers/{containerId}/start", async (LocalDockerClient docker, string containerId) =>
@@ -224,6 +361,24 @@ app.MapPost("/api/containers/{containerId}/unpause", async (LocalDockerClient do
     return HandleContainerAction("unpause", success, error, containerId);
 });
 
+app.MapPost("/api/containers/{containerId}/kill", async (LocalDockerClient docker, string containerId) =>
+{
+    var (success, error) = await docker.KillContainerAsync(containerId);
+    return HandleContainerAction("kill", success, error, containerId);
+});
+
+app.MapPost("/api/containers/{containerId}/remove", async (LocalDockerClient docker, string containerId, bool? force, bool? volumes) =>
+{
+    var (success, error) = await docker.RemoveContainerAsync(containerId, force ?? false, volumes ?? false);
+    return HandleContainerAction("remove", success, error, containerId);
+});
+
+app.MapPost("/api/containers/{containerId}/recreate", async (LocalDockerClient docker, string containerId) =>
+{
+    var (success, error, newContainerId) = await docker.RecreateContainerAsync(containerId);
+    return HandleContainerAction("recreate", success, error, containerId, newContainerId);
+});
+
 // =====================
 // Stats Endpoint
 // =====================
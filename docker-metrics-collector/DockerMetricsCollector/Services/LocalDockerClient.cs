@@ -1,7 +1,9 @@
 namespace DockerMetricsAgent.Services;
 
 using System.Net.Sockets;
+using System.Text;
 using System.Text.Json;
+using System.Text.Json.Nodes;
 using DockerMetricsAgent.Models;
 
 /// <summary>
@@ -13,6 +15,12 @@ public class LocalDockerClient
     private readonly PsiReader _psiReader;
     private readonly ILogger<LocalDockerClient> _logger;
     private string? _dockerVersion;
+    private string? _os;
+    private string? _architecture;
+    private string? _kernelVersion;
+    private string? _runtime;
+    private int? _cpuCount;
+    private long? _memoryTotalBytes;
 
     public LocalDockerClient(PsiReader psiReader, ILogger<LocalDockerClient> logger)
     {
@@ -39,6 +47,17 @@ public class LocalDockerClient
     }
 
     public string? DockerVersion => _dockerVersion;
+    public string? Os => _os;
+    public string? Architecture => _architecture;
+    public string? KernelVersion => _kernelVersion;
+    public int? CpuCount => _cpuCount;
+    public long? MemoryTotalBytes => _memoryTotalBytes;
+
+    /// <summary>
+    /// Container runtime behind the socket: "docker" or "podman".
+    /// </summary>
+    public string? Runtime => _runtime;
+    public bool IsPodman => _runtime == "podman";
 
     /// <summary>
     /// Check if Docker is reachable and get version info.
@@ -56,6 +75,30 @@ public class LocalDockerClient
                 {
                     _dockerVersion = v.GetString();
                 }
+                if (version.TryGetProperty("Os", out var os))
+                {
+                    _os = os.GetString();
+                }
+                if (version.TryGetProperty("Arch", out var arch))
+                {
+                    _architecture = arch.GetString();
+                }
+                if (version.TryGetProperty("KernelVersion", out var kernel))
+                {
+                    _kernelVersion = kernel.GetString();
+                }
+
+                // Podman's Docker-compatible API lists a "Podman Engine" component
+                _runtime = "docker";
+                if (version.TryGetProperty("Components", out var components) &&
+                    components.ValueKind == JsonValueKind.Array &&
+                    components.EnumerateArray().Any(c =>
+                        c.TryGetProperty("Name", out var n) &&
+                        (n.GetString() ?? "").Contains("Podman", StringComparison.OrdinalIgnoreCase)))
+                {
+                    _runtime = "podman";
+                }
+                await LoadHostCapacityAsync();
                 return true;
             }
             return false;
@@ -67,6 +110,34 @@ public class LocalDockerClient
         }
     }
 
+    /// <summary>
+    /// Read the host's CPU count and memory from /info; both Docker and Podman report them.
+    /// </summary>
+    private async Task LoadHostCapacityAsync()
+    {
+        try
+        {
+            var response = await _httpClient.GetAsync("/info");
+            if (!response.IsSuccessStatusCode)
+            {
+                return;
+            }
+            var info = JsonSerializer.Deserialize<JsonElement>(await response.Content.ReadAsStringAsync());
+            if (info.TryGetProperty("NCPU", out var ncpu) && ncpu.TryGetInt32(out var cpus))
+            {
+                _cpuCount = cpus;
+            }
+            if (info.TryGetProperty("MemTotal", out var mem) && mem.TryGetInt64(out var total))
+            {
+                _memoryTotalBytes = total;
+            }
+        }
+        catch (Exception ex)
+        {
+            _logger.LogDebug(ex, "Failed to read host capacity from Docker info");
+        }
+    }
+
     /// <summary>
     /// Get list of all containers.
     /// </summary>
@@ -80,6 +151,25 @@ public class LocalDockerClient
             var json = await response.Content.ReadAsStringAsync();
             var containers = JsonSerializer.Deserialize<JsonElement[]>(json) ?? [];
 
+            var pods = IsPodman ? await GetPodNamesAsync() : new Dictionary<string, string>();
+
+            // A container linked with --link gets an extra name /<linking container>/<alias>
+            var links = new Dictionary<string, List<string>>();
+            foreach (var container in containers)
+            {
+                var allNames = container.GetProperty("Names").EnumerateArray()
+                    .Select(n => n.GetString() ?? "").ToList();
+                foreach (var linkName in allNames.Skip(1))
+                {
+                    var parts = linkName.Trim('/').Split('/');
+                    if (parts.Length != 2)
+                        continue;
+                    if (!links.TryGetValue(parts[0], out var targets))
+                        links[parts[0]] = targets = new List<string>();
+                    targets.Add(allNames[0].TrimStart('/'));
+                }
+            }
+
             var result = new List<ContainerInfo>();
             foreach (var container in containers)
             {
@@ -88,16 +178,38 @@ public class LocalDockerClient
                     .FirstOrDefault().GetString() ?? "";
                 var stateStr = container.GetProperty("State").GetString();
                 var state = ContainerStateExtensions.ParseDockerState(stateStr);
+                var image = container.TryGetProperty("Image", out var img) ? img.GetString() : null;
+                var labels = new Dictionary<string, string>();
+                if (container.TryGetProperty("Labels", out var labelsProp) && labelsProp.ValueKind == JsonValueKind.Object)
+                {
+                    foreach (var label in labelsProp.EnumerateObject())
+                    {
+                        labels[label.Name] = label.Value.GetString() ?? "";
+                    }
+                }
 
-                // Note: /containers/json doesn't include full health info
-                // Health status will be populated from inspect endpoint for metrics
-                ContainerHealthStatus healthStatus = ContainerHealthStatus.None;
+                var networks = new List<string>();
+                if (container.TryGetProperty("NetworkSettings", out var netSettings) &&
+                    netSettings.TryGetProperty("Networks", out var networksProp) &&
+                    networksProp.ValueKind == JsonValueKind.Object)
+                {
+                    networks.AddRange(networksProp.EnumerateObject().Select(n => n.Name));
+                }
+
+                // /containers/json has no health object, but the Status text carries it
+                var statusText = container.TryGetProperty("Status", out var st) ? st.GetString() : null;
+                var healthStatus = ContainerHealthStatusExtensions.ParseDockerStatusText(statusText);
 
                 result.Add(new ContainerInfo(
                     ContainerId: id,
                     ContainerName: names,
                     State: state,
-                    HealthStatus: healthStatus
+                    HealthStatus: healthStatus,
+                    Image: image,
+                    Pod: pods.GetValueOrDefault(id),
+                    Labels: labels,
+                    Networks: networks,
+                    Links: links.GetValueOrDefault(names.TrimStart('/'))
                 ));
             }
 
@@ -110,6 +222,122 @@ public class LocalDockerClient
         }
     }
 
+    /// <summary>
+    /// Get swarm services with their desired and running task counts.
+    /// Returns null when this node is not a swarm manager.
+    /// </summary>
+    public async Task<List<SwarmService>?> GetSwarmServicesAsync()
+    {
+        try
+        {
+            // status=true adds ServiceStatus (Docker API 1.41)
+            var response = await _httpClient.GetAsync("/services?status=true");
+            if (!response.IsSuccessStatusCode)
+                return null;
+
+            var json = await response.Content.ReadAsStringAsync();
+            var result = new List<SwarmService>();
+            foreach (var service in JsonSerializer.Deserialize<JsonElement[]>(json) ?? [])
+            {
+                var id = service.GetProperty("ID").GetString() ?? "";
+                var spec = service.GetProperty("Spec");
+                var name = spec.TryGetProperty("Name", out var n) ? n.GetString() ?? id : id;
+                var mode = spec.TryGetProperty("Mode", out var m) && m.TryGetProperty("Global", out _) ? "global" : "replicated";
+
+                long desired = 0, running = 0;
+                if (service.TryGetProperty("ServiceStatus", out var status))
+                {
+                    desired = status.TryGetProperty("DesiredTasks", out var d) ? d.GetInt64() : 0;
+                    running = status.TryGetProperty("RunningTasks", out var r) ? r.GetInt64() : 0;
+                }
+                else if (m.ValueKind == JsonValueKind.Object &&
+                         m.TryGetProperty("Replicated", out var replicated) &&
+                         replicated.TryGetProperty("Replicas", out var replicas))
+                {
+                    desired = replicas.GetInt64();
+                }
+
+                result.Add(new SwarmService(id, name, mode, desired, running));
+            }
+            return result;
+        }
+        catch (Exception ex)
+        {
+            _logger.LogDebug(ex, "Failed to get swarm services");
+            return null;
+        }
+    }
+
+    /// <summary>
+    /// Get swarm nodes with their addresses, roles and availability.
+    /// Returns null when this node is not a swarm manager.
+    /// </summary>
+    public async Task<List<SwarmNode>?> GetSwarmNodesAsync()
+    {
+        try
+        {
+            var response = await _httpClient.GetAsync("/nodes");
+            if (!response.IsSuccessStatusCode)
+                return null;
+
+            var json = await response.Content.ReadAsStringAsync();
+            var result = new List<SwarmNode>();
+            foreach (var node in JsonSerializer.Deserialize<JsonElement[]>(json) ?? [])
+            {
+                var id = node.GetProperty("ID").GetString() ?? "";
+                var hostname = node.TryGetProperty("Description", out var desc) && desc.TryGetProperty("Hostname", out var h) ? h.GetString() ?? "" : "";
+                string role = "", availability = "", state = "", addr = "";
+                if (node.TryGetProperty("Spec", out var spec))
+                {
+                    role = spec.TryGetProperty("Role", out var r) ? r.GetString() ?? "" : "";
+                    availability = spec.TryGetProperty("Availability", out var a) ? a.GetString() ?? "" : "";
+                }
+                if (node.TryGetProperty("Status", out var status))
+                {
+                    state = status.TryGetProperty("State", out var s) ? s.GetString() ?? "" : "";
+                    addr = status.TryGetProperty("Addr", out var ad) ? ad.GetString() ?? "" : "";
+                }
+
+                result.Add(new SwarmNode(id, hostname, addr, role, state, availability));
+            }
+            return result;
+        }
+        catch (Exception ex)
+        {
+            _logger.LogDebug(ex, "Failed to get swarm nodes");
+            return null;
+        }
+    }
+
+    /// <summary>
+    /// Map container IDs to pod names using Podman's libpod API.
+    /// </summary>
+    private async Task<Dictionary<string, string>> GetPodNamesAsync()
+    {
+        var pods = new Dictionary<string, string>();
+        try
+        {
+            var response = await _httpClient.GetAsync("/libpod/containers/json?all=true");
+            if (!response.IsSuccessStatusCode)
+                return pods;
+
+            var json = await response.Content.ReadAsStringAsync();
+            foreach (var container in JsonSerializer.Deserialize<JsonElement[]>(json) ?? [])
+            {
+                var id = container.GetProperty("Id").GetString() ?? "";
+                if (container.TryGetProperty("PodName", out var podName) && !string.IsNullOrEmpty(podName.GetString()))
+                {
+                    pods[id] = podName.GetString()!;
+                }
+            }
+        }
+        catch (Exception ex)
+        {
+            _logger.LogDebug(ex, "Failed to get Podman pod names");
+        }
+        return pods;
+    }
+
     /// <summary>
     /// Get metrics for a specific container including PSI.
     /// </summary>
@@ -277,6 +505,106 @@ public class LocalDockerClient
         return await ExecuteContainerAction(containerId, "unpause");
     }
 
+    /// <summary>
+    /// Kill a container with SIGKILL, for containers that ignore stop.
+    /// </summary>
+    public async Task<(bool Success, string? Error)> KillContainerAsync(string containerId)
+    {
+        return await ExecuteContainerAction(containerId, "kill");
+    }
+
+    /// <summary>
+    /// Remove a container, optionally a running one and its anonymous volumes.
+    /// </summary>
+    public async Task<(bool Success, string? Error)> RemoveContainerAsync(string containerId, bool force, bool volumes)
+    {
+        try
+        {
+            var response = await _httpClient.DeleteAsync(
+                $"/containers/{containerId}?force={force.ToString().ToLowerInvariant()}&v={volumes.ToString().ToLowerInvariant()}");
+            if (response.IsSuccessStatusCode)
+                return (true, null);
+
+            var error = await response.Content.ReadAsStringAsync();
+            return (false, $"Failed: {response.StatusCode} - {error}");
+        }
+        catch (Exception ex)
+        {
+            return (false, ex.Message);
+        }
+    }
+
+    /// <summary>
+    /// Recreate a container: stop and remove it, then create and start one with the same
+    /// name, config, host config and networks. Returns the new container's ID.
+    /// </summary>
+    public async Task<(bool Success, string? Error, string? NewContainerId)> RecreateContainerAsync(string containerId)
+    {
+        try
+        {
+            var inspect = await _httpClient.GetAsync($"/containers/{containerId}/json");
+            if (!inspect.IsSuccessStatusCode)
+            {
+                var inspectError = await inspect.Content.ReadAsStringAsync();
+                return (false, $"Failed to inspect: {inspect.StatusCode} - {inspectError}", null);
+            }
+            var container = JsonNode.Parse(await inspect.Content.ReadAsStringAsync())!;
+            var name = container["Name"]?.GetValue<string>().TrimStart('/') ?? "";
+
+            // The create body is the inspected config plus the host config; older daemons take
+            // one network at create time, the others are connected before the start
+            var body = container["Config"]?.DeepClone().AsObject() ?? new JsonObject();
+            body["HostConfig"] = container["HostConfig"]?.DeepClone();
+            var networks = container["NetworkSettings"]?["Networks"]?.AsObject()
+                .Select(n => (Name: n.Key, Endpoint: new JsonObject
+                {
+                    ["Aliases"] = n.Value?["Aliases"]?.DeepClone(),
+                    ["Links"] = n.Value?["Links"]?.DeepClone(),
+                    ["IPAMConfig"] = n.Value?["IPAMConfig"]?.DeepClone(),
+                }))
+                .ToList() ?? new();
+            if (networks.Count > 0)
+            {
+                body["NetworkingConfig"] = new JsonObject
+                {
+                    ["EndpointsConfig"] = new JsonObject { [networks[0].Name] = networks[0].Endpoint },
+                };
+            }
+
+            var (stopped, stopError) = await ExecuteContainerAction(containerId, "stop?t=10");
+            if (!stopped)
+                return (false, stopError, null);
+            var (removed, removeError) = await RemoveContainerAsync(containerId, force: false, volumes: false);
+            if (!removed)
+                return (false, removeError, null);
+
+            var create = await _httpClient.PostAsync(
+                $"/containers/create?name={Uri.EscapeDataString(name)}",
+                new StringContent(body.ToJsonString(), Encoding.UTF8, "application/json"));
+            var created = await create.Content.ReadAsStringAsync();
+            if (!create.IsSuccessStatusCode)
+                return (false, $"Removed {name} but failed to create it again: {create.StatusCode} - {created}", null);
+            var newId = JsonNode.Parse(created)?["Id"]?.GetValue<string>() ?? "";
+
+            foreach (var (network, endpoint) in networks.Skip(1))
+            {
+                var connect = new JsonObject { ["Container"] = newId, ["EndpointConfig"] = endpoint };
+                var connected = await _httpClient.PostAsync(
+                    $"/networks/{Uri.EscapeDataString(network)}/connect",
+                    new StringContent(connect.ToJsonString(), Encoding.UTF8, "application/json"));
+                if (!connected.IsSuccessStatusCode)
+                    _logger.LogWarning("Failed to connect recreated container {Name} to network {Network}: {Status}", name, network, connected.StatusCode);
+            }
+
+            var (started, startError) = await ExecuteContainerAction(newId, "start");
+            return started ? (true, null, newId) : (false, startError, newId);
+        }
+        catch (Exception ex)
+        {
+            return (false, ex.Message, null);
+        }
+    }
+
     private async Task<(bool Success, string? Error)> ExecuteContainerAction(string containerId, string action)
     {
         try
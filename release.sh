@@ -206,18 +206,28 @@ build_datasource_plugin() {
 
     go mod tidy >&2
 
+    # Stamp version, commit and build date (shown in logs, health checks and frame meta)
+    local pkg="github.com/bitforge/dockermetrics-datasource/pkg/plugin"
+    local ldflags="-w -s -X ${pkg}.Version=${version} -X ${pkg}.Commit=$(git rev-parse --short HEAD) -X ${pkg}.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
     # Build for linux/amd64
     log_info "Building for linux/amd64..."
-    CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" \
+    CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags="${ldflags}" \
         -o dist/gpx_bitforge_dockermetrics_datasource_linux_amd64 \
         ./pkg >&2
 
     # Build for linux/arm64
     log_info "Building for linux/arm64..."
-    CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags="-w -s" \
+    CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags="${ldflags}" \
         -o dist/gpx_bitforge_dockermetrics_datasource_linux_arm64 \
         ./pkg >&2
 
+    # Build for linux/arm (ARMv7)
+    log_info "Building for linux/arm..."
+    CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 go build -ldflags="${ldflags}" \
+        -o dist/gpx_bitforge_dockermetrics_datasource_linux_arm \
+        ./pkg >&2
+
     # Verify backend build succeeded
     if [ ! -f "dist/gpx_bitforge_dockermetrics_datasource_linux_amd64" ]; then
         log_error "DataSource backend build failed"
@@ -198,10 +198,14 @@ build_datasource() {
     # Build Go backend
     log_info "Building Go backend..."
     go mod tidy
-    CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" \
+    local pkg="github.com/bitforge/dockermetrics-datasource/pkg/plugin"
+    local ldflags="-w -s -X ${pkg}.Version=${VERSION} -X ${pkg}.Commit=$(git rev-parse --short HEAD) -X ${pkg}.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+    CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags="${ldflags}" \
         -o dist/gpx_bitforge_dockermetrics_datasource_linux_amd64 ./pkg
-    CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags="-w -s" \
+    CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags="${ldflags}" \
         -o dist/gpx_bitforge_dockermetrics_datasource_linux_arm64 ./pkg
+    CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 go build -ldflags="${ldflags}" \
+        -o dist/gpx_bitforge_dockermetrics_datasource_linux_arm ./pkg
 
     # Create archive
     cd "$PROJECT_ROOT"